@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/elireisman/cass-dsapi/internal/advisories"
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/metrics"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
+)
+
+var (
+	importAdvisoriesFlags = flag.NewFlagSet("import-advisories", flag.ExitOnError)
+	affectedFlags         = flag.NewFlagSet("affected", flag.ExitOnError)
+	importAdvisoriesLog   = addLogFlags(importAdvisoriesFlags)
+	importAdvisoriesTrace = addTraceFlags(importAdvisoriesFlags)
+	affectedLog           = addLogFlags(affectedFlags)
+	affectedTrace         = addTraceFlags(affectedFlags)
+
+	advisoryID string
+)
+
+func init() {
+	importAdvisoriesFlags.Usage = func() {
+		fmt.Fprintf(importAdvisoriesFlags.Output(), "Usage: %s import-advisories OSV_FILE...\n", os.Args[0])
+		importAdvisoriesFlags.PrintDefaults()
+	}
+	affectedFlags.StringVar(&advisoryID, "id", "", "ID of the advisory to list affected repositories for")
+
+	commands["import-advisories"] = importAdvisories
+	commands["affected"] = affected
+}
+
+// importAdvisories loads OSV-format JSON files into the advisory tables
+func importAdvisories(args []string) {
+	importAdvisoriesFlags.Parse(args)
+	ctx := context.Background()
+	lgr := importAdvisoriesLog.logger()
+	defer importAdvisoriesTrace.setup(ctx, lgr, "dsapi-import-advisories")()
+
+	if importAdvisoriesFlags.NArg() == 0 {
+		importAdvisoriesFlags.Usage()
+		os.Exit(2)
+	}
+
+	sesh, err := data.CreateClient(ctx, lgr, metrics.Observe, tracing.Observe)
+	check(err, "creating gocql.Session")
+
+	err = data.CreateKeyspace(ctx, lgr, sesh, data.Keyspace)
+	check(err, "creating keyspace")
+
+	err = data.CreateTables(ctx, lgr, sesh, data.Keyspace)
+	check(err, "creating tables")
+
+	total := 0
+	for _, path := range importAdvisoriesFlags.Args() {
+		f, err := os.Open(path)
+		check(err, "opening OSV file")
+
+		advs, err := advisories.ParseOSV(f)
+		f.Close()
+		check(err, "parsing OSV file "+path)
+
+		for _, adv := range advs {
+			if len(adv.Affected) == 0 {
+				lgr.Warn("Skipping advisory with no affected packages in supported ecosystems", "advisory_id", adv.ID)
+				continue
+			}
+			err = data.WriteAdvisory(ctx, lgr, sesh, data.Keyspace, adv)
+			check(err, "ingesting advisory into Cassandra")
+			total++
+		}
+	}
+	lgr.Info("Imported advisories", "advisories", total)
+}
+
+// affected prints the repositories affected by an advisory as JSON
+func affected(args []string) {
+	affectedFlags.Parse(args)
+	ctx := context.Background()
+	lgr := affectedLog.logger()
+	defer affectedTrace.setup(ctx, lgr, "dsapi-affected")()
+
+	if advisoryID == "" {
+		affectedFlags.Usage()
+		os.Exit(2)
+	}
+
+	sesh, err := data.CreateClient(ctx, lgr, metrics.Observe, tracing.Observe)
+	check(err, "creating gocql.Session")
+
+	repos, err := data.AffectedRepositories(ctx, lgr, sesh, data.Keyspace, advisoryID)
+	check(err, "querying affected repositories")
+
+	jsn, _ := json.MarshalIndent(repos, "", "\t")
+	fmt.Println(string(jsn))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/bench"
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
+)
+
+var (
+	benchFlags = flag.NewFlagSet("bench", flag.ExitOnError)
+	benchLog   = addLogFlags(benchFlags)
+	benchTrace = addTraceFlags(benchFlags)
+
+	benchWorkloads   string
+	benchDuration    time.Duration
+	benchOps         int64
+	benchConcurrency int
+	benchSeed        int64
+	benchOutput      string
+	benchList        bool
+	benchLabel       string
+	benchSamples     int
+	benchVariant     string
+	fixtureLimits    bench.FixtureLimits
+
+	benchMix           string
+	ingestRate         float64
+	ingestWorkers      int
+	ingestManifests    int
+	ingestDependencies int
+)
+
+func init() {
+	benchFlags.StringVar(&benchWorkloads, "w", "", "comma-separated workloads to run (default all, see -list)")
+	benchFlags.DurationVar(&benchDuration, "duration", 10*time.Second, "how long to run each workload")
+	benchFlags.Int64Var(&benchOps, "ops", 0, "run each workload for this many operations instead of -duration")
+	benchFlags.IntVar(&benchConcurrency, "concurrency", 8, "number of concurrent workers issuing operations")
+	benchFlags.Int64Var(&benchSeed, "seed", time.Now().UnixNano(), "random seed for choosing query parameters")
+	benchFlags.StringVar(&benchOutput, "o", "", "path to write JSON results to (default stdout)")
+	benchFlags.BoolVar(&benchList, "list", false, "list available workloads and exit")
+	benchFlags.StringVar(&benchLabel, "label", "", "name for the configuration under test, recorded in the results")
+	benchFlags.StringVar(&benchVariant, "variant", data.DefaultVariant, "schema variant to benchmark, loaded into its own keyspace by seed -variants")
+	benchFlags.IntVar(&benchSamples, "samples", 10000, "latency samples to keep per workload in the results, for the compare command")
+	benchFlags.IntVar(&fixtureLimits.Snapshots, "fixture-snapshots", 1000, "max snapshots to sample query parameters from")
+	benchFlags.IntVar(&fixtureLimits.Manifests, "fixture-manifests", 5000, "max manifests to sample query parameters from")
+	benchFlags.IntVar(&fixtureLimits.Dependencies, "fixture-dependencies", 5000, "max dependencies to sample query parameters from")
+	benchFlags.StringVar(&benchMix, "mix", "", "run a weighted read mix, e.g. canonical-snapshot=5,page-of-dependents=1, with and without ingest")
+	benchFlags.Float64Var(&ingestRate, "ingest-rate", 1, "snapshots per second to generate and load during the -mix run")
+	benchFlags.IntVar(&ingestWorkers, "ingest-workers", 2, "max snapshots loaded concurrently during the -mix run")
+	benchFlags.IntVar(&ingestManifests, "ingest-manifests", 20, "manifests per snapshot ingested during the -mix run")
+	benchFlags.IntVar(&ingestDependencies, "ingest-dependencies", 200, "max dependencies per manifest ingested during the -mix run")
+
+	commands["bench"] = runBench
+}
+
+func runBench(args []string) {
+	benchFlags.Parse(args)
+	ctx := context.Background()
+	lgr := benchLog.logger()
+	defer benchTrace.setup(ctx, lgr, "dsapi-bench")()
+
+	variant, err := data.LookupVariant(benchVariant)
+	check(err, "selecting schema variant")
+	keyspace := variant.Keyspace(data.Keyspace)
+
+	// listing needs no cluster, and shows the workloads missing fixtures skip
+	if benchList {
+		for _, w := range bench.ListWorkloads(variant) {
+			fmt.Printf("%-36s %-14s %s\n", w.Name, "("+w.Fixture+")", w.Description)
+		}
+		fmt.Println()
+		for _, v := range data.Variants() {
+			fmt.Printf("-variant %-27s %s\n", v.Name, v.Description)
+		}
+		return
+	}
+
+	sesh, err := data.CreateClient(ctx, lgr, tracing.Observe)
+	check(err, "creating gocql.Session")
+
+	fx, err := bench.LoadFixtures(ctx, lgr, sesh, keyspace, fixtureLimits, benchSeed)
+	check(err, "loading benchmark fixtures")
+
+	// the other workloads query the baseline tables directly
+	available := bench.ModelWorkloads(lgr, sesh, keyspace, variant, fx)
+	if variant.Name == data.DefaultVariant {
+		available = append(bench.Workloads(lgr, sesh, keyspace, fx), available...)
+	}
+
+	opts := bench.Options{
+		Duration:    benchDuration,
+		Ops:         benchOps,
+		Concurrency: benchConcurrency,
+		Seed:        benchSeed,
+		Samples:     benchSamples,
+	}
+	report := bench.Report{
+		Version:   bench.ReportVersion,
+		StartedAt: time.Now(),
+		Keyspace:  keyspace,
+		Metadata: bench.Metadata{
+			Variant:       variant.Name,
+			SchemaVersion: variant.SchemaVersion(),
+			Label:         benchLabel,
+			Fixtures:      fixtureLimits,
+			GoVersion:     runtime.Version(),
+		},
+		Options: opts,
+	}
+	report.Metadata.Hostname, _ = os.Hostname()
+	report.Metadata.DataSet, err = data.TableSizes(ctx, lgr, sesh, keyspace)
+	check(err, "estimating data set size")
+	if benchMix != "" {
+		weights, err := bench.ParseWeights(benchMix)
+		check(err, "parsing -mix")
+
+		mixed, err := bench.RunMixed(ctx, lgr, sesh, keyspace, variant, available, bench.MixOptions{
+			Options:         opts,
+			Weights:         weights,
+			IngestRate:      ingestRate,
+			IngestWorkers:   ingestWorkers,
+			Manifests:       ingestManifests,
+			MaxDependencies: ingestDependencies,
+		})
+		check(err, "running mixed workload")
+		report.Mixed = &mixed
+	} else {
+		for _, w := range selectWorkloads(available, benchWorkloads) {
+			report.Results = append(report.Results, bench.Run(ctx, lgr, w, opts))
+		}
+	}
+
+	out := os.Stdout
+	if benchOutput != "" {
+		out, err = os.Create(benchOutput)
+		check(err, "creating results file")
+		defer out.Close()
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "\t")
+	check(enc.Encode(report), "writing results")
+}
+
+func selectWorkloads(available []bench.Workload, names string) []bench.Workload {
+	if names == "" {
+		return available
+	}
+
+	byName := map[string]bench.Workload{}
+	for _, w := range available {
+		byName[w.Name] = w
+	}
+
+	var out []bench.Workload
+	for _, name := range strings.Split(names, ",") {
+		w, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown or unavailable workload %q, see -list\n", name)
+			os.Exit(2)
+		}
+		out = append(out, w)
+	}
+	return out
+}
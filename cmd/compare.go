@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/elireisman/cass-dsapi/internal/bench"
+)
+
+var (
+	compareFlags = flag.NewFlagSet("compare", flag.ExitOnError)
+
+	compareThreshold float64
+	compareAlpha     float64
+)
+
+func init() {
+	compareFlags.Float64Var(&compareThreshold, "threshold", 0.05, "relative median latency increase above which a significant change is a regression")
+	compareFlags.Float64Var(&compareAlpha, "alpha", 0.01, "significance level of the Mann-Whitney U test")
+	compareFlags.Usage = func() {
+		fmt.Fprintf(compareFlags.Output(), "usage: compare [flags] BASE.json HEAD.json\n")
+		compareFlags.PrintDefaults()
+	}
+
+	commands["compare"] = runCompare
+}
+
+// runCompare exits with status 1 when any workload regressed, so it can gate CI.
+func runCompare(args []string) {
+	compareFlags.Parse(args)
+	if compareFlags.NArg() != 2 {
+		compareFlags.Usage()
+		os.Exit(2)
+	}
+
+	base, err := bench.LoadReport(compareFlags.Arg(0))
+	check(err, "loading base results")
+	head, err := bench.LoadReport(compareFlags.Arg(1))
+	check(err, "loading head results")
+
+	comparisons, notes := bench.Compare(base, head, compareThreshold, compareAlpha)
+	for _, note := range notes {
+		fmt.Printf("note: %s\n", note)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "WORKLOAD\tP50 (us)\tP99 (us)\tOPS/SEC\tMEDIAN\tP\tVERDICT")
+	regressions := 0
+	for _, cmp := range comparisons {
+		verdict := ""
+		switch {
+		case cmp.Regression:
+			verdict = "REGRESSION"
+			regressions++
+		case cmp.Significant && cmp.MedianChange < 0:
+			verdict = "improved"
+		case cmp.Significant:
+			verdict = "changed"
+		}
+		fmt.Fprintf(w, "%s\t%.0f -> %.0f\t%.0f -> %.0f\t%.1f -> %.1f\t%+.1f%%\t%.4f\t%s\n",
+			cmp.Workload, cmp.BaseP50, cmp.HeadP50, cmp.BaseP99, cmp.HeadP99,
+			cmp.BaseThroughput, cmp.HeadThroughput, cmp.MedianChange*100, cmp.P, verdict)
+	}
+	w.Flush()
+
+	if regressions > 0 {
+		fmt.Printf("%d workloads regressed by more than %.1f%%\n", regressions, compareThreshold*100)
+		os.Exit(1)
+	}
+}
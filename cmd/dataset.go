@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/advisories"
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/dataset"
+	"github.com/elireisman/cass-dsapi/internal/metrics"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
+
+	"github.com/gocql/gocql"
+)
+
+// manifests buffered between the generator and the data set writer
+const generateBuffer = 16
+
+var (
+	generateFlags  = flag.NewFlagSet("generate", flag.ExitOnError)
+	loadFlags      = flag.NewFlagSet("load", flag.ExitOnError)
+	exportCSVFlags = flag.NewFlagSet("export-csv", flag.ExitOnError)
+	generateLog    = addLogFlags(generateFlags)
+	loadLog        = addLogFlags(loadFlags)
+	loadTrace      = addTraceFlags(loadFlags)
+	exportCSVLog   = addLogFlags(exportCSVFlags)
+
+	dataSetPath    string
+	exportDir      string
+	exportKeyspace string
+	exportDialect  string
+)
+
+func init() {
+	addGenerateFlags(generateFlags)
+	generateFlags.StringVar(&dataSetPath, "o", "", "path to write the data set to as NDJSON, gzip compressed if it ends in .gz")
+
+	addLoadFlags(loadFlags)
+	loadFlags.Usage = func() {
+		fmt.Fprintf(loadFlags.Output(), "Usage: %s load [flags] DATA_SET...\n", os.Args[0])
+		loadFlags.PrintDefaults()
+	}
+
+	addGenerateFlags(exportCSVFlags)
+	exportCSVFlags.StringVar(&exportDir, "o", "", "directory to write a CSV file per table into, along with scripts creating and loading the tables")
+	exportCSVFlags.StringVar(&exportKeyspace, "keyspace", data.Keyspace, "keyspace the scripts create and load the tables into")
+	exportCSVFlags.StringVar(&exportDialect, "dialect", "cqlsh", "bulk loader to encode collections and timestamps for: "+strings.Join(data.CSVDialects, " or "))
+	exportCSVFlags.Usage = func() {
+		fmt.Fprintf(exportCSVFlags.Output(), "Usage: %s export-csv -o DIR [flags] [DATA_SET...]\n"+
+			"Exports the given data sets, or snapshots generated as the flags configure if none are given.\n", os.Args[0])
+		exportCSVFlags.PrintDefaults()
+	}
+
+	commands["generate"] = generate
+	commands["export-csv"] = exportCSV
+	commands["load"] = load
+}
+
+// generate writes generated snapshots, and advisories against them, to a
+// data set file rather than Cassandra
+func generate(args []string) {
+	generateFlags.Parse(args)
+	lgr := generateLog.logger()
+	ctx := context.Background()
+
+	if dataSetPath == "" {
+		fmt.Fprintln(generateFlags.Output(), "generate: -o is required")
+		generateFlags.PrintDefaults()
+		os.Exit(2)
+	}
+
+	w, err := dataset.Create(dataSetPath)
+	check(err, "creating data set")
+
+	start := time.Now()
+	manifests, advs := generateTo(ctx, lgr, w)
+	check(w.Close(), "writing data set")
+	lgr.Info("Generated data set", "path", dataSetPath, "snapshots", numSnapshots, "manifests", manifests,
+		"advisories", advs, "duration", time.Since(start))
+}
+
+// snapshotWriter writes snapshots, each followed by its manifests, and
+// advisories, as data set files and CSV exports do.
+type snapshotWriter interface {
+	WriteSnapshot(sm data.Snapshot) error
+	WriteManifest(mm data.Manifest) error
+	WriteAdvisory(adv advisories.Advisory) error
+}
+
+// generateTo writes snapshots generated as the generation flags configure,
+// and advisories against them, to w, returning how many manifests and
+// advisories it wrote.
+func generateTo(ctx context.Context, lgr *slog.Logger, w snapshotWriter) (int, int) {
+	// as in seed, only the canonical snapshot's header and the manifests
+	// sampled for advisories outlive each snapshot
+	var first *data.Snapshot
+	sample := &manifestSample{size: numAdvisories, r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	var manifests int
+	for i := 0; i < numSnapshots; i++ {
+		var base *data.Snapshot
+		if canonical && i > 0 {
+			base = first
+		}
+
+		snap, ms, errs := data.StreamSnapshot(ctx, lgr, base, numManifests, maxDependencies, generateBuffer)
+		check(w.WriteSnapshot(snap), "writing snapshot")
+		for mm := range ms {
+			check(w.WriteManifest(mm), "writing manifest")
+			sample.add(mm)
+			manifests++
+		}
+		check(<-errs, "generating snapshot")
+
+		if canonical && first == nil {
+			first = &snap
+		}
+	}
+
+	if numAdvisories == 0 {
+		return manifests, 0
+	}
+	advs, err := data.GenerateAdvisories(ctx, lgr, []data.Snapshot{{Manifests: sample.manifests}}, numAdvisories)
+	check(err, "generating synthetic advisories")
+	for _, adv := range advs {
+		check(w.WriteAdvisory(adv), "writing advisory")
+	}
+	return manifests, len(advs)
+}
+
+// exportCSV writes generated snapshots, or those of data set files, as a CSV
+// file per table for bulk loading
+func exportCSV(args []string) {
+	exportCSVFlags.Parse(args)
+	ctx := context.Background()
+	lgr := exportCSVLog.logger()
+
+	if exportDir == "" {
+		fmt.Fprintln(exportCSVFlags.Output(), "export-csv: -o is required")
+		exportCSVFlags.Usage()
+		os.Exit(2)
+	}
+
+	e, err := data.NewCSVExporter(exportDir, exportKeyspace, exportDialect)
+	check(err, "creating CSV export")
+
+	start := time.Now()
+	if exportCSVFlags.NArg() == 0 {
+		manifests, advs := generateTo(ctx, lgr, e)
+		lgr.Info("Generated snapshots", "snapshots", numSnapshots, "manifests", manifests, "advisories", advs)
+	}
+	for _, path := range exportCSVFlags.Args() {
+		copyDataSet(lgr, path, e)
+	}
+
+	check(e.Close(), "writing CSV export")
+	lgr.Info("Exported CSV files", "dir", exportDir, "keyspace", exportKeyspace, "dialect", exportDialect, "duration", time.Since(start))
+}
+
+// copyDataSet writes the records of the data set at path to w.
+func copyDataSet(lgr *slog.Logger, path string, w snapshotWriter) {
+	r, err := dataset.Open(path)
+	check(err, "opening data set")
+	defer r.Close()
+
+	var snapshots int
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			lgr.Info("Read data set", "path", path, "snapshots", snapshots)
+			return
+		}
+		check(err, "reading data set "+path)
+
+		switch {
+		case rec.Snapshot != nil:
+			check(w.WriteSnapshot(*rec.Snapshot), "writing snapshot")
+			snapshots++
+		case rec.Manifest != nil:
+			check(w.WriteManifest(*rec.Manifest), "writing manifest")
+		case rec.Advisory != nil:
+			check(w.WriteAdvisory(*rec.Advisory), "writing advisory")
+		}
+	}
+}
+
+// load writes the snapshots and advisories of data set files into Cassandra,
+// as seed writes generated ones
+func load(args []string) {
+	loadFlags.Parse(args)
+	ctx := context.Background()
+	lgr := loadLog.logger()
+	defer loadTrace.setup(ctx, lgr, "dsapi-load")()
+
+	if loadFlags.NArg() == 0 {
+		loadFlags.Usage()
+		os.Exit(2)
+	}
+	serveMetrics(lgr)
+
+	sesh, err := data.CreateClient(ctx, lgr, metrics.Observe, tracing.Observe)
+	check(err, "creating gocql.Session")
+
+	variants, keyspaces := setupVariants(ctx, lgr, sesh, seedVariants)
+
+	coord := data.NewCoordinator(ctx, loadLimits())
+	start := time.Now()
+	var snapshots int
+	var advs []advisories.Advisory
+	for _, path := range loadFlags.Args() {
+		n, fileAdvs := loadDataSet(coord, lgr, sesh, variants, keyspaces, path)
+		snapshots += n
+		advs = append(advs, fileAdvs...)
+	}
+	check(coord.Wait(), "ingesting data sets into Cassandra")
+	writes, rate := coord.Writes()
+	lgr.Info("Ingested data sets", "snapshots", snapshots, "keyspaces", strings.Join(keyspaces, ","),
+		"duration", time.Since(start), "writes", writes, "writes_per_sec", rate)
+
+	if len(advs) > 0 {
+		writeAdvisories(ctx, lgr, sesh, keyspaces, advs)
+	}
+}
+
+// loadDataSet reads the data set at path, loading each snapshot into every
+// variant as its manifests are read, and returns how many snapshots it read
+// along with its advisories. Reading stops early once any load fails.
+func loadDataSet(coord *data.Coordinator, lgr *slog.Logger, sesh *gocql.Session,
+	variants []data.Variant, keyspaces []string, path string) (int, []advisories.Advisory) {
+
+	r, err := dataset.Open(path)
+	check(err, "opening data set")
+	defer r.Close()
+
+	var snapshots int
+	var advs []advisories.Advisory
+	var out chan data.Manifest
+	defer func() {
+		if out != nil {
+			close(out)
+		}
+	}()
+
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			lgr.Info("Read data set", "path", path, "snapshots", snapshots, "advisories", len(advs))
+			return snapshots, advs
+		}
+		check(err, "reading data set "+path)
+
+		switch {
+		case rec.Snapshot != nil:
+			if out != nil {
+				close(out)
+			}
+			snap, manifests := *rec.Snapshot, make(chan data.Manifest, seedBuffer)
+			out = manifests
+			coord.Go(func(ctx context.Context) error {
+				return loadVariants(ctx, coord, lgr, sesh, variants, keyspaces, snap, manifests, seedBuffer, nil)
+			})
+			snapshots++
+		case rec.Manifest != nil:
+			select {
+			case out <- *rec.Manifest:
+			case <-coord.Done():
+				return snapshots, advs
+			}
+		case rec.Advisory != nil:
+			advs = append(advs, *rec.Advisory)
+		}
+	}
+}
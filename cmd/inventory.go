@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/metrics"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
+)
+
+var (
+	reconcileFlags = flag.NewFlagSet("reconcile-counts", flag.ExitOnError)
+	reconcileLog   = addLogFlags(reconcileFlags)
+	reconcileTrace = addTraceFlags(reconcileFlags)
+
+	reconcileVariant string
+	reconcileOwner   uint
+)
+
+func init() {
+	reconcileFlags.StringVar(&reconcileVariant, "variant", data.DefaultVariant, "schema variant whose keyspace to repair")
+	reconcileFlags.UintVar(&reconcileOwner, "owner", 0, "owner ID whose inventory counts to recount")
+
+	commands["reconcile-counts"] = reconcileCounts
+}
+
+// reconcileCounts recounts the owner's ecosystem and license counters from
+// its inventory rows, printing the repaired counts as JSON. Stop any loads
+// into the keyspace first.
+func reconcileCounts(args []string) {
+	reconcileFlags.Parse(args)
+	ctx := context.Background()
+	lgr := reconcileLog.logger()
+	defer reconcileTrace.setup(ctx, lgr, "dsapi-reconcile-counts")()
+
+	if reconcileOwner == 0 {
+		reconcileFlags.Usage()
+		os.Exit(2)
+	}
+
+	sesh, err := data.CreateClient(ctx, lgr, metrics.Observe, tracing.Observe)
+	check(err, "creating gocql.Session")
+
+	variant, err := data.LookupVariant(reconcileVariant)
+	check(err, "selecting schema variant")
+
+	counts, err := data.ReconcileOwnerCounts(ctx, lgr, sesh, variant.Keyspace(data.Keyspace), reconcileOwner)
+	check(err, "reconciling owner inventory counts")
+
+	jsn, _ := json.MarshalIndent(counts, "", "\t")
+	fmt.Println(string(jsn))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/metrics"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
+
+	"github.com/gocql/gocql"
+)
+
+var (
+	licensesFlags = flag.NewFlagSet("licenses", flag.ExitOnError)
+	licensesLog   = addLogFlags(licensesFlags)
+	licensesTrace = addTraceFlags(licensesFlags)
+
+	repositoryID uint
+	ref          string
+	snapshotID   string
+)
+
+func init() {
+	licensesFlags.UintVar(&repositoryID, "repo", 0, "repository ID of the snapshot")
+	licensesFlags.StringVar(&ref, "ref", "refs/heads/main", "Git ref of the snapshot")
+	licensesFlags.StringVar(&snapshotID, "snapshot", "", "ID of the snapshot to evaluate")
+	licensesFlags.StringVar(&policyPath, "policy", "", "path to a JSON license policy ({\"allow\": [...], \"deny\": [...]}), defaults to flagging invalid licenses only")
+
+	commands["licenses"] = licenseViolations
+}
+
+// licenseViolations prints the snapshot's license policy violations as JSON
+func licenseViolations(args []string) {
+	licensesFlags.Parse(args)
+	ctx := context.Background()
+	lgr := licensesLog.logger()
+	defer licensesTrace.setup(ctx, lgr, "dsapi-licenses")()
+
+	snapID, err := gocql.ParseUUID(snapshotID)
+	if err != nil || repositoryID == 0 {
+		licensesFlags.Usage()
+		os.Exit(2)
+	}
+	policy := loadPolicy(policyPath)
+
+	sesh, err := data.CreateClient(ctx, lgr, metrics.Observe, tracing.Observe)
+	check(err, "creating gocql.Session")
+
+	violations, err := data.SnapshotLicenseViolations(ctx, lgr, sesh, data.Keyspace, repositoryID, ref, snapID, policy)
+	check(err, "evaluating license policy")
+
+	jsn, _ := json.MarshalIndent(violations, "", "\t")
+	fmt.Println(string(jsn))
+}
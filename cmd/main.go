@@ -2,73 +2,93 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"log"
-	"time"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
 
-	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/logging"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
 )
 
-var (
-	verbose         bool
-	canonical       bool
-	numSnapshots    int
-	numManifests    int
-	maxDependencies int
-)
-
-func init() {
-	flag.BoolVar(&verbose, "v", false, "verbose logging")
-	flag.BoolVar(&canonical, "c", false, "generate series of related snapshots (generate a canonical + historicals)")
-	flag.IntVar(&numSnapshots, "s", 1, "number of snapshots to generate and write to Cassandra")
-	flag.IntVar(&numManifests, "m", 20, "number of manifests to generate per snapshot")
-	flag.IntVar(&maxDependencies, "d", 200, "max number of dependencies per manifest to generate")
-}
+// commands maps each subcommand name to its entry point, which receives the
+// arguments following the subcommand name
+var commands = map[string]func(args []string){}
 
 func main() {
-	flag.Parse()
-	ctx := context.Background()
-	lgr := log.Default()
+	// with no subcommand (or only flags) fall back to "seed" so existing
+	// invocations like `bin/seed -c -s 5` keep working
+	cmd, args := "seed", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
 
-	var snapshots []data.Snapshot
-	start := time.Now()
-	for i := 0; i < numSnapshots; i++ {
-		var snap data.Snapshot
-		var err error
-		if canonical && i > 0 {
-			snap, err = data.GenerateSnapshot(ctx, lgr, &snapshots[0], numManifests, maxDependencies)
-			check(err, "generating canonical snapshot series")
-		} else {
-			snap, err = data.GenerateSnapshot(ctx, lgr, nil, numManifests, maxDependencies)
-			check(err, "generating unique snapshots")
-		}
-		snapshots = append(snapshots, snap)
+	run, ok := commands[cmd]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q, expected one of: %s\n", cmd, strings.Join(commandNames(), ", "))
+		os.Exit(2)
 	}
-	dur := time.Since(start)
-	lgr.Printf("Generated %d snapshots in %s", len(snapshots), dur)
+	run(args)
+}
 
-	sesh, err := data.CreateClient(ctx, lgr)
-	check(err, "creating gocql.Session")
+func commandNames() []string {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
-	err = data.CreateKeyspace(ctx, lgr, sesh, data.Keyspace)
-	check(err, "creating keyspace")
+// logFlags are the logging flags shared by every command.
+type logFlags struct {
+	verbose bool
+	format  string
+}
 
-	err = data.CreateTables(ctx, lgr, sesh, data.Keyspace)
-	check(err, "creating tables")
+func addLogFlags(fs *flag.FlagSet) *logFlags {
+	lf := &logFlags{}
+	fs.BoolVar(&lf.verbose, "v", false, "verbose (debug) logging, including per-manifest detail")
+	fs.StringVar(&lf.format, "log-format", "text", "log output format: "+strings.Join(logging.Formats, " or "))
+	return lf
+}
+
+// logger returns a logger writing to stderr as the flags configure.
+func (lf *logFlags) logger() *slog.Logger {
+	lgr, err := logging.New(os.Stderr, lf.format, lf.verbose)
+	check(err, "configuring logging")
+	return lgr
+}
+
+// traceFlags are the tracing flags shared by the commands talking to Cassandra.
+type traceFlags struct {
+	exporter string
+	endpoint string
+}
+
+func addTraceFlags(fs *flag.FlagSet) *traceFlags {
+	tf := &traceFlags{}
+	fs.StringVar(&tf.exporter, "trace", "", "export OpenTelemetry spans with: "+strings.Join(tracing.Exporters, " or ")+" (disabled if empty)")
+	fs.StringVar(&tf.endpoint, "trace-endpoint", "", "OTLP/HTTP collector host:port (default from OTEL_EXPORTER_OTLP_ENDPOINT, else localhost:4318)")
+	return tf
+}
 
-	start = time.Now()
-	for _, snap := range snapshots {
-		if verbose {
-			jsn, _ := json.MarshalIndent(&snap, "", "\t")
-			fmt.Printf("\n%s\n", string(jsn))
+// setup installs the selected exporter, if any, returning a function that
+// flushes buffered spans before exiting.
+func (tf *traceFlags) setup(ctx context.Context, lgr *slog.Logger, service string) func() {
+	if tf.exporter == "" {
+		return func() {}
+	}
+	shutdown, err := tracing.Setup(ctx, service, tf.exporter, tf.endpoint)
+	check(err, "configuring tracing")
+	lgr.Info("Exporting traces", "exporter", tf.exporter, "endpoint", tf.endpoint)
+	return func() {
+		if err := shutdown(context.Background()); err != nil {
+			lgr.Warn("Flushing traces failed", "error", err)
 		}
-		err = data.Load(ctx, lgr, sesh, snap, data.Keyspace)
-		check(err, "ingesting snapshot into Cassandra")
 	}
-	dur = time.Since(start)
-	lgr.Printf("Ingested %d snapshots into Cassandra in %s", len(snapshots), dur)
 }
 
 func check(err error, msg string) {
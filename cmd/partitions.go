@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/metrics"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
+)
+
+var (
+	partitionsFlags = flag.NewFlagSet("partitions", flag.ExitOnError)
+	partitionsLog   = addLogFlags(partitionsFlags)
+	partitionsTrace = addTraceFlags(partitionsFlags)
+
+	partitionsVariant string
+	partitionsTables  string
+	partitionsOutput  string
+	partitionLimits   data.PartitionLimits
+)
+
+func init() {
+	partitionsFlags.StringVar(&partitionsVariant, "variant", data.DefaultVariant, "schema variant whose keyspace to scan")
+	partitionsFlags.StringVar(&partitionsTables, "tables", "dependent_repositories,dependent_repository_counts", "comma-separated tables to scan")
+	partitionsFlags.IntVar(&partitionLimits.Top, "top", 10, "number of largest partitions to report per table")
+	partitionsFlags.Int64Var(&partitionLimits.MaxRows, "max-rows", 0, "stop scanning each table after this many rows (default unlimited)")
+	partitionsFlags.Int64Var(&partitionLimits.WarnRows, "warn-rows", 100000, "warn about partitions with more rows than this (0 disables)")
+	partitionsFlags.Int64Var(&partitionLimits.WarnBytes, "warn-bytes", 100<<20, "warn about partitions larger than this many bytes (0 disables)")
+	partitionsFlags.StringVar(&partitionsOutput, "o", "", "path to also write the JSON reports to")
+
+	commands["partitions"] = runPartitions
+}
+
+// runPartitions exits with status 1 when any partition is past a warning
+// threshold, so it can gate seeding larger data sets.
+func runPartitions(args []string) {
+	partitionsFlags.Parse(args)
+	ctx := context.Background()
+	lgr := partitionsLog.logger()
+	defer partitionsTrace.setup(ctx, lgr, "dsapi-partitions")()
+
+	sesh, err := data.CreateClient(ctx, lgr, metrics.Observe, tracing.Observe)
+	check(err, "creating gocql.Session")
+
+	variant, err := data.LookupVariant(partitionsVariant)
+	check(err, "selecting schema variant")
+	keyspace := variant.Keyspace(data.Keyspace)
+
+	estimates, err := data.TableSizes(ctx, lgr, sesh, keyspace)
+	check(err, "reading table size estimates")
+
+	var reports []data.PartitionReport
+	oversized := int64(0)
+	for _, table := range strings.Split(partitionsTables, ",") {
+		report, err := data.ScanPartitions(ctx, lgr, sesh, keyspace, strings.TrimSpace(table), partitionLimits)
+		check(err, "scanning partitions of "+table)
+		reports = append(reports, report)
+		oversized += report.Oversized
+
+		printPartitionReport(report, estimates[report.Table])
+	}
+
+	if partitionsOutput != "" {
+		out, err := os.Create(partitionsOutput)
+		check(err, "creating output file")
+		defer out.Close()
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		check(enc.Encode(reports), "writing partition reports")
+	}
+
+	if oversized > 0 {
+		fmt.Printf("%d partitions exceed %d rows or %d bytes\n", oversized, partitionLimits.WarnRows, partitionLimits.WarnBytes)
+		os.Exit(1)
+	}
+}
+
+func printPartitionReport(report data.PartitionReport, estimate data.TableSize) {
+	fmt.Printf("%s.%s: %d partitions, %d rows, %d bytes measured",
+		partitionsVariant, report.Table, report.Partitions, report.Rows, report.Bytes)
+	if report.Partitions > 0 {
+		fmt.Printf(" (mean %d rows, %d bytes)", report.Rows/report.Partitions, report.Bytes/report.Partitions)
+	}
+	if report.Truncated {
+		fmt.Printf(", stopped at -max-rows")
+	}
+	fmt.Printf("\n  size_estimates: %d partitions, mean %d bytes\n", estimate.Partitions, estimate.MeanPartitionSize)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "  PARTITION (%s)\tROWS\tBYTES\t\n", strings.Join(report.KeyColumns, ", "))
+	for _, p := range report.Largest {
+		warning := ""
+		if partitionLimits.Exceeds(p) {
+			warning = "WARNING: oversized"
+		}
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%s\n", p.Key, p.Rows, p.Bytes, warning)
+	}
+	w.Flush()
+}
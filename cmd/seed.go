@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/advisories"
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/metrics"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
+
+	"github.com/gocql/gocql"
+	"golang.org/x/sync/errgroup"
+)
+
+var (
+	seedFlags = flag.NewFlagSet("seed", flag.ExitOnError)
+	seedLog   = addLogFlags(seedFlags)
+	seedTrace = addTraceFlags(seedFlags)
+
+	canonical       bool
+	numSnapshots    int
+	numManifests    int
+	maxDependencies int
+	numAdvisories   int
+	seedVariants    string
+	seedBuffer      int
+	seedParallel    int
+	seedWorkers     int
+	seedInFlight    int
+	seedWriteRate   float64
+	metricsAddr     string
+)
+
+func init() {
+	addGenerateFlags(seedFlags)
+	addLoadFlags(seedFlags)
+
+	commands["seed"] = seed
+}
+
+// addGenerateFlags registers the flags shaping the generated data, shared by
+// seed and generate.
+func addGenerateFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&canonical, "c", false, "generate series of related snapshots (generate a canonical + historicals)")
+	fs.IntVar(&numSnapshots, "s", 1, "number of snapshots to generate")
+	fs.IntVar(&numManifests, "m", 20, "number of manifests to generate per snapshot")
+	fs.IntVar(&maxDependencies, "d", 200, "max number of dependencies per manifest to generate")
+	fs.IntVar(&numAdvisories, "a", 0, "number of synthetic advisories to generate against the generated dependencies")
+}
+
+// addLoadFlags registers the flags shaping how data is loaded, shared by seed
+// and load.
+func addLoadFlags(fs *flag.FlagSet) {
+	fs.StringVar(&seedVariants, "variants", data.DefaultVariant, "comma-separated schema variants to load the same snapshots into, each in its own keyspace")
+	fs.IntVar(&seedBuffer, "buffer", 16, "max manifests buffered per schema variant awaiting loading")
+	fs.IntVar(&seedParallel, "p", 1, "number of snapshots to load concurrently")
+	fs.IntVar(&seedWorkers, "workers", 8, "number of each snapshot's manifests to write concurrently, per schema variant")
+	fs.IntVar(&seedInFlight, "in-flight", 2000, "max statements executing at once across all snapshots, counting each statement of a batch, 0 for no limit")
+	fs.Float64Var(&seedWriteRate, "write-rate", 0, "max statements started per second across all snapshots, counting each statement of a batch, 0 for no limit")
+	fs.StringVar(&metricsAddr, "metrics-addr", "", "address to serve Prometheus metrics on at /metrics while loading, e.g. :9100")
+}
+
+// loadLimits are the limits set by the load flags.
+func loadLimits() data.LoadLimits {
+	return data.LoadLimits{
+		Snapshots: seedParallel,
+		Workers:   seedWorkers,
+		InFlight:  seedInFlight,
+		Rate:      seedWriteRate,
+	}
+}
+
+// serveMetrics serves the Prometheus metrics in the background if the
+// -metrics-addr flag is set.
+func serveMetrics(lgr *slog.Logger) {
+	if metricsAddr == "" {
+		return
+	}
+	lgr.Info("Serving metrics", "addr", metricsAddr, "path", "/metrics")
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		check(http.ListenAndServe(metricsAddr, mux), "serving metrics")
+	}()
+}
+
+func seed(args []string) {
+	seedFlags.Parse(args)
+	ctx := context.Background()
+	lgr := seedLog.logger()
+	defer seedTrace.setup(ctx, lgr, "dsapi-seed")()
+
+	serveMetrics(lgr)
+
+	sesh, err := data.CreateClient(ctx, lgr, metrics.Observe, tracing.Observe)
+	check(err, "creating gocql.Session")
+
+	variants, keyspaces := setupVariants(ctx, lgr, sesh, seedVariants)
+
+	// each snapshot is generated once and its manifests loaded into every
+	// variant as they are generated; only the canonical snapshot's header and
+	// the manifests sampled for advisories outlive the snapshot
+	coord := data.NewCoordinator(ctx, loadLimits())
+	var first *data.Snapshot
+	sample := &manifestSample{size: numAdvisories, r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	start := time.Now()
+	for i := 0; i < numSnapshots; i++ {
+		var base *data.Snapshot
+		if canonical && i > 0 {
+			base = first
+		}
+
+		headers := make(chan data.Snapshot, 1)
+		coord.Go(func(ctx context.Context) error {
+			return seedSnapshot(ctx, coord, lgr, sesh, variants, keyspaces, base, sample, headers)
+		})
+		// the rest of a canonical series are based on the first snapshot
+		if canonical && first == nil {
+			snap := <-headers
+			first = &snap
+		}
+	}
+	check(coord.Wait(), "generating and ingesting snapshots into Cassandra")
+	dur := time.Since(start)
+	writes, rate := coord.Writes()
+	lgr.Info("Generated and ingested snapshots", "snapshots", numSnapshots, "keyspaces", strings.Join(keyspaces, ","),
+		"duration", dur, "writes", writes, "writes_per_sec", rate)
+
+	if numAdvisories > 0 {
+		advs, err := data.GenerateAdvisories(ctx, lgr, []data.Snapshot{{Manifests: sample.manifests}}, numAdvisories)
+		check(err, "generating synthetic advisories")
+
+		writeAdvisories(ctx, lgr, sesh, keyspaces, advs)
+	}
+}
+
+// writeAdvisories writes the advisories into each of the keyspaces.
+func writeAdvisories(ctx context.Context, lgr *slog.Logger, sesh *gocql.Session, keyspaces []string, advs []advisories.Advisory) {
+	for _, keyspace := range keyspaces {
+		for _, adv := range advs {
+			err := data.WriteAdvisory(ctx, lgr, sesh, keyspace, adv)
+			check(err, "ingesting advisory into Cassandra")
+		}
+		lgr.Info("Ingested advisories", "advisories", len(advs), "keyspace", keyspace)
+	}
+}
+
+// setupVariants looks up the comma-separated schema variants, creating each
+// one's keyspace and tables, and returns them along with their keyspaces.
+func setupVariants(ctx context.Context, lgr *slog.Logger, sesh *gocql.Session, names string) ([]data.Variant, []string) {
+	var variants []data.Variant
+	var keyspaces []string
+	for _, name := range strings.Split(names, ",") {
+		variant, err := data.LookupVariant(strings.TrimSpace(name))
+		check(err, "selecting schema variant")
+		keyspace := variant.Keyspace(data.Keyspace)
+
+		err = data.CreateKeyspace(ctx, lgr, sesh, keyspace)
+		check(err, "creating keyspace")
+
+		err = variant.CreateTables(ctx, lgr, sesh, keyspace)
+		check(err, "creating tables")
+
+		variants = append(variants, variant)
+		keyspaces = append(keyspaces, keyspace)
+	}
+	return variants, keyspaces
+}
+
+// seedSnapshot generates a snapshot, sending its header on headers, and loads
+// its manifests into each of the variants' keyspaces as they are generated.
+func seedSnapshot(ctx context.Context, coord *data.Coordinator, lgr *slog.Logger, sesh *gocql.Session,
+	variants []data.Variant, keyspaces []string, base *data.Snapshot, sample *manifestSample, headers chan<- data.Snapshot) error {
+
+	// stops generating if loading fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	snap, manifests, errs := data.StreamSnapshot(ctx, lgr, base, numManifests, maxDependencies, seedBuffer)
+	headers <- snap
+	lgr.Debug("Seeding snapshot", "snapshot_id", snap.ID, "repository_id", snap.RepositoryID, "owner_id", snap.OwnerID, "ref", snap.Ref)
+
+	err := loadVariants(ctx, coord, lgr, sesh, variants, keyspaces, snap, manifests, seedBuffer, func(mm data.Manifest) {
+		lgr.Debug("Seeding manifest", "snapshot_id", snap.ID, "manifest_id", mm.ID, "package_manager", mm.PackageManager,
+			"manifest_key", mm.FilePath, "dependencies", len(mm.Runtime)+len(mm.Development)+len(mm.Transitives))
+		sample.add(mm)
+	})
+	if err != nil {
+		return err
+	}
+	if err := <-errs; err != nil {
+		return fmt.Errorf("generating snapshot %s: %s", snap.ID, err)
+	}
+	return nil
+}
+
+// loadVariants loads the snapshot into each of the variants' keyspaces,
+// passing each manifest received to seen, if set, and then on to every
+// variant's load, buffering up to buffer of them per variant.
+func loadVariants(ctx context.Context, coord *data.Coordinator, lgr *slog.Logger, sesh *gocql.Session,
+	variants []data.Variant, keyspaces []string, snap data.Snapshot, manifests <-chan data.Manifest, buffer int, seen func(data.Manifest)) error {
+
+	g, gctx := errgroup.WithContext(ctx)
+	outs := make([]chan data.Manifest, len(variants))
+	for j := range variants {
+		variant, keyspace, out := variants[j], keyspaces[j], make(chan data.Manifest, buffer)
+		outs[j] = out
+		g.Go(func() error {
+			return coord.LoadStream(gctx, lgr, sesh, variant, snap, out, keyspace)
+		})
+	}
+	g.Go(func() error {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+
+		for mm := range manifests {
+			if seen != nil {
+				seen(mm)
+			}
+			for _, out := range outs {
+				select {
+				case out <- mm:
+				case <-gctx.Done():
+					return gctx.Err()
+				}
+			}
+		}
+		return nil
+	})
+
+	return g.Wait()
+}
+
+// manifestSample keeps a uniform random sample of up to size of the manifests
+// with dependencies passed to add (reservoir sampling), for generating
+// advisories against without holding every generated manifest.
+type manifestSample struct {
+	mu        sync.Mutex
+	size      int
+	seen      int
+	r         *rand.Rand
+	manifests []data.Manifest
+}
+
+func (ms *manifestSample) add(mm data.Manifest) {
+	if ms.size == 0 || len(mm.Runtime)+len(mm.Development)+len(mm.Transitives) == 0 {
+		return
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.seen++
+	if len(ms.manifests) < ms.size {
+		ms.manifests = append(ms.manifests, mm)
+		return
+	}
+	if i := ms.r.Intn(ms.seen); i < ms.size {
+		ms.manifests[i] = mm
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"net/http"
+
+	"github.com/elireisman/cass-dsapi/internal/api"
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/licenses"
+	"github.com/elireisman/cass-dsapi/internal/metrics"
+	"github.com/elireisman/cass-dsapi/internal/paging"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
+)
+
+var (
+	serveFlags = flag.NewFlagSet("serve", flag.ExitOnError)
+	serveLog   = addLogFlags(serveFlags)
+	serveTrace = addTraceFlags(serveFlags)
+
+	listenAddr   string
+	policyPath   string
+	pageSize     int
+	pageTokenKey string
+)
+
+func init() {
+	serveFlags.StringVar(&listenAddr, "addr", ":8080", "address for the API server to listen on")
+	serveFlags.StringVar(&policyPath, "policy", "", "path to a JSON license policy ({\"allow\": [...], \"deny\": [...]}), defaults to flagging invalid licenses only")
+
+	serveFlags.IntVar(&pageSize, "page-size", 100, "default number of rows per page of list endpoints")
+	serveFlags.StringVar(&pageTokenKey, "page-key", "", "hex-encoded key signing page tokens, random if unset (tokens then expire on restart)")
+
+	commands["serve"] = serve
+}
+
+func serve(args []string) {
+	serveFlags.Parse(args)
+	ctx := context.Background()
+	lgr := serveLog.logger()
+	defer serveTrace.setup(ctx, lgr, "dsapi-serve")()
+
+	policy := loadPolicy(policyPath)
+
+	sesh, err := data.CreateClient(ctx, lgr, metrics.Observe, tracing.Observe)
+	check(err, "creating gocql.Session")
+
+	key, err := hex.DecodeString(pageTokenKey)
+	check(err, "decoding page token key")
+	if len(key) == 0 {
+		key, err = paging.RandomKey()
+		check(err, "generating page token key")
+	}
+
+	srv := api.NewServer(lgr, sesh, api.Config{
+		Keyspace:        data.Keyspace,
+		Policy:          policy,
+		PageTokenKey:    key,
+		DefaultPageSize: pageSize,
+	})
+	lgr.Info("API server listening", "addr", listenAddr)
+	check(http.ListenAndServe(listenAddr, srv.Handler()), "serving API")
+}
+
+func loadPolicy(path string) licenses.Policy {
+	if path == "" {
+		return licenses.Policy{}
+	}
+	policy, err := licenses.LoadPolicy(path)
+	check(err, "loading license policy")
+
+	return policy
+}
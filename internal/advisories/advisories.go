@@ -0,0 +1,210 @@
+package advisories
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Advisory is a security advisory reduced to the fields needed to find the
+// repositories it affects.
+type Advisory struct {
+	ID        string
+	Summary   string
+	Details   string
+	Severity  string
+	Aliases   []string
+	Published time.Time
+	Modified  time.Time
+	Affected  []AffectedPackage
+}
+
+// AffectedPackage identifies a package in the same terms as data.Dependency
+// and lists the version ranges (see versions.ParseRange) in which it is
+// vulnerable.
+type AffectedPackage struct {
+	PackageManager string
+	Namespace      string
+	Name           string
+	Ranges         []string
+}
+
+// OSV ecosystem names mapped to the package managers used throughout this repo
+var ecosystems = map[string]string{
+	"npm":       "npm",
+	"PyPI":      "pip",
+	"crates.io": "cargo",
+	"Pub":       "pub",
+	"Maven":     "maven",
+	"RubyGems":  "gem",
+}
+
+// https://ossf.github.io/osv-schema/
+type osvAdvisory struct {
+	ID        string    `json:"id"`
+	Summary   string    `json:"summary"`
+	Details   string    `json:"details"`
+	Aliases   []string  `json:"aliases"`
+	Published time.Time `json:"published"`
+	Modified  time.Time `json:"modified"`
+	Severity  []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	Affected []struct {
+		Package struct {
+			Ecosystem string `json:"ecosystem"`
+			Name      string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string              `json:"type"`
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+		Versions []string `json:"versions"`
+	} `json:"affected"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+// ParseOSV decodes OSV-format JSON, which may hold a single advisory object or
+// an array of them. Affected packages from ecosystems this repo does not model
+// and GIT commit ranges are dropped.
+func ParseOSV(r io.Reader) ([]Advisory, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []osvAdvisory
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &docs); err != nil {
+			return nil, fmt.Errorf("decoding OSV advisory list: %s", err)
+		}
+	} else {
+		var doc osvAdvisory
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, fmt.Errorf("decoding OSV advisory: %s", err)
+		}
+		docs = append(docs, doc)
+	}
+
+	var out []Advisory
+	for _, doc := range docs {
+		if doc.ID == "" {
+			return nil, fmt.Errorf("OSV advisory is missing an id")
+		}
+		out = append(out, fromOSV(doc))
+	}
+
+	return out, nil
+}
+
+func fromOSV(doc osvAdvisory) Advisory {
+	adv := Advisory{
+		ID:        doc.ID,
+		Summary:   doc.Summary,
+		Details:   doc.Details,
+		Severity:  doc.DatabaseSpecific.Severity,
+		Aliases:   doc.Aliases,
+		Published: doc.Published,
+		Modified:  doc.Modified,
+	}
+	if adv.Severity == "" && len(doc.Severity) > 0 {
+		adv.Severity = doc.Severity[0].Score
+	}
+
+	for _, aff := range doc.Affected {
+		pkgMgr, ok := ecosystems[aff.Package.Ecosystem]
+		if !ok {
+			continue
+		}
+		ns, name := splitPackageName(pkgMgr, aff.Package.Name)
+
+		var ranges []string
+		for _, rng := range aff.Ranges {
+			if rng.Type == "SEMVER" || rng.Type == "ECOSYSTEM" {
+				ranges = append(ranges, eventsToRanges(rng.Events)...)
+			}
+		}
+		// fall back to the enumerated versions when only GIT ranges are given
+		if len(ranges) == 0 {
+			for _, v := range aff.Versions {
+				ranges = append(ranges, "="+v)
+			}
+		}
+		if len(ranges) == 0 {
+			continue
+		}
+
+		adv.Affected = append(adv.Affected, AffectedPackage{
+			PackageManager: pkgMgr,
+			Namespace:      ns,
+			Name:           name,
+			Ranges:         ranges,
+		})
+	}
+
+	return adv
+}
+
+// eventsToRanges converts an ordered OSV event list into range expressions:
+// [introduced: 1.0.0, fixed: 1.2.3, introduced: 2.0.0] => [">=1.0.0 <1.2.3", ">=2.0.0"]
+func eventsToRanges(events []map[string]string) []string {
+	var out []string
+	var introduced string
+	var open bool
+
+	for _, event := range events {
+		switch {
+		case event["introduced"] != "":
+			introduced, open = event["introduced"], true
+		case event["fixed"] != "" && open:
+			out = append(out, joinRange(introduced, "<"+event["fixed"]))
+			open = false
+		case event["limit"] != "" && open:
+			out = append(out, joinRange(introduced, "<"+event["limit"]))
+			open = false
+		case event["last_affected"] != "" && open:
+			out = append(out, joinRange(introduced, "<="+event["last_affected"]))
+			open = false
+		}
+	}
+	if open {
+		out = append(out, joinRange(introduced, ""))
+	}
+
+	return out
+}
+
+func joinRange(introduced, upper string) string {
+	// "0" is OSV's marker for "all versions before the upper bound"
+	if introduced == "0" {
+		if upper == "" {
+			return ">=0"
+		}
+		return upper
+	}
+	return strings.TrimSpace(">=" + introduced + " " + upper)
+}
+
+// splitPackageName maps an OSV package name onto the namespace/name pair
+// used by data.Dependency.
+func splitPackageName(pkgMgr, pkg string) (string, string) {
+	switch pkgMgr {
+	case "npm":
+		if strings.HasPrefix(pkg, "@") {
+			if ns, name, ok := strings.Cut(pkg[1:], "/"); ok {
+				return ns, name
+			}
+		}
+	case "maven":
+		if ns, name, ok := strings.Cut(pkg, ":"); ok {
+			return ns, name
+		}
+	}
+	return "", pkg
+}
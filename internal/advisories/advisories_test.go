@@ -0,0 +1,80 @@
+package advisories
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const ghsaExample = `{
+  "id": "GHSA-xxxx-yyyy-zzzz",
+  "summary": "Prototype pollution in left-pad",
+  "aliases": ["CVE-2026-0001"],
+  "published": "2026-01-02T03:04:05Z",
+  "modified": "2026-01-03T03:04:05Z",
+  "database_specific": {"severity": "HIGH"},
+  "affected": [
+    {
+      "package": {"ecosystem": "npm", "name": "@acme/left-pad"},
+      "ranges": [
+        {"type": "SEMVER", "events": [{"introduced": "0"}, {"fixed": "1.2.3"}, {"introduced": "2.0.0"}, {"last_affected": "2.1.0"}]},
+        {"type": "GIT", "repo": "https://github.com/acme/left-pad", "events": [{"introduced": "abc"}]}
+      ]
+    },
+    {
+      "package": {"ecosystem": "Maven", "name": "org.acme:widgets"},
+      "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "1.0"}]}]
+    },
+    {
+      "package": {"ecosystem": "PyPI", "name": "widgets"},
+      "ranges": [{"type": "GIT", "events": [{"introduced": "abc"}]}],
+      "versions": ["0.9", "0.9.1"]
+    },
+    {
+      "package": {"ecosystem": "Hex", "name": "unsupported"},
+      "ranges": [{"type": "SEMVER", "events": [{"introduced": "0"}]}]
+    }
+  ]
+}`
+
+func TestParseOSV(t *testing.T) {
+	advs, err := ParseOSV(strings.NewReader(ghsaExample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(advs) != 1 {
+		t.Fatalf("expected 1 advisory, got %d", len(advs))
+	}
+
+	adv := advs[0]
+	if adv.ID != "GHSA-xxxx-yyyy-zzzz" || adv.Severity != "HIGH" || adv.Published.Year() != 2026 {
+		t.Errorf("unexpected advisory metadata: %+v", adv)
+	}
+
+	expected := []AffectedPackage{
+		{PackageManager: "npm", Namespace: "acme", Name: "left-pad", Ranges: []string{"<1.2.3", ">=2.0.0 <=2.1.0"}},
+		{PackageManager: "maven", Namespace: "org.acme", Name: "widgets", Ranges: []string{">=1.0"}},
+		{PackageManager: "pip", Namespace: "", Name: "widgets", Ranges: []string{"=0.9", "=0.9.1"}},
+	}
+	if !reflect.DeepEqual(adv.Affected, expected) {
+		t.Errorf("expected affected packages:\n%+v\ngot:\n%+v", expected, adv.Affected)
+	}
+}
+
+func TestParseOSVList(t *testing.T) {
+	advs, err := ParseOSV(strings.NewReader("[" + ghsaExample + "," + ghsaExample + "]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(advs) != 2 {
+		t.Fatalf("expected 2 advisories, got %d", len(advs))
+	}
+}
+
+func TestParseOSVErrors(t *testing.T) {
+	for _, doc := range []string{"", "{", `{"summary": "no id"}`} {
+		if _, err := ParseOSV(strings.NewReader(doc)); err == nil {
+			t.Errorf("expected error parsing %q", doc)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 5000
+)
+
+// PageResponse is the envelope of every list endpoint. NextPageToken is
+// omitted on the last page.
+type PageResponse struct {
+	Items         interface{} `json:"items"`
+	NextPageToken string      `json:"next_page_token,omitempty"`
+}
+
+// pageParams reads the optional page_size and page_token query parameters.
+func (s *Server) pageParams(req *http.Request) (data.Page, error) {
+	params := req.URL.Query()
+	page := data.Page{Size: s.pageSize}
+
+	if raw := params.Get("page_size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size <= 0 || size > maxPageSize {
+			return data.Page{}, fmt.Errorf("page_size must be between 1 and %d", maxPageSize)
+		}
+		page.Size = size
+	}
+
+	state, err := s.pages.Decode(pageScope(req), params.Get("page_token"))
+	if err != nil {
+		return data.Page{}, err
+	}
+	page.State = state
+
+	return page, nil
+}
+
+func (s *Server) writePage(w http.ResponseWriter, req *http.Request, items interface{}, next []byte) {
+	s.writeJSON(w, http.StatusOK, PageResponse{
+		Items:         items,
+		NextPageToken: s.pages.Encode(pageScope(req), next),
+	})
+}
+
+// pageScope binds page tokens to the endpoint and the parameters selecting
+// the rows, so a token can't be replayed against a different query. The page
+// size is left out since it may change from one page to the next.
+func pageScope(req *http.Request) string {
+	params := url.Values{}
+	for k, v := range req.URL.Query() {
+		if k != "page_token" && k != "page_size" {
+			params[k] = v
+		}
+	}
+	return req.URL.Path + "?" + params.Encode()
+}
@@ -0,0 +1,281 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/licenses"
+	"github.com/elireisman/cass-dsapi/internal/metrics"
+	"github.com/elireisman/cass-dsapi/internal/paging"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
+	"github.com/elireisman/cass-dsapi/internal/versions"
+
+	"github.com/gocql/gocql"
+)
+
+// Config holds the server's settings.
+type Config struct {
+	Keyspace string
+
+	// license policy evaluated by /snapshots/license-violations
+	Policy licenses.Policy
+
+	// key signing page tokens, and the page size used when a request doesn't
+	// specify one
+	PageTokenKey    []byte
+	DefaultPageSize int
+}
+
+// Server serves read-only JSON views over the data model.
+type Server struct {
+	lgr      *slog.Logger
+	client   *gocql.Session
+	keyspace string
+	policy   licenses.Policy
+	pages    *paging.Codec
+	pageSize int
+}
+
+func NewServer(lgr *slog.Logger, client *gocql.Session, cfg Config) *Server {
+	pageSize := cfg.DefaultPageSize
+	if pageSize <= 0 || pageSize > maxPageSize {
+		pageSize = defaultPageSize
+	}
+
+	return &Server{
+		lgr:      lgr,
+		client:   client,
+		keyspace: cfg.Keyspace,
+		policy:   cfg.Policy,
+		pages:    paging.NewCodec(cfg.PageTokenKey),
+		pageSize: pageSize,
+	}
+}
+
+// Handler routes the API's endpoints, counting, timing and tracing requests
+// per route, and serves the Prometheus metrics at /metrics.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	handle := func(route string, h http.HandlerFunc) {
+		mux.Handle(route, metrics.InstrumentHandler(route, tracing.Handler(route, h)))
+	}
+	handle("/snapshots/license-violations", s.licenseViolations)
+	handle("/snapshots/manifests", s.snapshotManifests)
+	handle("/manifests/dependencies", s.manifestDependencies)
+	handle("/packages/dependents", s.packageDependents)
+	handle("/owners/inventory", s.ownerInventory)
+	handle("/owners/inventory/summary", s.ownerInventorySummary)
+	mux.Handle("/metrics", metrics.Handler())
+
+	return mux
+}
+
+// GET /snapshots/manifests?repository_id=1&ref=refs/heads/main&snapshot_id=<uuid>[&page_size=100&page_token=...]
+func (s *Server) snapshotManifests(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	repoID, ref, snapID, err := snapshotParams(req)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	page, err := s.pageParams(req)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	manifests, next, err := data.ManifestsForSnapshotPage(req.Context(), s.lgr, s.client, s.keyspace, repoID, ref, snapID, page)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writePage(w, req, manifests, next)
+}
+
+// GET /manifests/dependencies?manifest_id=<uuid>[&page_size=100&page_token=...]
+func (s *Server) manifestDependencies(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	manifestID, err := gocql.ParseUUID(req.URL.Query().Get("manifest_id"))
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid manifest_id: %s", err))
+		return
+	}
+	page, err := s.pageParams(req)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	deps, next, err := data.DependenciesForManifestPage(req.Context(), s.lgr, s.client, s.keyspace, manifestID, page)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writePage(w, req, deps, next)
+}
+
+// GET /packages/dependents?package_manager=npm&namespace=acme&name=left-pad[&range=>=1.0.0 <2.0.0&page_size=100&page_token=...]
+func (s *Server) packageDependents(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	params := req.URL.Query()
+	pkgMgr, ns, name := params.Get("package_manager"), params.Get("namespace"), params.Get("name")
+	if pkgMgr == "" || name == "" {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("package_manager and name are required"))
+		return
+	}
+	rangeExpr := params.Get("range")
+	if rangeExpr != "" {
+		if _, err := versions.ParseRange(pkgMgr, rangeExpr); err != nil {
+			s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid range %q: %s", rangeExpr, err))
+			return
+		}
+	}
+	page, err := s.pageParams(req)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	deps, next, err := data.DependentRepositoriesInRangePage(req.Context(), s.lgr, s.client, s.keyspace, pkgMgr, ns, name, rangeExpr, page)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writePage(w, req, deps, next)
+}
+
+// GET /snapshots/license-violations?repository_id=1&ref=refs/heads/main&snapshot_id=<uuid>
+func (s *Server) licenseViolations(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	repoID, ref, snapID, err := snapshotParams(req)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	violations, err := data.SnapshotLicenseViolations(req.Context(), s.lgr, s.client, s.keyspace, repoID, ref, snapID, s.policy)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, violations)
+}
+
+// GET /owners/inventory?owner_id=1[&page_size=100&page_token=...]
+func (s *Server) ownerInventory(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	ownerID, err := ownerParam(req)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	page, err := s.pageParams(req)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	inventory, next, err := data.OwnerInventoryPage(req.Context(), s.lgr, s.client, s.keyspace, ownerID, page)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writePage(w, req, inventory, next)
+}
+
+// GET /owners/inventory/summary?owner_id=1
+func (s *Server) ownerInventorySummary(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		s.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", req.Method))
+		return
+	}
+
+	ownerID, err := ownerParam(req)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	summary, err := data.OwnerInventoryCounts(req.Context(), s.lgr, s.client, s.keyspace, ownerID)
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, summary)
+}
+
+func ownerParam(req *http.Request) (uint, error) {
+	ownerID, err := strconv.ParseUint(req.URL.Query().Get("owner_id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid owner_id: %s", err)
+	}
+	return uint(ownerID), nil
+}
+
+func snapshotParams(req *http.Request) (uint, string, gocql.UUID, error) {
+	params := req.URL.Query()
+
+	repoID, err := strconv.ParseUint(params.Get("repository_id"), 10, 64)
+	if err != nil {
+		return 0, "", gocql.UUID{}, fmt.Errorf("invalid repository_id: %s", err)
+	}
+	ref := params.Get("ref")
+	if ref == "" {
+		return 0, "", gocql.UUID{}, fmt.Errorf("missing ref")
+	}
+	snapID, err := gocql.ParseUUID(params.Get("snapshot_id"))
+	if err != nil {
+		return 0, "", gocql.UUID{}, fmt.Errorf("invalid snapshot_id: %s", err)
+	}
+
+	return uint(repoID), ref, snapID, nil
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.lgr.Warn("Writing response failed", "error", err)
+	}
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	s.lgr.Log(context.Background(), level, "Request failed", "status", status, "error", err)
+	s.writeJSON(w, status, map[string]string{"error": err.Error()})
+}
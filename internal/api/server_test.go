@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elireisman/cass-dsapi/internal/logging"
+)
+
+// TestParamValidation checks that malformed requests are rejected before any
+// query is issued, so the server needs no cluster.
+func TestParamValidation(t *testing.T) {
+	h := NewServer(logging.Discard(), nil, Config{Keyspace: "ks_test", PageTokenKey: []byte("test")}).Handler()
+
+	const snapshot = "repository_id=1&ref=refs/heads/main&snapshot_id=5a4a1e3c-9c1b-11ee-8c90-0242ac120002"
+	for _, tc := range []struct {
+		method, target string
+		status         int
+		err            string
+	}{
+		{http.MethodPost, "/owners/inventory?owner_id=1", http.StatusMethodNotAllowed, "method POST not allowed"},
+		{http.MethodGet, "/owners/inventory?owner_id=x", http.StatusBadRequest, "invalid owner_id"},
+		{http.MethodGet, "/owners/inventory/summary", http.StatusBadRequest, "invalid owner_id"},
+		{http.MethodGet, "/owners/inventory?owner_id=1&page_size=0", http.StatusBadRequest, "page_size must be between"},
+		{http.MethodGet, "/owners/inventory?owner_id=1&page_token=bogus", http.StatusBadRequest, ""},
+		{http.MethodGet, "/snapshots/manifests?ref=refs/heads/main", http.StatusBadRequest, "invalid repository_id"},
+		{http.MethodGet, "/snapshots/manifests?repository_id=1&snapshot_id=x", http.StatusBadRequest, "missing ref"},
+		{http.MethodGet, "/snapshots/license-violations?repository_id=1&ref=main&snapshot_id=x", http.StatusBadRequest, "invalid snapshot_id"},
+		{http.MethodGet, "/snapshots/manifests?" + snapshot + "&page_size=5001", http.StatusBadRequest, "page_size must be between"},
+		{http.MethodGet, "/manifests/dependencies?manifest_id=x", http.StatusBadRequest, "invalid manifest_id"},
+		{http.MethodGet, "/packages/dependents?package_manager=npm", http.StatusBadRequest, "package_manager and name are required"},
+		{http.MethodGet, "/packages/dependents?package_manager=npm&name=left-pad&range=%3D%3E1.0.0", http.StatusBadRequest, "invalid range"},
+		{http.MethodGet, "/packages/dependents?package_manager=npm&name=left-pad&range=%3E%3D", http.StatusBadRequest, "invalid range"},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.target, nil))
+
+		if rec.Code != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d: %s", tc.method, tc.target, tc.status, rec.Code, rec.Body)
+			continue
+		}
+		var body map[string]string
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Errorf("%s %s: decoding error response: %s", tc.method, tc.target, err)
+			continue
+		}
+		if body["error"] == "" || !strings.Contains(body["error"], tc.err) {
+			t.Errorf("%s %s: expected an error containing %q, got %q", tc.method, tc.target, tc.err, body["error"])
+		}
+	}
+}
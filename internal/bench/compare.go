@@ -0,0 +1,129 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/elireisman/cass-dsapi/internal/stats"
+)
+
+// Comparison describes how a workload's latency changed from a base run to a
+// head run. MedianChange is the relative change in median latency, and P the
+// two-sided Mann-Whitney p-value of the latency samples differing.
+type Comparison struct {
+	Workload       string  `json:"workload"`
+	BaseP50        float64 `json:"base_p50_us"`
+	HeadP50        float64 `json:"head_p50_us"`
+	BaseP99        float64 `json:"base_p99_us"`
+	HeadP99        float64 `json:"head_p99_us"`
+	BaseThroughput float64 `json:"base_ops_per_sec"`
+	HeadThroughput float64 `json:"head_ops_per_sec"`
+	MedianChange   float64 `json:"median_change"`
+	P              float64 `json:"p"`
+	Significant    bool    `json:"significant"`
+	Regression     bool    `json:"regression"`
+}
+
+// LoadReport reads a Report written by the bench command.
+func LoadReport(path string) (Report, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var out Report
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return Report{}, fmt.Errorf("decoding bench report %s: %s", path, err)
+	}
+	if out.Version != ReportVersion {
+		return Report{}, fmt.Errorf("bench report %s has version %d, expected %d", path, out.Version, ReportVersion)
+	}
+
+	return out, nil
+}
+
+// Compare pairs up the workloads run in both reports and tests each for a
+// change in latency. A workload regressed when its samples differ with p below
+// alpha and its median latency grew by more than threshold (e.g. 0.05 for 5%).
+// Workloads without samples are compared on their summaries alone and never
+// flagged. Compare also returns notes on differences between the runs' setups
+// that may explain, or invalidate, the comparison.
+func Compare(base, head Report, threshold, alpha float64) ([]Comparison, []string) {
+	var notes []string
+	if base.Metadata.Variant != head.Metadata.Variant {
+		notes = append(notes, fmt.Sprintf("schema variant changed from %s to %s", base.Metadata.Variant, head.Metadata.Variant))
+	}
+	if base.Metadata.SchemaVersion != head.Metadata.SchemaVersion {
+		notes = append(notes, fmt.Sprintf("schema version changed from %s to %s", base.Metadata.SchemaVersion, head.Metadata.SchemaVersion))
+	}
+	if !reflect.DeepEqual(base.Metadata.DataSet, head.Metadata.DataSet) {
+		notes = append(notes, "data set size estimates differ")
+	}
+	if base.Options.Concurrency != head.Options.Concurrency {
+		notes = append(notes, fmt.Sprintf("concurrency changed from %d to %d", base.Options.Concurrency, head.Options.Concurrency))
+	}
+
+	baseResults := map[string]Result{}
+	for _, r := range base.namedResults() {
+		baseResults[r.Workload] = r
+	}
+
+	var out []Comparison
+	for _, h := range head.namedResults() {
+		b, ok := baseResults[h.Workload]
+		if !ok {
+			notes = append(notes, fmt.Sprintf("workload %s is only in the head run", h.Workload))
+			continue
+		}
+		delete(baseResults, h.Workload)
+
+		cmp := Comparison{
+			Workload:       h.Workload,
+			BaseP50:        b.Latency.P50,
+			HeadP50:        h.Latency.P50,
+			BaseP99:        b.Latency.P99,
+			HeadP99:        h.Latency.P99,
+			BaseThroughput: b.Throughput,
+			HeadThroughput: h.Throughput,
+			P:              1,
+		}
+		if len(b.Samples) > 0 && len(h.Samples) > 0 {
+			baseMedian, headMedian := stats.Median(b.Samples), stats.Median(h.Samples)
+			if baseMedian > 0 {
+				cmp.MedianChange = (headMedian - baseMedian) / baseMedian
+			}
+			cmp.P = stats.MannWhitneyU(b.Samples, h.Samples).P
+			cmp.Significant = cmp.P < alpha
+			cmp.Regression = cmp.Significant && cmp.MedianChange > threshold
+		} else if b.Latency.P50 > 0 {
+			cmp.MedianChange = (h.Latency.P50 - b.Latency.P50) / b.Latency.P50
+		}
+		out = append(out, cmp)
+	}
+	for _, b := range base.namedResults() {
+		if _, missing := baseResults[b.Workload]; missing {
+			notes = append(notes, fmt.Sprintf("workload %s is only in the base run", b.Workload))
+		}
+	}
+
+	return out, notes
+}
+
+// namedResults flattens the report's results, naming those of a mixed run by
+// their phase.
+func (r Report) namedResults() []Result {
+	out := append([]Result{}, r.Results...)
+	if r.Mixed != nil {
+		for _, res := range r.Mixed.Baseline {
+			res.Workload = "mixed-baseline/" + res.Workload
+			out = append(out, res)
+		}
+		for _, res := range r.Mixed.UnderIngest {
+			res.Workload = "mixed-ingest/" + res.Workload
+			out = append(out, res)
+		}
+	}
+	return out
+}
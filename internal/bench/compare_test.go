@@ -0,0 +1,44 @@
+package bench
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	samples := func(scale float64) []float64 {
+		out := make([]float64, 1000)
+		for i := range out {
+			out[i] = 500 + r.ExpFloat64()*scale
+		}
+		sort.Float64s(out)
+		return out
+	}
+
+	base := Report{Version: ReportVersion, Results: []Result{
+		{Workload: "steady", Samples: samples(100)},
+		{Workload: "slower", Samples: samples(100)},
+		{Workload: "dropped", Samples: samples(100)},
+	}}
+	head := Report{Version: ReportVersion, Metadata: Metadata{SchemaVersion: "changed"}, Results: []Result{
+		{Workload: "steady", Samples: samples(100)},
+		{Workload: "slower", Samples: samples(400)},
+		{Workload: "added", Samples: samples(100)},
+	}}
+
+	comparisons, notes := Compare(base, head, 0.05, 0.01)
+	if len(comparisons) != 2 {
+		t.Fatalf("expected 2 comparisons, got %+v", comparisons)
+	}
+	for _, cmp := range comparisons {
+		if regressed := cmp.Workload == "slower"; cmp.Regression != regressed {
+			t.Errorf("expected regression=%t for %s, got %+v", regressed, cmp.Workload, cmp)
+		}
+	}
+	// schema version change and the workloads in only one run
+	if len(notes) != 3 {
+		t.Errorf("expected 3 notes, got %q", notes)
+	}
+}
@@ -0,0 +1,149 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+
+	"github.com/gocql/gocql"
+)
+
+// SnapshotKey identifies a snapshot row.
+type SnapshotKey struct {
+	ID           gocql.UUID
+	OwnerID      uint
+	RepositoryID uint
+	Ref          string
+}
+
+// ManifestKey identifies a manifest row.
+type ManifestKey struct {
+	SnapshotKey
+	ID             gocql.UUID
+	PackageManager string
+	FilePath       string
+}
+
+// DependencyKey identifies a manifest_dependencies row, and through its
+// package fields, a dependent_repositories partition.
+type DependencyKey struct {
+	ManifestID     gocql.UUID
+	PackageManager string
+	Namespace      string
+	Name           string
+	Version        string
+}
+
+// Fixtures are the keys workloads draw their query parameters from.
+type Fixtures struct {
+	Snapshots    []SnapshotKey
+	Manifests    []ManifestKey
+	Dependencies []DependencyKey
+}
+
+// FixtureLimits caps how many keys of each kind are sampled.
+type FixtureLimits struct {
+	Snapshots    int
+	Manifests    int
+	Dependencies int
+}
+
+// LoadFixtures samples keys from the key catalog written alongside each
+// snapshot. Buckets of the catalog are visited in an order chosen by seed, a
+// page at a time, so the same seed over the same data always yields the same
+// fixtures. Each bucket is read at most once through, so sampling terminates
+// however small the data set, returning an error if the catalog is empty.
+func LoadFixtures(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, limits FixtureLimits, seed int64) (Fixtures, error) {
+	fx, err := sampleFixtures(limits, seed, func(bucket int, page data.Page) ([]data.CatalogEntry, []byte, error) {
+		return data.KeyCatalogPage(ctx, lgr, client, keyspace, bucket, page)
+	})
+	if err != nil {
+		return fx, err
+	}
+	if len(fx.Snapshots) == 0 {
+		return fx, fmt.Errorf("no key catalog entries found in keyspace %s, seed some snapshots first", keyspace)
+	}
+	lgr.Info("Loaded fixtures", "keyspace", keyspace,
+		"snapshots", len(fx.Snapshots), "manifests", len(fx.Manifests), "dependencies", len(fx.Dependencies))
+
+	return fx, nil
+}
+
+// sampleFixtures makes passes over the catalog buckets in a random order,
+// reading the next page of each until every limit is met or every bucket is
+// exhausted. Pages are sized so a full sample spreads over all the buckets.
+func sampleFixtures(limits FixtureLimits, seed int64, fetch func(bucket int, page data.Page) ([]data.CatalogEntry, []byte, error)) (Fixtures, error) {
+	var fx Fixtures
+	full := func() bool {
+		return len(fx.Snapshots) >= limits.Snapshots &&
+			len(fx.Manifests) >= limits.Manifests &&
+			len(fx.Dependencies) >= limits.Dependencies
+	}
+
+	pageSize := limits.Manifests
+	if limits.Snapshots > pageSize {
+		pageSize = limits.Snapshots
+	}
+	pageSize = pageSize/data.CatalogBuckets + 1
+
+	order := rand.New(rand.NewSource(seed)).Perm(data.CatalogBuckets)
+	states := make([][]byte, data.CatalogBuckets)
+	exhausted := make([]bool, data.CatalogBuckets)
+	remaining := data.CatalogBuckets
+	seen := map[gocql.UUID]bool{}
+
+	for remaining > 0 && !full() {
+		for _, bucket := range order {
+			if exhausted[bucket] || full() {
+				continue
+			}
+			entries, next, err := fetch(bucket, data.Page{Size: pageSize, State: states[bucket]})
+			if err != nil {
+				return fx, fmt.Errorf("sampling key catalog bucket %d: %s", bucket, err)
+			}
+			if len(next) == 0 {
+				exhausted[bucket] = true
+				remaining--
+			}
+			states[bucket] = next
+
+			for _, ce := range entries {
+				sk := SnapshotKey{
+					ID:           ce.SnapshotID,
+					OwnerID:      ce.OwnerID,
+					RepositoryID: ce.RepositoryID,
+					Ref:          ce.Ref,
+				}
+				if !seen[ce.SnapshotID] && len(fx.Snapshots) < limits.Snapshots {
+					seen[ce.SnapshotID] = true
+					fx.Snapshots = append(fx.Snapshots, sk)
+				}
+				if len(fx.Manifests) < limits.Manifests {
+					fx.Manifests = append(fx.Manifests, ManifestKey{
+						SnapshotKey:    sk,
+						ID:             ce.ManifestID,
+						PackageManager: ce.PackageManager,
+						FilePath:       ce.ManifestKey,
+					})
+				}
+				for _, dep := range ce.Dependencies {
+					if len(fx.Dependencies) >= limits.Dependencies {
+						break
+					}
+					fx.Dependencies = append(fx.Dependencies, DependencyKey{
+						ManifestID:     ce.ManifestID,
+						PackageManager: ce.PackageManager,
+						Namespace:      dep.Namespace,
+						Name:           dep.Name,
+						Version:        dep.Version,
+					})
+				}
+			}
+		}
+	}
+
+	return fx, nil
+}
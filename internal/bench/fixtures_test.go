@@ -0,0 +1,121 @@
+package bench
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+
+	"github.com/gocql/gocql"
+)
+
+// fakeCatalog serves buckets of entries a page at a time, using the index of
+// the next entry as the page state.
+type fakeCatalog struct {
+	buckets [][]data.CatalogEntry
+	fetches int
+}
+
+func newFakeCatalog(snapshots, manifestsPer int) *fakeCatalog {
+	fc := &fakeCatalog{buckets: make([][]data.CatalogEntry, data.CatalogBuckets)}
+	for i := 0; i < snapshots; i++ {
+		snapshotID := gocql.TimeUUID()
+		bucket := data.CatalogBucket(snapshotID)
+		for j := 0; j < manifestsPer; j++ {
+			fc.buckets[bucket] = append(fc.buckets[bucket], data.CatalogEntry{
+				SnapshotID:     snapshotID,
+				ManifestID:     gocql.TimeUUID(),
+				RepositoryID:   uint(i),
+				Ref:            "refs/heads/main",
+				PackageManager: "npm",
+				Dependencies:   []data.CatalogDependency{{Name: "a", Version: "1.0.0"}, {Name: "b", Version: "2.0.0"}},
+			})
+		}
+	}
+	return fc
+}
+
+func (fc *fakeCatalog) fetch(bucket int, page data.Page) ([]data.CatalogEntry, []byte, error) {
+	fc.fetches++
+	start := 0
+	if len(page.State) > 0 {
+		start = int(page.State[0])
+	}
+	end := start + page.Size
+	if end >= len(fc.buckets[bucket]) {
+		return fc.buckets[bucket][start:], nil, nil
+	}
+	return fc.buckets[bucket][start:end], []byte{byte(end)}, nil
+}
+
+func TestSampleFixturesSmallDataSet(t *testing.T) {
+	fc := newFakeCatalog(3, 2)
+	limits := FixtureLimits{Snapshots: 1000, Manifests: 5000, Dependencies: 5000}
+
+	fx, err := sampleFixtures(limits, 1, fc.fetch)
+	if err != nil {
+		t.Fatalf("sampling fixtures: %s", err)
+	}
+	if len(fx.Snapshots) != 3 || len(fx.Manifests) != 6 || len(fx.Dependencies) != 12 {
+		t.Errorf("expected every key of the catalog, got %d snapshots, %d manifests, %d dependencies",
+			len(fx.Snapshots), len(fx.Manifests), len(fx.Dependencies))
+	}
+	if fc.fetches != data.CatalogBuckets {
+		t.Errorf("expected each bucket to be read once, got %d reads", fc.fetches)
+	}
+
+	empty, err := sampleFixtures(limits, 1, newFakeCatalog(0, 0).fetch)
+	if err != nil || len(empty.Snapshots) != 0 {
+		t.Errorf("expected an empty sample of an empty catalog, got %+v, %v", empty, err)
+	}
+}
+
+func TestSampleFixturesReproducible(t *testing.T) {
+	fc := newFakeCatalog(500, 10)
+	limits := FixtureLimits{Snapshots: 100, Manifests: 200, Dependencies: 300}
+
+	first, err := sampleFixtures(limits, 42, fc.fetch)
+	if err != nil {
+		t.Fatalf("sampling fixtures: %s", err)
+	}
+	if len(first.Snapshots) != 100 || len(first.Manifests) != 200 || len(first.Dependencies) != 300 {
+		t.Errorf("expected limits to be met, got %d snapshots, %d manifests, %d dependencies",
+			len(first.Snapshots), len(first.Manifests), len(first.Dependencies))
+	}
+
+	again, _ := sampleFixtures(limits, 42, fc.fetch)
+	if !reflect.DeepEqual(first, again) {
+		t.Errorf("expected the same seed to yield the same fixtures")
+	}
+	other, _ := sampleFixtures(limits, 43, fc.fetch)
+	if reflect.DeepEqual(first, other) {
+		t.Errorf("expected a different seed to yield different fixtures")
+	}
+}
+
+func TestWorkloadsFound(t *testing.T) {
+	variant, err := data.LookupVariant(data.DefaultVariant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := ListWorkloads(variant)
+	for _, w := range listed {
+		if w.Fixture == "" || w.Op != nil {
+			t.Errorf("%s: expected a fixture kind and no Op, got %q", w.Name, w.Fixture)
+		}
+	}
+
+	// only the workloads drawing on the kinds of fixtures found are runnable
+	fx := Fixtures{Snapshots: []SnapshotKey{{ID: gocql.TimeUUID(), RepositoryID: 1, Ref: data.DefaultRef}}}
+	keyspace := variant.Keyspace(data.Keyspace)
+	runnable := append(Workloads(nil, nil, keyspace, fx), ModelWorkloads(nil, nil, keyspace, variant, fx)...)
+	want := 0
+	for _, w := range listed {
+		if w.Fixture == SnapshotFixtures {
+			want++
+		}
+	}
+	if len(runnable) != want || len(runnable) == len(listed) {
+		t.Errorf("expected the %d snapshot workloads of %d, got %d", want, len(listed), len(runnable))
+	}
+}
@@ -0,0 +1,261 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/logging"
+	"github.com/elireisman/cass-dsapi/internal/stats"
+
+	"github.com/gocql/gocql"
+)
+
+// MixOptions configures a mixed read/write run: a weighted mix of read
+// workloads, driven per Options, while snapshots are generated and loaded in
+// the background at IngestRate snapshots per second.
+type MixOptions struct {
+	Options
+
+	Weights map[string]int `json:"weights"`
+
+	IngestRate      float64 `json:"ingest_rate"`
+	IngestWorkers   int     `json:"ingest_workers"`
+	Manifests       int     `json:"ingest_manifests"`
+	MaxDependencies int     `json:"ingest_max_dependencies"`
+}
+
+// IngestResult describes the background ingest of a mixed run. Snapshots,
+// Rate and Latency cover the loads completed within the read window, Latency
+// being the time taken by data.Load per snapshot, excluding generation.
+// Drained counts the loads still running when the window closed, which are
+// left to complete without reads running alongside.
+type IngestResult struct {
+	Snapshots  int64         `json:"snapshots"`
+	Drained    int64         `json:"drained"`
+	TargetRate float64       `json:"target_rate"`
+	Rate       float64       `json:"snapshots_per_sec"`
+	Latency    stats.Summary `json:"latency"`
+}
+
+// Degradation compares a read workload under ingest against its baseline.
+// Ratios above 1 mean latency got worse; below 1 mean throughput got worse.
+type Degradation struct {
+	Workload        string  `json:"workload"`
+	P50Ratio        float64 `json:"p50_ratio"`
+	P99Ratio        float64 `json:"p99_ratio"`
+	ThroughputRatio float64 `json:"throughput_ratio"`
+}
+
+// MixedResult holds the read mix results without and with ingest.
+type MixedResult struct {
+	Options     MixOptions    `json:"options"`
+	Baseline    []Result      `json:"baseline"`
+	UnderIngest []Result      `json:"under_ingest"`
+	Ingest      IngestResult  `json:"ingest"`
+	Degradation []Degradation `json:"degradation"`
+}
+
+// ParseWeights parses "name=weight,name=weight" (a bare name has weight 1).
+func ParseWeights(spec string) (map[string]int, error) {
+	out := map[string]int{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, raw, hasWeight := strings.Cut(entry, "=")
+		weight := 1
+		if hasWeight {
+			var err error
+			if weight, err = strconv.Atoi(raw); err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight %q for workload %s", raw, name)
+			}
+		}
+		out[strings.TrimSpace(name)] = weight
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no workloads in mix %q", spec)
+	}
+	return out, nil
+}
+
+// mixTable returns the weighted workloads in name order, so a seed picks the
+// same schedule on every run, along with their cumulative weights.
+func mixTable(available []Workload, weights map[string]int) ([]Workload, []int, error) {
+	byName := map[string]Workload{}
+	for _, w := range available {
+		byName[w.Name] = w
+	}
+
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var workloads []Workload
+	var cumulative []int
+	total := 0
+	for _, name := range names {
+		w, ok := byName[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown or unavailable workload %q", name)
+		}
+		total += weights[name]
+		workloads = append(workloads, w)
+		cumulative = append(cumulative, total)
+	}
+	if total == 0 {
+		return nil, nil, fmt.Errorf("no workloads in mix")
+	}
+	return workloads, cumulative, nil
+}
+
+// RunMixed runs the weighted read mix twice, first alone as a baseline and
+// then alongside background ingest, and compares the two.
+func RunMixed(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, variant data.Variant,
+	available []Workload, opts MixOptions) (MixedResult, error) {
+	workloads, cumulative, err := mixTable(available, opts.Weights)
+	if err != nil {
+		return MixedResult{}, err
+	}
+	total := cumulative[len(cumulative)-1]
+	pick := func(r *rand.Rand) int {
+		n := r.Intn(total)
+		for i, c := range cumulative {
+			if n < c {
+				return i
+			}
+		}
+		return len(cumulative) - 1
+	}
+
+	out := MixedResult{Options: opts}
+
+	lgr.Info("Running read mix baseline", "options", opts.Options)
+	out.Baseline = drive(ctx, workloads, pick, opts.Options)
+	for _, result := range out.Baseline {
+		logResult(lgr, result)
+	}
+
+	lgr.Info("Running read mix under ingest", "snapshots_per_sec", opts.IngestRate)
+	ingestCtx, stopIngest := context.WithCancel(ctx)
+	ingestDone := make(chan IngestResult)
+	go func() {
+		ingestDone <- ingest(ingestCtx, lgr, client, keyspace, variant, opts)
+	}()
+	out.UnderIngest = drive(ctx, workloads, pick, opts.Options)
+	stopIngest()
+	out.Ingest = <-ingestDone
+
+	for i, result := range out.UnderIngest {
+		logResult(lgr, result)
+		base := out.Baseline[i]
+		out.Degradation = append(out.Degradation, Degradation{
+			Workload:        result.Workload,
+			P50Ratio:        ratio(result.Latency.P50, base.Latency.P50),
+			P99Ratio:        ratio(result.Latency.P99, base.Latency.P99),
+			ThroughputRatio: ratio(result.Throughput, base.Throughput),
+		})
+	}
+	lgr.Info("Ingested snapshots", "snapshots", out.Ingest.Snapshots, "drained", out.Ingest.Drained, "snapshots_per_sec", out.Ingest.Rate,
+		"target_snapshots_per_sec", out.Ingest.TargetRate, "p50_us", out.Ingest.Latency.P50, "p99_us", out.Ingest.Latency.P99)
+
+	return out, nil
+}
+
+// ingest generates snapshots and loads them with the schema variant's write
+// path at opts.IngestRate until ctx is done, using up to opts.IngestWorkers
+// concurrent loads. Ticks arriving while every worker is busy are dropped, so
+// the achieved rate shows when the cluster can't keep up. Loads in flight when
+// ctx is done are drained before returning.
+func ingest(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, variant data.Variant, opts MixOptions) IngestResult {
+	out := IngestResult{TargetRate: opts.IngestRate}
+	if opts.IngestRate <= 0 {
+		<-ctx.Done()
+		return out
+	}
+	workers := opts.IngestWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	// per-snapshot generation and load logging would drown out the benchmark
+	quiet := logging.Discard()
+	rec := stats.NewRecorder(stats.DefaultMaxSamples, opts.Seed)
+	var drained atomic.Int64
+	tickets := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.IngestRate))
+	defer ticker.Stop()
+
+	start := time.Now()
+	var window time.Duration
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			window = time.Since(start)
+			break loop
+		case <-ticker.C:
+		}
+
+		select {
+		case tickets <- struct{}{}:
+		default:
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-tickets }()
+
+			snap, err := data.GenerateSnapshot(ctx, quiet, nil, opts.Manifests, opts.MaxDependencies)
+			if err != nil {
+				// generation is cut short when the window closes
+				if ctx.Err() == nil {
+					rec.Error()
+				}
+				return
+			}
+			loadStart := time.Now()
+			// not cancelled with ctx, so an in-flight load completes rather than leaving a partial snapshot
+			if err := variant.Load(context.WithoutCancel(ctx), quiet, client, snap, keyspace); err != nil {
+				lgr.Warn("Background ingest of snapshot failed", "snapshot_id", snap.ID, "error", err)
+				rec.Error()
+				return
+			}
+			if ctx.Err() != nil {
+				drained.Add(1)
+				return
+			}
+			rec.Record(time.Since(loadStart))
+		}()
+	}
+	wg.Wait()
+
+	out.Latency = rec.Summary()
+	out.Snapshots = out.Latency.Count
+	out.Drained = drained.Load()
+	out.Rate = float64(out.Snapshots) / window.Seconds()
+
+	return out
+}
+
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
@@ -0,0 +1,50 @@
+package bench
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWeights(t *testing.T) {
+	weights, err := ParseWeights("canonical-snapshot=5, page-of-dependents=2,usage-counts")
+	if err != nil {
+		t.Fatalf("parsing weights: %s", err)
+	}
+	expected := map[string]int{"canonical-snapshot": 5, "page-of-dependents": 2, "usage-counts": 1}
+	if !reflect.DeepEqual(weights, expected) {
+		t.Errorf("expected %v, got %v", expected, weights)
+	}
+
+	for _, spec := range []string{"", ",", "usage-counts=0", "usage-counts=-1", "usage-counts=x"} {
+		if _, err := ParseWeights(spec); err == nil {
+			t.Errorf("expected error parsing %q", spec)
+		}
+	}
+}
+
+func TestMixTable(t *testing.T) {
+	available := []Workload{{Name: "usage-counts"}, {Name: "canonical-snapshot"}, {Name: "page-of-dependents"}}
+	weights := map[string]int{"usage-counts": 1, "page-of-dependents": 2, "canonical-snapshot": 5}
+
+	// the table, and so the schedule a seed picks, doesn't depend on map order
+	for i := 0; i < 20; i++ {
+		workloads, cumulative, err := mixTable(available, weights)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, w := range workloads {
+			names = append(names, w.Name)
+		}
+		if want := []string{"canonical-snapshot", "page-of-dependents", "usage-counts"}; !reflect.DeepEqual(names, want) {
+			t.Fatalf("expected workloads %v, got %v", want, names)
+		}
+		if want := []int{5, 7, 8}; !reflect.DeepEqual(cumulative, want) {
+			t.Fatalf("expected cumulative weights %v, got %v", want, cumulative)
+		}
+	}
+
+	if _, _, err := mixTable(available, map[string]int{"missing": 1}); err == nil {
+		t.Errorf("expected an unknown workload to be rejected")
+	}
+}
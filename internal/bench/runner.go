@@ -0,0 +1,158 @@
+package bench
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/stats"
+)
+
+// Options controls how long and how hard a workload is driven. When Ops is
+// set the run stops after that many operations, otherwise after Duration.
+type Options struct {
+	Duration    time.Duration `json:"duration_ns"`
+	Ops         int64         `json:"ops"`
+	Concurrency int           `json:"concurrency"`
+	Seed        int64         `json:"seed"`
+
+	// Samples caps the latency samples kept per workload in the report, for
+	// comparing runs; 0 keeps none
+	Samples int `json:"samples"`
+}
+
+// Result is the outcome of running a single workload.
+type Result struct {
+	Workload    string        `json:"workload"`
+	Concurrency int           `json:"concurrency"`
+	Elapsed     float64       `json:"elapsed_s"`
+	Throughput  float64       `json:"ops_per_sec"`
+	Latency     stats.Summary `json:"latency"`
+	Samples     []float64     `json:"samples_us,omitempty"`
+}
+
+// ReportVersion is the version of the Report format, bumped whenever a change
+// would make older reports incomparable.
+const ReportVersion = 1
+
+// Metadata describes what a bench run was run against, so that runs can be
+// compared knowing what changed between them.
+type Metadata struct {
+	// Variant is the schema variant benchmarked and SchemaVersion a hash of
+	// its table definitions, and Label a free-form name for the configuration
+	// under test, e.g. the schema change being evaluated
+	Variant       string `json:"variant"`
+	SchemaVersion string `json:"schema_version"`
+	Label         string `json:"label,omitempty"`
+
+	Fixtures FixtureLimits             `json:"fixture_limits"`
+	DataSet  map[string]data.TableSize `json:"data_set,omitempty"`
+
+	Hostname  string `json:"hostname,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Report is the machine-readable output of a bench run.
+type Report struct {
+	Version   int          `json:"version"`
+	StartedAt time.Time    `json:"started_at"`
+	Keyspace  string       `json:"keyspace"`
+	Metadata  Metadata     `json:"metadata"`
+	Options   Options      `json:"options"`
+	Results   []Result     `json:"results,omitempty"`
+	Mixed     *MixedResult `json:"mixed,omitempty"`
+}
+
+// Run drives the workload from opts.Concurrency workers, each issuing one
+// operation at a time, and records the latency of every operation.
+func Run(ctx context.Context, lgr *slog.Logger, w Workload, opts Options) Result {
+	lgr.Info("Running workload", "workload", w.Name, "options", opts)
+	results := drive(ctx, []Workload{w}, func(*rand.Rand) int { return 0 }, opts)
+	logResult(lgr, results[0])
+
+	return results[0]
+}
+
+// drive runs opts.Concurrency workers until the run ends, each repeatedly
+// choosing a workload with pick and timing one of its operations. It returns
+// a Result per workload.
+func drive(ctx context.Context, workloads []Workload, pick func(*rand.Rand) int, opts Options) []Result {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+
+	var runCtx context.Context
+	var cancel context.CancelFunc
+	if opts.Ops > 0 {
+		runCtx, cancel = context.WithCancel(ctx)
+	} else {
+		runCtx, cancel = context.WithTimeout(ctx, opts.Duration)
+	}
+	defer cancel()
+
+	recs := make([]*stats.Recorder, len(workloads))
+	for i := range recs {
+		recs[i] = stats.NewRecorder(stats.DefaultMaxSamples, opts.Seed+int64(i))
+	}
+	var issued int64
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func(worker int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(opts.Seed + worker))
+
+			for runCtx.Err() == nil {
+				if opts.Ops > 0 && atomic.AddInt64(&issued, 1) > opts.Ops {
+					return
+				}
+
+				selection := pick(r)
+				opStart := time.Now()
+				err := workloads[selection].Op(runCtx, r)
+				elapsed := time.Since(opStart)
+
+				switch {
+				case err == nil:
+					recs[selection].Record(elapsed)
+				case runCtx.Err() != nil:
+					// cut short by the end of the run, not a failure
+				default:
+					recs[selection].Error()
+				}
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var out []Result
+	for i, w := range workloads {
+		summary := recs[i].Summary()
+		result := Result{
+			Workload:    w.Name,
+			Concurrency: opts.Concurrency,
+			Elapsed:     elapsed.Seconds(),
+			Throughput:  float64(summary.Count) / elapsed.Seconds(),
+			Latency:     summary,
+		}
+		if opts.Samples > 0 {
+			result.Samples = recs[i].Samples(opts.Samples)
+		}
+		out = append(out, result)
+	}
+
+	return out
+}
+
+func logResult(lgr *slog.Logger, result Result) {
+	l := result.Latency
+	lgr.Info("Workload finished", "workload", result.Workload, "ops", l.Count, "errors", l.Errors, "ops_per_sec", result.Throughput,
+		"p50_us", l.P50, "p90_us", l.P90, "p99_us", l.P99, "p999_us", l.P999)
+}
@@ -0,0 +1,330 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/versions"
+
+	"github.com/gocql/gocql"
+)
+
+// Workload is a named query issued repeatedly by the runner. Op draws its
+// parameters from the kind of fixtures named by Fixture using the worker's
+// random source.
+type Workload struct {
+	Name        string
+	Description string
+	Fixture     string
+	Op          func(ctx context.Context, r *rand.Rand) error
+}
+
+// The kinds of fixtures workloads draw their parameters from.
+const (
+	SnapshotFixtures   = "snapshots"
+	ManifestFixtures   = "manifests"
+	DependencyFixtures = "dependencies"
+)
+
+// ListWorkloads returns every workload that can run against the schema
+// variant, whatever fixtures are found, without their Op funcs, so they can
+// be listed without connecting to Cassandra.
+func ListWorkloads(variant data.Variant) []Workload {
+	keyspace := variant.Keyspace(data.Keyspace)
+	out := modelWorkloads(nil, nil, keyspace, variant, Fixtures{})
+	if variant.Name == data.DefaultVariant {
+		out = append(workloads(nil, nil, keyspace, Fixtures{}), out...)
+	}
+	for i := range out {
+		out[i].Op = nil
+	}
+	return out
+}
+
+// found keeps the workloads whose kind of fixture was found.
+func (fx Fixtures) found(workloads []Workload) []Workload {
+	counts := map[string]int{
+		SnapshotFixtures:   len(fx.Snapshots),
+		ManifestFixtures:   len(fx.Manifests),
+		DependencyFixtures: len(fx.Dependencies),
+	}
+	var out []Workload
+	for _, w := range workloads {
+		if counts[w.Fixture] > 0 {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+// Workloads returns the named query workloads that can run against the
+// fixtures, mirroring the go test benchmarks in internal/benchmarks.
+// Workloads needing a kind of fixture that wasn't found are left out.
+func Workloads(lgr *slog.Logger, client *gocql.Session, keyspace string, fx Fixtures) []Workload {
+	return fx.found(workloads(lgr, client, keyspace, fx))
+}
+
+func workloads(lgr *slog.Logger, client *gocql.Session, keyspace string, fx Fixtures) []Workload {
+	var out []Workload
+	stmts := data.StatementsFor(keyspace)
+
+	snapshot := func(r *rand.Rand) SnapshotKey {
+		return fx.Snapshots[r.Intn(len(fx.Snapshots))]
+	}
+
+	out = append(out,
+		Workload{
+			Name:        "canonical-snapshot",
+			Fixture:     SnapshotFixtures,
+			Description: "latest snapshot of a repository ref from the snapshots table",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				sk := snapshot(r)
+				return client.Query(stmts.SelectCanonicalSnapshot, sk.RepositoryID, sk.Ref).WithContext(ctx).Exec()
+			},
+		},
+		Workload{
+			Name:        "latest-snapshot-lookup",
+			Fixture:     SnapshotFixtures,
+			Description: "latest snapshot of a repository ref from the latest_snapshots table",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				sk := snapshot(r)
+				_, err := data.LatestSnapshot(ctx, lgr, client, keyspace, sk.RepositoryID, sk.Ref)
+				return err
+			},
+		},
+		Workload{
+			Name:        "refs-for-repository",
+			Fixture:     SnapshotFixtures,
+			Description: "all refs of a repository",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				_, err := data.RefsForRepository(ctx, lgr, client, keyspace, snapshot(r).RepositoryID)
+				return err
+			},
+		},
+		Workload{
+			Name:        "repositories-for-owner",
+			Fixture:     SnapshotFixtures,
+			Description: "all repositories of an owner",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				_, err := data.RepositoriesForOwner(ctx, lgr, client, keyspace, snapshot(r).OwnerID)
+				return err
+			},
+		},
+		Workload{
+			Name:        "all-manifests-for-snapshot",
+			Fixture:     SnapshotFixtures,
+			Description: "every manifest of a snapshot",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				sk := snapshot(r)
+				return client.Query(stmts.SelectAllManifestsForSnapshot, sk.RepositoryID, sk.Ref, sk.ID).WithContext(ctx).Exec()
+			},
+		},
+	)
+
+	manifest := func(r *rand.Rand) ManifestKey {
+		return fx.Manifests[r.Intn(len(fx.Manifests))]
+	}
+
+	out = append(out,
+		Workload{
+			Name:        "manifest-for-snapshot",
+			Fixture:     ManifestFixtures,
+			Description: "a single manifest of a snapshot",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				mk := manifest(r)
+				return client.Query(stmts.SelectManifest, mk.RepositoryID, mk.Ref, mk.SnapshotKey.ID, mk.PackageManager, mk.FilePath).WithContext(ctx).Exec()
+			},
+		},
+		Workload{
+			Name:        "all-dependencies-for-manifest",
+			Fixture:     ManifestFixtures,
+			Description: "every dependency of a manifest",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				return client.Query(stmts.SelectAllDependencies, manifest(r).ID).WithContext(ctx).Exec()
+			},
+		},
+	)
+
+	dependency := func(r *rand.Rand) DependencyKey {
+		return fx.Dependencies[r.Intn(len(fx.Dependencies))]
+	}
+
+	out = append(out,
+		Workload{
+			Name:        "one-dependency-all-versions",
+			Fixture:     DependencyFixtures,
+			Description: "every version of one package in a manifest",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				dk := dependency(r)
+				return client.Query(stmts.SelectDependencyAllVersions, dk.ManifestID, dk.PackageManager, dk.Namespace, dk.Name).WithContext(ctx).Exec()
+			},
+		},
+		Workload{
+			Name:        "page-of-dependents",
+			Fixture:     DependencyFixtures,
+			Description: "first 100 repositories depending on a package version",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				dk := dependency(r)
+				return client.Query(stmts.SelectPageOfDependents, dk.PackageManager, dk.Namespace, dk.Name, versions.Key(dk.PackageManager, dk.Version), dk.Version).WithContext(ctx).Exec()
+			},
+		},
+		Workload{
+			Name:        "dependents-in-version-range",
+			Fixture:     DependencyFixtures,
+			Description: "repositories depending on any version of a package within a major version",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				dk := dependency(r)
+				major := strings.SplitN(dk.Version, ".", 2)[0]
+				rangeExpr := fmt.Sprintf(">=%s.0.0 <%s.99999.0", major, major)
+				_, err := data.DependentRepositoriesInRange(ctx, lgr, client, keyspace, dk.PackageManager, dk.Namespace, dk.Name, rangeExpr)
+				return err
+			},
+		},
+		Workload{
+			Name:        "count-dependents",
+			Fixture:     DependencyFixtures,
+			Description: "count of repositories depending on any version of a package",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				dk := dependency(r)
+				return client.Query(stmts.CountDependents, dk.PackageManager, dk.Namespace, dk.Name).WithContext(ctx).Exec()
+			},
+		},
+		Workload{
+			Name:        "count-dependents-of-version",
+			Fixture:     DependencyFixtures,
+			Description: "count of repositories depending on a package version",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				dk := dependency(r)
+				return client.Query(stmts.CountDependentsOfVersion, dk.PackageManager, dk.Namespace, dk.Name, versions.Key(dk.PackageManager, dk.Version), dk.Version).WithContext(ctx).Exec()
+			},
+		},
+		Workload{
+			Name:        "usage-counts",
+			Fixture:     DependencyFixtures,
+			Description: "sum of the dependent repository counters of a package",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				dk := dependency(r)
+				return client.Query(stmts.SumUsageCounts, dk.PackageManager, dk.Namespace, dk.Name).WithContext(ctx).Exec()
+			},
+		},
+		Workload{
+			Name:        "usage-count-of-version",
+			Fixture:     DependencyFixtures,
+			Description: "dependent repository counter of a package version",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				dk := dependency(r)
+				return client.Query(stmts.SelectUsageCountOfVersion, dk.PackageManager, dk.Namespace, dk.Name, versions.Key(dk.PackageManager, dk.Version), dk.Version).WithContext(ctx).Exec()
+			},
+		},
+	)
+
+	return out
+}
+
+// ModelWorkloads returns workloads issuing the schema variant's implementation
+// of each of the queries every variant supports, so that variants loaded with
+// the same snapshots can be compared workload by workload. Workloads needing a
+// kind of fixture that wasn't found are left out.
+func ModelWorkloads(lgr *slog.Logger, client *gocql.Session, keyspace string, variant data.Variant, fx Fixtures) []Workload {
+	return fx.found(modelWorkloads(lgr, client, keyspace, variant, fx))
+}
+
+func modelWorkloads(lgr *slog.Logger, client *gocql.Session, keyspace string, variant data.Variant, fx Fixtures) []Workload {
+	var out []Workload
+	queries := variant.Queries
+
+	snapshot := func(r *rand.Rand) SnapshotKey {
+		return fx.Snapshots[r.Intn(len(fx.Snapshots))]
+	}
+
+	out = append(out,
+		Workload{
+			Name:        "model-canonical-snapshot",
+			Fixture:     SnapshotFixtures,
+			Description: "latest snapshot of a repository ref from the snapshots table, as the schema variant lays it out",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				sk := snapshot(r)
+				_, err := queries.CanonicalSnapshot(ctx, lgr, client, keyspace, sk.RepositoryID, sk.Ref)
+				return err
+			},
+		},
+		Workload{
+			Name:        "model-snapshots-in-range",
+			Fixture:     SnapshotFixtures,
+			Description: "snapshots of a repository ref created in the last 90 days, as the schema variant lays them out",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				sk := snapshot(r)
+				now := time.Now()
+				_, err := queries.SnapshotsInRange(ctx, lgr, client, keyspace, sk.RepositoryID, sk.Ref, now.AddDate(0, 0, -90), now)
+				return err
+			},
+		},
+		Workload{
+			Name:        "model-latest-snapshot",
+			Fixture:     SnapshotFixtures,
+			Description: "latest snapshot of a repository ref, as the schema variant stores it",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				sk := snapshot(r)
+				_, err := queries.LatestSnapshot(ctx, lgr, client, keyspace, sk.RepositoryID, sk.Ref)
+				return err
+			},
+		},
+		Workload{
+			Name:        "model-manifests-for-snapshot",
+			Fixture:     SnapshotFixtures,
+			Description: "every manifest of a snapshot, as the schema variant stores them",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				sk := snapshot(r)
+				_, err := queries.ManifestsForSnapshot(ctx, lgr, client, keyspace, sk.RepositoryID, sk.Ref, sk.ID)
+				return err
+			},
+		},
+	)
+
+	out = append(out, Workload{
+		Name:        "model-dependencies-for-manifest",
+		Fixture:     ManifestFixtures,
+		Description: "every dependency of a manifest, as the schema variant stores them",
+		Op: func(ctx context.Context, r *rand.Rand) error {
+			mk := fx.Manifests[r.Intn(len(fx.Manifests))]
+			_, err := queries.DependenciesForManifest(ctx, lgr, client, keyspace, mk.SnapshotKey.ID, mk.ID)
+			return err
+		},
+	})
+
+	dependency := func(r *rand.Rand) (DependencyKey, string) {
+		dk := fx.Dependencies[r.Intn(len(fx.Dependencies))]
+		major := strings.SplitN(dk.Version, ".", 2)[0]
+		return dk, fmt.Sprintf(">=%s.0.0 <%s.99999.0", major, major)
+	}
+
+	out = append(out,
+		Workload{
+			Name:        "model-dependents-in-version-range",
+			Fixture:     DependencyFixtures,
+			Description: "repositories depending on a package within a major version, as the schema variant stores them",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				dk, rangeExpr := dependency(r)
+				_, err := queries.DependentRepositoriesInRange(ctx, lgr, client, keyspace, dk.PackageManager, dk.Namespace, dk.Name, rangeExpr)
+				return err
+			},
+		},
+		Workload{
+			Name:        "model-usage-counts-in-version-range",
+			Fixture:     DependencyFixtures,
+			Description: "dependent repository counts of each version of a package within a major version, as the schema variant stores them",
+			Op: func(ctx context.Context, r *rand.Rand) error {
+				dk, rangeExpr := dependency(r)
+				_, err := queries.UsageCountsInRange(ctx, lgr, client, keyspace, dk.PackageManager, dk.Namespace, dk.Name, rangeExpr)
+				return err
+			},
+		},
+	)
+
+	return out
+}
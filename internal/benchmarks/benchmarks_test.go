@@ -2,188 +2,141 @@ package benchmarks
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/elireisman/cass-dsapi/internal/bench"
 	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/versions"
 
 	"github.com/gocql/gocql"
 )
 
 var (
+	fixtureSeed = flag.Int64("fixture-seed", 1, "random seed for sampling benchmark keys from the key catalog")
+
 	ctx    context.Context
 	client *gocql.Session
 	r      *rand.Rand
-	lgr    *log.Logger
+	lgr    *slog.Logger
+
+	snapshots    []bench.SnapshotKey
+	manifests    []bench.ManifestKey
+	dependencies []bench.DependencyKey
 
-	snapshots    []Snapshot
-	manifests    []Manifest
-	dependencies []Dependency
+	setupOnce sync.Once
+	setupErr  error
 )
 
-type Snapshot struct {
-	ID           gocql.UUID
-	OwnerID      uint
-	RepositoryID uint
-	NWO          string
-	SourceURL    string
-	Ref          string
-	CommitOID    string
-	CreatedAt    time.Time
-	BlobURL      string
-}
+// setup connects to the cluster and samples benchmark keys the first time a
+// benchmark runs, skipping benchmarks when no seeded cluster is reachable so
+// that `go test ./...` passes without one.
+func setup(b *testing.B) {
+	setupOnce.Do(func() {
+		ctx = context.Background()
+		lgr = slog.Default()
+		r = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+		client, setupErr = data.CreateClient(ctx, lgr, func(cfg *gocql.ClusterConfig) {
+			cfg.FrameHeaderObserver = &received
+		})
+		if setupErr != nil {
+			return
+		}
 
-type Manifest struct {
-	ID             gocql.UUID
-	SnapshotID     gocql.UUID
-	OwnerID        uint
-	RepositoryID   uint
-	Ref            string
-	CommitOID      string
-	BlobKey        string
-	ManifestKey    string
-	PackageManager string
-	ProjectName    string
-	ProjectVersion string
-	ProjectLicense string
+		var fx bench.Fixtures
+		fx, setupErr = bench.LoadFixtures(ctx, lgr, client, data.Keyspace, bench.FixtureLimits{
+			Snapshots:    1000,
+			Manifests:    5000,
+			Dependencies: 5000,
+		}, *fixtureSeed)
+		snapshots, manifests, dependencies = fx.Snapshots, fx.Manifests, fx.Dependencies
+	})
+	if setupErr != nil {
+		b.Skipf("benchmarks need a seeded cluster: %s", setupErr)
+	}
 }
 
-type Dependency struct {
-	SnapshotID     gocql.UUID
-	ManifestID     gocql.UUID
-	PackageManager string
-	Namespace      string
-	Name           string
-	Version        string
-	License        string
-	SourceURL      string
-	Scope          string
-	Relationship   string
-	Runtime        []string
-	Development    []string
-}
+func BenchmarkCanonicalSnapshotQuery(b *testing.B) {
+	setup(b)
 
-func init() {
-	var err error
+	q := data.StatementsFor(data.Keyspace).SelectCanonicalSnapshot
 
-	ctx = context.Background()
-	lgr = log.Default()
-	r = rand.New(rand.NewSource(time.Now().UnixNano()))
+	for n := 0; n < b.N; n++ {
+		selection := int(r.Uint32() % uint32(len(snapshots)))
+		repoID := snapshots[selection].RepositoryID
+		ref := snapshots[selection].Ref
 
-	client, err = data.CreateClient(ctx, lgr)
-	if err != nil {
-		panic(err.Error())
+		if err := client.Query(q).Bind(repoID, ref).Exec(); err != nil {
+			b.Fatal(err.Error())
+		}
 	}
+}
+
+func BenchmarkLatestSnapshotLookupQuery(b *testing.B) {
+	setup(b)
 
-	q := fmt.Sprintf(`SELECT id, owner_id, repository_id, nwo, source_url, ref, commit_oid, created_at, blob_url
-	  FROM %s.snapshots LIMIT 1000`, data.Keyspace)
-	scanner := client.Query(q).Iter().Scanner()
-	for scanner.Next() {
-		snapshot := Snapshot{}
-		if err := scanner.Scan(
-			&snapshot.ID,
-			&snapshot.OwnerID,
-			&snapshot.RepositoryID,
-			&snapshot.NWO,
-			&snapshot.SourceURL,
-			&snapshot.Ref,
-			&snapshot.CommitOID,
-			&snapshot.CreatedAt,
-			&snapshot.BlobURL); err != nil {
-			panic("obtaining snapshot seed list: " + err.Error())
+	for n := 0; n < b.N; n++ {
+		selection := int(r.Uint32() % uint32(len(snapshots)))
+		repoID := snapshots[selection].RepositoryID
+		ref := snapshots[selection].Ref
+
+		if _, err := data.LatestSnapshot(ctx, lgr, client, data.Keyspace, repoID, ref); err != nil {
+			b.Fatal(err.Error())
 		}
-		snapshots = append(snapshots, snapshot)
 	}
+}
+
+func BenchmarkLatestSnapshotTreeQuery(b *testing.B) {
+	setup(b)
 
-	for len(manifests) < 5000 {
+	for n := 0; n < b.N; n++ {
 		selection := int(r.Uint32() % uint32(len(snapshots)))
-		randoRepoID := snapshots[selection].RepositoryID
-
-		q = fmt.Sprintf(`SELECT id, snapshot_id, owner_id, repository_id, ref, commit_oid, blob_key, manifest_key,
-		  package_manager, project_name, project_version, project_license
-		  FROM %s.manifests WHERE repository_id = ? LIMIT 100 ALLOW FILTERING`, data.Keyspace)
-
-		scanner = client.Query(q).Bind(randoRepoID).Iter().Scanner()
-		for scanner.Next() {
-			manifest := Manifest{}
-			if err := scanner.Scan(
-				&manifest.ID,
-				&manifest.SnapshotID,
-				&manifest.OwnerID,
-				&manifest.RepositoryID,
-				&manifest.Ref,
-				&manifest.CommitOID,
-				&manifest.BlobKey,
-				&manifest.ManifestKey,
-				&manifest.PackageManager,
-				&manifest.ProjectName,
-				&manifest.ProjectVersion,
-				&manifest.ProjectLicense); err != nil {
-				panic("obtaining manifest seed list: " + err.Error())
-			}
-			manifests = append(manifests, manifest)
+		repoID := snapshots[selection].RepositoryID
+		ref := snapshots[selection].Ref
+
+		if _, err := data.LatestSnapshotTree(ctx, lgr, client, data.Keyspace, repoID, ref); err != nil {
+			b.Fatal(err.Error())
 		}
 	}
+}
 
-	for len(dependencies) < 5000 {
-		selection := int(r.Uint32() % uint32(len(manifests)))
-		randoManifestID := manifests[selection].ID
-
-		q = fmt.Sprintf(`SELECT snapshot_id, manifest_id, package_manager, namespace, name,
-		  version, license, source_url, scope, relationship, runtime, development
-		  FROM %s.manifest_dependencies WHERE manifest_id = ? LIMIT 100 ALLOW FILTERING`, data.Keyspace)
-
-		scanner = client.Query(q).Bind(randoManifestID).Iter().Scanner()
-		for scanner.Next() {
-			dependency := Dependency{}
-			if err := scanner.Scan(
-				&dependency.SnapshotID,
-				&dependency.ManifestID,
-				&dependency.PackageManager,
-				&dependency.Namespace,
-				&dependency.Name,
-				&dependency.Version,
-				&dependency.License,
-				&dependency.SourceURL,
-				&dependency.Scope,
-				&dependency.Relationship,
-				&dependency.Runtime,
-				&dependency.Development); err != nil {
-				panic("obtaining dependency seed list: " + err.Error())
-			}
-			dependencies = append(dependencies, dependency)
+func BenchmarkRefsForRepositoryQuery(b *testing.B) {
+	setup(b)
+
+	for n := 0; n < b.N; n++ {
+		selection := int(r.Uint32() % uint32(len(snapshots)))
+		repoID := snapshots[selection].RepositoryID
+
+		if _, err := data.RefsForRepository(ctx, lgr, client, data.Keyspace, repoID); err != nil {
+			b.Fatal(err.Error())
 		}
 	}
 }
 
-func BenchmarkCanonicalSnapshotQuery(b *testing.B) {
-	q := fmt.Sprintf(`
-	  SELECT * FROM %s.snapshots
-	  WHERE repository_id = ? AND ref = ?
-	  ORDER BY created_at DESC
-	  LIMIT 1`, data.Keyspace)
+func BenchmarkRepositoriesForOwnerQuery(b *testing.B) {
+	setup(b)
 
 	for n := 0; n < b.N; n++ {
 		selection := int(r.Uint32() % uint32(len(snapshots)))
-		repoID := snapshots[selection].RepositoryID
-		ref := snapshots[selection].Ref
+		ownerID := snapshots[selection].OwnerID
 
-		if err := client.Query(q).Bind(repoID, ref).Exec(); err != nil {
+		if _, err := data.RepositoriesForOwner(ctx, lgr, client, data.Keyspace, ownerID); err != nil {
 			b.Fatal(err.Error())
 		}
 	}
 }
 
 func BenchmarkAllManifestsForSnapshotQuery(b *testing.B) {
-	q := fmt.Sprintf(`
-	  SELECT * FROM %s.manifests
-	  WHERE repository_id = ?
-	    AND ref = ?
-	    AND snapshot_id = ?
-          `, data.Keyspace)
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectAllManifestsForSnapshot
 
 	for n := 0; n < b.N; n++ {
 		selection := int(r.Uint32() % uint32(len(snapshots)))
@@ -198,22 +151,17 @@ func BenchmarkAllManifestsForSnapshotQuery(b *testing.B) {
 }
 
 func BenchmarkManifestForSnapshotQuery(b *testing.B) {
-	q := fmt.Sprintf(`
-	  SELECT * FROM %s.manifests
-	  WHERE repository_id = ?
-	    AND ref = ?
-	    AND snapshot_id = ?
-	    AND package_manager = ?
-	    AND manifest_key = ?
-          `, data.Keyspace)
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectManifest
 
 	for n := 0; n < b.N; n++ {
 		selection := int(r.Uint32() % uint32(len(manifests)))
 		repoID := manifests[selection].RepositoryID
 		ref := manifests[selection].Ref
-		snapID := manifests[selection].SnapshotID
+		snapID := manifests[selection].SnapshotKey.ID
 		pm := manifests[selection].PackageManager
-		key := manifests[selection].ManifestKey
+		key := manifests[selection].FilePath
 
 		if err := client.Query(q).Bind(repoID, ref, snapID, pm, key).Exec(); err != nil {
 			b.Fatal(err.Error())
@@ -222,9 +170,9 @@ func BenchmarkManifestForSnapshotQuery(b *testing.B) {
 }
 
 func BenchmarkAllDependenciesFromSnapshotManifestQuery(b *testing.B) {
-	q := fmt.Sprintf(
-		`SELECT * FROM %s.manifest_dependencies WHERE manifest_id = ?`,
-		data.Keyspace)
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectAllDependencies
 
 	for n := 0; n < b.N; n++ {
 		selection := int(r.Uint32() % uint32(len(manifests)))
@@ -237,13 +185,9 @@ func BenchmarkAllDependenciesFromSnapshotManifestQuery(b *testing.B) {
 }
 
 func BenchmarkOneDependencyAllVersionsFromSnapshotManifestQuery(b *testing.B) {
-	q := fmt.Sprintf(`
-	  SELECT * FROM %s.manifest_dependencies
-	  WHERE manifest_id = ?
-	    AND package_manager = ?
-	    AND namespace = ?
-	    AND name = ?
-	  `, data.Keyspace)
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectDependencyAllVersions
 
 	for n := 0; n < b.N; n++ {
 		selection := int(r.Uint32() % uint32(len(dependencies)))
@@ -259,14 +203,9 @@ func BenchmarkOneDependencyAllVersionsFromSnapshotManifestQuery(b *testing.B) {
 }
 
 func BenchmarkPageOfRepositoriesDependingOnPackageQuery(b *testing.B) {
-	q := fmt.Sprintf(`
-	  SELECT * FROM %s.dependent_repositories
-	  WHERE package_manager = ?
-	    AND namespace = ?
-	    AND name = ?
-	    AND version = ?
-	  LIMIT 100
-	  `, data.Keyspace)
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectPageOfDependents
 
 	for n := 0; n < b.N; n++ {
 		selection := int(r.Uint32() % uint32(len(dependencies)))
@@ -274,20 +213,18 @@ func BenchmarkPageOfRepositoriesDependingOnPackageQuery(b *testing.B) {
 		ns := dependencies[selection].Namespace
 		name := dependencies[selection].Name
 		version := dependencies[selection].Version
+		versionKey := versions.Key(pm, version)
 
-		if err := client.Query(q).Bind(pm, ns, name, version).Exec(); err != nil {
+		if err := client.Query(q).Bind(pm, ns, name, versionKey, version).Exec(); err != nil {
 			b.Fatal(err.Error())
 		}
 	}
 }
 
 func BenchmarkCountRepositoriesDependingOnPackageQuery(b *testing.B) {
-	q := fmt.Sprintf(`
-	  SELECT COUNT(*) FROM %s.dependent_repositories
-	  WHERE package_manager = ?
-	    AND namespace = ?
-	    AND name = ?
-	  `, data.Keyspace)
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).CountDependents
 
 	for n := 0; n < b.N; n++ {
 		selection := int(r.Uint32() % uint32(len(dependencies)))
@@ -302,13 +239,9 @@ func BenchmarkCountRepositoriesDependingOnPackageQuery(b *testing.B) {
 }
 
 func BenchmarkCountRepositoriesDependingOnPackageVersionQuery(b *testing.B) {
-	q := fmt.Sprintf(`
-	  SELECT COUNT(*) FROM %s.dependent_repositories
-	  WHERE package_manager = ?
-	    AND namespace = ?
-	    AND name = ?
-	    AND version = ?
-	  `, data.Keyspace)
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).CountDependentsOfVersion
 
 	for n := 0; n < b.N; n++ {
 		selection := int(r.Uint32() % uint32(len(dependencies)))
@@ -316,21 +249,18 @@ func BenchmarkCountRepositoriesDependingOnPackageVersionQuery(b *testing.B) {
 		ns := dependencies[selection].Namespace
 		name := dependencies[selection].Name
 		version := dependencies[selection].Version
+		versionKey := versions.Key(pm, version)
 
-		if err := client.Query(q).Bind(pm, ns, name, version).Exec(); err != nil {
+		if err := client.Query(q).Bind(pm, ns, name, versionKey, version).Exec(); err != nil {
 			b.Fatal(err.Error())
 		}
 	}
 }
 
 func BenchmarkRepositoriesDependencyCountsOfPackageQuery(b *testing.B) {
-	q := fmt.Sprintf(`
-	  SELECT SUM(used_by) FROM %s.dependent_repository_counts
-	  WHERE package_manager = ?
-	    AND namespace = ?
-	    AND name = ?
-	  LIMIT 1
-	  `, data.Keyspace)
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SumUsageCounts
 
 	for n := 0; n < b.N; n++ {
 		selection := int(r.Uint32() % uint32(len(dependencies)))
@@ -343,15 +273,28 @@ func BenchmarkRepositoriesDependencyCountsOfPackageQuery(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkRepositoriesDependingOnPackageVersionRangeQuery(b *testing.B) {
+	setup(b)
+
+	for n := 0; n < b.N; n++ {
+		selection := int(r.Uint32() % uint32(len(dependencies)))
+		pm := dependencies[selection].PackageManager
+		ns := dependencies[selection].Namespace
+		name := dependencies[selection].Name
+		major := strings.SplitN(dependencies[selection].Version, ".", 2)[0]
+		rangeExpr := fmt.Sprintf(">=%s.0.0 <%s.99999.0", major, major)
+
+		if _, err := data.DependentRepositoriesInRange(ctx, lgr, client, data.Keyspace, pm, ns, name, rangeExpr); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
 func BenchmarkRepositoriesDependencyCountsOfPackageVersionQuery(b *testing.B) {
-	q := fmt.Sprintf(`
-	  SELECT used_by FROM %s.dependent_repository_counts
-	  WHERE package_manager = ?
-	    AND namespace = ?
-	    AND name = ?
-	    AND version = ?
-	  LIMIT 1
-	  `, data.Keyspace)
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectUsageCountOfVersion
 
 	for n := 0; n < b.N; n++ {
 		selection := int(r.Uint32() % uint32(len(dependencies)))
@@ -359,8 +302,9 @@ func BenchmarkRepositoriesDependencyCountsOfPackageVersionQuery(b *testing.B) {
 		ns := dependencies[selection].Namespace
 		name := dependencies[selection].Name
 		version := dependencies[selection].Version
+		versionKey := versions.Key(pm, version)
 
-		if err := client.Query(q).Bind(pm, ns, name, version).Exec(); err != nil {
+		if err := client.Query(q).Bind(pm, ns, name, versionKey, version).Exec(); err != nil {
 			b.Fatal(err.Error())
 		}
 	}
@@ -0,0 +1,230 @@
+package benchmarks
+
+import (
+	"context"
+	"flag"
+	"sync/atomic"
+	"testing"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/versions"
+
+	"github.com/gocql/gocql"
+)
+
+// The Scan benchmarks iterate and decode every row of the result into the
+// domain types of internal/data, unlike the benchmarks above which only
+// Exec() and so measure time to the first page. They decode every column of
+// the table; the ScanProjected benchmarks read only the columns the typed
+// row scanners of internal/data decode. By default only the first page is
+// read; pass -page-all to follow page state through the whole result, e.g.
+//
+//	go test -bench Scan ./internal/benchmarks -args -page-all -page-size 1000
+var (
+	pageAll  = flag.Bool("page-all", false, "page through all results in the Scan benchmarks")
+	pageSize = flag.Int("page-size", 5000, "page size of the Scan benchmarks")
+
+	received frameBytes
+)
+
+// frameBytes counts the bytes of RESULT frames received from the cluster.
+type frameBytes struct {
+	n int64
+}
+
+func (fb *frameBytes) ObserveFrameHeader(ctx context.Context, h gocql.ObservedFrameHeader) {
+	if h.Opcode.String() == "RESULT" {
+		atomic.AddInt64(&fb.n, int64(h.Length))
+	}
+}
+
+// scanBenchmark runs query n times, scanning each row with scan, and reports
+// rows and result bytes per op alongside the usual timings.
+func scanBenchmark(b *testing.B, query func() *gocql.Query, scan func(gocql.Scanner) error) {
+	var rows int64
+	startBytes := atomic.LoadInt64(&received.n)
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		var state []byte
+		for {
+			iter := query().PageSize(*pageSize).PageState(state).Iter()
+			state = iter.PageState()
+			scanner := iter.Scanner()
+			for scanner.Next() {
+				if err := scan(scanner); err != nil {
+					b.Fatal(err.Error())
+				}
+				rows++
+			}
+			if err := scanner.Err(); err != nil {
+				b.Fatal(err.Error())
+			}
+			if !*pageAll || len(state) == 0 {
+				break
+			}
+		}
+	}
+
+	b.StopTimer()
+	b.ReportMetric(float64(rows)/float64(b.N), "rows/op")
+	b.ReportMetric(float64(atomic.LoadInt64(&received.n)-startBytes)/float64(b.N), "resultbytes/op")
+}
+
+func BenchmarkScanCanonicalSnapshotQuery(b *testing.B) {
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectCanonicalSnapshot
+
+	scanBenchmark(b, func() *gocql.Query {
+		selection := snapshots[int(r.Uint32()%uint32(len(snapshots)))]
+		return client.Query(q, selection.RepositoryID, selection.Ref)
+	}, func(scanner gocql.Scanner) error {
+		_, err := data.ScanSnapshot(scanner)
+		return err
+	})
+}
+
+// scanManifestRow decodes a row of SelectManifestRowsForSnapshot.
+func scanManifestRow(scanner gocql.Scanner) error {
+	var sm data.Snapshot
+	var mm data.Manifest
+	return scanner.Scan(
+		&mm.ID,
+		&sm.ID,
+		&sm.OwnerID,
+		&sm.RepositoryID,
+		&sm.Ref,
+		&sm.CommitSHA,
+		&mm.BlobKey,
+		&mm.FilePath,
+		&mm.PackageManager,
+		&mm.ProjectName,
+		&mm.ProjectVersion,
+		&mm.ProjectLicense)
+}
+
+// scanDependencyRow decodes a row of SelectDependencyRows or
+// SelectDependencyVersionRows.
+func scanDependencyRow(scanner gocql.Scanner) error {
+	var snapshotID, manifestID gocql.UUID
+	var pkgMgr string
+	var dep data.Dependency
+	return scanner.Scan(
+		&snapshotID,
+		&manifestID,
+		&pkgMgr,
+		&dep.Namespace,
+		&dep.Name,
+		&dep.Version,
+		&dep.License,
+		&dep.SourceURL,
+		&dep.Scope,
+		&dep.Relationship,
+		&dep.Runtime,
+		&dep.Development)
+}
+
+func BenchmarkScanAllManifestsForSnapshotQuery(b *testing.B) {
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectManifestRowsForSnapshot
+
+	scanBenchmark(b, func() *gocql.Query {
+		selection := snapshots[int(r.Uint32()%uint32(len(snapshots)))]
+		return client.Query(q, selection.RepositoryID, selection.Ref, selection.ID)
+	}, scanManifestRow)
+}
+
+func BenchmarkScanProjectedAllManifestsForSnapshotQuery(b *testing.B) {
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectManifestsForSnapshot
+
+	scanBenchmark(b, func() *gocql.Query {
+		selection := snapshots[int(r.Uint32()%uint32(len(snapshots)))]
+		return client.Query(q, selection.RepositoryID, selection.Ref, selection.ID)
+	}, func(scanner gocql.Scanner) error {
+		_, err := data.ScanManifest(scanner)
+		return err
+	})
+}
+
+func BenchmarkScanAllDependenciesFromSnapshotManifestQuery(b *testing.B) {
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectDependencyRows
+
+	scanBenchmark(b, func() *gocql.Query {
+		selection := manifests[int(r.Uint32()%uint32(len(manifests)))]
+		return client.Query(q, selection.ID)
+	}, scanDependencyRow)
+}
+
+func BenchmarkScanProjectedAllDependenciesFromSnapshotManifestQuery(b *testing.B) {
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectDependencies
+
+	scanBenchmark(b, func() *gocql.Query {
+		selection := manifests[int(r.Uint32()%uint32(len(manifests)))]
+		return client.Query(q, selection.ID)
+	}, func(scanner gocql.Scanner) error {
+		_, err := data.ScanDependency(scanner)
+		return err
+	})
+}
+
+func BenchmarkScanOneDependencyAllVersionsFromSnapshotManifestQuery(b *testing.B) {
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectDependencyVersionRows
+
+	scanBenchmark(b, func() *gocql.Query {
+		selection := dependencies[int(r.Uint32()%uint32(len(dependencies)))]
+		return client.Query(q, selection.ManifestID, selection.PackageManager, selection.Namespace, selection.Name)
+	}, scanDependencyRow)
+}
+
+func BenchmarkScanProjectedOneDependencyAllVersionsFromSnapshotManifestQuery(b *testing.B) {
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectDependencyVersions
+
+	scanBenchmark(b, func() *gocql.Query {
+		selection := dependencies[int(r.Uint32()%uint32(len(dependencies)))]
+		return client.Query(q, selection.ManifestID, selection.PackageManager, selection.Namespace, selection.Name)
+	}, func(scanner gocql.Scanner) error {
+		_, err := data.ScanDependency(scanner)
+		return err
+	})
+}
+
+func BenchmarkScanRepositoriesDependingOnPackageQuery(b *testing.B) {
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectDependentRepositories
+
+	scanBenchmark(b, func() *gocql.Query {
+		selection := dependencies[int(r.Uint32()%uint32(len(dependencies)))]
+		return client.Query(q, selection.PackageManager, selection.Namespace, selection.Name)
+	}, func(scanner gocql.Scanner) error {
+		_, _, err := data.ScanDependentRepository(scanner)
+		return err
+	})
+}
+
+func BenchmarkScanRepositoriesDependingOnPackageVersionQuery(b *testing.B) {
+	setup(b)
+
+	q := data.StatementsFor(data.Keyspace).SelectDependentRepositoriesOfVersion
+
+	scanBenchmark(b, func() *gocql.Query {
+		selection := dependencies[int(r.Uint32()%uint32(len(dependencies)))]
+		versionKey := versions.Key(selection.PackageManager, selection.Version)
+		return client.Query(q, selection.PackageManager, selection.Namespace, selection.Name, versionKey, selection.Version)
+	}, func(scanner gocql.Scanner) error {
+		_, _, err := data.ScanDependentRepository(scanner)
+		return err
+	})
+}
@@ -0,0 +1,75 @@
+package benchmarks
+
+import (
+	"testing"
+
+	"github.com/elireisman/cass-dsapi/internal/bench"
+	"github.com/elireisman/cass-dsapi/internal/data"
+)
+
+// variantFixtures samples keys from the variant's keyspace, skipping the
+// benchmark if the variant hasn't been seeded (see seed -variants).
+func variantFixtures(b *testing.B, variant data.Variant) bench.Fixtures {
+	if variant.Name == data.DefaultVariant {
+		return bench.Fixtures{Snapshots: snapshots, Manifests: manifests, Dependencies: dependencies}
+	}
+	fx, err := bench.LoadFixtures(ctx, lgr, client, variant.Keyspace(data.Keyspace), bench.FixtureLimits{
+		Snapshots:    100,
+		Manifests:    1000,
+		Dependencies: 0,
+	}, *fixtureSeed)
+	if err != nil {
+		b.Skipf("variant %s not seeded: %s", variant.Name, err)
+	}
+	return fx
+}
+
+// BenchmarkVariantCanonicalSnapshot reads the latest snapshot of a ref from
+// the snapshots table as each schema variant lays it out, e.g. a partition per
+// ref versus a partition per ref and month.
+func BenchmarkVariantCanonicalSnapshot(b *testing.B) {
+	setup(b)
+
+	for _, variant := range data.Variants() {
+		variant := variant
+		b.Run(variant.Name, func(b *testing.B) {
+			keys := variantFixtures(b, variant).Snapshots
+			keyspace := variant.Keyspace(data.Keyspace)
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				sk := keys[int(r.Uint32()%uint32(len(keys)))]
+				if _, err := variant.Queries.CanonicalSnapshot(ctx, lgr, client, keyspace, sk.RepositoryID, sk.Ref); err != nil {
+					b.Fatal(err.Error())
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkVariantDependenciesForManifest reads and decodes the full
+// dependency list of a manifest as each schema variant stores it, e.g. one row
+// per dependency versus a single blob.
+func BenchmarkVariantDependenciesForManifest(b *testing.B) {
+	setup(b)
+
+	for _, variant := range data.Variants() {
+		variant := variant
+		b.Run(variant.Name, func(b *testing.B) {
+			keys := variantFixtures(b, variant).Manifests
+			keyspace := variant.Keyspace(data.Keyspace)
+			var deps int
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				mk := keys[int(r.Uint32()%uint32(len(keys)))]
+				out, err := variant.Queries.DependenciesForManifest(ctx, lgr, client, keyspace, mk.SnapshotKey.ID, mk.ID)
+				if err != nil {
+					b.Fatal(err.Error())
+				}
+				deps += len(out)
+			}
+			b.ReportMetric(float64(deps)/float64(b.N), "deps/op")
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Package cql identifies the operation and table of the CQL statements the
+// data package executes, and lets several gocql observers watch them, for
+// labelling metrics and trace spans.
+package cql
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/gocql/gocql"
+)
+
+// Statement is the operation of a CQL statement and the table it operates on,
+// without its keyspace.
+type Statement struct {
+	Op, Table string
+}
+
+// statements caches parsed statements by their text; there are only as many
+// as the data package prepares.
+var statements sync.Map
+
+// Parse returns the operation (the statement's first keyword, lowercased) and
+// table of stmt. The table is empty for statements not naming one after INTO,
+// UPDATE or FROM.
+func Parse(stmt string) Statement {
+	if s, ok := statements.Load(stmt); ok {
+		return s.(Statement)
+	}
+
+	var out Statement
+	fields := strings.Fields(stmt)
+	if len(fields) > 0 {
+		out.Op = strings.ToLower(fields[0])
+	}
+	for i := 0; i < len(fields)-1 && out.Table == ""; i++ {
+		switch strings.ToUpper(fields[i]) {
+		case "INTO", "UPDATE", "FROM":
+			table := strings.SplitN(fields[i+1], "(", 2)[0]
+			if dot := strings.LastIndex(table, "."); dot >= 0 {
+				table = table[dot+1:]
+			}
+			out.Table = table
+		}
+	}
+
+	statements.Store(stmt, out)
+	return out
+}
+
+// BatchTable returns the table the batch's statements operate on, or "mixed"
+// if they span tables.
+func BatchTable(stmts []string) string {
+	table := ""
+	for i, s := range stmts {
+		if t := Parse(s).Table; i == 0 {
+			table = t
+		} else if t != table {
+			return "mixed"
+		}
+	}
+	return table
+}
+
+// AddObservers attaches q and b to cfg, keeping the observers already
+// attached: each statement and batch is passed to those first.
+func AddObservers(cfg *gocql.ClusterConfig, q gocql.QueryObserver, b gocql.BatchObserver) {
+	if cfg.QueryObserver != nil {
+		q = queryObservers{cfg.QueryObserver, q}
+	}
+	if cfg.BatchObserver != nil {
+		b = batchObservers{cfg.BatchObserver, b}
+	}
+	cfg.QueryObserver, cfg.BatchObserver = q, b
+}
+
+type queryObservers []gocql.QueryObserver
+
+func (qs queryObservers) ObserveQuery(ctx context.Context, q gocql.ObservedQuery) {
+	for _, o := range qs {
+		o.ObserveQuery(ctx, q)
+	}
+}
+
+type batchObservers []gocql.BatchObserver
+
+func (bs batchObservers) ObserveBatch(ctx context.Context, b gocql.ObservedBatch) {
+	for _, o := range bs {
+		o.ObserveBatch(ctx, b)
+	}
+}
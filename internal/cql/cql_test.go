@@ -0,0 +1,58 @@
+package cql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		stmt, op, table string
+	}{
+		{"INSERT INTO ks.snapshots\n\t  (id, created_at) VALUES(?, ?)", "insert", "snapshots"},
+		{"INSERT INTO ks.key_catalog(bucket) VALUES(?)", "insert", "key_catalog"},
+		{"UPDATE ks.dependent_repository_counts SET used = used + 1 WHERE name = ?", "update", "dependent_repository_counts"},
+		{"DELETE FROM ks.owner_packages WHERE owner_id = ?", "delete", "owner_packages"},
+		{"SELECT id, ref\n\t  FROM ks.latest_snapshots WHERE repository_id = ?", "select", "latest_snapshots"},
+		{"CREATE KEYSPACE IF NOT EXISTS ks", "create", ""},
+	} {
+		got := Parse(tc.stmt)
+		if got.Op != tc.op || got.Table != tc.table {
+			t.Errorf("%q: expected %s on %q, got %s on %q", tc.stmt, tc.op, tc.table, got.Op, got.Table)
+		}
+	}
+}
+
+func TestBatchTable(t *testing.T) {
+	insert := "INSERT INTO ks.manifest_dependencies (manifest_id) VALUES(?)"
+	if got := BatchTable([]string{insert, insert}); got != "manifest_dependencies" {
+		t.Errorf("expected a single-table batch, got %q", got)
+	}
+	if got := BatchTable([]string{insert, "INSERT INTO ks.repository_refs (ref) VALUES(?)"}); got != "mixed" {
+		t.Errorf("expected a mixed batch, got %q", got)
+	}
+}
+
+type countingObserver struct{ queries, batches *int }
+
+func (o countingObserver) ObserveQuery(context.Context, gocql.ObservedQuery) { *o.queries++ }
+func (o countingObserver) ObserveBatch(context.Context, gocql.ObservedBatch) { *o.batches++ }
+
+func TestAddObservers(t *testing.T) {
+	var firstQueries, firstBatches, secondQueries, secondBatches int
+	first := countingObserver{&firstQueries, &firstBatches}
+	second := countingObserver{&secondQueries, &secondBatches}
+
+	cfg := gocql.NewCluster("127.0.0.1")
+	AddObservers(cfg, first, first)
+	AddObservers(cfg, second, second)
+	cfg.QueryObserver.ObserveQuery(context.Background(), gocql.ObservedQuery{})
+	cfg.BatchObserver.ObserveBatch(context.Background(), gocql.ObservedBatch{})
+
+	if firstQueries != 1 || firstBatches != 1 || secondQueries != 1 || secondBatches != 1 {
+		t.Errorf("expected both observers to see the query and batch, got %d/%d and %d/%d",
+			firstQueries, firstBatches, secondQueries, secondBatches)
+	}
+}
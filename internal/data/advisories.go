@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/elireisman/cass-dsapi/internal/advisories"
+
+	"github.com/gocql/gocql"
+)
+
+// AffectedRepository is a repository depending on a package version that falls
+// within one of an advisory's affected ranges.
+type AffectedRepository struct {
+	AdvisoryID string
+	Range      string
+	DependentRepository
+}
+
+// WriteAdvisory stores the advisory and its affected package ranges.
+func WriteAdvisory(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, adv advisories.Advisory) error {
+	stmts := StatementsFor(keyspace)
+	if err := client.Query(stmts.InsertAdvisory).WithContext(ctx).Bind(
+		adv.ID,
+		adv.Summary,
+		adv.Details,
+		adv.Severity,
+		adv.Aliases,
+		adv.Published,
+		adv.Modified).Exec(); err != nil {
+		return fmt.Errorf("writing advisory %s: %s", adv.ID, err)
+	}
+
+	batch := client.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+	for _, pkg := range adv.Affected {
+		batch.Query(stmts.InsertAdvisoryAffectedPackage, adv.ID, pkg.PackageManager, pkg.Namespace, pkg.Name, pkg.Ranges)
+	}
+	if err := client.ExecuteBatch(batch); err != nil {
+		return fmt.Errorf("writing affected packages of advisory %s: %s", adv.ID, err)
+	}
+	lgr.Debug("Advisory written", "advisory_id", adv.ID, "affected_packages", len(adv.Affected))
+
+	return nil
+}
+
+// AffectedRepositories joins the advisory's affected package ranges against
+// dependent_repositories, returning every repository (and the manifests within
+// it) that depends on a vulnerable version.
+func AffectedRepositories(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace, advisoryID string) ([]AffectedRepository, error) {
+	var affected []advisories.AffectedPackage
+	scanner := client.Query(StatementsFor(keyspace).SelectAdvisoryAffectedPackages, advisoryID).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var pkg advisories.AffectedPackage
+		if err := scanner.Scan(&pkg.PackageManager, &pkg.Namespace, &pkg.Name, &pkg.Ranges); err != nil {
+			return nil, fmt.Errorf("scanning advisory_affected_packages row: %s", err)
+		}
+		affected = append(affected, pkg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("querying affected packages of advisory %s: %s", advisoryID, err)
+	}
+
+	var out []AffectedRepository
+	for _, pkg := range affected {
+		for _, rangeExpr := range pkg.Ranges {
+			deps, err := DependentRepositoriesInRange(ctx, lgr, client, keyspace, pkg.PackageManager, pkg.Namespace, pkg.Name, rangeExpr)
+			if err != nil {
+				return nil, err
+			}
+			for _, dep := range deps {
+				out = append(out, AffectedRepository{
+					AdvisoryID:          advisoryID,
+					Range:               rangeExpr,
+					DependentRepository: dep,
+				})
+			}
+		}
+	}
+	lgr.Debug("Advisory affects repository package versions", "advisory_id", advisoryID, "package_versions", len(out))
+
+	return out, nil
+}
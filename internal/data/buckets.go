@@ -0,0 +1,171 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// SnapshotBucketLayout formats a snapshot's creation time as the monthly
+// bucket of the time-bucketed schema variant.
+const SnapshotBucketLayout = "2006-01"
+
+// SnapshotBucket returns the bucket holding snapshots created at t.
+func SnapshotBucket(t time.Time) string {
+	return t.UTC().Format(SnapshotBucketLayout)
+}
+
+// time-bucketed: a repository ref's snapshots are split into a partition per
+// month, so no partition grows without bound, with snapshot_buckets indexing
+// the buckets each ref has snapshots in
+
+const bucketedSnapshotsTable = `
+CREATE TABLE IF NOT EXISTS %s.snapshots (
+	// unique ID for the record
+	id   uuid,
+
+	// repo metadata
+	owner_id varint,
+	repository_id varint,
+	nwo text,
+	source_url text,
+
+	// Git metadata (ref, commit SHA)
+	ref text,
+	commit_oid text,
+
+	// month of created_at, formatted as YYYY-MM
+	bucket text,
+	created_at timestamp,
+
+	// AzBS target for manifests correlated to this snapshot
+	blob_url text,
+
+	// partition and clustering keys
+	PRIMARY KEY ((repository_id, ref, bucket), created_at)
+) WITH CLUSTERING ORDER BY (created_at DESC);
+`
+
+const snapshotBucketsTable = `
+CREATE TABLE IF NOT EXISTS %s.snapshot_buckets (
+	// partition key: one row per bucket a repository ref has snapshots in
+	repository_id varint,
+	ref text,
+	bucket text,
+
+	// partition and clustering keys
+	PRIMARY KEY ((repository_id, ref), bucket)
+) WITH CLUSTERING ORDER BY (bucket DESC);
+`
+
+// writeBucketedSnapshot writes the snapshot and its bucket's index entry in a
+// logged batch, so a snapshot is never written without being findable.
+func writeBucketedSnapshot(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, sm Snapshot) error {
+	bucket := SnapshotBucket(sm.CreatedAt)
+	stmts := StatementsFor(keyspace)
+	batch := client.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+
+	batch.Query(stmts.InsertBucketedSnapshot,
+		sm.ID,
+		sm.OwnerID,
+		sm.RepositoryID,
+		sm.RepositoryNWO,
+		bucket,
+		sm.CreatedAt,
+		sm.Ref,
+		sm.CommitSHA,
+		sm.BlobURL,
+		sm.SourceURL)
+
+	batch.Query(stmts.InsertSnapshotBucket,
+		sm.RepositoryID,
+		sm.Ref,
+		bucket)
+
+	return executeBatch(ctx, client, batch)
+}
+
+// SnapshotBuckets returns the buckets, newest first, in which the repository
+// ref has snapshots between the buckets of from and to inclusive.
+func SnapshotBuckets(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	repositoryID uint, ref string, from, to time.Time) ([]string, error) {
+
+	var out []string
+	scanner := client.Query(StatementsFor(keyspace).SelectSnapshotBuckets, repositoryID, ref, SnapshotBucket(from), SnapshotBucket(to)).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var bucket string
+		if err := scanner.Scan(&bucket); err != nil {
+			return nil, fmt.Errorf("scanning snapshot_buckets row: %s", err)
+		}
+		out = append(out, bucket)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("querying snapshot buckets of %d %s: %s", repositoryID, ref, err)
+	}
+
+	return out, nil
+}
+
+// BucketedCanonicalSnapshot is CanonicalSnapshot for the time-bucketed
+// layout: it walks the ref's buckets backwards from the newest, returning the
+// latest snapshot of the first bucket that has one.
+func BucketedCanonicalSnapshot(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	repositoryID uint, ref string) (Snapshot, error) {
+
+	buckets, err := SnapshotBuckets(ctx, lgr, client, keyspace, repositoryID, ref, time.Time{}, time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	q := StatementsFor(keyspace).SelectCanonicalBucketedSnapshot
+
+	for _, bucket := range buckets {
+		scanner := client.Query(q, repositoryID, ref, bucket).WithContext(ctx).Iter().Scanner()
+		if !scanner.Next() {
+			if err := scanner.Err(); err != nil {
+				return Snapshot{}, fmt.Errorf("querying snapshots of %d %s in %s: %s", repositoryID, ref, bucket, err)
+			}
+			continue
+		}
+		sm, err := ScanSnapshot(scanner)
+		if err != nil {
+			return Snapshot{}, fmt.Errorf("scanning snapshots row: %s", err)
+		}
+		return sm, scanner.Err()
+	}
+
+	return Snapshot{}, gocql.ErrNotFound
+}
+
+// BucketedSnapshotsInRange is SnapshotsInRange for the time-bucketed layout,
+// querying each bucket overlapping the range from newest to oldest.
+func BucketedSnapshotsInRange(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	repositoryID uint, ref string, from, to time.Time) ([]Snapshot, error) {
+
+	buckets, err := SnapshotBuckets(ctx, lgr, client, keyspace, repositoryID, ref, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	q := StatementsFor(keyspace).SelectBucketedSnapshotsInRange
+
+	var out []Snapshot
+	for _, bucket := range buckets {
+		scanner := client.Query(q, repositoryID, ref, bucket, from, to).WithContext(ctx).Iter().Scanner()
+		for scanner.Next() {
+			sm, err := ScanSnapshot(scanner)
+			if err != nil {
+				return nil, fmt.Errorf("scanning snapshots row: %s", err)
+			}
+			out = append(out, sm)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("querying snapshots of %d %s in %s: %s", repositoryID, ref, bucket, err)
+		}
+	}
+
+	return out, nil
+}
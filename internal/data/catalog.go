@@ -0,0 +1,121 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	// CatalogBuckets is the number of key_catalog partitions.
+	CatalogBuckets = 64
+
+	// max dependencies of each manifest recorded in the key catalog
+	catalogDependencies = 8
+)
+
+// CatalogEntry is a key_catalog row: the keys of a manifest and its snapshot,
+// along with a sample of the manifest's dependencies. The catalog lets
+// benchmarks and tools sample keys without scanning the data tables.
+type CatalogEntry struct {
+	SnapshotID     gocql.UUID
+	ManifestID     gocql.UUID
+	OwnerID        uint
+	RepositoryID   uint
+	Ref            string
+	PackageManager string
+	ManifestKey    string
+	Dependencies   []CatalogDependency
+}
+
+// CatalogDependency is a dependency of a CatalogEntry's manifest, stored as a
+// (namespace, name, version) tuple.
+type CatalogDependency struct {
+	Namespace string
+	Name      string
+	Version   string
+}
+
+// CatalogBucket returns the key_catalog partition of the snapshot.
+func CatalogBucket(snapshotID gocql.UUID) int {
+	h := fnv.New32a()
+	h.Write(snapshotID.Bytes())
+	return int(h.Sum32() % CatalogBuckets)
+}
+
+// writeKeyCatalog records the keys of every manifest of the snapshot. All of a
+// snapshot's entries share a partition, so they are written in a single batch.
+func writeKeyCatalog(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, sm Snapshot) error {
+	q := StatementsFor(keyspace).InsertKeyCatalogEntry
+	bucket := CatalogBucket(sm.ID)
+	batch := client.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+	for _, mm := range sm.Manifests {
+		var deps []CatalogDependency
+		for _, dep := range catalogManifest(mm).Runtime {
+			deps = append(deps, CatalogDependency{dep.Namespace, dep.Name, dep.Version})
+		}
+		batch.Query(q, bucket, sm.ID, mm.ID, sm.OwnerID, sm.RepositoryID, sm.Ref, mm.PackageManager, mm.FilePath, deps)
+
+		if batch.Size() >= batchSize {
+			if err := executeBatch(ctx, client, batch); err != nil {
+				return err
+			}
+			batch = client.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+		}
+	}
+	if batch.Size() > 0 {
+		return executeBatch(ctx, client, batch)
+	}
+
+	return nil
+}
+
+// catalogManifest returns mm with only the first catalogDependencies of its
+// dependencies, as Runtime, copied so the rest can be released.
+func catalogManifest(mm Manifest) Manifest {
+	var deps []Dependency
+	for _, group := range [][]Dependency{mm.Runtime, mm.Development, mm.Transitives} {
+		for _, dep := range group {
+			if len(deps) == catalogDependencies {
+				break
+			}
+			deps = append(deps, dep)
+		}
+	}
+	mm.Runtime, mm.Development, mm.Transitives = deps, nil, nil
+	return mm
+}
+
+// KeyCatalogPage returns a page of the entries in a key_catalog bucket along
+// with the page state of the next page.
+func KeyCatalogPage(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	bucket int, page Page) ([]CatalogEntry, []byte, error) {
+
+	var out []CatalogEntry
+	iter := page.apply(client.Query(StatementsFor(keyspace).SelectKeyCatalogBucket, bucket).WithContext(ctx)).Iter()
+	next := iter.PageState()
+	scanner := iter.Scanner()
+	for scanner.Next() {
+		var ce CatalogEntry
+		if err := scanner.Scan(
+			&ce.SnapshotID,
+			&ce.ManifestID,
+			&ce.OwnerID,
+			&ce.RepositoryID,
+			&ce.Ref,
+			&ce.PackageManager,
+			&ce.ManifestKey,
+			&ce.Dependencies); err != nil {
+			return nil, nil, fmt.Errorf("scanning key_catalog row: %s", err)
+		}
+		out = append(out, ce)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("querying key catalog bucket %d: %s", bucket, err)
+	}
+
+	return out, next, nil
+}
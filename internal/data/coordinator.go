@@ -0,0 +1,254 @@
+package data
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/metrics"
+
+	"github.com/gocql/gocql"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// LoadLimits bounds the loads of a Coordinator. Writes are counted by
+// statement, so a batch counts as many writes as it has statements.
+type LoadLimits struct {
+	// Snapshots is how many snapshots are loaded concurrently.
+	Snapshots int
+	// Workers is how many of each snapshot's manifests are written concurrently.
+	Workers int
+	// InFlight caps the statements executing at once across every load, if
+	// positive. A batch larger than InFlight executes alone.
+	InFlight int
+	// Rate caps the statements started per second across every load, if positive.
+	Rate float64
+}
+
+// DefaultLoadLimits loads a snapshot at a time, as Load does.
+var DefaultLoadLimits = LoadLimits{Snapshots: 1, Workers: maxWriteConcurrency}
+
+// Coordinator loads many snapshots concurrently, sharing one budget of
+// in-flight writes and write rate between them.
+type Coordinator struct {
+	limits  LoadLimits
+	limiter *writeLimiter
+	group   *errgroup.Group
+	ctx     context.Context
+	start   time.Time
+}
+
+// NewCoordinator returns a Coordinator loading within limits. Loads started
+// with Go are canceled along with ctx.
+func NewCoordinator(ctx context.Context, limits LoadLimits) *Coordinator {
+	if limits.Snapshots < 1 {
+		limits.Snapshots = DefaultLoadLimits.Snapshots
+	}
+	if limits.Workers < 1 {
+		limits.Workers = DefaultLoadLimits.Workers
+	}
+
+	group, gctx := errgroup.WithContext(ctx)
+	group.SetLimit(limits.Snapshots)
+	return &Coordinator{
+		limits:  limits,
+		limiter: newWriteLimiter(limits.InFlight, limits.Rate),
+		group:   group,
+		ctx:     gctx,
+		start:   time.Now(),
+	}
+}
+
+// Go calls load in a new goroutine once fewer than limits.Snapshots calls are
+// running, blocking until then. load should load a snapshot, into one or more
+// schema variants, with the coordinator's Load or LoadStream and the context
+// it is passed, which is canceled once any load fails.
+func (c *Coordinator) Go(load func(ctx context.Context) error) {
+	c.group.Go(func() error {
+		return load(c.ctx)
+	})
+}
+
+// Done is closed once any load started with Go fails, or the context the
+// coordinator was created with is done, so callers feeding loads can stop.
+func (c *Coordinator) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
+// Wait waits for every load started with Go, returning the first error.
+func (c *Coordinator) Wait() error {
+	return c.group.Wait()
+}
+
+// Load writes the snapshot into keyspace with the variant's write path as
+// variant.Load does, within the coordinator's limits.
+func (c *Coordinator) Load(ctx context.Context, lgr *slog.Logger, client *gocql.Session, variant Variant,
+	snapshot Snapshot, keyspace string) error {
+	return c.LoadStream(ctx, lgr, client, variant, snapshot, manifestChannel(snapshot), keyspace)
+}
+
+// LoadStream writes the snapshot into keyspace with the variant's write path
+// as variant.LoadStream does, within the coordinator's limits.
+func (c *Coordinator) LoadStream(ctx context.Context, lgr *slog.Logger, client *gocql.Session, variant Variant,
+	snapshot Snapshot, manifests <-chan Manifest, keyspace string) error {
+	ctx = context.WithValue(ctx, writeLimiterKey{}, c.limiter)
+	return loadStream(ctx, lgr, client, snapshot, manifests, keyspace, variant.writes, c.limits.Workers)
+}
+
+// Writes returns the number of statements executed by the coordinator's
+// loads, alone or in batches, and their rate per second since it was created.
+func (c *Coordinator) Writes() (int64, float64) {
+	n := c.limiter.writes.Load()
+	return n, float64(n) / time.Since(c.start).Seconds()
+}
+
+type writeLimiterKey struct{}
+
+// writeLimiter admits writes of n statements once no more than cap
+// statements would be executing and n intervals have passed since the
+// previous write was admitted. Either limit is skipped when unset.
+type writeLimiter struct {
+	inFlight *semaphore.Weighted
+	cap      int64
+	interval time.Duration
+	writes   atomic.Int64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newWriteLimiter(inFlight int, rate float64) *writeLimiter {
+	l := &writeLimiter{}
+	if inFlight > 0 {
+		l.inFlight, l.cap = semaphore.NewWeighted(int64(inFlight)), int64(inFlight)
+	}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return l
+}
+
+// acquire blocks until a write of n statements may start; release must be
+// called with the same n once it's done.
+func (l *writeLimiter) acquire(ctx context.Context, n int) error {
+	if l.interval > 0 {
+		l.mu.Lock()
+		now := time.Now()
+		if l.next.Before(now) {
+			l.next = now
+		}
+		wait := l.next.Sub(now)
+		l.next = l.next.Add(time.Duration(n) * l.interval)
+		l.mu.Unlock()
+
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+	}
+
+	if l.inFlight != nil {
+		if err := l.inFlight.Acquire(ctx, l.permits(n)); err != nil {
+			return err
+		}
+	}
+	l.writes.Add(int64(n))
+	return nil
+}
+
+func (l *writeLimiter) release(n int) {
+	if l.inFlight != nil {
+		l.inFlight.Release(l.permits(n))
+	}
+}
+
+// permits returns the in-flight permits a write of n statements holds: one
+// per statement, but no more than the limit, so an oversized batch can run.
+func (l *writeLimiter) permits(n int) int64 {
+	if int64(n) > l.cap {
+		return l.cap
+	}
+	return int64(n)
+}
+
+// execWrite executes the write query within the limits of the Coordinator
+// loading under ctx, if any.
+func execWrite(ctx context.Context, q *gocql.Query) error {
+	return limitWrite(ctx, 1, q.Exec)
+}
+
+// executeBatch executes the batch as execWrite does, counting each of its
+// statements against the limits.
+func executeBatch(ctx context.Context, client *gocql.Session, batch *gocql.Batch) error {
+	return limitWrite(ctx, batch.Size(), func() error {
+		return client.ExecuteBatch(batch)
+	})
+}
+
+// limitWrite executes write, of the given number of statements, once the
+// limiter under ctx, if any, admits it.
+func limitWrite(ctx context.Context, statements int, write func() error) error {
+	// a canceled load writes nothing more, rather than completing a snapshot
+	// whose manifests stopped arriving
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l, _ := ctx.Value(writeLimiterKey{}).(*writeLimiter); l != nil {
+		if statements < 1 {
+			statements = 1
+		}
+		if err := l.acquire(ctx, statements); err != nil {
+			return err
+		}
+		defer l.release(statements)
+	}
+
+	metrics.WritesInFlight.Inc()
+	defer metrics.WritesInFlight.Dec()
+	return write()
+}
+
+// keyedMutex holds a mutex per key, created on first use and dropped once no
+// goroutine holds or waits for it.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[any]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock blocks until key's mutex is held, returning the func releasing it.
+func (km *keyedMutex) lock(key any) func() {
+	km.mu.Lock()
+	if km.locks == nil {
+		km.locks = map[any]*keyedLock{}
+	}
+	l := km.locks[key]
+	if l == nil {
+		l = &keyedLock{}
+		km.locks[key] = l
+	}
+	l.refs++
+	km.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		km.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(km.locks, key)
+		}
+		km.mu.Unlock()
+	}
+}
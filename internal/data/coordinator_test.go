@@ -0,0 +1,146 @@
+package data
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteLimiterInFlight(t *testing.T) {
+	l := newWriteLimiter(3, 0)
+
+	var running, peak atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.acquire(context.Background(), 1); err != nil {
+				t.Error(err)
+				return
+			}
+			defer l.release(1)
+
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	if peak.Load() > 3 {
+		t.Errorf("expected at most 3 writes in flight, got %d", peak.Load())
+	}
+	if l.writes.Load() != 20 {
+		t.Errorf("expected 20 writes counted, got %d", l.writes.Load())
+	}
+}
+
+func TestWriteLimiterRate(t *testing.T) {
+	l := newWriteLimiter(0, 1000)
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		if err := l.acquire(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+		l.release(1)
+	}
+	// the first write starts immediately, the rest a millisecond apart
+	if elapsed := time.Since(start); elapsed < 19*time.Millisecond {
+		t.Errorf("expected 20 writes at 1000/sec to take at least 19ms, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := newWriteLimiter(0, 0.001)
+	slow.acquire(ctx, 1)
+	if err := slow.acquire(ctx, 1); err != context.Canceled {
+		t.Errorf("expected a canceled write to give up waiting, got %v", err)
+	}
+}
+
+func TestWriteLimiterBatches(t *testing.T) {
+	l := newWriteLimiter(10, 0)
+
+	// a batch holds a permit per statement
+	if err := l.acquire(context.Background(), 8); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx, 3); err != context.DeadlineExceeded {
+		t.Errorf("expected 3 more statements to wait for the batch of 8, got %v", err)
+	}
+	if err := l.acquire(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	l.release(2)
+	l.release(8)
+
+	// a batch larger than the limit runs alone rather than never
+	if err := l.acquire(context.Background(), 25); err != nil {
+		t.Fatal(err)
+	}
+	l.release(25)
+	if l.writes.Load() != 35 {
+		t.Errorf("expected 35 statements counted, got %d", l.writes.Load())
+	}
+
+	// the rate limit spaces writes by their statements
+	rl := newWriteLimiter(0, 1000)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := rl.acquire(context.Background(), 5); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected 25 statements at 1000/sec to take at least 20ms, took %s", elapsed)
+	}
+}
+
+func TestKeyedMutex(t *testing.T) {
+	var km keyedMutex
+
+	var running, peak atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// even goroutines share a key, odd ones each have their own
+			key := any(refLockKey{"ks", 1, DefaultRef})
+			if i%2 == 1 {
+				key = refLockKey{"ks", uint(i), DefaultRef}
+			}
+			unlock := km.lock(key)
+			defer unlock()
+
+			if i%2 == 0 {
+				n := running.Add(1)
+				if n > peak.Load() {
+					peak.Store(n)
+				}
+				time.Sleep(time.Millisecond)
+				running.Add(-1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if peak.Load() != 1 {
+		t.Errorf("expected holders of the same key to run one at a time, got %d at once", peak.Load())
+	}
+	if len(km.locks) != 0 {
+		t.Errorf("expected released locks to be dropped, %d remain", len(km.locks))
+	}
+}
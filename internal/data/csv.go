@@ -0,0 +1,500 @@
+package data
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/advisories"
+	"github.com/elireisman/cass-dsapi/internal/versions"
+
+	"github.com/gocql/gocql"
+)
+
+// CSVDialects are the bulk loaders CSVExporter can encode values for:
+// "cqlsh" writes collections and tuples as CQL literals for cqlsh COPY FROM,
+// and "dsbulk" writes them as JSON for DSBulk.
+var CSVDialects = []string{"cqlsh", "dsbulk"}
+
+// csvColumns are the columns of each baseline table, in the order
+// CSVExporter writes them.
+var csvColumns = map[string][]string{
+	"snapshots":                   {"id", "owner_id", "repository_id", "nwo", "created_at", "ref", "commit_oid", "blob_url", "source_url"},
+	"latest_snapshots":            {"repository_id", "ref", "snapshot_id", "owner_id", "nwo", "created_at", "commit_oid", "blob_url", "source_url"},
+	"repository_refs":             {"repository_id", "ref", "latest_snapshot_id", "updated_at"},
+	"owner_repositories":          {"owner_id", "repository_id", "nwo", "source_url", "updated_at"},
+	"owner_repository_packages":   {"owner_id", "repository_id", "package_manager", "namespace", "name", "version", "license", "snapshot_id"},
+	"owner_packages":              {"owner_id", "package_manager", "namespace", "name", "version", "repository_id", "license", "nwo"},
+	"owner_ecosystem_counts":      {"owner_id", "package_manager", "used"},
+	"owner_license_counts":        {"owner_id", "license", "used"},
+	"manifests":                   {"id", "snapshot_id", "owner_id", "repository_id", "ref", "commit_oid", "blob_key", "manifest_key", "package_manager", "project_name", "project_version", "project_license"},
+	"manifest_dependencies":       {"manifest_id", "package_manager", "namespace", "name", "version", "snapshot_id", "license", "source_url", "scope", "relationship", "runtime", "development"},
+	"dependent_repositories":      {"package_manager", "namespace", "name", "version_key", "version", "repository_id", "owner_id", "license", "source_url", "manifest_keys"},
+	"dependent_repository_counts": {"package_manager", "namespace", "name", "version_key", "version", "used_by"},
+	"advisories":                  {"id", "summary", "details", "severity", "aliases", "published", "modified"},
+	"advisory_affected_packages":  {"advisory_id", "package_manager", "namespace", "name", "ranges"},
+	"key_catalog":                 {"bucket", "snapshot_id", "manifest_id", "owner_id", "repository_id", "ref", "package_manager", "manifest_key", "dependencies"},
+}
+
+// csvNull marks null values in the COPY FROM statements written for cqlsh,
+// whose default of an empty field would otherwise turn empty strings,
+// including empty clustering columns, into nulls. No value is ever null.
+const csvNull = `\N`
+
+// csvList marks a []string as a list<text> value rather than a set<text>.
+type csvList []string
+
+// CSVExporter writes snapshots and advisories as a CSV file per baseline
+// table, for bulk loading with cqlsh COPY FROM or DSBulk rather than CQL
+// writes. Rows the loader writes once per snapshot or manifest are written as
+// they are received. Rows the loader overwrites or merges across snapshots,
+// the lookup tables, owner inventories, dependent_repositories and the
+// counter tables, are held and written by Close with the values the loader
+// would have left: the latest snapshot of each ref and repository, and
+// counters summed over every snapshot. Memory use therefore grows with the
+// distinct (package version, repository) pairs exported.
+type CSVExporter struct {
+	dir      string
+	keyspace string
+	dialect  string
+	files    map[string]*csvFile
+
+	snapshot  Snapshot
+	inventory map[inventoryKey]string
+
+	latest      map[refKey]Snapshot
+	owners      map[ownerRepositoryKey]Snapshot
+	inventories map[ownerRepositoryKey]csvInventory
+	dependents  map[dependentKey]*csvDependent
+	usage       map[inventoryKey]int64
+}
+
+type refKey struct {
+	RepositoryID uint
+	Ref          string
+}
+
+type ownerRepositoryKey struct {
+	OwnerID      uint
+	RepositoryID uint
+}
+
+type dependentKey struct {
+	inventoryKey
+	RepositoryID uint
+}
+
+type csvInventory struct {
+	snapshot Snapshot
+	packages map[inventoryKey]string
+}
+
+type csvDependent struct {
+	ownerID      uint
+	license      string
+	sourceURL    string
+	manifestKeys map[string]struct{}
+}
+
+// NewCSVExporter creates dir if need be, and returns a CSVExporter writing
+// the CSV files of the keyspace's tables into it, encoded for dialect.
+func NewCSVExporter(dir, keyspace, dialect string) (*CSVExporter, error) {
+	if dialect != "cqlsh" && dialect != "dsbulk" {
+		return nil, fmt.Errorf("unknown CSV dialect %q, expected one of: %s", dialect, strings.Join(CSVDialects, ", "))
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating export directory: %s", err)
+	}
+
+	e := &CSVExporter{
+		dir:         dir,
+		keyspace:    keyspace,
+		dialect:     dialect,
+		files:       map[string]*csvFile{},
+		latest:      map[refKey]Snapshot{},
+		owners:      map[ownerRepositoryKey]Snapshot{},
+		inventories: map[ownerRepositoryKey]csvInventory{},
+		dependents:  map[dependentKey]*csvDependent{},
+		usage:       map[inventoryKey]int64{},
+	}
+	for _, table := range csvTables() {
+		f, err := createCSVFile(filepath.Join(dir, table+".csv"), csvColumns[table])
+		if err != nil {
+			e.closeFiles()
+			return nil, err
+		}
+		e.files[table] = f
+	}
+	return e, nil
+}
+
+// csvTables returns the exported tables in the order of their definitions.
+func csvTables() []string {
+	var out []string
+	for _, ddl := range tables {
+		out = append(out, tableName(ddl))
+	}
+	return out
+}
+
+// tableName returns the name of the table a statement of tables creates.
+func tableName(ddl string) string {
+	name := ddl[strings.Index(ddl, "%s.")+len("%s."):]
+	return name[:strings.IndexAny(name, " (")]
+}
+
+// WriteSnapshot writes the snapshot followed by each of its manifests.
+// Snapshots streamed from StreamSnapshot have no manifests; write them with
+// WriteManifest as they are received instead.
+func (e *CSVExporter) WriteSnapshot(sm Snapshot) error {
+	e.finishSnapshot()
+
+	header := sm
+	header.Manifests = nil
+	e.snapshot, e.inventory = header, map[inventoryKey]string{}
+	if err := e.write("snapshots", BindSnapshot(sm)...); err != nil {
+		return err
+	}
+
+	if latest, ok := e.latest[refKey{sm.RepositoryID, sm.Ref}]; !ok || !latest.CreatedAt.After(sm.CreatedAt) {
+		e.latest[refKey{sm.RepositoryID, sm.Ref}] = header
+	}
+	if latest, ok := e.owners[ownerRepositoryKey{sm.OwnerID, sm.RepositoryID}]; !ok || !latest.CreatedAt.After(sm.CreatedAt) {
+		e.owners[ownerRepositoryKey{sm.OwnerID, sm.RepositoryID}] = header
+	}
+
+	for _, mm := range sm.Manifests {
+		if err := e.WriteManifest(mm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteManifest writes a manifest of the snapshot last written, and its
+// dependencies.
+func (e *CSVExporter) WriteManifest(mm Manifest) error {
+	sm := e.snapshot
+	if err := e.write("manifests", BindManifest(sm, mm)...); err != nil {
+		return err
+	}
+
+	var catalog []CatalogDependency
+	for _, dep := range catalogManifest(mm).Runtime {
+		catalog = append(catalog, CatalogDependency{dep.Namespace, dep.Name, dep.Version})
+	}
+	if err := e.write("key_catalog", CatalogBucket(sm.ID), sm.ID, mm.ID, sm.OwnerID, sm.RepositoryID, sm.Ref,
+		mm.PackageManager, mm.FilePath, catalog); err != nil {
+		return err
+	}
+
+	for _, deps := range [][]Dependency{mm.Runtime, mm.Development, mm.Transitives} {
+		for _, dep := range deps {
+			if err := e.write("manifest_dependencies", BindManifestDependency(sm, mm, dep)...); err != nil {
+				return err
+			}
+
+			// each dependency is an upsert of its dependent_repositories row,
+			// adding the manifest's path, and an increment of its usage count
+			key := inventoryKey{mm.PackageManager, dep.Namespace, dep.Name, dep.Version}
+			dr := e.dependents[dependentKey{key, sm.RepositoryID}]
+			if dr == nil {
+				dr = &csvDependent{manifestKeys: map[string]struct{}{}}
+				e.dependents[dependentKey{key, sm.RepositoryID}] = dr
+			}
+			dr.ownerID, dr.license, dr.sourceURL = sm.OwnerID, dep.License, dep.SourceURL
+			dr.manifestKeys[mm.FilePath] = struct{}{}
+			e.usage[key]++
+		}
+	}
+	addInventory(e.inventory, mm)
+	return nil
+}
+
+// finishSnapshot keeps the inventory of the snapshot last written if it is
+// the latest of its repository's default ref, as updateOwnerInventory does.
+func (e *CSVExporter) finishSnapshot() {
+	sm := e.snapshot
+	if e.inventory == nil || sm.Ref != DefaultRef {
+		return
+	}
+	key := ownerRepositoryKey{sm.OwnerID, sm.RepositoryID}
+	if current, ok := e.inventories[key]; ok && current.snapshot.CreatedAt.After(sm.CreatedAt) {
+		return
+	}
+	e.inventories[key] = csvInventory{snapshot: sm, packages: e.inventory}
+}
+
+// WriteAdvisory writes the advisory and its affected package ranges.
+func (e *CSVExporter) WriteAdvisory(adv advisories.Advisory) error {
+	if err := e.write("advisories", adv.ID, adv.Summary, adv.Details, adv.Severity, adv.Aliases, adv.Published, adv.Modified); err != nil {
+		return err
+	}
+	for _, pkg := range adv.Affected {
+		if err := e.write("advisory_affected_packages", adv.ID, pkg.PackageManager, pkg.Namespace, pkg.Name, csvList(pkg.Ranges)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the rows held for the lookup, inventory, reverse dependency
+// and counter tables, then scripts creating the tables and loading the files
+// (see writeScripts), and closes the files. It must be called for
+// the export to be complete.
+func (e *CSVExporter) Close() error {
+	err := e.writeHeld()
+	if err == nil {
+		err = e.writeScripts()
+	}
+	if cerr := e.closeFiles(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (e *CSVExporter) writeHeld() error {
+	e.finishSnapshot()
+
+	for _, sm := range e.latest {
+		if err := e.write("latest_snapshots", sm.RepositoryID, sm.Ref, sm.ID, sm.OwnerID, sm.RepositoryNWO, sm.CreatedAt,
+			sm.CommitSHA, sm.BlobURL, sm.SourceURL); err != nil {
+			return err
+		}
+		if err := e.write("repository_refs", sm.RepositoryID, sm.Ref, sm.ID, sm.CreatedAt); err != nil {
+			return err
+		}
+	}
+	for _, sm := range e.owners {
+		if err := e.write("owner_repositories", sm.OwnerID, sm.RepositoryID, sm.RepositoryNWO, sm.SourceURL, sm.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	ecosystems := map[ownerCountKey]int64{}
+	licenses := map[ownerCountKey]int64{}
+	for _, inv := range e.inventories {
+		sm := inv.snapshot
+		for key, license := range inv.packages {
+			if err := e.write("owner_repository_packages", sm.OwnerID, sm.RepositoryID, key.PackageManager, key.Namespace,
+				key.Name, key.Version, license, sm.ID); err != nil {
+				return err
+			}
+			if err := e.write("owner_packages", sm.OwnerID, key.PackageManager, key.Namespace, key.Name, key.Version,
+				sm.RepositoryID, license, sm.RepositoryNWO); err != nil {
+				return err
+			}
+			ecosystems[ownerCountKey{sm.OwnerID, key.PackageManager}]++
+			licenses[ownerCountKey{sm.OwnerID, license}]++
+		}
+	}
+	for key, used := range ecosystems {
+		if err := e.write("owner_ecosystem_counts", key.OwnerID, key.Value, used); err != nil {
+			return err
+		}
+	}
+	for key, used := range licenses {
+		if err := e.write("owner_license_counts", key.OwnerID, key.Value, used); err != nil {
+			return err
+		}
+	}
+
+	for key, dr := range e.dependents {
+		keys := make([]string, 0, len(dr.manifestKeys))
+		for k := range dr.manifestKeys {
+			keys = append(keys, k)
+		}
+		if err := e.write("dependent_repositories", key.PackageManager, key.Namespace, key.Name,
+			versions.Key(key.PackageManager, key.Version), key.Version, key.RepositoryID, dr.ownerID, dr.license, dr.sourceURL, keys); err != nil {
+			return err
+		}
+	}
+	for key, used := range e.usage {
+		if err := e.write("dependent_repository_counts", key.PackageManager, key.Namespace, key.Name,
+			versions.Key(key.PackageManager, key.Version), key.Version, used); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type ownerCountKey struct {
+	OwnerID uint
+	Value   string
+}
+
+// writeScripts writes schema.cql, creating the keyspace and tables, and a
+// script loading each file: load.cql of cqlsh COPY FROM statements, or
+// load.sh of dsbulk load commands.
+func (e *CSVExporter) writeScripts() error {
+	dir, err := filepath.Abs(e.dir)
+	if err != nil {
+		return fmt.Errorf("resolving export directory: %s", err)
+	}
+
+	var schema, load strings.Builder
+	schema.WriteString(keyspaceDDL(e.keyspace) + ";\n")
+	for _, ddl := range tables {
+		schema.WriteString(fmt.Sprintf(ddl, e.keyspace))
+	}
+
+	script, mode := "load.cql", os.FileMode(0o644)
+	if e.dialect == "dsbulk" {
+		script, mode = "load.sh", 0o755
+		load.WriteString("#!/bin/sh\nset -e\n")
+	}
+	for _, table := range csvTables() {
+		path := filepath.Join(dir, table+".csv")
+		if e.dialect == "dsbulk" {
+			fmt.Fprintf(&load, "dsbulk load -k %s -t %s -url '%s' -header true\n", e.keyspace, table, path)
+			continue
+		}
+		fmt.Fprintf(&load, "COPY %s.%s (%s) FROM '%s' WITH HEADER = true AND NULL = '%s';\n",
+			e.keyspace, table, strings.Join(csvColumns[table], ", "), path, csvNull)
+	}
+
+	if err := os.WriteFile(filepath.Join(e.dir, "schema.cql"), []byte(schema.String()), 0o644); err != nil {
+		return fmt.Errorf("writing schema script: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(e.dir, script), []byte(load.String()), mode); err != nil {
+		return fmt.Errorf("writing load script: %s", err)
+	}
+	return nil
+}
+
+func (e *CSVExporter) write(table string, values ...interface{}) error {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		field, err := e.format(v)
+		if err != nil {
+			return fmt.Errorf("writing %s row: %s", table, err)
+		}
+		fields[i] = field
+	}
+	if err := e.files[table].write(fields); err != nil {
+		return fmt.Errorf("writing %s row: %s", table, err)
+	}
+	return nil
+}
+
+// format encodes a value as cqlsh COPY TO would, or as DSBulk's default
+// codecs expect: timestamps with millisecond precision, as stored, and
+// collections and tuples as CQL literals or JSON arrays. Sets are sorted and
+// deduplicated.
+func (e *CSVExporter) format(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case gocql.UUID:
+		return v.String(), nil
+	case time.Time:
+		if e.dialect == "dsbulk" {
+			return v.UTC().Format("2006-01-02T15:04:05.000Z"), nil
+		}
+		return v.UTC().Format("2006-01-02 15:04:05.000-0700"), nil
+	case []string:
+		set := append([]string(nil), v...)
+		sort.Strings(set)
+		for i := len(set) - 1; i > 0; i-- {
+			if set[i] == set[i-1] {
+				set = append(set[:i], set[i+1:]...)
+			}
+		}
+		return e.collection("{", "}", set), nil
+	case csvList:
+		return e.collection("[", "]", v), nil
+	case []CatalogDependency:
+		tuples := make([]string, len(v))
+		for i, dep := range v {
+			tuples[i] = e.collection("(", ")", []string{dep.Namespace, dep.Name, dep.Version})
+		}
+		if e.dialect == "dsbulk" {
+			return "[" + strings.Join(tuples, ",") + "]", nil
+		}
+		return "[" + strings.Join(tuples, ", ") + "]", nil
+	default:
+		return "", fmt.Errorf("unsupported CSV value type %T", v)
+	}
+}
+
+// collection encodes strings as a CQL collection or tuple literal delimited
+// by open and close, or as a JSON array for DSBulk.
+func (e *CSVExporter) collection(open, close string, elems []string) string {
+	if e.dialect == "dsbulk" {
+		if elems == nil {
+			elems = []string{}
+		}
+		out, _ := json.Marshal(elems)
+		return string(out)
+	}
+
+	quoted := make([]string, len(elems))
+	for i, elem := range elems {
+		quoted[i] = "'" + strings.ReplaceAll(elem, "'", "''") + "'"
+	}
+	return open + strings.Join(quoted, ", ") + close
+}
+
+func (e *CSVExporter) closeFiles() error {
+	var err error
+	for _, f := range e.files {
+		if cerr := f.close(); err == nil && cerr != nil {
+			err = fmt.Errorf("closing CSV file: %s", cerr)
+		}
+	}
+	return err
+}
+
+// csvFile writes CSV records, quoting empty fields so that bulk loaders
+// read them as empty strings rather than nulls.
+type csvFile struct {
+	f   *os.File
+	buf *bufio.Writer
+}
+
+func createCSVFile(path string, columns []string) (*csvFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating CSV file: %s", err)
+	}
+	cf := &csvFile{f: f, buf: bufio.NewWriterSize(f, 1<<16)}
+	if err := cf.write(columns); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return cf, nil
+}
+
+func (cf *csvFile) write(fields []string) error {
+	for i, field := range fields {
+		if i > 0 {
+			cf.buf.WriteByte(',')
+		}
+		if field == "" || strings.ContainsAny(field, ",\"\r\n") || field[0] == ' ' {
+			field = `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+		}
+		cf.buf.WriteString(field)
+	}
+	_, err := cf.buf.WriteString("\n")
+	return err
+}
+
+func (cf *csvFile) close() error {
+	err := cf.buf.Flush()
+	if cerr := cf.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
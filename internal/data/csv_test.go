@@ -0,0 +1,157 @@
+package data
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func TestCSVColumns(t *testing.T) {
+	column := regexp.MustCompile(`(?m)^\s*([a-z_]+)\s+(uuid|varint|text|timestamp|int|counter|set<|list<)`)
+	for _, ddl := range tables {
+		table := tableName(ddl)
+		var want []string
+		for _, m := range column.FindAllStringSubmatch(ddl, -1) {
+			want = append(want, m[1])
+		}
+		got := append([]string(nil), csvColumns[table]...)
+		sort.Strings(want)
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: expected CSV columns %v, got %v", table, want, got)
+		}
+	}
+}
+
+func TestCSVExporter(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 678900000, time.UTC)
+	dep := func(name, version string) Dependency {
+		return Dependency{Namespace: "ns", Name: name, Version: version, License: "MIT", Development: []string{"it's", "b", "it's"}}
+	}
+	older := Snapshot{ID: gocql.TimeUUID(), OwnerID: 7, RepositoryID: 42, Ref: DefaultRef, CreatedAt: created, Manifests: []Manifest{
+		{ID: gocql.TimeUUID(), PackageManager: "npm", FilePath: "package.json", Runtime: []Dependency{dep("left", "1.0.0"), dep("gone", "1.0.0")}},
+	}}
+	newer := Snapshot{ID: gocql.TimeUUID(), OwnerID: 7, RepositoryID: 42, Ref: DefaultRef, CreatedAt: created.Add(time.Hour), Manifests: []Manifest{
+		{ID: gocql.TimeUUID(), PackageManager: "npm", FilePath: "package.json", Runtime: []Dependency{dep("left", "1.0.0")}},
+		{ID: gocql.TimeUUID(), PackageManager: "npm", FilePath: "web/package.json", Runtime: []Dependency{dep("left", "1.0.0")}},
+	}}
+	branch := Snapshot{ID: gocql.TimeUUID(), OwnerID: 7, RepositoryID: 42, Ref: "refs/heads/dev", CreatedAt: created.Add(2 * time.Hour)}
+
+	for _, dialect := range CSVDialects {
+		dir := t.TempDir()
+		e, err := NewCSVExporter(dir, "ks", dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// loaded out of order, as concurrent loads may be
+		for _, sm := range []Snapshot{newer, older, branch} {
+			if err := e.WriteSnapshot(sm); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		read := func(table string) []map[string]string {
+			f, err := os.Open(filepath.Join(dir, table+".csv"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			records, err := csv.NewReader(f).ReadAll()
+			if err != nil {
+				t.Fatalf("%s: %s", table, err)
+			}
+			var rows []map[string]string
+			for _, rec := range records[1:] {
+				row := map[string]string{}
+				for i, col := range records[0] {
+					row[col] = rec[i]
+				}
+				rows = append(rows, row)
+			}
+			return rows
+		}
+
+		if n := len(read("snapshots")); n != 3 {
+			t.Errorf("%s: expected every snapshot, got %d", dialect, n)
+		}
+		if n := len(read("manifest_dependencies")); n != 4 {
+			t.Errorf("%s: expected every manifest's dependencies, got %d", dialect, n)
+		}
+		if rows := read("latest_snapshots"); len(rows) != 2 {
+			t.Errorf("%s: expected a latest snapshot per ref, got %d", dialect, len(rows))
+		}
+		if rows := read("owner_repositories"); len(rows) != 1 || !strings.Contains(rows[0]["updated_at"], "05:04:05.678") {
+			t.Errorf("%s: expected the repository as of its newest snapshot, got %v", dialect, rows)
+		}
+
+		// the inventory is that of the newest default ref snapshot only
+		inventory := read("owner_repository_packages")
+		if len(inventory) != 1 || inventory[0]["name"] != "left" || inventory[0]["snapshot_id"] != newer.ID.String() {
+			t.Errorf("%s: expected only the newer snapshot's package, got %v", dialect, inventory)
+		}
+		if rows := read("owner_ecosystem_counts"); len(rows) != 1 || rows[0]["used"] != "1" {
+			t.Errorf("%s: expected a count of 1 npm package version, got %v", dialect, rows)
+		}
+
+		// usage counts every loaded dependency row, as the loader's increments do
+		counts := map[string]string{}
+		for _, row := range read("dependent_repository_counts") {
+			counts[row["name"]] = row["used_by"]
+		}
+		if counts["left"] != "3" || counts["gone"] != "1" {
+			t.Errorf("%s: unexpected usage counts %v", dialect, counts)
+		}
+
+		wantKeys, wantDev, wantTime := `{'package.json', 'web/package.json'}`, `{'b', 'it''s'}`, "2024-01-02 03:04:05.678+0000"
+		if dialect == "dsbulk" {
+			wantKeys, wantDev, wantTime = `["package.json","web/package.json"]`, `["b","it's"]`, "2024-01-02T03:04:05.678Z"
+		}
+		for _, row := range read("dependent_repositories") {
+			if row["name"] == "left" && row["manifest_keys"] != wantKeys {
+				t.Errorf("%s: expected merged manifest keys %s, got %s", dialect, wantKeys, row["manifest_keys"])
+			}
+		}
+		if dev := read("manifest_dependencies")[0]["development"]; dev != wantDev {
+			t.Errorf("%s: expected set %s, got %s", dialect, wantDev, dev)
+		}
+		for _, row := range read("snapshots") {
+			if row["id"] == older.ID.String() && row["created_at"] != wantTime {
+				t.Errorf("%s: expected timestamp %s, got %s", dialect, wantTime, row["created_at"])
+			}
+		}
+
+		script, want := "load.cql", "COPY ks.dependent_repository_counts (package_manager, namespace, name, version_key, version, used_by) FROM"
+		if dialect == "dsbulk" {
+			script, want = "load.sh", "dsbulk load -k ks -t dependent_repository_counts -url"
+		}
+		out, err := os.ReadFile(filepath.Join(dir, script))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), want) {
+			t.Errorf("%s: expected a load command per table, got %s", dialect, out)
+		}
+	}
+}
+
+func TestCSVExporterUnsupportedType(t *testing.T) {
+	e, err := NewCSVExporter(t.TempDir(), "ks", "cqlsh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	if err := e.write("snapshots", 1.5); err == nil || !strings.Contains(err.Error(), "unsupported CSV value type float64") {
+		t.Errorf("expected an unsupported type error, got %v", err)
+	}
+}
@@ -5,14 +5,18 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/elireisman/cass-dsapi/internal/advisories"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
+
 	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // words list cache to build various generated data from
@@ -60,16 +64,17 @@ type Manifest struct {
 	Transitives    []Dependency
 }
 
+// cql tags map Dependency onto the dependency UDT of the frozen-udt schema variant
 type Dependency struct {
-	Namespace    string
-	Name         string
-	Version      string
-	SourceURL    string
-	License      string
-	Scope        string
-	Relationship string
-	Runtime      []string // PURLs of transitive deps
-	Development  []string // PURLs of transitive deps
+	Namespace    string   `cql:"namespace"`
+	Name         string   `cql:"name"`
+	Version      string   `cql:"version"`
+	SourceURL    string   `cql:"source_url"`
+	License      string   `cql:"license"`
+	Scope        string   `cql:"scope"`
+	Relationship string   `cql:"relationship"`
+	Runtime      []string `cql:"runtime"`     // PURLs of transitive deps
+	Development  []string `cql:"development"` // PURLs of transitive deps
 }
 
 func (pm Dependency) ToPURL(pkgMgr string) string {
@@ -83,13 +88,36 @@ func (pm Dependency) ToPURL(pkgMgr string) string {
 	return fmt.Sprintf("%s:%s/%s@%s", pkgMgr, ns, name, pm.Version)
 }
 
-func GenerateSnapshot(ctx context.Context, lgr *log.Logger, canonical *Snapshot, manifestCount, maxDepsPer int) (Snapshot, error) {
+// GenerateSnapshot generates a snapshot along with all of its manifests. With
+// a canonical snapshot, the new snapshot is another commit of the same
+// repository ref.
+func GenerateSnapshot(ctx context.Context, lgr *slog.Logger, canonical *Snapshot, manifestCount, maxDepsPer int) (Snapshot, error) {
+	snapshot, manifests, errs := StreamSnapshot(ctx, lgr, canonical, manifestCount, maxDepsPer, 0)
+	for manifest := range manifests {
+		snapshot.Manifests = append(snapshot.Manifests, manifest)
+	}
+	return snapshot, <-errs
+}
+
+// StreamSnapshot generates a snapshot as GenerateSnapshot does, but returns it
+// without Manifests and sends each manifest on the returned channel as it is
+// generated, buffering at most buffer of them until they are received. The
+// channel is closed once every manifest is sent, generation fails or ctx is
+// done, after which the error channel yields the outcome. Only the manifest
+// being generated is held, so memory use depends on buffer and maxDepsPer
+// rather than manifestCount.
+func StreamSnapshot(ctx context.Context, lgr *slog.Logger, canonical *Snapshot, manifestCount, maxDepsPer, buffer int) (Snapshot, <-chan Manifest, <-chan error) {
+	manifests := make(chan Manifest, buffer)
+	errs := make(chan error, 1)
+
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	pool := generatePackagePool(r, 10000)
 
 	snapID, err := gocql.RandomUUID()
 	if err != nil {
-		return Snapshot{}, err
+		close(manifests)
+		errs <- err
+		return Snapshot{}, manifests, errs
 	}
 	snapshot := Snapshot{ID: snapID}
 
@@ -105,39 +133,122 @@ func GenerateSnapshot(ctx context.Context, lgr *log.Logger, canonical *Snapshot,
 			Ref:           "refs/heads/main",
 			CreatedAt:     time.Now(),
 		}
-		lgr.Printf("Creating Snapshot %s: %+v", snapshot.ID, snapshot)
+		lgr.Debug("Creating snapshot", snapshotAttrs(snapshot)...)
 	} else {
 		snapshot = *canonical
 		snapshot.ID = snapID
 		snapshot.CommitSHA = generateCommitSHA(r)
 		snapshot.CreatedAt = time.Now()
 		snapshot.Manifests = nil
-		lgr.Printf("Creating Snapshot from canonical base %s: %+v", snapshot.ID, snapshot)
+		lgr.Debug("Creating snapshot from canonical base", append(snapshotAttrs(snapshot), "canonical_snapshot_id", canonical.ID)...)
 	}
 
-	for i := 0; i < manifestCount; i++ {
-		depsCount := int(r.Uint32() % uint32(maxDepsPer))
-		var runtimeCount, devCount, transitivesCount int
-		if depsCount > 0 {
-			transitivesCount = int(r.Uint32() % uint32(depsCount))
-			directsCount := int(depsCount) - transitivesCount
-			split := int(r.Uint32() % uint32(directsCount))
-			runtimeCount = int(split)
-			devCount = directsCount - split
+	// the span lasts until the last manifest is handed off, so it is as long
+	// as generation is kept waiting by the receiver
+	ctx, span := tracing.Start(ctx, "GenerateSnapshot",
+		append(snapshotSpanAttrs(snapshot, ""), attribute.Int("snapshot.manifests", manifestCount))...)
+	go func() {
+		defer close(errs)
+		defer close(manifests)
+
+		err := func() error {
+			for i := 0; i < manifestCount; i++ {
+				depsCount := int(r.Uint32() % uint32(maxDepsPer))
+				var runtimeCount, devCount, transitivesCount int
+				if depsCount > 0 {
+					transitivesCount = int(r.Uint32() % uint32(depsCount))
+					directsCount := int(depsCount) - transitivesCount
+					split := int(r.Uint32() % uint32(directsCount))
+					runtimeCount = int(split)
+					devCount = directsCount - split
+				}
+
+				manifest, err := generateManifest(ctx, lgr, r, snapshot, runtimeCount, devCount, transitivesCount, pool)
+				if err != nil {
+					return err
+				}
+
+				select {
+				case manifests <- manifest:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		}()
+		tracing.End(span, err)
+		if err != nil {
+			errs <- err
 		}
+	}()
 
-		manifest, err := generateManifest(ctx, lgr, r, snapshot, runtimeCount, devCount, transitivesCount, pool)
-		if err != nil {
-			return snapshot, err
+	return snapshot, manifests, errs
+}
+
+// GenerateAdvisories creates synthetic advisories, each affecting a range of
+// versions around a dependency drawn at random from the given snapshots so
+// that affected-repository lookups have something to find.
+func GenerateAdvisories(ctx context.Context, lgr *slog.Logger, snapshots []Snapshot, count int) ([]advisories.Advisory, error) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	var candidates []Manifest
+	for _, snap := range snapshots {
+		for _, mm := range snap.Manifests {
+			if len(mm.Runtime)+len(mm.Development)+len(mm.Transitives) > 0 {
+				candidates = append(candidates, mm)
+			}
 		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no dependencies to generate advisories against")
+	}
 
-		snapshot.Manifests = append(snapshot.Manifests, manifest)
+	var out []advisories.Advisory
+	for i := 0; i < count; i++ {
+		mm := candidates[r.Uint32()%uint32(len(candidates))]
+		deps := append(append(append([]Dependency{}, mm.Runtime...), mm.Development...), mm.Transitives...)
+		dep := deps[r.Uint32()%uint32(len(deps))]
+
+		var major, minor, patch int
+		fmt.Sscanf(dep.Version, "%d.%d.%d", &major, &minor, &patch)
+		published := time.Now().Add(-time.Duration(r.Uint32()%8760) * time.Hour)
+
+		adv := advisories.Advisory{
+			// unlike the aliases, IDs key the advisories table, so they must
+			// not collide with those of earlier runs
+			ID:        fmt.Sprintf("SYN-%d-%s", published.Year(), gocql.TimeUUID()),
+			Summary:   fmt.Sprintf("%s in %s", generateVulnerabilityClass(r), dep.Name),
+			Details:   strings.Join([]string{getWord(r), getWord(r), getWord(r), getWord(r)}, " "),
+			Severity:  generateSeverity(r),
+			Aliases:   []string{fmt.Sprintf("CVE-%d-%05d", published.Year(), r.Uint32()%100000)},
+			Published: published,
+			Modified:  published,
+			Affected: []advisories.AffectedPackage{{
+				PackageManager: mm.PackageManager,
+				Namespace:      dep.Namespace,
+				Name:           dep.Name,
+				Ranges:         []string{fmt.Sprintf(">=%d.%d.0 <%d.%d.0", major, minor, major, minor+1)},
+			}},
+		}
+		lgr.Debug("Creating advisory", "advisory_id", adv.ID, "purl", dep.ToPURL(mm.PackageManager), "range", adv.Affected[0].Ranges[0])
+		out = append(out, adv)
 	}
 
-	return snapshot, nil
+	return out, nil
 }
 
-func generateManifest(ctx context.Context, lgr *log.Logger, r *rand.Rand, sm Snapshot,
+// snapshotAttrs are the attributes identifying a snapshot in log records.
+func snapshotAttrs(sm Snapshot) []any {
+	return []any{
+		"snapshot_id", sm.ID,
+		"repository_id", sm.RepositoryID,
+		"owner_id", sm.OwnerID,
+		"ref", sm.Ref,
+		"commit_sha", sm.CommitSHA,
+	}
+}
+
+func generateManifest(ctx context.Context, lgr *slog.Logger, r *rand.Rand, sm Snapshot,
 	rtDepsCount, devDepsCount, transDepsCount int, pool []Dependency) (Manifest, error) {
 
 	pkgMgr := generatePackageManager(r)
@@ -146,7 +257,8 @@ func generateManifest(ctx context.Context, lgr *log.Logger, r *rand.Rand, sm Sna
 		return Manifest{}, err
 	}
 
-	lgr.Printf("Creating Manifest %s: with dependencies: (runtime=%d, dev=%d, transitive=%d)", mfstID, rtDepsCount, devDepsCount, transDepsCount)
+	lgr.Debug("Creating manifest", "snapshot_id", sm.ID, "manifest_id", mfstID,
+		"runtime", rtDepsCount, "development", devDepsCount, "transitive", transDepsCount)
 	mm := Manifest{
 		ID:             mfstID,
 		PackageManager: pkgMgr,
@@ -257,8 +369,8 @@ func selectPURLs(r *rand.Rand, pkgMgr string, pool []Dependency, count int) []st
 }
 
 func generateLicense(r *rand.Rand) string {
-	selection := r.Uint32() % uint32(len(licenses))
-	return licenses[selection]
+	selection := r.Uint32() % uint32(len(licenseExpressions))
+	return licenseExpressions[selection]
 }
 
 func generateScope(r *rand.Rand) string {
@@ -266,6 +378,16 @@ func generateScope(r *rand.Rand) string {
 	return scopes[selection]
 }
 
+func generateSeverity(r *rand.Rand) string {
+	selection := r.Uint32() % uint32(len(severities))
+	return severities[selection]
+}
+
+func generateVulnerabilityClass(r *rand.Rand) string {
+	selection := r.Uint32() % uint32(len(vulnerabilityClasses))
+	return vulnerabilityClasses[selection]
+}
+
 func generateSemver(r *rand.Rand) string {
 	return fmt.Sprintf("%d.%d.%d", r.Uint32()%10, r.Uint32()%50, r.Uint32()%100)
 }
@@ -326,14 +448,30 @@ var (
 
 	scopes = []string{"runtime", "development"}
 
-	licenses = []string{
+	severities = []string{"LOW", "MODERATE", "HIGH", "CRITICAL"}
+
+	vulnerabilityClasses = []string{
+		"Prototype pollution",
+		"Regular expression denial of service",
+		"Path traversal",
+		"Remote code execution",
+		"Cross-site scripting",
+		"Improper input validation",
+	}
+
+	// SPDX license expressions (see internal/licenses)
+	licenseExpressions = []string{
 		"Apache-2.0",
 		"MIT",
 		"GPL-1.0-only",
-		"APL-1.0-only",
+		"APL-1.0",
 		"MS-PL",
 		"NASA-1.3",
 		"OSL-1.0",
 		"SPL-1.0",
+		"BSD-3-Clause",
+		"ISC",
+		"MIT OR Apache-2.0",
+		"GPL-2.0-only WITH Classpath-exception-2.0",
 	}
 )
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"context"
+	"testing"
+
+	"github.com/elireisman/cass-dsapi/internal/logging"
+)
+
+func TestStreamSnapshot(t *testing.T) {
+	quiet := logging.Discard()
+
+	sm, manifests, errs := StreamSnapshot(context.Background(), quiet, nil, 10, 20, 2)
+	if sm.Manifests != nil {
+		t.Errorf("expected the streamed snapshot to have no manifests, got %d", len(sm.Manifests))
+	}
+	if cap(manifests) != 2 {
+		t.Errorf("expected 2 manifests to be buffered, got %d", cap(manifests))
+	}
+	var n int
+	for range manifests {
+		n++
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Errorf("expected 10 manifests, got %d", n)
+	}
+
+	// a canceled stream stops generating rather than blocking on its receiver
+	ctx, cancel := context.WithCancel(context.Background())
+	_, manifests, errs = StreamSnapshot(ctx, quiet, &sm, 1000, 20, 1)
+	<-manifests
+	cancel()
+	n = 0
+	for range manifests {
+		n++
+	}
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("expected the stream to be canceled, got %v", err)
+	}
+	if n >= 999 {
+		t.Errorf("expected generation to stop once canceled, got all %d remaining manifests", n)
+	}
+}
+
+func TestCatalogManifest(t *testing.T) {
+	mm := Manifest{
+		Runtime:     make([]Dependency, 3),
+		Development: make([]Dependency, 2),
+		Transitives: make([]Dependency, 10),
+	}
+	mm.Transitives[2].Name = "last"
+
+	got := catalogManifest(mm)
+	if len(got.Runtime) != catalogDependencies || got.Development != nil || got.Transitives != nil {
+		t.Fatalf("expected only the first %d dependencies, got %d, %d, %d",
+			catalogDependencies, len(got.Runtime), len(got.Development), len(got.Transitives))
+	}
+	if got.Runtime[catalogDependencies-1].Name != "last" {
+		t.Errorf("expected dependencies in runtime, development, transitive order")
+	}
+
+	empty := catalogManifest(Manifest{})
+	if empty.Runtime != nil {
+		t.Errorf("expected no catalog dependencies for a manifest without dependencies")
+	}
+}
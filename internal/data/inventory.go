@@ -0,0 +1,431 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/gocql/gocql"
+)
+
+// DefaultRef is the ref whose latest snapshot represents a repository in its
+// owner's dependency inventory.
+const DefaultRef = "refs/heads/main"
+
+// OwnerPackage is a package version used somewhere across an owner's
+// repositories, along with the repositories using it.
+type OwnerPackage struct {
+	PackageManager string
+	Namespace      string
+	Name           string
+	Version        string
+	License        string
+	RepositoryIDs  []uint
+}
+
+// OwnerInventorySummary counts the (repository, package version) pairs in an
+// owner's inventory per ecosystem and per license.
+type OwnerInventorySummary struct {
+	OwnerID    uint
+	Ecosystems map[string]int64
+	Licenses   map[string]int64
+}
+
+// inventoryKey identifies a package version within a repository's inventory
+type inventoryKey struct {
+	PackageManager string
+	Namespace      string
+	Name           string
+	Version        string
+}
+
+// updateOwnerInventory replaces the repository's contribution to its owner's
+// inventory with current, the package versions of the snapshot (see
+// addInventory), if the snapshot is the latest of the repository's default
+// ref. The previous package set is read back from owner_repository_packages so
+// that only the difference is written, and the owner's counters are adjusted
+// by that difference.
+//
+// The counters are not idempotent: a load retried after a partial failure, or
+// loads of the same repository racing from separate processes, can leave them
+// off from the inventory rows until repaired with ReconcileOwnerCounts.
+func updateOwnerInventory(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, sm Snapshot,
+	current map[inventoryKey]string) error {
+	if sm.Ref != DefaultRef {
+		return nil
+	}
+
+	latest, err := LatestSnapshot(ctx, lgr, client, keyspace, sm.RepositoryID, sm.Ref)
+	switch {
+	case err == gocql.ErrNotFound:
+	case err != nil:
+		return fmt.Errorf("checking latest snapshot: %s", err)
+	case latest.CreatedAt.After(sm.CreatedAt):
+		lgr.Debug("Snapshot is older than latest snapshot, skipping owner inventory", "snapshot_id", sm.ID, "latest_snapshot_id", latest.ID)
+		return nil
+	}
+
+	previous, err := repositoryInventory(ctx, client, keyspace, sm.OwnerID, sm.RepositoryID)
+	if err != nil {
+		return err
+	}
+
+	// a changed license is an overwrite of the same rows, never a delete and
+	// insert, whose tombstone would shadow the insert sharing its timestamp
+	upserts, deletes := inventoryChanges(previous, current)
+	stmts := StatementsFor(keyspace)
+	batch := &statementBatcher{ctx: ctx, client: client}
+	for _, key := range deletes {
+		if err := batch.add(stmts.DeleteOwnerRepositoryPackage,
+			sm.OwnerID, sm.RepositoryID, key.PackageManager, key.Namespace, key.Name, key.Version); err != nil {
+			return err
+		}
+		if err := batch.add(stmts.DeleteOwnerPackage,
+			sm.OwnerID, key.PackageManager, key.Namespace, key.Name, key.Version, sm.RepositoryID); err != nil {
+			return err
+		}
+	}
+	for _, key := range upserts {
+		license := current[key]
+		if err := batch.add(stmts.InsertOwnerRepositoryPackage,
+			sm.OwnerID, sm.RepositoryID, key.PackageManager, key.Namespace, key.Name, key.Version, license, sm.ID); err != nil {
+			return err
+		}
+		if err := batch.add(stmts.InsertOwnerPackage,
+			sm.OwnerID, key.PackageManager, key.Namespace, key.Name, key.Version, sm.RepositoryID, license, sm.RepositoryNWO); err != nil {
+			return err
+		}
+	}
+
+	ecosystems, licenses := inventoryCountDeltas(previous, current, upserts, deletes)
+	for pkgMgr, delta := range ecosystems {
+		if err := batch.addCounter(stmts.AdjustOwnerEcosystemCount, delta, sm.OwnerID, pkgMgr); err != nil {
+			return err
+		}
+	}
+	for license, delta := range licenses {
+		if err := batch.addCounter(stmts.AdjustOwnerLicenseCount, delta, sm.OwnerID, license); err != nil {
+			return err
+		}
+	}
+	if err := batch.flush(); err != nil {
+		return err
+	}
+	lgr.Debug("Owner inventory updated", "owner_id", sm.OwnerID, "repository_id", sm.RepositoryID, "snapshot_id", sm.ID,
+		"package_versions", len(current), "upserted", len(upserts), "deleted", len(deletes))
+
+	return nil
+}
+
+// inventoryChanges returns the package versions of current that are new or
+// whose license changed since previous, and those of previous no longer in
+// current.
+func inventoryChanges(previous, current map[inventoryKey]string) (upserts, deletes []inventoryKey) {
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			deletes = append(deletes, key)
+		}
+	}
+	for key, license := range current {
+		if prevLicense, ok := previous[key]; !ok || prevLicense != license {
+			upserts = append(upserts, key)
+		}
+	}
+	return upserts, deletes
+}
+
+// inventoryCountDeltas returns the non-zero changes to the owner's ecosystem
+// and license counters made by the inventoryChanges from previous to current.
+// A relicensed version moves a count between licenses only.
+func inventoryCountDeltas(previous, current map[inventoryKey]string, upserts, deletes []inventoryKey) (ecosystems, licenses map[string]int64) {
+	ecosystems, licenses = map[string]int64{}, map[string]int64{}
+	for _, key := range deletes {
+		ecosystems[key.PackageManager]--
+		licenses[previous[key]]--
+	}
+	for _, key := range upserts {
+		if prevLicense, ok := previous[key]; ok {
+			licenses[prevLicense]--
+		} else {
+			ecosystems[key.PackageManager]++
+		}
+		licenses[current[key]]++
+	}
+	for _, deltas := range []map[string]int64{ecosystems, licenses} {
+		for key, delta := range deltas {
+			if delta == 0 {
+				delete(deltas, key)
+			}
+		}
+	}
+	return ecosystems, licenses
+}
+
+// ReconcileOwnerCounts recounts the owner's inventory rows per ecosystem and
+// per license, and adjusts the owner's counters by their difference from the
+// stored values, repairing counters left off by failed or racing loads. It
+// scans the owner's whole inventory, and must not run while snapshots of the
+// owner's repositories are loading, whose increments it would double.
+func ReconcileOwnerCounts(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, ownerID uint) (OwnerInventorySummary, error) {
+	stmts := StatementsFor(keyspace)
+
+	counted := OwnerInventorySummary{OwnerID: ownerID, Ecosystems: map[string]int64{}, Licenses: map[string]int64{}}
+	scanner := client.Query(stmts.SelectOwnerInventoryTally, ownerID).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var pkgMgr, license string
+		if err := scanner.Scan(&pkgMgr, &license); err != nil {
+			return counted, fmt.Errorf("scanning owner_packages row: %s", err)
+		}
+		counted.Ecosystems[pkgMgr]++
+		counted.Licenses[license]++
+	}
+	if err := scanner.Err(); err != nil {
+		return counted, fmt.Errorf("counting inventory of owner %d: %s", ownerID, err)
+	}
+
+	stored, err := ownerCounts(ctx, client, keyspace, ownerID)
+	if err != nil {
+		return counted, err
+	}
+
+	batch := &statementBatcher{ctx: ctx, client: client}
+	adjusted := 0
+	for _, agg := range []struct {
+		stmt            string
+		counted, stored map[string]int64
+	}{
+		{stmts.AdjustOwnerEcosystemCount, counted.Ecosystems, stored.Ecosystems},
+		{stmts.AdjustOwnerLicenseCount, counted.Licenses, stored.Licenses},
+	} {
+		for key, delta := range countAdjustments(agg.counted, agg.stored) {
+			if err := batch.addCounter(agg.stmt, delta, ownerID, key); err != nil {
+				return counted, err
+			}
+			adjusted++
+		}
+	}
+	if err := batch.flush(); err != nil {
+		return counted, err
+	}
+	lgr.Info("Owner counts reconciled", "owner_id", ownerID, "keyspace", keyspace, "adjusted", adjusted)
+
+	return counted, nil
+}
+
+// countAdjustments returns the non-zero differences between the counted and
+// stored values of each key.
+func countAdjustments(counted, stored map[string]int64) map[string]int64 {
+	out := map[string]int64{}
+	for key, n := range counted {
+		if delta := n - stored[key]; delta != 0 {
+			out[key] = delta
+		}
+	}
+	for key, n := range stored {
+		if _, ok := counted[key]; !ok && n != 0 {
+			out[key] = -n
+		}
+	}
+	return out
+}
+
+// addInventory adds the package versions of the manifest to a snapshot's
+// deduplicated inventory
+func addInventory(inventory map[inventoryKey]string, mm Manifest) {
+	for _, deps := range [][]Dependency{mm.Runtime, mm.Development, mm.Transitives} {
+		for _, dep := range deps {
+			inventory[inventoryKey{mm.PackageManager, dep.Namespace, dep.Name, dep.Version}] = dep.License
+		}
+	}
+}
+
+func repositoryInventory(ctx context.Context, client *gocql.Session, keyspace string, ownerID, repositoryID uint) (map[inventoryKey]string, error) {
+	out := map[inventoryKey]string{}
+	scanner := client.Query(StatementsFor(keyspace).SelectRepositoryInventory, ownerID, repositoryID).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var key inventoryKey
+		var license string
+		if err := scanner.Scan(&key.PackageManager, &key.Namespace, &key.Name, &key.Version, &license); err != nil {
+			return nil, fmt.Errorf("scanning owner_repository_packages row: %s", err)
+		}
+		out[key] = license
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("querying inventory of repository %d: %s", repositoryID, err)
+	}
+
+	return out, nil
+}
+
+// OwnerInventory returns every distinct package version used across the
+// owner's repositories, with the repositories using each.
+func OwnerInventory(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, ownerID uint) ([]OwnerPackage, error) {
+	pkgs, err := allPages(func(page Page) ([]OwnerPackage, []byte, error) {
+		return OwnerInventoryPage(ctx, lgr, client, keyspace, ownerID, page)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// rejoin package versions whose repositories were split across pages
+	var out []OwnerPackage
+	for _, pkg := range pkgs {
+		if n := len(out); n > 0 && out[n-1].sameVersion(pkg) {
+			out[n-1].RepositoryIDs = append(out[n-1].RepositoryIDs, pkg.RepositoryIDs...)
+			continue
+		}
+		out = append(out, pkg)
+	}
+
+	return out, nil
+}
+
+// OwnerInventoryPage returns a single page of OwnerInventory along with the
+// page state of the next page. Since pages are made of (package version,
+// repository) rows, a package version's repositories may continue on the
+// next page.
+func OwnerInventoryPage(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	ownerID uint, page Page) ([]OwnerPackage, []byte, error) {
+
+	var out []OwnerPackage
+	iter := page.apply(client.Query(StatementsFor(keyspace).SelectOwnerInventory, ownerID).WithContext(ctx)).Iter()
+	next := iter.PageState()
+	scanner := iter.Scanner()
+	for scanner.Next() {
+		var pkg OwnerPackage
+		var repoID uint
+		if err := scanner.Scan(&pkg.PackageManager, &pkg.Namespace, &pkg.Name, &pkg.Version, &repoID, &pkg.License); err != nil {
+			return nil, nil, fmt.Errorf("scanning owner_packages row: %s", err)
+		}
+
+		// rows are clustered by package version, so each version's repositories are contiguous
+		if n := len(out); n > 0 && out[n-1].sameVersion(pkg) {
+			out[n-1].RepositoryIDs = append(out[n-1].RepositoryIDs, repoID)
+			continue
+		}
+		pkg.RepositoryIDs = []uint{repoID}
+		out = append(out, pkg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("querying inventory of owner %d: %s", ownerID, err)
+	}
+
+	return out, next, nil
+}
+
+func (pkg OwnerPackage) sameVersion(other OwnerPackage) bool {
+	return pkg.PackageManager == other.PackageManager && pkg.Namespace == other.Namespace &&
+		pkg.Name == other.Name && pkg.Version == other.Version
+}
+
+// OwnerInventoryCounts returns the owner's per-ecosystem and per-license usage counts.
+func OwnerInventoryCounts(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, ownerID uint) (OwnerInventorySummary, error) {
+	out, err := ownerCounts(ctx, client, keyspace, ownerID)
+	if err != nil {
+		return out, err
+	}
+
+	// a repository no longer using an ecosystem or license leaves its counter at zero
+	for _, counts := range []map[string]int64{out.Ecosystems, out.Licenses} {
+		for key, used := range counts {
+			if used <= 0 {
+				delete(counts, key)
+			}
+		}
+	}
+	return out, nil
+}
+
+// ownerCounts returns the owner's stored counters as they are, emptied
+// entries included.
+func ownerCounts(ctx context.Context, client *gocql.Session, keyspace string, ownerID uint) (OwnerInventorySummary, error) {
+	out := OwnerInventorySummary{
+		OwnerID:    ownerID,
+		Ecosystems: map[string]int64{},
+		Licenses:   map[string]int64{},
+	}
+
+	stmts := StatementsFor(keyspace)
+	for _, agg := range []struct {
+		table, query string
+		counts       map[string]int64
+	}{
+		{"owner_ecosystem_counts", stmts.SelectOwnerEcosystemCounts, out.Ecosystems},
+		{"owner_license_counts", stmts.SelectOwnerLicenseCounts, out.Licenses},
+	} {
+		scanner := client.Query(agg.query, ownerID).WithContext(ctx).Iter().Scanner()
+		for scanner.Next() {
+			var key string
+			var used int64
+			if err := scanner.Scan(&key, &used); err != nil {
+				return out, fmt.Errorf("scanning %s row: %s", agg.table, err)
+			}
+			agg.counts[key] = used
+		}
+		if err := scanner.Err(); err != nil {
+			return out, fmt.Errorf("querying %s of owner %d: %s", agg.table, ownerID, err)
+		}
+	}
+
+	return out, nil
+}
+
+// statementBatcher accumulates statements into unlogged and counter batches,
+// executing each whenever it reaches batchSize entries. The first error is
+// retained and returned by every later add and flush, which then do nothing.
+type statementBatcher struct {
+	ctx      context.Context
+	client   *gocql.Session
+	regular  *gocql.Batch
+	counters *gocql.Batch
+	err      error
+}
+
+func (sb *statementBatcher) add(stmt string, args ...interface{}) error {
+	if sb.err != nil {
+		return sb.err
+	}
+	if sb.regular == nil {
+		sb.regular = sb.client.NewBatch(gocql.UnloggedBatch).WithContext(sb.ctx)
+	}
+	sb.regular.Query(stmt, args...)
+	if sb.regular.Size() >= batchSize {
+		return sb.flush()
+	}
+	return nil
+}
+
+func (sb *statementBatcher) addCounter(stmt string, args ...interface{}) error {
+	if sb.err != nil {
+		return sb.err
+	}
+	if sb.counters == nil {
+		sb.counters = sb.client.NewBatch(gocql.CounterBatch).WithContext(sb.ctx)
+	}
+	sb.counters.Query(stmt, args...)
+	if sb.counters.Size() >= batchSize {
+		return sb.flush()
+	}
+	return nil
+}
+
+func (sb *statementBatcher) flush() error {
+	if sb.err != nil {
+		return sb.err
+	}
+	if sb.regular != nil && sb.regular.Size() > 0 {
+		if err := executeBatch(sb.ctx, sb.client, sb.regular); err != nil {
+			sb.err = fmt.Errorf("flushing batch: %s", err)
+			return sb.err
+		}
+	}
+	sb.regular = nil
+	if sb.counters != nil && sb.counters.Size() > 0 {
+		if err := executeBatch(sb.ctx, sb.client, sb.counters); err != nil {
+			sb.err = fmt.Errorf("flushing counter batch: %s", err)
+			return sb.err
+		}
+	}
+	sb.counters = nil
+	return nil
+}
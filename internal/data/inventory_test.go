@@ -0,0 +1,68 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInventoryChanges(t *testing.T) {
+	kept := inventoryKey{"npm", "", "left-pad", "1.3.0"}
+	relicensed := inventoryKey{"npm", "acme", "widget", "2.0.0"}
+	dropped := inventoryKey{"npm", "", "gone", "0.1.0"}
+	added := inventoryKey{"pip", "", "requests", "2.31.0"}
+
+	previous := map[inventoryKey]string{kept: "MIT", relicensed: "MIT", dropped: "ISC"}
+	current := map[inventoryKey]string{kept: "MIT", relicensed: "Apache-2.0", added: "Apache-2.0"}
+
+	upserts, deletes := inventoryChanges(previous, current)
+	got := map[inventoryKey]bool{}
+	for _, key := range upserts {
+		got[key] = true
+	}
+	if len(upserts) != 2 || !got[relicensed] || !got[added] {
+		t.Errorf("expected the relicensed and added versions to be upserted, got %v", upserts)
+	}
+	// a license change on an unchanged version must not delete the row it overwrites
+	if !reflect.DeepEqual(deletes, []inventoryKey{dropped}) {
+		t.Errorf("expected only the dropped version to be deleted, got %v", deletes)
+	}
+
+	if upserts, deletes := inventoryChanges(current, current); len(upserts)+len(deletes) > 0 {
+		t.Errorf("expected an unchanged inventory to write nothing, got %v and %v", upserts, deletes)
+	}
+}
+
+func TestInventoryCountDeltas(t *testing.T) {
+	kept := inventoryKey{"npm", "", "left-pad", "1.3.0"}
+	relicensed := inventoryKey{"npm", "acme", "widget", "2.0.0"}
+	dropped := inventoryKey{"npm", "", "gone", "0.1.0"}
+	added := inventoryKey{"pip", "", "requests", "2.31.0"}
+
+	previous := map[inventoryKey]string{kept: "MIT", relicensed: "MIT", dropped: "ISC"}
+	current := map[inventoryKey]string{kept: "MIT", relicensed: "Apache-2.0", added: "Apache-2.0"}
+
+	upserts, deletes := inventoryChanges(previous, current)
+	ecosystems, licenses := inventoryCountDeltas(previous, current, upserts, deletes)
+	if want := map[string]int64{"npm": -1, "pip": 1}; !reflect.DeepEqual(ecosystems, want) {
+		t.Errorf("expected ecosystem deltas %v, got %v", want, ecosystems)
+	}
+	if want := map[string]int64{"MIT": -1, "ISC": -1, "Apache-2.0": 2}; !reflect.DeepEqual(licenses, want) {
+		t.Errorf("expected license deltas %v, got %v", want, licenses)
+	}
+}
+
+func TestCountAdjustments(t *testing.T) {
+	// a version relicensed from MIT to Apache-2.0 moves one count between licenses
+	counted := map[string]int64{"MIT": 2, "Apache-2.0": 1}
+	stored := map[string]int64{"MIT": 3, "ISC": 1, "BSD-3-Clause": 0}
+
+	want := map[string]int64{"MIT": -1, "Apache-2.0": 1, "ISC": -1}
+	if got := countAdjustments(counted, stored); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected adjustments %v, got %v", want, got)
+	}
+
+	// reconciling again after the adjustments are applied changes nothing
+	if got := countAdjustments(counted, map[string]int64{"MIT": 2, "Apache-2.0": 1, "ISC": 0}); len(got) > 0 {
+		t.Errorf("expected no adjustments once reconciled, got %v", got)
+	}
+}
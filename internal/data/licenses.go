@@ -0,0 +1,73 @@
+package data
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/elireisman/cass-dsapi/internal/licenses"
+
+	"github.com/gocql/gocql"
+)
+
+// LicenseViolation is a manifest's project license, or one of its
+// dependencies' licenses, that does not satisfy a license policy.
+type LicenseViolation struct {
+	ManifestPath   string
+	PackageManager string
+	PURL           string // empty when the violation is the manifest's own project license
+	License        string
+	Verdict        licenses.Verdict
+	Reason         string
+}
+
+// SnapshotLicenseViolations evaluates the policy against the project license
+// of every manifest in the snapshot and against the license of every one of
+// their dependencies.
+func SnapshotLicenseViolations(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	repositoryID uint, ref string, snapshotID gocql.UUID, policy licenses.Policy) ([]LicenseViolation, error) {
+
+	manifests, err := ManifestsForSnapshot(ctx, lgr, client, keyspace, repositoryID, ref, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := []LicenseViolation{}
+	for _, mm := range manifests {
+		deps, err := DependenciesForManifest(ctx, lgr, client, keyspace, mm.ID)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, manifestLicenseViolations(mm, deps, policy)...)
+	}
+	lgr.Debug("Snapshot license violations", "snapshot_id", snapshotID, "violations", len(out), "manifests", len(manifests))
+
+	return out, nil
+}
+
+func manifestLicenseViolations(mm Manifest, deps []Dependency, policy licenses.Policy) []LicenseViolation {
+	var out []LicenseViolation
+	if verdict, reason := policy.Evaluate(mm.ProjectLicense); verdict != licenses.Allowed {
+		out = append(out, LicenseViolation{
+			ManifestPath:   mm.FilePath,
+			PackageManager: mm.PackageManager,
+			License:        mm.ProjectLicense,
+			Verdict:        verdict,
+			Reason:         reason,
+		})
+	}
+
+	for _, dep := range deps {
+		if verdict, reason := policy.Evaluate(dep.License); verdict != licenses.Allowed {
+			out = append(out, LicenseViolation{
+				ManifestPath:   mm.FilePath,
+				PackageManager: mm.PackageManager,
+				PURL:           dep.ToPURL(mm.PackageManager),
+				License:        dep.License,
+				Verdict:        verdict,
+				Reason:         reason,
+			})
+		}
+	}
+
+	return out
+}
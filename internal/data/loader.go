@@ -3,10 +3,17 @@ package data
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
 	"time"
 
+	"github.com/elireisman/cass-dsapi/internal/logging"
+	"github.com/elireisman/cass-dsapi/internal/metrics"
+	"github.com/elireisman/cass-dsapi/internal/tracing"
+
 	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -14,35 +21,48 @@ const (
 	Keyspace = "eli_demo"
 
 	maxWriteConcurrency = 8
+	maxReadConcurrency  = 8
 	batchSize           = 200
 )
 
-func CreateClient(ctx context.Context, lgr *log.Logger) (*gocql.Session, error) {
+// CreateClient connects to the local cluster. Each of configure may adjust the
+// cluster configuration before connecting, e.g. to attach observers.
+func CreateClient(ctx context.Context, lgr *slog.Logger, configure ...func(*gocql.ClusterConfig)) (*gocql.Session, error) {
 	cfg := gocql.NewCluster("127.0.0.1")
-	cfg.Logger = lgr
+	cfg.Logger = logging.Std(lgr, "gocql", slog.LevelWarn)
 	cfg.ProtoVersion = 3
 	cfg.ConnectTimeout = 2 * time.Second
 	cfg.Timeout = 10 * time.Second
 	cfg.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.RoundRobinHostPolicy())
+	for _, fn := range configure {
+		fn(cfg)
+	}
 
 	return cfg.CreateSession()
 }
 
-func CreateKeyspace(ctx context.Context, lgr *log.Logger, client *gocql.Session, keyspace string) error {
-	lgr.Printf("Creating keyspace %q...", keyspace)
-	q := fmt.Sprintf(`
+func CreateKeyspace(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string) error {
+	lgr.Info("Creating keyspace", "keyspace", keyspace)
+	return client.Query(keyspaceDDL(keyspace)).Exec()
+}
+
+func keyspaceDDL(keyspace string) string {
+	return fmt.Sprintf(`
 	  CREATE KEYSPACE IF NOT EXISTS %s
 	  WITH replication = {
 	      'class' : 'SimpleStrategy',
 	      'replication_factor' : 1
 	  }`, keyspace)
-	return client.Query(q).Exec()
 }
 
-func CreateTables(ctx context.Context, lgr *log.Logger, client *gocql.Session, keyspace string) error {
+func CreateTables(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string) error {
+	return createTables(ctx, lgr, client, keyspace, tables)
+}
+
+func createTables(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, tables []string) error {
 	for _, table := range tables {
 		q := fmt.Sprintf(table, keyspace)
-		lgr.Printf("\n%s", q)
+		lgr.Debug("Creating table", "keyspace", keyspace, "cql", q)
 		if err := client.Query(q).Exec(); err != nil {
 			return err
 		}
@@ -50,193 +70,367 @@ func CreateTables(ctx context.Context, lgr *log.Logger, client *gocql.Session, k
 	return nil
 }
 
-func Load(ctx context.Context, lgr *log.Logger, client *gocql.Session, snapshot Snapshot, keyspace string) error {
-	if err := writeSnapshot(ctx, lgr, client, keyspace, snapshot); err != nil {
+// SchemaVersion identifies the table definitions by a hash of their DDL, so
+// results gathered against different schemas can be told apart.
+func SchemaVersion() string {
+	return variants[DefaultVariant].SchemaVersion()
+}
+
+func Load(ctx context.Context, lgr *slog.Logger, client *gocql.Session, snapshot Snapshot, keyspace string) error {
+	return load(ctx, lgr, client, snapshot, keyspace, baselineWrites)
+}
+
+// writePath is the part of Load that schema variants may store differently:
+// the snapshots row, and the dependencies of each manifest (returning how
+// many were written).
+type writePath struct {
+	snapshot     func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, sm Snapshot) error
+	dependencies func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, sm Snapshot, mm Manifest) (uint, error)
+}
+
+var baselineWrites = writePath{
+	snapshot:     writeSnapshot,
+	dependencies: batchDependencies,
+}
+
+// LoadStream writes the snapshot as Load does, but takes its manifests from
+// the manifests channel rather than snapshot.Manifests, writing each as it
+// arrives. Only the few dependencies of each manifest recorded in the key
+// catalog and the snapshot's distinct package versions are kept until the
+// channel is closed, so a snapshot needn't be held in memory to be loaded.
+func LoadStream(ctx context.Context, lgr *slog.Logger, client *gocql.Session, snapshot Snapshot, manifests <-chan Manifest, keyspace string) error {
+	return loadStream(ctx, lgr, client, snapshot, manifests, keyspace, baselineWrites, maxWriteConcurrency)
+}
+
+// load writes the snapshot as Load does, using the given write path.
+func load(ctx context.Context, lgr *slog.Logger, client *gocql.Session, snapshot Snapshot, keyspace string, writes writePath) error {
+	return loadStream(ctx, lgr, client, snapshot, manifestChannel(snapshot), keyspace, writes, maxWriteConcurrency)
+}
+
+// loadManifest writes a manifest and its dependencies, under a span of its own
+// so the statements and batches doing so are grouped by manifest.
+func loadManifest(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, snapshot Snapshot, manifest Manifest,
+	writes writePath) (err error) {
+	ctx, span := tracing.Start(ctx, "WriteManifest",
+		attribute.String("manifest.id", manifest.ID.String()),
+		attribute.String("manifest.package_manager", manifest.PackageManager))
+	defer func() { tracing.End(span, err) }()
+
+	if err := writeManifest(ctx, lgr, client, keyspace, snapshot, manifest); err != nil {
+		return fmt.Errorf("writing manifest %s: %s", manifest.ID, err)
+	}
+	lgr.Debug("Manifest written", "snapshot_id", snapshot.ID, "manifest_id", manifest.ID)
+
+	total, err := writes.dependencies(ctx, lgr, client, keyspace, snapshot, manifest)
+	if err != nil {
+		return fmt.Errorf("writing dependency batches for manifest %s: %s", manifest.ID, err)
+	}
+	lgr.Debug("Dependencies written", "snapshot_id", snapshot.ID, "manifest_id", manifest.ID, "dependencies", total)
+	span.SetAttributes(attribute.Int("manifest.dependencies", int(total)))
+	return nil
+}
+
+// snapshotSpanAttrs identifies the snapshot, and the keyspace it is loaded
+// into if any, on a span.
+func snapshotSpanAttrs(snapshot Snapshot, keyspace string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("snapshot.id", snapshot.ID.String()),
+		attribute.Int64("repository.id", int64(snapshot.RepositoryID)),
+	}
+	if keyspace != "" {
+		attrs = append(attrs, semconv.DBName(keyspace))
+	}
+	return attrs
+}
+
+// manifestChannel returns a closed channel holding the snapshot's manifests.
+func manifestChannel(snapshot Snapshot) <-chan Manifest {
+	manifests := make(chan Manifest, len(snapshot.Manifests))
+	for _, manifest := range snapshot.Manifests {
+		manifests <- manifest
+	}
+	close(manifests)
+	return manifests
+}
+
+// loadStream writes the snapshot as LoadStream does, using the given write
+// path and writing up to workers manifests concurrently, under a LoadSnapshot
+// span parenting every manifest's span.
+func loadStream(ctx context.Context, lgr *slog.Logger, client *gocql.Session, snapshot Snapshot, manifests <-chan Manifest,
+	keyspace string, writes writePath, workers int) (err error) {
+
+	ctx, span := tracing.Start(ctx, "LoadSnapshot", snapshotSpanAttrs(snapshot, keyspace)...)
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	if err := writes.snapshot(ctx, lgr, client, keyspace, snapshot); err != nil {
+		go drain(manifests)
 		return fmt.Errorf("writing snapshot %s: %s", snapshot.ID, err)
 	}
-	lgr.Printf("Snapshot %s written", snapshot.ID)
+	lgr.Debug("Snapshot written", "snapshot_id", snapshot.ID, "keyspace", keyspace)
 
+	summary := newSnapshotSummary()
 	g, gctx := errgroup.WithContext(ctx)
-	tickets := make(chan struct{}, maxWriteConcurrency)
-	for i := 0; i < len(snapshot.Manifests); i++ {
-		manifest := snapshot.Manifests[i]
-
+	for i := 0; i < workers; i++ {
 		g.Go(func() error {
-			// return ticket when work is done
-			defer func() { <-tickets }()
+			for {
+				var manifest Manifest
+				var ok bool
+				select {
+				case manifest, ok = <-manifests:
+					if !ok {
+						return nil
+					}
+				case <-gctx.Done():
+					return gctx.Err()
+				}
+
+				if err := loadManifest(gctx, lgr, client, keyspace, snapshot, manifest, writes); err != nil {
+					return err
+				}
+				summary.add(manifest)
+				metrics.ManifestsLoaded.WithLabelValues(keyspace).Inc()
+			}
+		})
+	}
+	if err := g.Wait(); err != nil {
+		// keep the sender from blocking on a load that has given up
+		go drain(manifests)
+		return err
+	}
 
-			// add a ticket before doing work (can block!)
-			tickets <- struct{}{}
+	// the key catalog only needs each manifest's keys and first few dependencies
+	catalogued := snapshot
+	catalogued.Manifests = summary.catalog
+	if err := writeKeyCatalog(ctx, lgr, client, keyspace, catalogued); err != nil {
+		return fmt.Errorf("writing key catalog for snapshot %s: %s", snapshot.ID, err)
+	}
 
-			if err := writeManifest(gctx, lgr, client, keyspace, snapshot, manifest); err != nil {
-				return fmt.Errorf("writing manifest %s: %s", manifest.ID, err)
-			}
-			lgr.Printf("\tManifest %s written", manifest.ID)
+	if err := finishSnapshot(ctx, lgr, client, keyspace, snapshot, summary.inventory); err != nil {
+		return err
+	}
+	lgr.Info("Snapshot loaded", "snapshot_id", snapshot.ID, "repository_id", snapshot.RepositoryID, "keyspace", keyspace,
+		"manifests", len(summary.catalog), "duration", time.Since(start))
+	span.SetAttributes(attribute.Int("snapshot.manifests", len(summary.catalog)))
 
-			total, err := batchDependencies(gctx, lgr, client, keyspace, snapshot, manifest)
-			if err != nil {
-				return fmt.Errorf("writing dependency batches for manifest %s: %s", manifest.ID, err)
-			}
-			lgr.Printf("\t\tTotal %d Dependencies written", total)
+	metrics.SnapshotsLoaded.WithLabelValues(keyspace).Inc()
+	metrics.LoadDuration.WithLabelValues(keyspace).Observe(time.Since(start).Seconds())
+	return nil
+}
 
-			return nil
-		})
+// refLocks serializes the end of concurrent loads of the same repository ref
+// into a keyspace (see finishSnapshot).
+var refLocks keyedMutex
+
+type refLockKey struct {
+	keyspace     string
+	repositoryID uint
+	ref          string
+}
+
+// finishSnapshot updates the owner inventory from a fully written snapshot
+// and points the lookup tables at it. Loads of the same ref, such as those of
+// canonical snapshots loaded in parallel, finish one at a time: each checks
+// latest_snapshots before updating the inventory, so it must see the lookups
+// written by any load that finished before it.
+func finishSnapshot(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, snapshot Snapshot,
+	inventory map[inventoryKey]string) error {
+	defer refLocks.lock(refLockKey{keyspace, snapshot.RepositoryID, snapshot.Ref})()
+
+	if err := updateOwnerInventory(ctx, lgr, client, keyspace, snapshot, inventory); err != nil {
+		return fmt.Errorf("updating owner inventory for snapshot %s: %s", snapshot.ID, err)
+	}
+
+	// only point the lookup tables at the snapshot once it is fully written
+	if err := writeSnapshotLookups(ctx, lgr, client, keyspace, snapshot); err != nil {
+		return fmt.Errorf("writing lookups for snapshot %s: %s", snapshot.ID, err)
 	}
+	return nil
+}
+
+// snapshotSummary is what loadStream keeps of each written manifest: its
+// key catalog entry and its contribution to the snapshot's inventory.
+type snapshotSummary struct {
+	mu        sync.Mutex
+	catalog   []Manifest
+	inventory map[inventoryKey]string
+}
+
+func newSnapshotSummary() *snapshotSummary {
+	return &snapshotSummary{inventory: map[inventoryKey]string{}}
+}
+
+func (ss *snapshotSummary) add(mm Manifest) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
 
-	return g.Wait()
+	ss.catalog = append(ss.catalog, catalogManifest(mm))
+	addInventory(ss.inventory, mm)
 }
 
-func batchDependencies(ctx context.Context, lgr *log.Logger, client *gocql.Session, keyspace string, snapshot Snapshot, manifest Manifest) (uint, error) {
-	mdeps := client.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+func drain(manifests <-chan Manifest) {
+	for range manifests {
+	}
+}
+
+func batchDependencies(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, snapshot Snapshot, manifest Manifest) (uint, error) {
+	return batchDependencyRows(ctx, lgr, client, keyspace, snapshot, manifest, true, 0)
+}
+
+// batchReverseDependencies writes only the dependent_repositories and
+// dependent_repository_counts rows of the manifest's dependencies, for schema
+// variants that store the manifest's own dependency rows elsewhere.
+func batchReverseDependencies(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, snapshot Snapshot, manifest Manifest) (uint, error) {
+	return batchDependencyRows(ctx, lgr, client, keyspace, snapshot, manifest, false, 0)
+}
+
+// batchDependencyRows writes the rows of each of the manifest's dependencies,
+// leaving out the manifest_dependencies rows unless manifestRows is set. When
+// reverseShards is non-zero the reverse tables are sharded (see ReverseShard).
+func batchDependencyRows(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, snapshot Snapshot, manifest Manifest,
+	manifestRows bool, reverseShards int) (uint, error) {
+	var mdeps *gocql.Batch
+	if manifestRows {
+		mdeps = client.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
+	}
 	rdeps := client.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
 	dcounts := client.NewBatch(gocql.CounterBatch).WithContext(ctx)
 
 	rtProcessed := 0
 	for _, dependency := range manifest.Runtime {
-		if err := addDependency(ctx, client, &mdeps, &rdeps, &dcounts, keyspace, snapshot, manifest, dependency); err != nil {
+		if err := addDependency(ctx, client, &mdeps, &rdeps, &dcounts, keyspace, snapshot, manifest, dependency, reverseShards); err != nil {
 			return 0, err
 		}
 		rtProcessed++
 	}
-	lgr.Printf("\t\t%d direct runtime dependencies submitted", rtProcessed)
+	lgr.Debug("Direct runtime dependencies submitted", "manifest_id", manifest.ID, "dependencies", rtProcessed)
 
 	devProcessed := 0
 	for _, dependency := range manifest.Development {
-		if err := addDependency(ctx, client, &mdeps, &rdeps, &dcounts, keyspace, snapshot, manifest, dependency); err != nil {
+		if err := addDependency(ctx, client, &mdeps, &rdeps, &dcounts, keyspace, snapshot, manifest, dependency, reverseShards); err != nil {
 			return 0, err
 		}
 		devProcessed++
 	}
-	lgr.Printf("\t\t%d direct development dependencies submitted", devProcessed)
+	lgr.Debug("Direct development dependencies submitted", "manifest_id", manifest.ID, "dependencies", devProcessed)
 
 	trProcessed := 0
 	for _, dependency := range manifest.Transitives {
-		if err := addDependency(ctx, client, &mdeps, &rdeps, &dcounts, keyspace, snapshot, manifest, dependency); err != nil {
+		if err := addDependency(ctx, client, &mdeps, &rdeps, &dcounts, keyspace, snapshot, manifest, dependency, reverseShards); err != nil {
 			return 0, err
 		}
 		trProcessed++
 	}
-	lgr.Printf("\t\t%d transitive dependencies submitted", trProcessed)
+	lgr.Debug("Transitive dependencies submitted", "manifest_id", manifest.ID, "dependencies", trProcessed)
 
 	// final flush
 	for _, batch := range []*gocql.Batch{mdeps, rdeps, dcounts} {
-		if err := client.ExecuteBatch(batch); err != nil {
+		if batch == nil {
+			continue
+		}
+		if err := executeBatch(ctx, client, batch); err != nil {
 			return 0, fmt.Errorf("performing final dependencies batch flush: %s", err)
 		}
 	}
 	return uint(rtProcessed + devProcessed + trProcessed), nil
 }
 
-func writeSnapshot(ctx context.Context, lgr *log.Logger, client *gocql.Session, keyspace string, sm Snapshot) error {
-	query := fmt.Sprintf(`INSERT INTO %s.snapshots
-	  (id, owner_id, repository_id, nwo, created_at, ref, commit_oid, blob_url, source_url)
-	  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`, keyspace)
+func writeSnapshot(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, sm Snapshot) error {
+	return execWrite(ctx, client.Query(StatementsFor(keyspace).InsertSnapshot, BindSnapshot(sm)...).WithContext(ctx))
+}
+
+// writeSnapshotLookups maintains the denormalized latest_snapshots,
+// repository_refs and owner_repositories tables. Writes are timestamped with
+// the snapshot's creation time so that loading an older snapshot after a newer
+// one never replaces the newer entry.
+func writeSnapshotLookups(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, sm Snapshot) error {
+	ts := sm.CreatedAt.UnixMicro()
+	stmts := StatementsFor(keyspace)
+	batch := client.NewBatch(gocql.LoggedBatch).WithContext(ctx)
 
-	return client.Query(query).Bind(
+	batch.Query(stmts.InsertLatestSnapshot,
+		sm.RepositoryID,
+		sm.Ref,
 		sm.ID,
 		sm.OwnerID,
-		sm.RepositoryID,
 		sm.RepositoryNWO,
 		sm.CreatedAt,
-		sm.Ref,
 		sm.CommitSHA,
 		sm.BlobURL,
-		sm.SourceURL).Exec()
-}
-
-func writeManifest(ctx context.Context, lgr *log.Logger, client *gocql.Session, keyspace string, sm Snapshot, mm Manifest) error {
-	query := fmt.Sprintf(`INSERT INTO %s.manifests
-	  (id, snapshot_id, owner_id, repository_id, ref, commit_oid, blob_key, manifest_key,
-	   package_manager, project_name, project_version, project_license)
-	  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, keyspace)
+		sm.SourceURL,
+		ts)
 
-	return client.Query(query).Bind(
-		mm.ID,
+	batch.Query(stmts.InsertRepositoryRef,
+		sm.RepositoryID,
+		sm.Ref,
 		sm.ID,
+		sm.CreatedAt,
+		ts)
+
+	batch.Query(stmts.InsertOwnerRepository,
 		sm.OwnerID,
 		sm.RepositoryID,
-		sm.Ref,
-		sm.CommitSHA,
-		mm.BlobKey, // tracked here since Snapshot can be composite of N submissions as cached in AzBS
-		mm.FilePath,
-		mm.PackageManager,
-		mm.ProjectName,
-		mm.ProjectVersion,
-		mm.ProjectLicense).Exec()
+		sm.RepositoryNWO,
+		sm.SourceURL,
+		sm.CreatedAt,
+		ts)
+
+	return executeBatch(ctx, client, batch)
+}
+
+func writeManifest(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, sm Snapshot, mm Manifest) error {
+	return execWrite(ctx, client.Query(StatementsFor(keyspace).InsertManifest, BindManifest(sm, mm)...).WithContext(ctx))
 }
 
 func addDependency(ctx context.Context, client *gocql.Session, mdeps, drepos, dcounts **gocql.Batch,
-	keyspace string, sm Snapshot, mm Manifest, dep Dependency) error {
-
-	mdQuery := fmt.Sprintf(`INSERT INTO %s.manifest_dependencies
-	  (manifest_id, package_manager, namespace, name, version, snapshot_id, license, source_url, scope, relationship, runtime, development)
-	  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, keyspace)
-
-	(*mdeps).Entries = append((*mdeps).Entries, gocql.BatchEntry{
-		Stmt: mdQuery,
-		Args: []interface{}{
-			mm.ID,
-			mm.PackageManager,
-			dep.Namespace,
-			dep.Name,
-			dep.Version,
-			sm.ID,
-			dep.License,
-			dep.SourceURL,
-			dep.Scope,
-			dep.Relationship,
-			dep.Runtime,
-			dep.Development},
-		Idempotent: false,
-	})
-
-	drQuery := fmt.Sprintf(`INSERT INTO %s.dependent_repositories
-          (package_manager, namespace, name, version, owner_id, repository_id, license, source_url)
-	  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, keyspace)
-	(*drepos).Entries = append((*drepos).Entries, gocql.BatchEntry{
-		Stmt: drQuery,
-		Args: []interface{}{
-			mm.PackageManager,
-			dep.Namespace,
-			dep.Name,
-			dep.Version,
-			sm.OwnerID,
-			sm.RepositoryID,
-			dep.License,
-			dep.SourceURL},
+	keyspace string, sm Snapshot, mm Manifest, dep Dependency, reverseShards int) error {
+
+	stmts := StatementsFor(keyspace)
+	if *mdeps != nil {
+		(*mdeps).Entries = append((*mdeps).Entries, gocql.BatchEntry{
+			Stmt:       stmts.InsertManifestDependency,
+			Args:       BindManifestDependency(sm, mm, dep),
+			Idempotent: false,
+		})
+	}
+
+	drEntry := gocql.BatchEntry{
+		Stmt:       stmts.UpdateDependentRepository,
+		Args:       BindDependentRepository(sm, mm, dep),
 		Idempotent: true,
-	})
-
-	countsQuery := fmt.Sprintf(`UPDATE %s.dependent_repository_counts SET used_by = used_by + 1
-	  WHERE package_manager = ? AND namespace = ? AND name = ? AND version = ?`, keyspace)
-	(*dcounts).Entries = append((*dcounts).Entries, gocql.BatchEntry{
-		Stmt: countsQuery,
-		Args: []interface{}{
-			mm.PackageManager,
-			dep.Namespace,
-			dep.Name,
-			dep.Version},
+	}
+	countsEntry := gocql.BatchEntry{
+		Stmt:       stmts.UpdateDependentRepositoryCount,
+		Args:       BindDependentRepositoryCount(mm, dep),
 		Idempotent: false,
-	})
+	}
+	// the sharded layout adds the repository's shard to the package partition key
+	if reverseShards > 0 {
+		shard := ReverseShard(sm.RepositoryID, reverseShards)
+		drEntry.Stmt, drEntry.Args = stmts.UpdateShardedDependentRepository, append(drEntry.Args, shard)
+		countsEntry.Stmt, countsEntry.Args = stmts.UpdateShardedDependentRepositoryCount, append(countsEntry.Args, shard)
+	}
+	(*drepos).Entries = append((*drepos).Entries, drEntry)
+	(*dcounts).Entries = append((*dcounts).Entries, countsEntry)
 
 	return checkFlushBatches(ctx, client, mdeps, drepos, dcounts)
 }
 
 func checkFlushBatches(ctx context.Context, client *gocql.Session, mdeps, drepos, dcounts **gocql.Batch) error {
-	if (*mdeps).Size()%batchSize == 0 {
-		if err := client.ExecuteBatch(*mdeps); err != nil {
+	if *mdeps != nil && (*mdeps).Size()%batchSize == 0 {
+		if err := executeBatch(ctx, client, *mdeps); err != nil {
 			return fmt.Errorf("flushing manifest_dependencies entries: %s", err)
 		}
 		*mdeps = client.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
 	}
 	if (*drepos).Size()%batchSize == 0 {
-		if err := client.ExecuteBatch(*drepos); err != nil {
+		if err := executeBatch(ctx, client, *drepos); err != nil {
 			return fmt.Errorf("flushing dependent_repositories entries: %s", err)
 		}
 		*drepos = client.NewBatch(gocql.UnloggedBatch).WithContext(ctx)
 	}
 	if (*dcounts).Size()%batchSize == 0 {
-		if err := client.ExecuteBatch(*dcounts); err != nil {
+		if err := executeBatch(ctx, client, *dcounts); err != nil {
 			return fmt.Errorf("flushing dependent_repository_counts entries: %s", err)
 		}
 		*dcounts = client.NewBatch(gocql.CounterBatch).WithContext(ctx)
@@ -271,6 +465,128 @@ CREATE TABLE IF NOT EXISTS %s.snapshots (
 	// partition and clustering keys
 	PRIMARY KEY ((repository_id, ref), created_at)
 ) WITH CLUSTERING ORDER BY (created_at DESC);
+`,
+
+	`
+CREATE TABLE IF NOT EXISTS %s.latest_snapshots (
+	// partition key: one row per repository ref
+	repository_id varint,
+	ref text,
+
+	// most recent snapshot of the ref, copied from snapshots
+	snapshot_id uuid,
+	owner_id varint,
+	nwo text,
+	created_at timestamp,
+	commit_oid text,
+	blob_url text,
+	source_url text,
+
+	PRIMARY KEY ((repository_id, ref))
+);
+`,
+
+	`
+CREATE TABLE IF NOT EXISTS %s.repository_refs (
+	repository_id varint,
+	ref text,
+
+	// most recent snapshot of the ref
+	latest_snapshot_id uuid,
+	updated_at timestamp,
+
+	// partition and clustering keys
+	PRIMARY KEY ((repository_id), ref)
+) WITH CLUSTERING ORDER BY (ref ASC);
+`,
+
+	`
+CREATE TABLE IF NOT EXISTS %s.owner_repositories (
+	owner_id varint,
+	repository_id varint,
+
+	// repo metadata as of the most recent snapshot
+	nwo text,
+	source_url text,
+	updated_at timestamp,
+
+	// partition and clustering keys
+	PRIMARY KEY ((owner_id), repository_id)
+) WITH CLUSTERING ORDER BY (repository_id ASC);
+`,
+
+	`
+CREATE TABLE IF NOT EXISTS %s.owner_repository_packages (
+	// the package versions each repository currently contributes to its
+	// owner's inventory, read back to diff against the next snapshot
+	owner_id varint,
+	repository_id varint,
+
+	// decomposed package PURL fields
+	package_manager text,
+	namespace text,
+	name text,
+	version text,
+
+	// package metadata
+	license text,
+
+	// snapshot of the repository's default ref the entry came from
+	snapshot_id uuid,
+
+	// partition and clustering keys
+	PRIMARY KEY ((owner_id, repository_id), package_manager, namespace, name, version)
+);
+`,
+
+	`
+CREATE TABLE IF NOT EXISTS %s.owner_packages (
+	// org-wide inventory: every package version used by the latest default
+	// ref snapshot of each of the owner's repositories
+	owner_id varint,
+
+	// decomposed package PURL fields
+	package_manager text,
+	namespace text,
+	name text,
+	version text,
+
+	// repository using the package version
+	repository_id varint,
+	nwo text,
+
+	// package metadata
+	license text,
+
+	// partition and clustering keys
+	PRIMARY KEY ((owner_id), package_manager, namespace, name, version, repository_id)
+);
+`,
+
+	`
+CREATE TABLE IF NOT EXISTS %s.owner_ecosystem_counts (
+	owner_id varint,
+	package_manager text,
+
+	// number of (repository, package version) pairs in the owner's inventory
+	used counter,
+
+	// partition and clustering keys
+	PRIMARY KEY ((owner_id), package_manager)
+);
+`,
+
+	`
+CREATE TABLE IF NOT EXISTS %s.owner_license_counts (
+	owner_id varint,
+	license text,
+
+	// number of (repository, package version) pairs in the owner's inventory
+	used counter,
+
+	// partition and clustering keys
+	PRIMARY KEY ((owner_id), license)
+);
 `,
 
 	`
@@ -342,6 +658,10 @@ CREATE TABLE IF NOT EXISTS %s.dependent_repositories (
       name text,
       version text,
 
+      // sortable encoding of version (see versions.Key) so that clustering
+      // order and range queries follow the ecosystem's version precedence
+      version_key text,
+
       // repo metadata
       owner_id varint,
       repository_id varint,
@@ -350,9 +670,12 @@ CREATE TABLE IF NOT EXISTS %s.dependent_repositories (
       license text,
       source_url text,
 
+      // paths of the manifests in the repository depending on this version
+      manifest_keys set<text>,
+
       // partition and clustering keys
-      PRIMARY KEY ((package_manager, namespace, name), version, repository_id)
-) WITH CLUSTERING ORDER BY (version DESC, repository_id ASC);
+      PRIMARY KEY ((package_manager, namespace, name), version_key, version, repository_id)
+) WITH CLUSTERING ORDER BY (version_key DESC, version DESC, repository_id ASC);
 `,
 
 	`
@@ -363,11 +686,73 @@ CREATE TABLE IF NOT EXISTS %s.dependent_repository_counts (
       name text,
       version text,
 
+      // sortable encoding of version (see versions.Key)
+      version_key text,
+
       // usage counter type
       used_by counter,
 
       // partition and clustering keys
-      PRIMARY KEY ((package_manager, namespace, name), version)
-) WITH CLUSTERING ORDER BY (version DESC);
+      PRIMARY KEY ((package_manager, namespace, name), version_key, version)
+) WITH CLUSTERING ORDER BY (version_key DESC, version DESC);
+`,
+
+	`
+CREATE TABLE IF NOT EXISTS %s.advisories (
+      // advisory identifier, e.g. GHSA-xxxx-xxxx-xxxx
+      id text,
+
+      // advisory metadata
+      summary text,
+      details text,
+      severity text,
+      aliases set<text>,
+      published timestamp,
+      modified timestamp,
+
+      // partition key
+      PRIMARY KEY (id)
+);
+`,
+
+	`
+CREATE TABLE IF NOT EXISTS %s.advisory_affected_packages (
+      // parent advisory ID
+      advisory_id text,
+
+      // decomposed package PURL fields
+      package_manager text,
+      namespace text,
+      name text,
+
+      // affected version range expressions, e.g. ">=1.0.0 <1.2.3"
+      ranges list<text>,
+
+      // partition and clustering keys
+      PRIMARY KEY ((advisory_id), package_manager, namespace, name)
+);
+`,
+
+	`
+CREATE TABLE IF NOT EXISTS %s.key_catalog (
+      // partition key: snapshots are spread over a fixed number of buckets by
+      // a hash of their ID, so keys can be sampled from a few random partitions
+      bucket int,
+
+      // keys of a manifest and its snapshot
+      snapshot_id uuid,
+      manifest_id uuid,
+      owner_id varint,
+      repository_id varint,
+      ref text,
+      package_manager text,
+      manifest_key text,
+
+      // a sample of the manifest's dependencies as (namespace, name, version)
+      dependencies list<frozen<tuple<text, text, text>>>,
+
+      // partition and clustering keys
+      PRIMARY KEY ((bucket), snapshot_id, manifest_id)
+);
 `,
 }
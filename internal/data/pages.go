@@ -0,0 +1,37 @@
+package data
+
+import "github.com/gocql/gocql"
+
+// Page selects a single page of a list query: up to Size rows (0 for the
+// session's default page size) starting from State, the gocql page state
+// returned alongside the previous page (nil for the first page).
+type Page struct {
+	Size  int
+	State []byte
+}
+
+// apply sets the page on the query. Setting a page state, even a nil one,
+// disables gocql's automatic paging so the query returns exactly one page.
+func (p Page) apply(q *gocql.Query) *gocql.Query {
+	if p.Size > 0 {
+		q = q.PageSize(p.Size)
+	}
+	return q.PageState(p.State)
+}
+
+// allPages walks every page of a paged query, collecting all of its rows.
+func allPages[T any](fetch func(Page) ([]T, []byte, error)) ([]T, error) {
+	var out []T
+	var page Page
+	for {
+		rows, next, err := fetch(page)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rows...)
+		if len(next) == 0 {
+			return out, nil
+		}
+		page.State = next
+	}
+}
@@ -0,0 +1,236 @@
+package data
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// PartitionSize is the measured size of one partition of a table. Bytes is the
+// sum of the encoded sizes of the partition's cell values, which understates
+// the on-disk size (no row, cell or compression overheads) but grows with it.
+type PartitionSize struct {
+	Key   string `json:"key"`
+	Rows  int64  `json:"rows"`
+	Bytes int64  `json:"bytes"`
+}
+
+// PartitionLimits configures ScanPartitions.
+type PartitionLimits struct {
+	// Top is how many of the largest partitions to report.
+	Top int
+	// MaxRows stops the scan after this many rows, if non-zero.
+	MaxRows int64
+	// WarnRows and WarnBytes are the sizes past which a partition is
+	// counted as oversized, if non-zero.
+	WarnRows  int64
+	WarnBytes int64
+}
+
+// PartitionReport summarizes the partitions of a table seen by ScanPartitions.
+type PartitionReport struct {
+	Table      string          `json:"table"`
+	KeyColumns []string        `json:"key_columns"`
+	Partitions int64           `json:"partitions"`
+	Rows       int64           `json:"rows"`
+	Bytes      int64           `json:"bytes"`
+	Oversized  int64           `json:"oversized"`
+	Largest    []PartitionSize `json:"largest"`
+	// Truncated is set when the scan stopped at MaxRows, in which case the
+	// last partition counted may be incomplete.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Exceeds reports whether the partition is past either of the limits' warning thresholds.
+func (l PartitionLimits) Exceeds(p PartitionSize) bool {
+	return (l.WarnRows > 0 && p.Rows > l.WarnRows) || (l.WarnBytes > 0 && p.Bytes > l.WarnBytes)
+}
+
+// ScanPartitions measures every partition of the table by reading all of its
+// rows in token order, so a partition's rows arrive together and only the
+// largest partitions are held in memory. This is a full table scan: bound it
+// with MaxRows on large data sets.
+func ScanPartitions(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace, table string,
+	limits PartitionLimits) (PartitionReport, error) {
+
+	keyColumns, err := partitionKeyColumns(ctx, client, keyspace, table)
+	if err != nil {
+		return PartitionReport{}, err
+	}
+
+	tally := newPartitionTally(limits)
+	q := fmt.Sprintf(`SELECT * FROM %s.%s`, keyspace, table)
+	iter := client.Query(q).WithContext(ctx).PageSize(5000).Iter()
+
+	keyIndexes := make([]int, len(keyColumns))
+	for i, name := range keyColumns {
+		keyIndexes[i] = -1
+		for j, col := range iter.Columns() {
+			if col.Name == name {
+				keyIndexes[i] = j
+			}
+		}
+		if keyIndexes[i] < 0 {
+			iter.Close()
+			return PartitionReport{}, fmt.Errorf("partition key column %s missing from %s", name, table)
+		}
+	}
+
+	var current PartitionSize
+	keyParts := make([]string, len(keyColumns))
+	for limits.MaxRows == 0 || tally.rows < limits.MaxRows {
+		rd, err := iter.RowData()
+		if err != nil {
+			iter.Close()
+			return PartitionReport{}, fmt.Errorf("preparing %s row: %s", table, err)
+		}
+		if !iter.Scan(rd.Values...) {
+			break
+		}
+
+		var size int64
+		for i, col := range iter.Columns() {
+			encoded, err := gocql.Marshal(col.TypeInfo, rd.Values[i])
+			if err != nil {
+				iter.Close()
+				return PartitionReport{}, fmt.Errorf("measuring %s.%s: %s", table, col.Name, err)
+			}
+			size += int64(len(encoded))
+		}
+		for i, idx := range keyIndexes {
+			keyParts[i] = fmt.Sprint(deref(rd.Values[idx]))
+		}
+
+		if key := strings.Join(keyParts, "/"); key != current.Key {
+			if current.Rows > 0 {
+				tally.add(current)
+			}
+			current = PartitionSize{Key: key}
+		}
+		current.Rows++
+		current.Bytes += size
+		tally.rows++
+	}
+	truncated := limits.MaxRows > 0 && tally.rows >= limits.MaxRows
+	if err := iter.Close(); err != nil {
+		return PartitionReport{}, fmt.Errorf("scanning %s: %s", table, err)
+	}
+	if current.Rows > 0 {
+		tally.add(current)
+	}
+
+	report := tally.report()
+	report.Table = table
+	report.KeyColumns = keyColumns
+	report.Truncated = truncated
+	return report, nil
+}
+
+// partitionKeyColumns returns the table's partition key columns in key order.
+func partitionKeyColumns(ctx context.Context, client *gocql.Session, keyspace, table string) ([]string, error) {
+	q := `SELECT column_name, kind, position FROM system_schema.columns
+	  WHERE keyspace_name = ? AND table_name = ?`
+
+	type column struct {
+		name     string
+		position int
+	}
+	var columns []column
+	scanner := client.Query(q, keyspace, table).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var c column
+		var kind string
+		if err := scanner.Scan(&c.name, &kind, &c.position); err != nil {
+			return nil, fmt.Errorf("scanning system_schema.columns row: %s", err)
+		}
+		if kind == "partition_key" {
+			columns = append(columns, c)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("querying columns of %s.%s: %s", keyspace, table, err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no table %s.%s", keyspace, table)
+	}
+
+	sort.Slice(columns, func(i, j int) bool { return columns[i].position < columns[j].position })
+	out := make([]string, len(columns))
+	for i, c := range columns {
+		out[i] = c.name
+	}
+	return out, nil
+}
+
+// deref returns the value a RowData pointer points to, for formatting.
+func deref(v interface{}) interface{} {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		return rv.Elem().Interface()
+	}
+	return v
+}
+
+// partitionTally accumulates partition sizes, keeping the largest by bytes.
+type partitionTally struct {
+	limits  PartitionLimits
+	largest partitionHeap
+	totals  PartitionReport
+	// rows scanned, including those of the partition not yet added
+	rows int64
+}
+
+func newPartitionTally(limits PartitionLimits) *partitionTally {
+	return &partitionTally{limits: limits}
+}
+
+func (t *partitionTally) add(p PartitionSize) {
+	t.totals.Partitions++
+	t.totals.Rows += p.Rows
+	t.totals.Bytes += p.Bytes
+	if t.limits.Exceeds(p) {
+		t.totals.Oversized++
+	}
+
+	if t.limits.Top <= 0 {
+		return
+	}
+	if len(t.largest) < t.limits.Top {
+		heap.Push(&t.largest, p)
+	} else if p.Bytes > t.largest[0].Bytes {
+		t.largest[0] = p
+		heap.Fix(&t.largest, 0)
+	}
+}
+
+// report returns the totals and the largest partitions, largest first.
+func (t *partitionTally) report() PartitionReport {
+	out := t.totals
+	out.Largest = append([]PartitionSize{}, t.largest...)
+	sort.Slice(out.Largest, func(i, j int) bool {
+		if out.Largest[i].Bytes != out.Largest[j].Bytes {
+			return out.Largest[i].Bytes > out.Largest[j].Bytes
+		}
+		return out.Largest[i].Key < out.Largest[j].Key
+	})
+	return out
+}
+
+// partitionHeap is a min-heap of partitions by bytes.
+type partitionHeap []PartitionSize
+
+func (h partitionHeap) Len() int            { return len(h) }
+func (h partitionHeap) Less(i, j int) bool  { return h[i].Bytes < h[j].Bytes }
+func (h partitionHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *partitionHeap) Push(x interface{}) { *h = append(*h, x.(PartitionSize)) }
+func (h *partitionHeap) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return p
+}
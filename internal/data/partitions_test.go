@@ -0,0 +1,31 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPartitionTally(t *testing.T) {
+	tally := newPartitionTally(PartitionLimits{Top: 2, WarnRows: 10, WarnBytes: 1000})
+	for _, p := range []PartitionSize{
+		{Key: "a", Rows: 1, Bytes: 100},
+		{Key: "b", Rows: 20, Bytes: 500},
+		{Key: "c", Rows: 5, Bytes: 2000},
+		{Key: "d", Rows: 2, Bytes: 200},
+	} {
+		tally.add(p)
+	}
+
+	report := tally.report()
+	if report.Partitions != 4 || report.Rows != 28 || report.Bytes != 2800 {
+		t.Errorf("unexpected totals %+v", report)
+	}
+	// b has too many rows, c too many bytes
+	if report.Oversized != 2 {
+		t.Errorf("expected 2 oversized partitions, got %d", report.Oversized)
+	}
+	expected := []PartitionSize{{Key: "c", Rows: 5, Bytes: 2000}, {Key: "b", Rows: 20, Bytes: 500}}
+	if !reflect.DeepEqual(report.Largest, expected) {
+		t.Errorf("expected largest %+v, got %+v", expected, report.Largest)
+	}
+}
@@ -0,0 +1,505 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/versions"
+
+	"github.com/gocql/gocql"
+)
+
+// DependentRepository is a row of the dependent_repositories table: a single
+// repository that depends on a specific package version.
+type DependentRepository struct {
+	PackageManager string
+	Namespace      string
+	Name           string
+	Version        string
+	OwnerID        uint
+	RepositoryID   uint
+	License        string
+	SourceURL      string
+	ManifestKeys   []string
+}
+
+// VersionUsage is a row of the dependent_repository_counts table.
+type VersionUsage struct {
+	Version string
+	UsedBy  int64
+}
+
+// DependentRepositoriesInRange returns the repositories depending on any version
+// of the package that satisfies rangeExpr (e.g. ">=1.2.0 <2.0.0", or "" for all
+// versions), ordered from highest to lowest version according to the package
+// manager's version rules.
+func DependentRepositoriesInRange(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace,
+	pkgMgr, namespace, name, rangeExpr string) ([]DependentRepository, error) {
+
+	return allPages(func(page Page) ([]DependentRepository, []byte, error) {
+		return DependentRepositoriesInRangePage(ctx, lgr, client, keyspace, pkgMgr, namespace, name, rangeExpr, page)
+	})
+}
+
+// DependentRepositoriesInRangePage returns a single page of DependentRepositoriesInRange
+// along with the page state of the next page.
+func DependentRepositoriesInRangePage(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace,
+	pkgMgr, namespace, name, rangeExpr string, page Page) ([]DependentRepository, []byte, error) {
+
+	var rng versions.Range
+	if rangeExpr != "" {
+		var err error
+		if rng, err = versions.ParseRange(pkgMgr, rangeExpr); err != nil {
+			return nil, nil, fmt.Errorf("parsing version range %q: %s", rangeExpr, err)
+		}
+	}
+
+	q := StatementsFor(keyspace).SelectDependentRepositoriesInRange[rangeShape(rng, false)]
+	return dependentRepositoriesPage(ctx, client, q, rng, versionRangeArgs(rng, pkgMgr, namespace, name), page)
+}
+
+// dependentRepositoriesPage returns the rows of a page of the
+// SelectDependentRepositoriesInRange statement q, dropping those outside rng.
+func dependentRepositoriesPage(ctx context.Context, client *gocql.Session, q string,
+	rng versions.Range, args []interface{}, page Page) ([]DependentRepository, []byte, error) {
+
+	var out []DependentRepository
+	iter := page.apply(client.Query(q, args...).WithContext(ctx)).Iter()
+	next := iter.PageState()
+	scanner := iter.Scanner()
+	for scanner.Next() {
+		dr, versionKey, err := ScanDependentRepository(scanner)
+		if err != nil {
+			return nil, nil, fmt.Errorf("scanning dependent_repositories row: %s", err)
+		}
+		if rng.Contains(versionKey) {
+			out = append(out, dr)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("querying dependent_repositories: %s", err)
+	}
+
+	return out, next, nil
+}
+
+// dependentRepositoryColumns are the columns of the dependent_repositories
+// table scanned by ScanDependentRepository
+const dependentRepositoryColumns = `package_manager, namespace, name, version_key, version, owner_id, repository_id, license, source_url, manifest_keys`
+
+// ScanDependentRepository scans a row of dependentRepositoryColumns, returning
+// the version key along with the row.
+func ScanDependentRepository(scanner gocql.Scanner) (DependentRepository, string, error) {
+	var dr DependentRepository
+	var versionKey string
+	err := scanner.Scan(
+		&dr.PackageManager,
+		&dr.Namespace,
+		&dr.Name,
+		&versionKey,
+		&dr.Version,
+		&dr.OwnerID,
+		&dr.RepositoryID,
+		&dr.License,
+		&dr.SourceURL,
+		&dr.ManifestKeys)
+	return dr, versionKey, err
+}
+
+// DependentRepositories returns the repositories depending on exactly the
+// given version of the package.
+func DependentRepositories(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace,
+	pkgMgr, namespace, name, version string) ([]DependentRepository, error) {
+
+	q := StatementsFor(keyspace).SelectDependentRepositoriesOfVersion
+	return allPages(func(page Page) ([]DependentRepository, []byte, error) {
+		var out []DependentRepository
+		iter := page.apply(client.Query(q, pkgMgr, namespace, name, versions.Key(pkgMgr, version), version).WithContext(ctx)).Iter()
+		next := iter.PageState()
+		scanner := iter.Scanner()
+		for scanner.Next() {
+			dr, _, err := ScanDependentRepository(scanner)
+			if err != nil {
+				return nil, nil, fmt.Errorf("scanning dependent_repositories row: %s", err)
+			}
+			out = append(out, dr)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, fmt.Errorf("querying dependents of %s %s/%s@%s: %s", pkgMgr, namespace, name, version, err)
+		}
+		return out, next, nil
+	})
+}
+
+// UsageCount returns the number of repositories depending on exactly the given
+// version of the package, or 0 if none do.
+func UsageCount(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace,
+	pkgMgr, namespace, name, version string) (int64, error) {
+
+	q := StatementsFor(keyspace).SelectUsageCountOfVersion
+
+	var usedBy int64
+	err := client.Query(q, pkgMgr, namespace, name, versions.Key(pkgMgr, version), version).WithContext(ctx).Scan(&usedBy)
+	if err == gocql.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("querying usage count of %s %s/%s@%s: %s", pkgMgr, namespace, name, version, err)
+	}
+
+	return usedBy, nil
+}
+
+// UsageCountsInRange returns the dependent repository counts of each version of
+// the package that satisfies rangeExpr, ordered from highest to lowest version.
+func UsageCountsInRange(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace,
+	pkgMgr, namespace, name, rangeExpr string) ([]VersionUsage, error) {
+
+	rng, err := versions.ParseRange(pkgMgr, rangeExpr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing version range %q: %s", rangeExpr, err)
+	}
+
+	q := StatementsFor(keyspace).SelectVersionUsagesInRange[rangeShape(rng, false)]
+	return versionUsages(ctx, client, q, rng, versionRangeArgs(rng, pkgMgr, namespace, name))
+}
+
+// versionUsages returns the rows of the SelectVersionUsagesInRange statement
+// q, dropping those outside rng.
+func versionUsages(ctx context.Context, client *gocql.Session, q string,
+	rng versions.Range, args []interface{}) ([]VersionUsage, error) {
+
+	var out []VersionUsage
+	scanner := client.Query(q, args...).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var vu VersionUsage
+		var versionKey string
+		if err := scanner.Scan(&versionKey, &vu.Version, &vu.UsedBy); err != nil {
+			return nil, fmt.Errorf("scanning dependent_repository_counts row: %s", err)
+		}
+		if rng.Contains(versionKey) {
+			out = append(out, vu)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("querying dependent_repository_counts: %s", err)
+	}
+
+	return out, nil
+}
+
+// versionRangeArgs returns the arguments of the statement of rng's shape (see
+// rangeShape); append the shard for a sharded partition.
+func versionRangeArgs(rng versions.Range, pkgMgr, namespace, name string) []interface{} {
+	args := []interface{}{pkgMgr, namespace, name}
+	if rng.Lower != nil {
+		args = append(args, rng.Lower.Key)
+	}
+	if rng.Upper != nil {
+		args = append(args, rng.Upper.Key)
+	}
+	return args
+}
+
+// ManifestsForSnapshot returns the manifests of a snapshot, without their
+// dependencies (see DependenciesForManifest).
+func ManifestsForSnapshot(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	repositoryID uint, ref string, snapshotID gocql.UUID) ([]Manifest, error) {
+
+	return allPages(func(page Page) ([]Manifest, []byte, error) {
+		return ManifestsForSnapshotPage(ctx, lgr, client, keyspace, repositoryID, ref, snapshotID, page)
+	})
+}
+
+// ManifestsForSnapshotPage returns a single page of ManifestsForSnapshot along
+// with the page state of the next page.
+func ManifestsForSnapshotPage(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	repositoryID uint, ref string, snapshotID gocql.UUID, page Page) ([]Manifest, []byte, error) {
+
+	q := StatementsFor(keyspace).SelectManifestsForSnapshot
+
+	var out []Manifest
+	iter := page.apply(client.Query(q, repositoryID, ref, snapshotID).WithContext(ctx)).Iter()
+	next := iter.PageState()
+	scanner := iter.Scanner()
+	for scanner.Next() {
+		mm, err := ScanManifest(scanner)
+		if err != nil {
+			return nil, nil, fmt.Errorf("scanning manifests row: %s", err)
+		}
+		out = append(out, mm)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("querying manifests of snapshot %s: %s", snapshotID, err)
+	}
+
+	return out, next, nil
+}
+
+// manifestColumns are the columns of the manifests table scanned by ScanManifest
+const manifestColumns = `id, package_manager, manifest_key, blob_key, project_name, project_version, project_license`
+
+// ScanManifest scans a row of manifestColumns.
+func ScanManifest(scanner gocql.Scanner) (Manifest, error) {
+	var mm Manifest
+	err := scanner.Scan(
+		&mm.ID,
+		&mm.PackageManager,
+		&mm.FilePath,
+		&mm.BlobKey,
+		&mm.ProjectName,
+		&mm.ProjectVersion,
+		&mm.ProjectLicense)
+	return mm, err
+}
+
+// DependenciesForManifest returns every dependency (direct and transitive) of
+// a manifest, with Scope and Relationship populated.
+func DependenciesForManifest(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	manifestID gocql.UUID) ([]Dependency, error) {
+
+	return allPages(func(page Page) ([]Dependency, []byte, error) {
+		return DependenciesForManifestPage(ctx, lgr, client, keyspace, manifestID, page)
+	})
+}
+
+// DependenciesForManifestPage returns a single page of DependenciesForManifest
+// along with the page state of the next page.
+func DependenciesForManifestPage(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	manifestID gocql.UUID, page Page) ([]Dependency, []byte, error) {
+
+	q := StatementsFor(keyspace).SelectDependencies
+
+	var out []Dependency
+	iter := page.apply(client.Query(q, manifestID).WithContext(ctx)).Iter()
+	next := iter.PageState()
+	scanner := iter.Scanner()
+	for scanner.Next() {
+		dep, err := ScanDependency(scanner)
+		if err != nil {
+			return nil, nil, fmt.Errorf("scanning manifest_dependencies row: %s", err)
+		}
+		out = append(out, dep)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("querying dependencies of manifest %s: %s", manifestID, err)
+	}
+
+	return out, next, nil
+}
+
+// DependencyVersions returns every version of one package the manifest
+// depends on, directly or transitively.
+func DependencyVersions(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	manifestID gocql.UUID, pkgMgr, namespace, name string) ([]Dependency, error) {
+
+	q := StatementsFor(keyspace).SelectDependencyVersions
+
+	var out []Dependency
+	scanner := client.Query(q, manifestID, pkgMgr, namespace, name).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		dep, err := ScanDependency(scanner)
+		if err != nil {
+			return nil, fmt.Errorf("scanning manifest_dependencies row: %s", err)
+		}
+		out = append(out, dep)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("querying versions of %s/%s in manifest %s: %s", namespace, name, manifestID, err)
+	}
+
+	return out, nil
+}
+
+// dependencyColumns are the columns of the manifest_dependencies table
+// scanned by ScanDependency
+const dependencyColumns = `namespace, name, version, license, source_url, scope, relationship, runtime, development`
+
+// ScanDependency scans a row of dependencyColumns.
+func ScanDependency(scanner gocql.Scanner) (Dependency, error) {
+	var dep Dependency
+	err := scanner.Scan(
+		&dep.Namespace,
+		&dep.Name,
+		&dep.Version,
+		&dep.License,
+		&dep.SourceURL,
+		&dep.Scope,
+		&dep.Relationship,
+		&dep.Runtime,
+		&dep.Development)
+	return dep, err
+}
+
+// RepositoryRef is a row of the repository_refs table.
+type RepositoryRef struct {
+	RepositoryID     uint
+	Ref              string
+	LatestSnapshotID gocql.UUID
+	UpdatedAt        time.Time
+}
+
+// OwnerRepository is a row of the owner_repositories table.
+type OwnerRepository struct {
+	OwnerID       uint
+	RepositoryID  uint
+	RepositoryNWO string
+	SourceURL     string
+	UpdatedAt     time.Time
+}
+
+// LatestSnapshot returns the most recently created snapshot of the repository
+// ref, without its manifests. It returns gocql.ErrNotFound if the ref has no
+// snapshots.
+func LatestSnapshot(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	repositoryID uint, ref string) (Snapshot, error) {
+
+	q := StatementsFor(keyspace).SelectLatestSnapshot
+
+	var sm Snapshot
+	if err := client.Query(q, repositoryID, ref).WithContext(ctx).Scan(
+		&sm.RepositoryID,
+		&sm.Ref,
+		&sm.ID,
+		&sm.OwnerID,
+		&sm.RepositoryNWO,
+		&sm.CreatedAt,
+		&sm.CommitSHA,
+		&sm.BlobURL,
+		&sm.SourceURL); err != nil {
+		return Snapshot{}, err
+	}
+
+	return sm, nil
+}
+
+// snapshotColumns are the columns of the snapshots table scanned by ScanSnapshot
+const snapshotColumns = `id, owner_id, repository_id, nwo, source_url, ref, commit_oid, created_at, blob_url`
+
+// ScanSnapshot scans a row of snapshotColumns.
+func ScanSnapshot(scanner gocql.Scanner) (Snapshot, error) {
+	var sm Snapshot
+	err := scanner.Scan(
+		&sm.ID,
+		&sm.OwnerID,
+		&sm.RepositoryID,
+		&sm.RepositoryNWO,
+		&sm.SourceURL,
+		&sm.Ref,
+		&sm.CommitSHA,
+		&sm.CreatedAt,
+		&sm.BlobURL)
+	return sm, err
+}
+
+// CanonicalSnapshot returns the most recently created snapshot of the
+// repository ref from the snapshots table itself, rather than the
+// latest_snapshots lookup. It returns gocql.ErrNotFound if the ref has no
+// snapshots.
+func CanonicalSnapshot(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	repositoryID uint, ref string) (Snapshot, error) {
+
+	q := StatementsFor(keyspace).SelectCanonicalSnapshot
+
+	scanner := client.Query(q, repositoryID, ref).WithContext(ctx).Iter().Scanner()
+	if !scanner.Next() {
+		if err := scanner.Err(); err != nil {
+			return Snapshot{}, fmt.Errorf("querying latest snapshot of %d %s: %s", repositoryID, ref, err)
+		}
+		return Snapshot{}, gocql.ErrNotFound
+	}
+	sm, err := ScanSnapshot(scanner)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("scanning snapshots row: %s", err)
+	}
+
+	return sm, scanner.Err()
+}
+
+// SnapshotsInRange returns the snapshots of the repository ref created at or
+// after from and before to, newest first, without their manifests.
+func SnapshotsInRange(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	repositoryID uint, ref string, from, to time.Time) ([]Snapshot, error) {
+
+	q := StatementsFor(keyspace).SelectSnapshotsInRange
+
+	var out []Snapshot
+	scanner := client.Query(q, repositoryID, ref, from, to).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		sm, err := ScanSnapshot(scanner)
+		if err != nil {
+			return nil, fmt.Errorf("scanning snapshots row: %s", err)
+		}
+		out = append(out, sm)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("querying snapshots of %d %s: %s", repositoryID, ref, err)
+	}
+
+	return out, nil
+}
+
+// RefsForRepository returns every ref of the repository that has a snapshot.
+func RefsForRepository(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	repositoryID uint) ([]RepositoryRef, error) {
+
+	q := StatementsFor(keyspace).SelectRepositoryRefs
+
+	var out []RepositoryRef
+	scanner := client.Query(q, repositoryID).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var rr RepositoryRef
+		if err := scanner.Scan(&rr.RepositoryID, &rr.Ref, &rr.LatestSnapshotID, &rr.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scanning repository_refs row: %s", err)
+		}
+		out = append(out, rr)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("querying refs of repository %d: %s", repositoryID, err)
+	}
+
+	return out, nil
+}
+
+// RepositoriesForOwner returns every repository of the owner that has a snapshot.
+func RepositoriesForOwner(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	ownerID uint) ([]OwnerRepository, error) {
+
+	q := StatementsFor(keyspace).SelectOwnerRepositories
+
+	var out []OwnerRepository
+	scanner := client.Query(q, ownerID).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var repo OwnerRepository
+		if err := scanner.Scan(&repo.OwnerID, &repo.RepositoryID, &repo.RepositoryNWO, &repo.SourceURL, &repo.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scanning owner_repositories row: %s", err)
+		}
+		out = append(out, repo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("querying repositories of owner %d: %s", ownerID, err)
+	}
+
+	return out, nil
+}
+
+// SnapshotTree returns sm with its manifests and their dependencies read back
+// from the baseline tables (see VariantQueries.SnapshotTree).
+func SnapshotTree(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, sm Snapshot) (Snapshot, error) {
+	return variants[DefaultVariant].Queries.SnapshotTree(ctx, lgr, client, keyspace, sm)
+}
+
+// LatestSnapshotTree returns the latest snapshot of the repository ref with
+// its manifests and their dependencies. It returns gocql.ErrNotFound if the
+// ref has no snapshots.
+func LatestSnapshotTree(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	repositoryID uint, ref string) (Snapshot, error) {
+
+	sm, err := LatestSnapshot(ctx, lgr, client, keyspace, repositoryID, ref)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return SnapshotTree(ctx, lgr, client, keyspace, sm)
+}
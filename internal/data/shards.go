@@ -0,0 +1,213 @@
+package data
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sort"
+
+	"github.com/elireisman/cass-dsapi/internal/versions"
+
+	"github.com/gocql/gocql"
+	"golang.org/x/sync/errgroup"
+)
+
+// ReverseShards is the number of shards each package's partitions of the
+// reverse dependency tables are split into by the sharded-reverse variant.
+const ReverseShards = 16
+
+// ReverseShard returns the shard of the reverse dependency tables holding the
+// repository's rows, out of shards.
+func ReverseShard(repositoryID uint, shards int) int {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(repositoryID))
+	h := fnv.New32a()
+	h.Write(b[:])
+	return int(h.Sum32() % uint32(shards))
+}
+
+// sharded-reverse: a package's dependent_repositories and
+// dependent_repository_counts rows are spread over ReverseShards partitions by
+// repository, so popular packages don't grow a single unbounded partition.
+// Reads fan out to every shard and merge the results.
+
+const shardedDependentRepositoriesTable = `
+CREATE TABLE IF NOT EXISTS %s.dependent_repositories (
+      // decomposed package PURL fields
+      package_manager text,
+      namespace text,
+      name text,
+      version text,
+
+      // shard of the repository (see ReverseShard)
+      shard int,
+
+      // sortable encoding of version (see versions.Key) so that clustering
+      // order and range queries follow the ecosystem's version precedence
+      version_key text,
+
+      // repo metadata
+      owner_id varint,
+      repository_id varint,
+
+      // package metadata and usage counter
+      license text,
+      source_url text,
+
+      // paths of the manifests in the repository depending on this version
+      manifest_keys set<text>,
+
+      // partition and clustering keys
+      PRIMARY KEY ((package_manager, namespace, name, shard), version_key, version, repository_id)
+) WITH CLUSTERING ORDER BY (version_key DESC, version DESC, repository_id ASC);
+`
+
+const shardedDependentRepositoryCountsTable = `
+CREATE TABLE IF NOT EXISTS %s.dependent_repository_counts (
+      // decomposed package PURL fields
+      package_manager text,
+      namespace text,
+      name text,
+      version text,
+
+      // shard of the counted repositories (see ReverseShard)
+      shard int,
+
+      // sortable encoding of version (see versions.Key)
+      version_key text,
+
+      // usage counter type, summed across shards on read
+      used_by counter,
+
+      // partition and clustering keys
+      PRIMARY KEY ((package_manager, namespace, name, shard), version_key, version)
+) WITH CLUSTERING ORDER BY (version_key DESC, version DESC);
+`
+
+func batchShardedDependencies(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, snapshot Snapshot, manifest Manifest) (uint, error) {
+	return batchDependencyRows(ctx, lgr, client, keyspace, snapshot, manifest, true, ReverseShards)
+}
+
+// ShardedDependentRepositoriesInRange is DependentRepositoriesInRange for the
+// sharded-reverse layout, reading every shard of the package concurrently.
+func ShardedDependentRepositoriesInRange(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace,
+	pkgMgr, namespace, name, rangeExpr string) ([]DependentRepository, error) {
+
+	var rng versions.Range
+	if rangeExpr != "" {
+		var err error
+		if rng, err = versions.ParseRange(pkgMgr, rangeExpr); err != nil {
+			return nil, fmt.Errorf("parsing version range %q: %s", rangeExpr, err)
+		}
+	}
+
+	q := StatementsFor(keyspace).SelectDependentRepositoriesInRange[rangeShape(rng, true)]
+	shards := make([][]DependentRepository, ReverseShards)
+	g, gctx := errgroup.WithContext(ctx)
+	for shard := 0; shard < ReverseShards; shard++ {
+		shard := shard
+		g.Go(func() error {
+			args := append(versionRangeArgs(rng, pkgMgr, namespace, name), shard)
+			rows, err := allPages(func(page Page) ([]DependentRepository, []byte, error) {
+				return dependentRepositoriesPage(gctx, client, q, rng, args, page)
+			})
+			if err != nil {
+				return fmt.Errorf("reading shard %d: %s", shard, err)
+			}
+			shards[shard] = rows
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return mergeDependents(pkgMgr, shards), nil
+}
+
+// ShardedUsageCountsInRange is UsageCountsInRange for the sharded-reverse
+// layout, summing each version's counters across every shard of the package.
+func ShardedUsageCountsInRange(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace,
+	pkgMgr, namespace, name, rangeExpr string) ([]VersionUsage, error) {
+
+	rng, err := versions.ParseRange(pkgMgr, rangeExpr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing version range %q: %s", rangeExpr, err)
+	}
+
+	q := StatementsFor(keyspace).SelectVersionUsagesInRange[rangeShape(rng, true)]
+	shards := make([][]VersionUsage, ReverseShards)
+	g, gctx := errgroup.WithContext(ctx)
+	for shard := 0; shard < ReverseShards; shard++ {
+		shard := shard
+		g.Go(func() error {
+			args := append(versionRangeArgs(rng, pkgMgr, namespace, name), shard)
+			rows, err := versionUsages(gctx, client, q, rng, args)
+			if err != nil {
+				return fmt.Errorf("reading shard %d: %s", shard, err)
+			}
+			shards[shard] = rows
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return mergeUsages(pkgMgr, shards), nil
+}
+
+// mergeDependents merges the rows read from each shard into the order of an
+// unsharded partition: highest version first, then by repository.
+func mergeDependents(pkgMgr string, shards [][]DependentRepository) []DependentRepository {
+	var out []DependentRepository
+	for _, rows := range shards {
+		out = append(out, rows...)
+	}
+
+	keys := make(map[string]string)
+	for _, dr := range out {
+		if _, ok := keys[dr.Version]; !ok {
+			keys[dr.Version] = versions.Key(pkgMgr, dr.Version)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if ki, kj := keys[out[i].Version], keys[out[j].Version]; ki != kj {
+			return ki > kj
+		}
+		if out[i].Version != out[j].Version {
+			return out[i].Version > out[j].Version
+		}
+		return out[i].RepositoryID < out[j].RepositoryID
+	})
+
+	return out
+}
+
+// mergeUsages sums each version's counters read from each shard, ordered from
+// highest to lowest version.
+func mergeUsages(pkgMgr string, shards [][]VersionUsage) []VersionUsage {
+	sums := make(map[string]int64)
+	for _, rows := range shards {
+		for _, vu := range rows {
+			sums[vu.Version] += vu.UsedBy
+		}
+	}
+
+	out := make([]VersionUsage, 0, len(sums))
+	keys := make(map[string]string, len(sums))
+	for version, usedBy := range sums {
+		out = append(out, VersionUsage{Version: version, UsedBy: usedBy})
+		keys[version] = versions.Key(pkgMgr, version)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if ki, kj := keys[out[i].Version], keys[out[j].Version]; ki != kj {
+			return ki > kj
+		}
+		return out[i].Version > out[j].Version
+	})
+
+	return out
+}
@@ -0,0 +1,48 @@
+package data
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReverseShard(t *testing.T) {
+	counts := make([]int, ReverseShards)
+	for id := uint(1); id <= 1600; id++ {
+		shard := ReverseShard(id, ReverseShards)
+		if shard != ReverseShard(id, ReverseShards) {
+			t.Fatalf("shard of repository %d is not stable", id)
+		}
+		counts[shard]++
+	}
+	// sequential repository IDs should spread over every shard
+	for shard, n := range counts {
+		if n < 50 {
+			t.Errorf("shard %d got only %d of 1600 repositories", shard, n)
+		}
+	}
+}
+
+func TestMergeShards(t *testing.T) {
+	dependents := mergeDependents("npm", [][]DependentRepository{
+		{{Version: "1.10.0", RepositoryID: 7}, {Version: "1.2.0", RepositoryID: 3}},
+		nil,
+		{{Version: "1.10.0", RepositoryID: 2}, {Version: "1.9.0", RepositoryID: 5}},
+	})
+	var got []uint
+	for _, dr := range dependents {
+		got = append(got, dr.RepositoryID)
+	}
+	// by version precedence, not string order, then by repository
+	if expected := []uint{2, 7, 5, 3}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected repositories %v, got %v", expected, got)
+	}
+
+	usages := mergeUsages("npm", [][]VersionUsage{
+		{{Version: "1.10.0", UsedBy: 2}, {Version: "1.9.0", UsedBy: 1}},
+		{{Version: "1.10.0", UsedBy: 3}},
+	})
+	expected := []VersionUsage{{Version: "1.10.0", UsedBy: 5}, {Version: "1.9.0", UsedBy: 1}}
+	if !reflect.DeepEqual(usages, expected) {
+		t.Errorf("expected usages %+v, got %+v", expected, usages)
+	}
+}
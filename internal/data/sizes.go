@@ -0,0 +1,56 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/gocql/gocql"
+)
+
+// TableSize is Cassandra's estimate of a table's size on the local node.
+type TableSize struct {
+	Partitions        int64 `json:"partitions"`
+	MeanPartitionSize int64 `json:"mean_partition_bytes"`
+}
+
+// TableSizes sums the per-token-range estimates in system.size_estimates for
+// every table of the keyspace. Estimates are refreshed periodically by
+// Cassandra (every 5 minutes by default), so they lag recent writes.
+func TableSizes(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string) (map[string]TableSize, error) {
+	q := `SELECT table_name, partitions_count, mean_partition_size
+	  FROM system.size_estimates
+	  WHERE keyspace_name = ?`
+
+	type totals struct {
+		partitions, bytes int64
+	}
+	sums := map[string]*totals{}
+	scanner := client.Query(q, keyspace).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var table string
+		var partitions, meanSize int64
+		if err := scanner.Scan(&table, &partitions, &meanSize); err != nil {
+			return nil, fmt.Errorf("scanning size_estimates row: %s", err)
+		}
+		if sums[table] == nil {
+			sums[table] = &totals{}
+		}
+		sums[table].partitions += partitions
+		sums[table].bytes += partitions * meanSize
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("querying size estimates of keyspace %s: %s", keyspace, err)
+	}
+
+	out := map[string]TableSize{}
+	for table, t := range sums {
+		size := TableSize{Partitions: t.partitions}
+		if t.partitions > 0 {
+			size.MeanPartitionSize = t.bytes / t.partitions
+		}
+		out[table] = size
+	}
+
+	return out, nil
+}
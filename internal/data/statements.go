@@ -0,0 +1,403 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/elireisman/cass-dsapi/internal/versions"
+)
+
+// Statements is the CQL of the statements shared by the loader, queries and
+// benchmarks, qualified with a keyspace. Build them once per keyspace with
+// StatementsFor rather than formatting CQL per call; gocql prepares each
+// distinct statement once and caches it by its text.
+type Statements struct {
+	Keyspace string
+
+	// loader writes, bound by the Bind* helpers
+	InsertSnapshot                        string
+	InsertManifest                        string
+	InsertManifestDependency              string
+	UpdateDependentRepository             string
+	UpdateDependentRepositoryCount        string
+	UpdateShardedDependentRepository      string
+	UpdateShardedDependentRepositoryCount string
+
+	// snapshot lookup writes, each bound with a trailing write timestamp
+	InsertLatestSnapshot  string
+	InsertRepositoryRef   string
+	InsertOwnerRepository string
+
+	// owner inventory writes (see updateOwnerInventory)
+	InsertOwnerRepositoryPackage string
+	InsertOwnerPackage           string
+	DeleteOwnerRepositoryPackage string
+	DeleteOwnerPackage           string
+	AdjustOwnerEcosystemCount    string
+	AdjustOwnerLicenseCount      string
+
+	// writes of the key catalog, advisories and schema variants' own tables
+	InsertKeyCatalogEntry         string
+	InsertAdvisory                string
+	InsertAdvisoryAffectedPackage string
+	InsertBucketedSnapshot        string
+	InsertSnapshotBucket          string
+	InsertDependencyList          string
+	InsertDependencyBlob          string
+
+	// typed queries (see queries.go)
+	SelectCanonicalSnapshot              string
+	SelectSnapshotsInRange               string
+	SelectLatestSnapshot                 string
+	SelectManifestsForSnapshot           string
+	SelectDependencies                   string
+	SelectDependencyVersions             string
+	SelectDependentRepositories          string
+	SelectDependentRepositoriesOfVersion string
+	SelectRepositoryRefs                 string
+	SelectOwnerRepositories              string
+	SelectRepositoryInventory            string
+	SelectOwnerInventory                 string
+	SelectOwnerInventoryTally            string
+	SelectOwnerEcosystemCounts           string
+	SelectOwnerLicenseCounts             string
+	SelectKeyCatalogBucket               string
+	SelectAdvisoryAffectedPackages       string
+	SelectSnapshotBuckets                string
+	SelectCanonicalBucketedSnapshot      string
+	SelectBucketedSnapshotsInRange       string
+	SelectDependenciesBySnapshot         string
+	SelectDependencyList                 string
+	SelectDependencyBlob                 string
+
+	// reads of a slice of a package partition of the reverse dependency
+	// tables, one per shape of version range (see rangeShape)
+	SelectDependentRepositoriesInRange [rangeShapes]string
+	SelectVersionUsagesInRange         [rangeShapes]string
+
+	// raw reads issued by the benchmarks and bench workloads
+	SelectAllManifestsForSnapshot string
+	SelectManifestRowsForSnapshot string
+	SelectDependencyRows          string
+	SelectDependencyVersionRows   string
+	SelectManifest                string
+	SelectAllDependencies         string
+	SelectDependencyAllVersions   string
+	SelectPageOfDependents        string
+	CountDependents               string
+	CountDependentsOfVersion      string
+	SumUsageCounts                string
+	SelectUsageCountOfVersion     string
+}
+
+var statementCache sync.Map
+
+// StatementsFor returns the statements qualified with keyspace.
+func StatementsFor(keyspace string) *Statements {
+	if s, ok := statementCache.Load(keyspace); ok {
+		return s.(*Statements)
+	}
+	s, _ := statementCache.LoadOrStore(keyspace, newStatements(keyspace))
+	return s.(*Statements)
+}
+
+func newStatements(ks string) *Statements {
+	return &Statements{
+		Keyspace: ks,
+
+		InsertSnapshot: fmt.Sprintf(`INSERT INTO %s.snapshots
+	  (id, owner_id, repository_id, nwo, created_at, ref, commit_oid, blob_url, source_url)
+	  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`, ks),
+		InsertManifest: fmt.Sprintf(`INSERT INTO %s.manifests
+	  (id, snapshot_id, owner_id, repository_id, ref, commit_oid, blob_key, manifest_key,
+	   package_manager, project_name, project_version, project_license)
+	  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, ks),
+		InsertManifestDependency: fmt.Sprintf(`INSERT INTO %s.manifest_dependencies
+	  (manifest_id, package_manager, namespace, name, version, snapshot_id, license, source_url, scope, relationship, runtime, development)
+	  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, ks),
+		// an upsert, so each manifest in the repository depending on this version is added to the set
+		UpdateDependentRepository: fmt.Sprintf(`UPDATE %s.dependent_repositories
+	  SET owner_id = ?, license = ?, source_url = ?, manifest_keys = manifest_keys + ?
+	  WHERE package_manager = ? AND namespace = ? AND name = ? AND version_key = ? AND version = ? AND repository_id = ?`, ks),
+		UpdateDependentRepositoryCount: fmt.Sprintf(`UPDATE %s.dependent_repository_counts SET used_by = used_by + 1
+	  WHERE package_manager = ? AND namespace = ? AND name = ? AND version_key = ? AND version = ?`, ks),
+		// the sharded-reverse layout's shard is bound last (see ReverseShard)
+		UpdateShardedDependentRepository: fmt.Sprintf(`UPDATE %s.dependent_repositories
+	  SET owner_id = ?, license = ?, source_url = ?, manifest_keys = manifest_keys + ?
+	  WHERE package_manager = ? AND namespace = ? AND name = ? AND version_key = ? AND version = ? AND repository_id = ? AND shard = ?`, ks),
+		UpdateShardedDependentRepositoryCount: fmt.Sprintf(`UPDATE %s.dependent_repository_counts SET used_by = used_by + 1
+	  WHERE package_manager = ? AND namespace = ? AND name = ? AND version_key = ? AND version = ? AND shard = ?`, ks),
+
+		InsertLatestSnapshot: fmt.Sprintf(`INSERT INTO %s.latest_snapshots
+	  (repository_id, ref, snapshot_id, owner_id, nwo, created_at, commit_oid, blob_url, source_url)
+	  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?) USING TIMESTAMP ?`, ks),
+		InsertRepositoryRef: fmt.Sprintf(`INSERT INTO %s.repository_refs
+	  (repository_id, ref, latest_snapshot_id, updated_at)
+	  VALUES(?, ?, ?, ?) USING TIMESTAMP ?`, ks),
+		InsertOwnerRepository: fmt.Sprintf(`INSERT INTO %s.owner_repositories
+	  (owner_id, repository_id, nwo, source_url, updated_at)
+	  VALUES(?, ?, ?, ?, ?) USING TIMESTAMP ?`, ks),
+
+		InsertOwnerRepositoryPackage: fmt.Sprintf(`INSERT INTO %s.owner_repository_packages
+	  (owner_id, repository_id, package_manager, namespace, name, version, license, snapshot_id)
+	  VALUES(?, ?, ?, ?, ?, ?, ?, ?)`, ks),
+		InsertOwnerPackage: fmt.Sprintf(`INSERT INTO %s.owner_packages
+	  (owner_id, package_manager, namespace, name, version, repository_id, license, nwo)
+	  VALUES(?, ?, ?, ?, ?, ?, ?, ?)`, ks),
+		DeleteOwnerRepositoryPackage: fmt.Sprintf(`DELETE FROM %s.owner_repository_packages
+	  WHERE owner_id = ? AND repository_id = ? AND package_manager = ? AND namespace = ? AND name = ? AND version = ?`, ks),
+		DeleteOwnerPackage: fmt.Sprintf(`DELETE FROM %s.owner_packages
+	  WHERE owner_id = ? AND package_manager = ? AND namespace = ? AND name = ? AND version = ? AND repository_id = ?`, ks),
+		// bound with the signed difference between the counted and stored values
+		AdjustOwnerEcosystemCount: fmt.Sprintf(`UPDATE %s.owner_ecosystem_counts SET used = used + ?
+	  WHERE owner_id = ? AND package_manager = ?`, ks),
+		AdjustOwnerLicenseCount: fmt.Sprintf(`UPDATE %s.owner_license_counts SET used = used + ?
+	  WHERE owner_id = ? AND license = ?`, ks),
+
+		InsertKeyCatalogEntry: fmt.Sprintf(`INSERT INTO %s.key_catalog
+	  (bucket, snapshot_id, manifest_id, owner_id, repository_id, ref, package_manager, manifest_key, dependencies)
+	  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`, ks),
+		InsertAdvisory: fmt.Sprintf(`INSERT INTO %s.advisories
+	  (id, summary, details, severity, aliases, published, modified)
+	  VALUES(?, ?, ?, ?, ?, ?, ?)`, ks),
+		InsertAdvisoryAffectedPackage: fmt.Sprintf(`INSERT INTO %s.advisory_affected_packages
+	  (advisory_id, package_manager, namespace, name, ranges)
+	  VALUES(?, ?, ?, ?, ?)`, ks),
+		InsertBucketedSnapshot: fmt.Sprintf(`INSERT INTO %s.snapshots
+	  (id, owner_id, repository_id, nwo, bucket, created_at, ref, commit_oid, blob_url, source_url)
+	  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, ks),
+		InsertSnapshotBucket: fmt.Sprintf(`INSERT INTO %s.snapshot_buckets
+	  (repository_id, ref, bucket)
+	  VALUES(?, ?, ?)`, ks),
+		InsertDependencyList: fmt.Sprintf(`INSERT INTO %s.manifest_dependency_lists
+	  (manifest_id, snapshot_id, package_manager, dependencies)
+	  VALUES(?, ?, ?, ?)`, ks),
+		InsertDependencyBlob: fmt.Sprintf(`INSERT INTO %s.manifest_dependency_blobs
+	  (manifest_id, snapshot_id, package_manager, dependency_count, dependencies)
+	  VALUES(?, ?, ?, ?, ?)`, ks),
+
+		SelectCanonicalSnapshot: fmt.Sprintf(`SELECT %s FROM %s.snapshots
+	  WHERE repository_id = ? AND ref = ?
+	  ORDER BY created_at DESC
+	  LIMIT 1`, snapshotColumns, ks),
+		SelectSnapshotsInRange: fmt.Sprintf(`SELECT %s FROM %s.snapshots
+	  WHERE repository_id = ? AND ref = ? AND created_at >= ? AND created_at < ?`, snapshotColumns, ks),
+		SelectLatestSnapshot: fmt.Sprintf(`SELECT repository_id, ref, snapshot_id, owner_id, nwo, created_at, commit_oid, blob_url, source_url
+	  FROM %s.latest_snapshots
+	  WHERE repository_id = ? AND ref = ?`, ks),
+		SelectManifestsForSnapshot: fmt.Sprintf(`SELECT %s FROM %s.manifests
+	  WHERE repository_id = ? AND ref = ? AND snapshot_id = ?`, manifestColumns, ks),
+		SelectDependencies: fmt.Sprintf(`SELECT %s FROM %s.manifest_dependencies
+	  WHERE manifest_id = ?`, dependencyColumns, ks),
+		SelectDependencyVersions: fmt.Sprintf(`SELECT %s FROM %s.manifest_dependencies
+	  WHERE manifest_id = ? AND package_manager = ? AND namespace = ? AND name = ?`, dependencyColumns, ks),
+		SelectDependentRepositories: fmt.Sprintf(`SELECT %s FROM %s.dependent_repositories
+	  WHERE package_manager = ? AND namespace = ? AND name = ?`, dependentRepositoryColumns, ks),
+		SelectDependentRepositoriesOfVersion: fmt.Sprintf(`SELECT %s FROM %s.dependent_repositories
+	  WHERE package_manager = ? AND namespace = ? AND name = ? AND version_key = ? AND version = ?`, dependentRepositoryColumns, ks),
+		SelectRepositoryRefs: fmt.Sprintf(`SELECT repository_id, ref, latest_snapshot_id, updated_at
+	  FROM %s.repository_refs
+	  WHERE repository_id = ?`, ks),
+		SelectOwnerRepositories: fmt.Sprintf(`SELECT owner_id, repository_id, nwo, source_url, updated_at
+	  FROM %s.owner_repositories
+	  WHERE owner_id = ?`, ks),
+		SelectRepositoryInventory: fmt.Sprintf(`SELECT package_manager, namespace, name, version, license
+	  FROM %s.owner_repository_packages
+	  WHERE owner_id = ? AND repository_id = ?`, ks),
+		SelectOwnerInventory: fmt.Sprintf(`SELECT package_manager, namespace, name, version, repository_id, license
+	  FROM %s.owner_packages
+	  WHERE owner_id = ?`, ks),
+		SelectOwnerInventoryTally:  fmt.Sprintf(`SELECT package_manager, license FROM %s.owner_packages WHERE owner_id = ?`, ks),
+		SelectOwnerEcosystemCounts: fmt.Sprintf(`SELECT package_manager, used FROM %s.owner_ecosystem_counts WHERE owner_id = ?`, ks),
+		SelectOwnerLicenseCounts:   fmt.Sprintf(`SELECT license, used FROM %s.owner_license_counts WHERE owner_id = ?`, ks),
+		SelectKeyCatalogBucket: fmt.Sprintf(`SELECT snapshot_id, manifest_id, owner_id, repository_id, ref, package_manager, manifest_key, dependencies
+	  FROM %s.key_catalog
+	  WHERE bucket = ?`, ks),
+		SelectAdvisoryAffectedPackages: fmt.Sprintf(`SELECT package_manager, namespace, name, ranges
+	  FROM %s.advisory_affected_packages
+	  WHERE advisory_id = ?`, ks),
+		SelectSnapshotBuckets: fmt.Sprintf(`SELECT bucket FROM %s.snapshot_buckets
+	  WHERE repository_id = ? AND ref = ? AND bucket >= ? AND bucket <= ?`, ks),
+		SelectCanonicalBucketedSnapshot: fmt.Sprintf(`SELECT %s FROM %s.snapshots
+	  WHERE repository_id = ? AND ref = ? AND bucket = ?
+	  LIMIT 1`, snapshotColumns, ks),
+		SelectBucketedSnapshotsInRange: fmt.Sprintf(`SELECT %s FROM %s.snapshots
+	  WHERE repository_id = ? AND ref = ? AND bucket = ? AND created_at >= ? AND created_at < ?`, snapshotColumns, ks),
+		SelectDependenciesBySnapshot: fmt.Sprintf(`SELECT namespace, name, version, license, source_url, scope, relationship, runtime, development
+	  FROM %s.manifest_dependencies
+	  WHERE snapshot_id = ? AND manifest_id = ?`, ks),
+		SelectDependencyList: fmt.Sprintf(`SELECT dependencies FROM %s.manifest_dependency_lists WHERE manifest_id = ?`, ks),
+		SelectDependencyBlob: fmt.Sprintf(`SELECT dependencies FROM %s.manifest_dependency_blobs WHERE manifest_id = ?`, ks),
+
+		SelectAllManifestsForSnapshot: fmt.Sprintf(`SELECT * FROM %s.manifests
+	  WHERE repository_id = ? AND ref = ? AND snapshot_id = ?`, ks),
+		// every column, in the order the full-row Scan benchmarks decode them
+		SelectManifestRowsForSnapshot: fmt.Sprintf(`SELECT id, snapshot_id, owner_id, repository_id, ref, commit_oid, blob_key, manifest_key,
+	    package_manager, project_name, project_version, project_license
+	  FROM %s.manifests
+	  WHERE repository_id = ? AND ref = ? AND snapshot_id = ?`, ks),
+		SelectDependencyRows: fmt.Sprintf(`SELECT snapshot_id, manifest_id, package_manager, namespace, name,
+	    version, license, source_url, scope, relationship, runtime, development
+	  FROM %s.manifest_dependencies
+	  WHERE manifest_id = ?`, ks),
+		SelectDependencyVersionRows: fmt.Sprintf(`SELECT snapshot_id, manifest_id, package_manager, namespace, name,
+	    version, license, source_url, scope, relationship, runtime, development
+	  FROM %s.manifest_dependencies
+	  WHERE manifest_id = ? AND package_manager = ? AND namespace = ? AND name = ?`, ks),
+		SelectManifest: fmt.Sprintf(`SELECT * FROM %s.manifests
+	  WHERE repository_id = ? AND ref = ? AND snapshot_id = ? AND package_manager = ? AND manifest_key = ?`, ks),
+		SelectAllDependencies: fmt.Sprintf(`SELECT * FROM %s.manifest_dependencies WHERE manifest_id = ?`, ks),
+		SelectDependencyAllVersions: fmt.Sprintf(`SELECT * FROM %s.manifest_dependencies
+	  WHERE manifest_id = ? AND package_manager = ? AND namespace = ? AND name = ?`, ks),
+		SelectPageOfDependents: fmt.Sprintf(`SELECT * FROM %s.dependent_repositories
+	  WHERE package_manager = ? AND namespace = ? AND name = ? AND version_key = ? AND version = ?
+	  LIMIT 100`, ks),
+		CountDependents: fmt.Sprintf(`SELECT COUNT(*) FROM %s.dependent_repositories
+	  WHERE package_manager = ? AND namespace = ? AND name = ?`, ks),
+		CountDependentsOfVersion: fmt.Sprintf(`SELECT COUNT(*) FROM %s.dependent_repositories
+	  WHERE package_manager = ? AND namespace = ? AND name = ? AND version_key = ? AND version = ?`, ks),
+		SumUsageCounts: fmt.Sprintf(`SELECT SUM(used_by) FROM %s.dependent_repository_counts
+	  WHERE package_manager = ? AND namespace = ? AND name = ?
+	  LIMIT 1`, ks),
+		SelectUsageCountOfVersion: fmt.Sprintf(`SELECT used_by FROM %s.dependent_repository_counts
+	  WHERE package_manager = ? AND namespace = ? AND name = ? AND version_key = ? AND version = ?
+	  LIMIT 1`, ks),
+
+		SelectDependentRepositoriesInRange: rangeStatements(fmt.Sprintf(`SELECT %s FROM %s.dependent_repositories`,
+			dependentRepositoryColumns, ks)),
+		SelectVersionUsagesInRange: rangeStatements(fmt.Sprintf(`SELECT version_key, version, used_by
+	  FROM %s.dependent_repository_counts`, ks)),
+	}
+}
+
+// rangeShapes is the number of shapes of version range read from the reverse
+// dependency tables: no, an exclusive or an inclusive lower bound, the same
+// for the upper bound, and an unsharded or sharded partition.
+const rangeShapes = 3 * 3 * 2
+
+// rangeShape returns the index of the statement reading rng from an unsharded
+// partition or, if sharded, a shard of one, whose shard is bound last.
+func rangeShape(rng versions.Range, sharded bool) int {
+	bound := func(b *versions.Bound) int {
+		switch {
+		case b == nil:
+			return 0
+		case b.Inclusive:
+			return 2
+		default:
+			return 1
+		}
+	}
+	shape := bound(rng.Lower)*6 + bound(rng.Upper)*2
+	if sharded {
+		shape++
+	}
+	return shape
+}
+
+// rangeStatements appends the WHERE clause of each range shape to the
+// selection, restricting a package partition to a slice of the version_key
+// clustering column.
+func rangeStatements(selection string) [rangeShapes]string {
+	var out [rangeShapes]string
+	for lower, lowerOp := range []string{"", ">", ">="} {
+		for upper, upperOp := range []string{"", "<", "<="} {
+			for sharded := 0; sharded < 2; sharded++ {
+				clauses := []string{"package_manager = ?", "namespace = ?", "name = ?"}
+				if lowerOp != "" {
+					clauses = append(clauses, "version_key "+lowerOp+" ?")
+				}
+				if upperOp != "" {
+					clauses = append(clauses, "version_key "+upperOp+" ?")
+				}
+				if sharded == 1 {
+					clauses = append(clauses, "shard = ?")
+				}
+				out[lower*6+upper*2+sharded] = selection + "\n\t  WHERE " + strings.Join(clauses, " AND ")
+			}
+		}
+	}
+	return out
+}
+
+// BindSnapshot returns the arguments of InsertSnapshot.
+func BindSnapshot(sm Snapshot) []interface{} {
+	return []interface{}{
+		sm.ID,
+		sm.OwnerID,
+		sm.RepositoryID,
+		sm.RepositoryNWO,
+		sm.CreatedAt,
+		sm.Ref,
+		sm.CommitSHA,
+		sm.BlobURL,
+		sm.SourceURL,
+	}
+}
+
+// BindManifest returns the arguments of InsertManifest.
+func BindManifest(sm Snapshot, mm Manifest) []interface{} {
+	return []interface{}{
+		mm.ID,
+		sm.ID,
+		sm.OwnerID,
+		sm.RepositoryID,
+		sm.Ref,
+		sm.CommitSHA,
+		mm.BlobKey, // tracked here since Snapshot can be composite of N submissions as cached in AzBS
+		mm.FilePath,
+		mm.PackageManager,
+		mm.ProjectName,
+		mm.ProjectVersion,
+		mm.ProjectLicense,
+	}
+}
+
+// BindManifestDependency returns the arguments of InsertManifestDependency.
+func BindManifestDependency(sm Snapshot, mm Manifest, dep Dependency) []interface{} {
+	return []interface{}{
+		mm.ID,
+		mm.PackageManager,
+		dep.Namespace,
+		dep.Name,
+		dep.Version,
+		sm.ID,
+		dep.License,
+		dep.SourceURL,
+		dep.Scope,
+		dep.Relationship,
+		dep.Runtime,
+		dep.Development,
+	}
+}
+
+// BindDependentRepository returns the arguments of UpdateDependentRepository;
+// append the repository's shard for UpdateShardedDependentRepository.
+func BindDependentRepository(sm Snapshot, mm Manifest, dep Dependency) []interface{} {
+	return []interface{}{
+		sm.OwnerID,
+		dep.License,
+		dep.SourceURL,
+		[]string{mm.FilePath},
+		mm.PackageManager,
+		dep.Namespace,
+		dep.Name,
+		versions.Key(mm.PackageManager, dep.Version),
+		dep.Version,
+		sm.RepositoryID,
+	}
+}
+
+// BindDependentRepositoryCount returns the arguments of
+// UpdateDependentRepositoryCount; append the repository's shard for
+// UpdateShardedDependentRepositoryCount.
+func BindDependentRepositoryCount(mm Manifest, dep Dependency) []interface{} {
+	return []interface{}{
+		mm.PackageManager,
+		dep.Namespace,
+		dep.Name,
+		versions.Key(mm.PackageManager, dep.Version),
+		dep.Version,
+	}
+}
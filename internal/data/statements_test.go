@@ -0,0 +1,107 @@
+package data
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/versions"
+
+	"github.com/gocql/gocql"
+)
+
+func TestStatementsFor(t *testing.T) {
+	stmts := StatementsFor("ks_test")
+	if StatementsFor("ks_test") != stmts {
+		t.Errorf("expected statements to be built once per keyspace")
+	}
+	if StatementsFor("other").InsertSnapshot == stmts.InsertSnapshot {
+		t.Errorf("expected statements of different keyspaces to differ")
+	}
+
+	v := reflect.ValueOf(*stmts)
+	for i := 0; i < v.NumField(); i++ {
+		name, field := v.Type().Field(i).Name, v.Field(i)
+		if name == "Keyspace" {
+			continue
+		}
+		cqls := []string{field.String()}
+		if field.Kind() == reflect.Array {
+			cqls = cqls[:0]
+			for j := 0; j < field.Len(); j++ {
+				cqls = append(cqls, field.Index(j).String())
+			}
+		}
+		for _, cql := range cqls {
+			if !strings.Contains(cql, " ks_test.") {
+				t.Errorf("%s is not qualified with the keyspace: %s", name, cql)
+			}
+			if strings.Contains(cql, "%") {
+				t.Errorf("%s has an unformatted verb: %s", name, cql)
+			}
+		}
+	}
+}
+
+func TestRangeStatements(t *testing.T) {
+	stmts := StatementsFor("ks_test")
+	bounds := []*versions.Bound{nil, {Key: "a"}, {Key: "a", Inclusive: true}}
+	seen := map[int]bool{}
+	for _, lower := range bounds {
+		for _, upper := range bounds {
+			for _, sharded := range []bool{false, true} {
+				rng := versions.Range{Lower: lower, Upper: upper}
+				shape := rangeShape(rng, sharded)
+				seen[shape] = true
+
+				args := versionRangeArgs(rng, "npm", "", "left-pad")
+				if sharded {
+					args = append(args, 0)
+				}
+				for _, cql := range []string{stmts.SelectDependentRepositoriesInRange[shape], stmts.SelectVersionUsagesInRange[shape]} {
+					if markers := strings.Count(cql, "?"); markers != len(args) {
+						t.Errorf("shape %d has %d bind markers but is bound with %d arguments: %s", shape, markers, len(args), cql)
+					}
+					if strings.Contains(cql, "shard = ?") != sharded {
+						t.Errorf("shape %d: expected sharded %v: %s", shape, sharded, cql)
+					}
+				}
+				if lower != nil && lower.Inclusive != strings.Contains(stmts.SelectVersionUsagesInRange[shape], "version_key >= ?") {
+					t.Errorf("shape %d: expected an inclusive lower bound %v", shape, lower.Inclusive)
+				}
+				if upper != nil && upper.Inclusive != strings.Contains(stmts.SelectVersionUsagesInRange[shape], "version_key <= ?") {
+					t.Errorf("shape %d: expected an inclusive upper bound %v", shape, upper.Inclusive)
+				}
+			}
+		}
+	}
+	if len(seen) != rangeShapes {
+		t.Errorf("expected %d distinct shapes, got %d", rangeShapes, len(seen))
+	}
+}
+
+func TestBindHelpers(t *testing.T) {
+	stmts := StatementsFor("ks_test")
+	sm := Snapshot{ID: gocql.TimeUUID(), OwnerID: 1, RepositoryID: 2, Ref: "main", CreatedAt: time.Now()}
+	mm := Manifest{ID: gocql.TimeUUID(), PackageManager: "npm", FilePath: "package.json"}
+	dep := Dependency{Namespace: "acme", Name: "widget", Version: "1.2.3"}
+
+	for _, tc := range []struct {
+		name string
+		cql  string
+		args []interface{}
+	}{
+		{"InsertSnapshot", stmts.InsertSnapshot, BindSnapshot(sm)},
+		{"InsertManifest", stmts.InsertManifest, BindManifest(sm, mm)},
+		{"InsertManifestDependency", stmts.InsertManifestDependency, BindManifestDependency(sm, mm, dep)},
+		{"UpdateDependentRepository", stmts.UpdateDependentRepository, BindDependentRepository(sm, mm, dep)},
+		{"UpdateDependentRepositoryCount", stmts.UpdateDependentRepositoryCount, BindDependentRepositoryCount(mm, dep)},
+		{"UpdateShardedDependentRepository", stmts.UpdateShardedDependentRepository, append(BindDependentRepository(sm, mm, dep), 0)},
+		{"UpdateShardedDependentRepositoryCount", stmts.UpdateShardedDependentRepositoryCount, append(BindDependentRepositoryCount(mm, dep), 0)},
+	} {
+		if markers := strings.Count(tc.cql, "?"); markers != len(tc.args) {
+			t.Errorf("%s has %d bind markers but is bound with %d arguments", tc.name, markers, len(tc.args))
+		}
+	}
+}
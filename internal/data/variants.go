@@ -0,0 +1,429 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/depblob"
+
+	"github.com/gocql/gocql"
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultVariant is the schema variant defined by tables and written by Load.
+const DefaultVariant = "baseline"
+
+// Variant is an alternative data model: its own table definitions, write path
+// and implementations of the queries compared across models. Each variant is
+// loaded into its own keyspace (see Keyspace) so that the same generated
+// snapshots can be loaded into several variants side by side.
+type Variant struct {
+	Name        string
+	Description string
+	Tables      []string
+	Load        func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, snapshot Snapshot, keyspace string) error
+	LoadStream  func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, snapshot Snapshot, manifests <-chan Manifest, keyspace string) error
+	Queries     VariantQueries
+
+	// writes is the write path registerVariant builds Load and LoadStream from
+	writes writePath
+}
+
+// VariantQueries are the read paths every variant implements, taking every key
+// any variant might partition by.
+type VariantQueries struct {
+	CanonicalSnapshot func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+		repositoryID uint, ref string) (Snapshot, error)
+	SnapshotsInRange func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+		repositoryID uint, ref string, from, to time.Time) ([]Snapshot, error)
+	LatestSnapshot func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+		repositoryID uint, ref string) (Snapshot, error)
+	ManifestsForSnapshot func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+		repositoryID uint, ref string, snapshotID gocql.UUID) ([]Manifest, error)
+	DependenciesForManifest func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+		snapshotID, manifestID gocql.UUID) ([]Dependency, error)
+	DependentRepositoriesInRange func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace,
+		pkgMgr, namespace, name, rangeExpr string) ([]DependentRepository, error)
+	UsageCountsInRange func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace,
+		pkgMgr, namespace, name, rangeExpr string) ([]VersionUsage, error)
+}
+
+// SnapshotTree returns sm with its manifests and each manifest's dependencies
+// read back with the variant's queries. Dependencies are split into Runtime,
+// Development and Transitives by their Relationship and Scope, in the order
+// the variant's tables return them rather than the order they were generated.
+func (q VariantQueries) SnapshotTree(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	sm Snapshot) (Snapshot, error) {
+
+	manifests, err := q.ManifestsForSnapshot(ctx, lgr, client, keyspace, sm.RepositoryID, sm.Ref, sm.ID)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxReadConcurrency)
+	for i := range manifests {
+		mm := &manifests[i]
+		g.Go(func() error {
+			deps, err := q.DependenciesForManifest(gctx, lgr, client, keyspace, sm.ID, mm.ID)
+			if err != nil {
+				return fmt.Errorf("reading dependencies of manifest %s: %s", mm.ID, err)
+			}
+			SplitDependencies(mm, deps)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return Snapshot{}, err
+	}
+
+	sm.Manifests = manifests
+	return sm, nil
+}
+
+// SplitDependencies sorts deps into the manifest's direct runtime, direct
+// development and transitive dependencies.
+func SplitDependencies(mm *Manifest, deps []Dependency) {
+	for _, dep := range deps {
+		switch {
+		case dep.Relationship != "direct":
+			mm.Transitives = append(mm.Transitives, dep)
+		case dep.Scope == "development":
+			mm.Development = append(mm.Development, dep)
+		default:
+			mm.Runtime = append(mm.Runtime, dep)
+		}
+	}
+}
+
+var variants = map[string]Variant{}
+
+func registerVariant(v Variant) {
+	v.Load, v.LoadStream = loader(v.writes), streamLoader(v.writes)
+	variants[v.Name] = v
+}
+
+// Variants returns every registered schema variant, ordered by name.
+func Variants() []Variant {
+	var out []Variant
+	for _, v := range variants {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// LookupVariant returns the named schema variant.
+func LookupVariant(name string) (Variant, error) {
+	v, ok := variants[name]
+	if !ok {
+		var names []string
+		for _, v := range Variants() {
+			names = append(names, v.Name)
+		}
+		return Variant{}, fmt.Errorf("unknown schema variant %q, expected one of: %s", name, strings.Join(names, ", "))
+	}
+	return v, nil
+}
+
+// Keyspace returns the keyspace the variant is loaded into: base itself for
+// the default variant, otherwise base suffixed with the variant's name.
+func (v Variant) Keyspace(base string) string {
+	if v.Name == DefaultVariant {
+		return base
+	}
+	return base + "_" + strings.ReplaceAll(v.Name, "-", "_")
+}
+
+// CreateTables creates the variant's tables in keyspace.
+func (v Variant) CreateTables(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string) error {
+	return createTables(ctx, lgr, client, keyspace, v.Tables)
+}
+
+// SchemaVersion identifies the variant's table definitions by a hash of their DDL.
+func (v Variant) SchemaVersion() string {
+	h := sha256.New()
+	for _, table := range v.Tables {
+		h.Write([]byte(table))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// loader returns a Load function using the given write path.
+func loader(writes writePath) func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, snapshot Snapshot, keyspace string) error {
+	return func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, snapshot Snapshot, keyspace string) error {
+		return load(ctx, lgr, client, snapshot, keyspace, writes)
+	}
+}
+
+// streamLoader returns a LoadStream function using the given write path.
+func streamLoader(writes writePath) func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, snapshot Snapshot,
+	manifests <-chan Manifest, keyspace string) error {
+	return func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, snapshot Snapshot, manifests <-chan Manifest, keyspace string) error {
+		return loadStream(ctx, lgr, client, snapshot, manifests, keyspace, writes, maxWriteConcurrency)
+	}
+}
+
+// replaceTable returns a copy of tables with the named table's definition
+// replaced by ddl, or ddl appended if there is no such table.
+func replaceTable(tables []string, name, ddl string) []string {
+	out := append([]string{}, tables...)
+	for i, table := range out {
+		if strings.Contains(table, "CREATE TABLE IF NOT EXISTS %s."+name+" (") {
+			out[i] = ddl
+			return out
+		}
+	}
+	return append(out, ddl)
+}
+
+func init() {
+	baselineQueries := VariantQueries{
+		CanonicalSnapshot:    CanonicalSnapshot,
+		SnapshotsInRange:     SnapshotsInRange,
+		LatestSnapshot:       LatestSnapshot,
+		ManifestsForSnapshot: ManifestsForSnapshot,
+		DependenciesForManifest: func(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+			snapshotID, manifestID gocql.UUID) ([]Dependency, error) {
+			return DependenciesForManifest(ctx, lgr, client, keyspace, manifestID)
+		},
+		DependentRepositoriesInRange: DependentRepositoriesInRange,
+		UsageCountsInRange:           UsageCountsInRange,
+	}
+
+	registerVariant(Variant{
+		Name:        DefaultVariant,
+		Description: "manifest_dependencies partitioned by manifest_id",
+		Tables:      tables,
+		Queries:     baselineQueries,
+		writes:      baselineWrites,
+	})
+
+	bySnapshotQueries := baselineQueries
+	bySnapshotQueries.DependenciesForManifest = dependenciesForManifestBySnapshot
+	registerVariant(Variant{
+		Name:        "deps-by-snapshot",
+		Description: "manifest_dependencies partitioned by snapshot_id, clustered by manifest_id",
+		Tables:      replaceTable(tables, "manifest_dependencies", manifestDependenciesBySnapshotTable),
+		Queries:     bySnapshotQueries,
+		writes:      baselineWrites,
+	})
+
+	udtQueries := baselineQueries
+	udtQueries.DependenciesForManifest = dependenciesForManifestFromList
+	registerVariant(Variant{
+		Name:        "frozen-udt",
+		Description: "each manifest's dependencies stored as a single list of frozen UDTs",
+		Tables:      replaceTable(append([]string{dependencyType}, tables...), "manifest_dependencies", manifestDependencyListsTable),
+		Queries:     udtQueries,
+		writes:      writePath{snapshot: writeSnapshot, dependencies: writeDependencyList},
+	})
+
+	blobQueries := baselineQueries
+	blobQueries.DependenciesForManifest = dependenciesForManifestFromBlob
+	registerVariant(Variant{
+		Name:        "blob",
+		Description: "each manifest's dependencies stored as a single compressed, versioned blob",
+		Tables:      replaceTable(tables, "manifest_dependencies", manifestDependencyBlobsTable),
+		Queries:     blobQueries,
+		writes:      writePath{snapshot: writeSnapshot, dependencies: writeDependencyBlob},
+	})
+
+	bucketedQueries := baselineQueries
+	bucketedQueries.CanonicalSnapshot = BucketedCanonicalSnapshot
+	bucketedQueries.SnapshotsInRange = BucketedSnapshotsInRange
+	registerVariant(Variant{
+		Name:        "time-bucketed",
+		Description: "snapshots partitioned by repository ref and month of creation",
+		Tables:      replaceTable(replaceTable(tables, "snapshots", bucketedSnapshotsTable), "snapshot_buckets", snapshotBucketsTable),
+		Queries:     bucketedQueries,
+		writes:      writePath{snapshot: writeBucketedSnapshot, dependencies: batchDependencies},
+	})
+
+	shardedQueries := baselineQueries
+	shardedQueries.DependentRepositoriesInRange = ShardedDependentRepositoriesInRange
+	shardedQueries.UsageCountsInRange = ShardedUsageCountsInRange
+	registerVariant(Variant{
+		Name:        "sharded-reverse",
+		Description: "reverse dependency tables partitioned by package and repository shard",
+		Tables: replaceTable(replaceTable(tables,
+			"dependent_repositories", shardedDependentRepositoriesTable),
+			"dependent_repository_counts", shardedDependentRepositoryCountsTable),
+		Queries: shardedQueries,
+		writes:  writePath{snapshot: writeSnapshot, dependencies: batchShardedDependencies},
+	})
+}
+
+// deps-by-snapshot: the same rows as manifest_dependencies, written by the
+// same statements, but a snapshot's dependencies share one partition
+
+const manifestDependenciesBySnapshotTable = `
+CREATE TABLE IF NOT EXISTS %s.manifest_dependencies (
+      // parent snapshot, manifest IDs
+      snapshot_id uuid,
+      manifest_id uuid,
+
+      // decomposed package PURL fields
+      package_manager text,
+      namespace text,
+      name text,
+      version text,
+
+      // package metadata
+      license text,
+      source_url text,
+      scope text,
+      relationship text,
+
+      // PURLs for all direct “runtime” deps
+      runtime set<text>,
+      // PURLs of all direct “development” deps
+      development set<text>,
+
+      // partition and clustering keys
+      PRIMARY KEY ((snapshot_id), manifest_id, package_manager, namespace, name, version)
+);
+`
+
+func dependenciesForManifestBySnapshot(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	snapshotID, manifestID gocql.UUID) ([]Dependency, error) {
+
+	var out []Dependency
+	scanner := client.Query(StatementsFor(keyspace).SelectDependenciesBySnapshot, snapshotID, manifestID).WithContext(ctx).Iter().Scanner()
+	for scanner.Next() {
+		var dep Dependency
+		if err := scanner.Scan(
+			&dep.Namespace,
+			&dep.Name,
+			&dep.Version,
+			&dep.License,
+			&dep.SourceURL,
+			&dep.Scope,
+			&dep.Relationship,
+			&dep.Runtime,
+			&dep.Development); err != nil {
+			return nil, fmt.Errorf("scanning manifest_dependencies row: %s", err)
+		}
+		out = append(out, dep)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("querying dependencies of manifest %s: %s", manifestID, err)
+	}
+
+	return out, nil
+}
+
+// frozen-udt: one row per manifest holding every dependency, read and written
+// as a single cell
+
+const dependencyType = `
+CREATE TYPE IF NOT EXISTS %s.dependency (
+      namespace text,
+      name text,
+      version text,
+      source_url text,
+      license text,
+      scope text,
+      relationship text,
+      runtime set<text>,
+      development set<text>
+);
+`
+
+const manifestDependencyListsTable = `
+CREATE TABLE IF NOT EXISTS %s.manifest_dependency_lists (
+      // partition key: one row per manifest
+      manifest_id uuid,
+
+      // parent snapshot ID
+      snapshot_id uuid,
+      package_manager text,
+
+      // direct runtime, direct development and transitive dependencies, in that order
+      dependencies list<frozen<dependency>>,
+
+      PRIMARY KEY (manifest_id)
+);
+`
+
+func writeDependencyList(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, sm Snapshot, mm Manifest) (uint, error) {
+	deps := make([]Dependency, 0, len(mm.Runtime)+len(mm.Development)+len(mm.Transitives))
+	deps = append(append(append(deps, mm.Runtime...), mm.Development...), mm.Transitives...)
+
+	q := StatementsFor(keyspace).InsertDependencyList
+	if err := execWrite(ctx, client.Query(q, mm.ID, sm.ID, mm.PackageManager, deps).WithContext(ctx)); err != nil {
+		return 0, fmt.Errorf("writing dependency list: %s", err)
+	}
+
+	return batchReverseDependencies(ctx, lgr, client, keyspace, sm, mm)
+}
+
+func dependenciesForManifestFromList(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	snapshotID, manifestID gocql.UUID) ([]Dependency, error) {
+
+	var out []Dependency
+	if err := client.Query(StatementsFor(keyspace).SelectDependencyList, manifestID).WithContext(ctx).Scan(&out); err != nil {
+		return nil, fmt.Errorf("querying dependencies of manifest %s: %s", manifestID, err)
+	}
+
+	return out, nil
+}
+
+// blob: one row per manifest holding every dependency encoded by depblob
+
+const manifestDependencyBlobsTable = `
+CREATE TABLE IF NOT EXISTS %s.manifest_dependency_blobs (
+      // partition key: one row per manifest
+      manifest_id uuid,
+
+      // parent snapshot ID
+      snapshot_id uuid,
+      package_manager text,
+
+      // direct runtime, direct development and transitive dependencies, in
+      // that order, encoded by depblob (format version in the first byte)
+      dependency_count int,
+      dependencies blob,
+
+      PRIMARY KEY (manifest_id)
+);
+`
+
+func writeDependencyBlob(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string, sm Snapshot, mm Manifest) (uint, error) {
+	deps := make([]Dependency, 0, len(mm.Runtime)+len(mm.Development)+len(mm.Transitives))
+	deps = append(append(append(deps, mm.Runtime...), mm.Development...), mm.Transitives...)
+
+	blob, err := depblob.Encode(deps)
+	if err != nil {
+		return 0, err
+	}
+
+	q := StatementsFor(keyspace).InsertDependencyBlob
+	if err := execWrite(ctx, client.Query(q, mm.ID, sm.ID, mm.PackageManager, len(deps), blob).WithContext(ctx)); err != nil {
+		return 0, fmt.Errorf("writing dependency blob: %s", err)
+	}
+
+	return batchReverseDependencies(ctx, lgr, client, keyspace, sm, mm)
+}
+
+func dependenciesForManifestFromBlob(ctx context.Context, lgr *slog.Logger, client *gocql.Session, keyspace string,
+	snapshotID, manifestID gocql.UUID) ([]Dependency, error) {
+
+	var blob []byte
+	if err := client.Query(StatementsFor(keyspace).SelectDependencyBlob, manifestID).WithContext(ctx).Scan(&blob); err != nil {
+		return nil, fmt.Errorf("querying dependencies of manifest %s: %s", manifestID, err)
+	}
+
+	var out []Dependency
+	if err := depblob.Decode(blob, &out); err != nil {
+		return nil, fmt.Errorf("decoding dependencies of manifest %s: %s", manifestID, err)
+	}
+
+	return out, nil
+}
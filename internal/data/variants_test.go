@@ -0,0 +1,103 @@
+package data
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/depblob"
+)
+
+func TestVariants(t *testing.T) {
+	for _, v := range Variants() {
+		q := v.Queries
+		if v.Load == nil || v.LoadStream == nil || q.CanonicalSnapshot == nil || q.SnapshotsInRange == nil || q.LatestSnapshot == nil || q.ManifestsForSnapshot == nil ||
+			q.DependenciesForManifest == nil || q.DependentRepositoriesInRange == nil || q.UsageCountsInRange == nil {
+			t.Errorf("variant %s is missing its write path or a query", v.Name)
+		}
+		if len(v.Tables) < len(tables) {
+			t.Errorf("variant %s defines %d tables, fewer than the baseline's %d", v.Name, len(v.Tables), len(tables))
+		}
+		if v.Name != DefaultVariant && v.SchemaVersion() == SchemaVersion() {
+			t.Errorf("variant %s has the baseline's schema version", v.Name)
+		}
+	}
+
+	if ks := variants[DefaultVariant].Keyspace(Keyspace); ks != Keyspace {
+		t.Errorf("expected the baseline to use keyspace %s, got %s", Keyspace, ks)
+	}
+	v, err := LookupVariant("deps-by-snapshot")
+	if err != nil {
+		t.Fatalf("looking up variant: %s", err)
+	}
+	if ks := v.Keyspace(Keyspace); ks != Keyspace+"_deps_by_snapshot" {
+		t.Errorf("unexpected keyspace %s", ks)
+	}
+	if _, err := LookupVariant("nope"); err == nil {
+		t.Errorf("expected an error looking up an unknown variant")
+	}
+
+	// the UDT must be created before the table using it
+	udt := variants["frozen-udt"].Tables
+	if !strings.Contains(udt[0], "CREATE TYPE") {
+		t.Errorf("expected the frozen-udt variant to create its type first")
+	}
+}
+
+func TestSnapshotBucket(t *testing.T) {
+	// buckets are by UTC month, and sort in time order as strings
+	late := time.Date(2023, 1, 31, 23, 30, 0, 0, time.FixedZone("UTC-1", -3600))
+	if bucket := SnapshotBucket(late); bucket != "2023-02" {
+		t.Errorf("expected bucket 2023-02, got %s", bucket)
+	}
+	if SnapshotBucket(time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)) >= SnapshotBucket(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected buckets to sort in time order")
+	}
+}
+
+func TestDependencyBlobRoundTrip(t *testing.T) {
+	deps := []Dependency{
+		{Namespace: "acme", Name: "widget", Version: "1.2.3", License: "MIT", Scope: "runtime", Relationship: "direct",
+			Runtime: []string{"pkg:npm/acme/gear@2.0.0"}},
+		{Name: "gear", Version: "2.0.0", License: "ISC", Scope: "runtime", Relationship: "indirect"},
+	}
+
+	blob, err := depblob.Encode(deps)
+	if err != nil {
+		t.Fatalf("encoding dependencies: %s", err)
+	}
+	var decoded []Dependency
+	if err := depblob.Decode(blob, &decoded); err != nil {
+		t.Fatalf("decoding dependencies: %s", err)
+	}
+	if !reflect.DeepEqual(deps, decoded) {
+		t.Errorf("expected %+v, got %+v", deps, decoded)
+	}
+}
+
+func TestSplitDependencies(t *testing.T) {
+	var mm Manifest
+	SplitDependencies(&mm, []Dependency{
+		{Name: "a", Scope: "runtime", Relationship: "direct"},
+		{Name: "b", Scope: "development", Relationship: "direct"},
+		{Name: "c", Scope: "development", Relationship: "indirect"},
+		{Name: "d", Scope: "runtime", Relationship: "indirect"},
+	})
+
+	names := func(deps []Dependency) (out []string) {
+		for _, dep := range deps {
+			out = append(out, dep.Name)
+		}
+		return out
+	}
+	if got := names(mm.Runtime); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("unexpected runtime dependencies %v", got)
+	}
+	if got := names(mm.Development); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("unexpected development dependencies %v", got)
+	}
+	if got := names(mm.Transitives); !reflect.DeepEqual(got, []string{"c", "d"}) {
+		t.Errorf("unexpected transitive dependencies %v", got)
+	}
+}
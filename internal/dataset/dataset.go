@@ -0,0 +1,219 @@
+// Package dataset reads and writes generated data sets as newline-delimited
+// JSON files, so the same snapshots can be loaded into several clusters or
+// schema variants, or kept alongside the results they produced.
+//
+// A data set is a sequence of records, one per line: a header naming the
+// format version, then each snapshot without its manifests followed by one
+// record per manifest, and finally any advisories. Snapshots and manifests
+// are encoded with their Go field names. Files named *.gz are gzip
+// compressed; compression is detected rather than assumed when reading.
+package dataset
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/elireisman/cass-dsapi/internal/advisories"
+	"github.com/elireisman/cass-dsapi/internal/data"
+)
+
+// FormatVersion is the format version of data sets written by Writer.
+const FormatVersion = 1
+
+// Record is a line of a data set. Exactly one of its fields is set.
+type Record struct {
+	Version  int                  `json:"version,omitempty"`
+	Snapshot *data.Snapshot       `json:"snapshot,omitempty"`
+	Manifest *data.Manifest       `json:"manifest,omitempty"`
+	Advisory *advisories.Advisory `json:"advisory,omitempty"`
+}
+
+// Writer writes a data set.
+type Writer struct {
+	buf     *bufio.Writer
+	enc     *json.Encoder
+	closers []io.Closer
+}
+
+// Create creates the file at path and returns a Writer writing a data set to
+// it, gzip compressed if path ends in ".gz".
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating data set: %s", err)
+	}
+
+	var w io.Writer = f
+	closers := []io.Closer{f}
+	if strings.HasSuffix(path, ".gz") {
+		gz := gzip.NewWriter(f)
+		w, closers = gz, []io.Closer{gz, f}
+	}
+	dw, err := newWriter(w, closers)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return dw, nil
+}
+
+// NewWriter returns a Writer writing an uncompressed data set to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	return newWriter(w, nil)
+}
+
+func newWriter(w io.Writer, closers []io.Closer) (*Writer, error) {
+	buf := bufio.NewWriterSize(w, 1<<16)
+	dw := &Writer{buf: buf, enc: json.NewEncoder(buf), closers: closers}
+	if err := dw.write(Record{Version: FormatVersion}); err != nil {
+		return nil, err
+	}
+	return dw, nil
+}
+
+// WriteSnapshot writes the snapshot followed by each of its manifests.
+// Snapshots generated with data.StreamSnapshot have no manifests; write
+// them with WriteManifest as they are received instead.
+func (w *Writer) WriteSnapshot(sm data.Snapshot) error {
+	header := sm
+	header.Manifests = nil
+	if err := w.write(Record{Snapshot: &header}); err != nil {
+		return err
+	}
+	for i := range sm.Manifests {
+		if err := w.WriteManifest(sm.Manifests[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteManifest writes a manifest of the snapshot last written.
+func (w *Writer) WriteManifest(mm data.Manifest) error {
+	return w.write(Record{Manifest: &mm})
+}
+
+// WriteAdvisory writes an advisory.
+func (w *Writer) WriteAdvisory(adv advisories.Advisory) error {
+	return w.write(Record{Advisory: &adv})
+}
+
+func (w *Writer) write(rec Record) error {
+	if err := w.enc.Encode(rec); err != nil {
+		return fmt.Errorf("writing data set: %s", err)
+	}
+	return nil
+}
+
+// Close flushes the data set and closes the file written by a Writer from
+// Create. It must be called for the data set to be complete.
+func (w *Writer) Close() error {
+	err := w.buf.Flush()
+	for _, c := range w.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("closing data set: %s", err)
+	}
+	return nil
+}
+
+// Reader reads a data set record by record, so a data set needn't fit in
+// memory to be loaded.
+type Reader struct {
+	dec      *json.Decoder
+	closers  []io.Closer
+	records  int
+	snapshot bool
+}
+
+// Open opens the data set at path, decompressing it if it is gzipped.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening data set: %s", err)
+	}
+	r, err := newReader(f, []io.Closer{f})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewReader returns a Reader reading the data set from r, decompressing it
+// if it is gzipped.
+func NewReader(r io.Reader) (*Reader, error) {
+	return newReader(r, nil)
+}
+
+func newReader(r io.Reader, closers []io.Closer) (*Reader, error) {
+	buf := bufio.NewReaderSize(r, 1<<16)
+	magic, err := buf.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading data set: %s", err)
+	}
+
+	var in io.Reader = buf
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buf)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing data set: %s", err)
+		}
+		in, closers = gz, append([]io.Closer{gz}, closers...)
+	}
+
+	dr := &Reader{dec: json.NewDecoder(in), closers: closers}
+	var header Record
+	if err := dr.dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("reading data set header: %s", err)
+	}
+	if header.Version < 1 || header.Version > FormatVersion {
+		return nil, fmt.Errorf("reading data set: unsupported format version %d", header.Version)
+	}
+	return dr, nil
+}
+
+// Next returns the next snapshot, manifest or advisory record, or io.EOF
+// once there are none left. Manifests belong to the last snapshot returned.
+func (r *Reader) Next() (Record, error) {
+	var rec Record
+	if err := r.dec.Decode(&rec); err != nil {
+		if err == io.EOF {
+			return rec, err
+		}
+		return rec, fmt.Errorf("reading data set record %d: %s", r.records+1, err)
+	}
+	r.records++
+
+	switch {
+	case rec.Snapshot != nil:
+		r.snapshot = true
+	case rec.Manifest != nil:
+		if !r.snapshot {
+			return rec, fmt.Errorf("reading data set record %d: manifest %s precedes any snapshot", r.records, rec.Manifest.ID)
+		}
+	case rec.Advisory != nil:
+	default:
+		return rec, fmt.Errorf("reading data set record %d: expected a snapshot, manifest or advisory", r.records)
+	}
+	return rec, nil
+}
+
+// Close closes the file read by a Reader from Open.
+func (r *Reader) Close() error {
+	var err error
+	for _, c := range r.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package dataset
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elireisman/cass-dsapi/internal/advisories"
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/logging"
+)
+
+func TestRoundTrip(t *testing.T) {
+	quiet := logging.Discard()
+	sm, err := data.GenerateSnapshot(context.Background(), quiet, nil, 5, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adv := advisories.Advisory{ID: "GHSA-test", Summary: "test", Aliases: []string{"CVE-0000-0000"}}
+
+	for _, name := range []string{"set.ndjson", "set.ndjson.gz"} {
+		path := filepath.Join(t.TempDir(), name)
+		w, err := Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteSnapshot(sm); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteAdvisory(adv); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got data.Snapshot
+		var advs []advisories.Advisory
+		for {
+			rec, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case rec.Snapshot != nil:
+				got = *rec.Snapshot
+			case rec.Manifest != nil:
+				got.Manifests = append(got.Manifests, *rec.Manifest)
+			case rec.Advisory != nil:
+				advs = append(advs, *rec.Advisory)
+			}
+		}
+		r.Close()
+
+		// compare timestamps by instant, as the monotonic reading is not encoded
+		if !got.CreatedAt.Equal(sm.CreatedAt) {
+			t.Errorf("%s: expected created at %s, got %s", name, sm.CreatedAt, got.CreatedAt)
+		}
+		got.CreatedAt = sm.CreatedAt
+		if !reflect.DeepEqual(got, sm) {
+			t.Errorf("%s: snapshot did not survive the round trip", name)
+		}
+		if len(advs) != 1 || advs[0].ID != adv.ID {
+			t.Errorf("%s: expected advisory %s, got %v", name, adv.ID, advs)
+		}
+	}
+}
+
+func TestReaderRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, err string
+	}{
+		{"future version", `{"version":2}`, "unsupported format version 2"},
+		{"missing header", `{"snapshot":{}}`, "unsupported format version 0"},
+		{"orphan manifest", "{\"version\":1}\n{\"manifest\":{}}", "precedes any snapshot"},
+		{"empty record", "{\"version\":1}\n{}", "expected a snapshot, manifest or advisory"},
+	} {
+		r, err := NewReader(strings.NewReader(tc.in))
+		if err == nil {
+			_, err = r.Next()
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Package depblob encodes values, such as a manifest's dependency list, as
+// compressed and versioned binary blobs to be stored in a single cell.
+//
+// A blob is a format version byte followed by the encoded value. Format 1 is
+// msgpack, with structs encoded as arrays of their fields in declaration
+// order, compressed with zstd. Fields may only be appended to encoded structs;
+// any other change needs a new format version.
+package depblob
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// FormatMsgpackZstd is the format version of blobs written by Encode.
+const FormatMsgpackZstd byte = 1
+
+// decoded blobs larger than this are rejected rather than allocated
+const maxDecodedSize = 64 << 20
+
+var (
+	// both are safe for concurrent use through EncodeAll and DecodeAll
+	compressor, _   = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	decompressor, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxDecodedSize))
+)
+
+// Encode returns the blob of v in the current format.
+func Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.UseArrayEncodedStructs(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("encoding blob: %s", err)
+	}
+
+	out := make([]byte, 1, buf.Len()/2+1)
+	out[0] = FormatMsgpackZstd
+	return compressor.EncodeAll(buf.Bytes(), out), nil
+}
+
+// Decode decodes the blob into v, which must be a pointer.
+func Decode(blob []byte, v interface{}) error {
+	if len(blob) == 0 {
+		return fmt.Errorf("decoding blob: empty blob")
+	}
+
+	switch blob[0] {
+	case FormatMsgpackZstd:
+		raw, err := decompressor.DecodeAll(blob[1:], nil)
+		if err != nil {
+			return fmt.Errorf("decompressing blob: %s", err)
+		}
+		if err := msgpack.Unmarshal(raw, v); err != nil {
+			return fmt.Errorf("decoding blob: %s", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("decoding blob: unsupported format version %d", blob[0])
+	}
+}
@@ -0,0 +1,89 @@
+package depblob
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type dependency struct {
+	Namespace   string
+	Name        string
+	Version     string
+	License     string
+	Runtime     []string
+	Development []string
+}
+
+func dependencies(n int) []dependency {
+	var out []dependency
+	for i := 0; i < n; i++ {
+		out = append(out, dependency{
+			Namespace: "example",
+			Name:      fmt.Sprintf("package-%d", i),
+			Version:   fmt.Sprintf("1.%d.0", i%10),
+			License:   "MIT",
+			Runtime:   []string{fmt.Sprintf("pkg:npm/example/package-%d@1.0.0", i+1)},
+		})
+	}
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	deps := dependencies(500)
+	blob, err := Encode(deps)
+	if err != nil {
+		t.Fatalf("encoding: %s", err)
+	}
+	if blob[0] != FormatMsgpackZstd {
+		t.Errorf("expected format version %d, got %d", FormatMsgpackZstd, blob[0])
+	}
+
+	var decoded []dependency
+	if err := Decode(blob, &decoded); err != nil {
+		t.Fatalf("decoding: %s", err)
+	}
+	if !reflect.DeepEqual(deps, decoded) {
+		t.Errorf("decoded dependencies differ from those encoded")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var decoded []dependency
+	for name, blob := range map[string][]byte{
+		"empty":           nil,
+		"unknown version": {99, 1, 2, 3},
+		"corrupt":         {FormatMsgpackZstd, 1, 2, 3},
+	} {
+		if err := Decode(blob, &decoded); err == nil {
+			t.Errorf("expected error decoding %s blob", name)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	deps := dependencies(500)
+	var size int
+	for n := 0; n < b.N; n++ {
+		blob, err := Encode(deps)
+		if err != nil {
+			b.Fatal(err.Error())
+		}
+		size = len(blob)
+	}
+	b.ReportMetric(float64(size), "blobbytes")
+}
+
+func BenchmarkDecode(b *testing.B) {
+	blob, err := Encode(dependencies(500))
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var decoded []dependency
+		if err := Decode(blob, &decoded); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
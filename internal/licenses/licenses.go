@@ -0,0 +1,215 @@
+package licenses
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+)
+
+var (
+	//go:embed spdx_licenses.txt
+	spdxLicensesFile string
+
+	//go:embed spdx_exceptions.txt
+	spdxExceptionsFile string
+
+	// canonical SPDX identifiers keyed by their upper-cased form, since
+	// identifier matching is case-insensitive per the SPDX specification
+	spdxLicenses   = loadIdentifiers(spdxLicensesFile)
+	spdxExceptions = loadIdentifiers(spdxExceptionsFile)
+)
+
+// Expression is a parsed SPDX license expression. Leaves carry a License (and
+// optionally OrLater and an Exception); compound nodes carry an Op of "AND"
+// or "OR" and two or more Operands.
+type Expression struct {
+	License   string
+	OrLater   bool
+	Exception string
+
+	Op       string
+	Operands []Expression
+}
+
+// String renders the expression in canonical form, parenthesizing nested
+// compound expressions.
+func (e Expression) String() string {
+	if e.Op == "" {
+		out := e.License
+		if e.OrLater {
+			out += "+"
+		}
+		if e.Exception != "" {
+			out += " WITH " + e.Exception
+		}
+		return out
+	}
+
+	var parts []string
+	for _, operand := range e.Operands {
+		if operand.Op != "" {
+			parts = append(parts, "("+operand.String()+")")
+		} else {
+			parts = append(parts, operand.String())
+		}
+	}
+	return strings.Join(parts, " "+e.Op+" ")
+}
+
+// Parse parses an SPDX license expression such as
+// "(MIT OR Apache-2.0) AND GPL-2.0-only WITH Classpath-exception-2.0",
+// validating every identifier against the SPDX license and exception lists.
+// LicenseRef- and DocumentRef- identifiers are accepted as-is.
+func Parse(expr string) (Expression, error) {
+	p := parser{tokens: tokenize(expr)}
+	if len(p.tokens) == 0 {
+		return Expression{}, fmt.Errorf("empty license expression")
+	}
+
+	out, err := p.parseOr()
+	if err != nil {
+		return Expression{}, fmt.Errorf("parsing license expression %q: %s", expr, err)
+	}
+	if p.pos < len(p.tokens) {
+		return Expression{}, fmt.Errorf("parsing license expression %q: unexpected %q", expr, p.tokens[p.pos])
+	}
+
+	return out, nil
+}
+
+// Valid reports whether id is a known SPDX license identifier.
+func Valid(id string) bool {
+	_, ok := spdxLicenses[strings.ToUpper(id)]
+	return ok
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+// OR has the lowest precedence, then AND, then WITH
+func (p *parser) parseOr() (Expression, error) {
+	return p.parseBinary("OR", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (Expression, error) {
+	return p.parseBinary("AND", p.parseWith)
+}
+
+func (p *parser) parseBinary(op string, operand func() (Expression, error)) (Expression, error) {
+	first, err := operand()
+	if err != nil {
+		return Expression{}, err
+	}
+
+	operands := []Expression{first}
+	for strings.ToUpper(p.peek()) == op {
+		p.next()
+		next, err := operand()
+		if err != nil {
+			return Expression{}, err
+		}
+		operands = append(operands, next)
+	}
+	if len(operands) == 1 {
+		return first, nil
+	}
+
+	return Expression{Op: op, Operands: operands}, nil
+}
+
+func (p *parser) parseWith() (Expression, error) {
+	out, err := p.parsePrimary()
+	if err != nil {
+		return Expression{}, err
+	}
+	if strings.ToUpper(p.peek()) != "WITH" {
+		return out, nil
+	}
+	p.next()
+
+	if out.Op != "" {
+		return Expression{}, fmt.Errorf("WITH must follow a single license identifier")
+	}
+	exc := p.next()
+	canonical, ok := spdxExceptions[strings.ToUpper(exc)]
+	if !ok {
+		return Expression{}, fmt.Errorf("unknown SPDX license exception %q", exc)
+	}
+	out.Exception = canonical
+
+	return out, nil
+}
+
+func (p *parser) parsePrimary() (Expression, error) {
+	tok := p.next()
+	switch strings.ToUpper(tok) {
+	case "":
+		return Expression{}, fmt.Errorf("unexpected end of expression")
+	case "(":
+		out, err := p.parseOr()
+		if err != nil {
+			return Expression{}, err
+		}
+		if p.next() != ")" {
+			return Expression{}, fmt.Errorf("missing closing parenthesis")
+		}
+		return out, nil
+	case ")", "AND", "OR", "WITH":
+		return Expression{}, fmt.Errorf("unexpected %q", tok)
+	}
+
+	var out Expression
+	if strings.HasSuffix(tok, "+") {
+		out.OrLater = true
+		tok = strings.TrimSuffix(tok, "+")
+	}
+
+	upper := strings.ToUpper(tok)
+	switch {
+	case strings.HasPrefix(upper, "LICENSEREF-"), strings.HasPrefix(upper, "DOCUMENTREF-"):
+		out.License = tok
+	case upper == "NONE" || upper == "NOASSERTION":
+		out.License = upper
+	default:
+		canonical, ok := spdxLicenses[upper]
+		if !ok {
+			return Expression{}, fmt.Errorf("unknown SPDX license identifier %q", tok)
+		}
+		out.License = canonical
+	}
+
+	return out, nil
+}
+
+func tokenize(expr string) []string {
+	expr = strings.ReplaceAll(expr, "(", " ( ")
+	expr = strings.ReplaceAll(expr, ")", " ) ")
+	return strings.Fields(expr)
+}
+
+func loadIdentifiers(file string) map[string]string {
+	out := map[string]string{}
+	for _, line := range strings.Split(file, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		out[strings.ToUpper(line)] = line
+	}
+	return out
+}
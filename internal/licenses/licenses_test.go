@@ -0,0 +1,78 @@
+package licenses
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	cases := map[string]string{
+		"MIT":                         "MIT",
+		"mit":                         "MIT",
+		"Apache-2.0 OR MIT":           "Apache-2.0 OR MIT",
+		"(MIT OR Apache-2.0) AND ISC": "(MIT OR Apache-2.0) AND ISC",
+		"MIT OR Apache-2.0 AND ISC":   "MIT OR (Apache-2.0 AND ISC)",
+		"GPL-2.0-only WITH Classpath-exception-2.0": "GPL-2.0-only WITH Classpath-exception-2.0",
+		"((GPL-2.0+))": "GPL-2.0+",
+		"LicenseRef-Proprietary AND BSD-3-Clause":              "LicenseRef-Proprietary AND BSD-3-Clause",
+		"MIT OR ISC OR 0BSD":                                   "MIT OR ISC OR 0BSD",
+		"(MIT and ISC) or gpl-3.0-only with GCC-exception-3.1": "(MIT AND ISC) OR GPL-3.0-only WITH GCC-exception-3.1",
+	}
+
+	for expr, expected := range cases {
+		parsed, err := Parse(expr)
+		if err != nil {
+			t.Errorf("parsing %q: %s", expr, err)
+			continue
+		}
+		if parsed.String() != expected {
+			t.Errorf("parsing %q: expected %q, got %q", expr, expected, parsed.String())
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"APL-1.0-only",
+		"MIT OR",
+		"AND MIT",
+		"(MIT",
+		"MIT)",
+		"MIT ISC",
+		"MIT WITH Not-An-Exception",
+		"(MIT OR ISC) WITH Classpath-exception-2.0",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("expected error parsing %q", expr)
+		}
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy := Policy{
+		Allow: []string{"MIT", "Apache-2.0", "BSD-3-Clause", "GPL-2.0-only WITH Classpath-exception-2.0"},
+		Deny:  []string{"GPL-3.0-only", "AGPL-3.0-only"},
+	}
+
+	cases := map[string]Verdict{
+		"MIT":                         Allowed,
+		"MIT OR GPL-3.0-only":         Allowed,
+		"MIT AND GPL-3.0-only":        Denied,
+		"ISC":                         Unlisted,
+		"ISC OR GPL-3.0-only":         Unlisted,
+		"Apache-2.0 AND (ISC OR MIT)": Allowed,
+		"GPL-2.0-only":                Unlisted,
+		"GPL-2.0-only WITH Classpath-exception-2.0": Allowed,
+		"":             Invalid,
+		"NASA-1.3 OR":  Invalid,
+		"APL-1.0-only": Invalid,
+	}
+
+	for expr, expected := range cases {
+		if verdict, reason := policy.Evaluate(expr); verdict != expected {
+			t.Errorf("evaluating %q: expected %s, got %s (%s)", expr, expected, verdict, reason)
+		}
+	}
+
+	if verdict, _ := (Policy{Deny: []string{"MIT"}}).Evaluate("ISC"); verdict != Allowed {
+		t.Errorf("expected an empty allow list to allow anything not denied, got %s", verdict)
+	}
+}
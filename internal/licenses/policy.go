@@ -0,0 +1,126 @@
+package licenses
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Verdict is the outcome of evaluating a license expression against a Policy,
+// ordered from best to worst.
+type Verdict int
+
+const (
+	Allowed Verdict = iota
+	Unlisted
+	Denied
+	Invalid
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Allowed:
+		return "allowed"
+	case Unlisted:
+		return "unlisted"
+	case Denied:
+		return "denied"
+	default:
+		return "invalid"
+	}
+}
+
+func (v Verdict) MarshalJSON() ([]byte, error) {
+	return json.Marshal(v.String())
+}
+
+// Policy lists allowed and denied license identifiers. A license is Denied if
+// it appears in Deny, Allowed if Allow is empty or lists it, and Unlisted
+// otherwise. Entries may also be full "ID WITH exception" leaves to carve out
+// exceptions to a denied license.
+type Policy struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// LoadPolicy reads a JSON policy file: {"allow": ["MIT", ...], "deny": ["GPL-3.0-only", ...]}
+func LoadPolicy(path string) (Policy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	var p Policy
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return Policy{}, fmt.Errorf("decoding license policy %s: %s", path, err)
+	}
+	for _, id := range append(append([]string{}, p.Allow...), p.Deny...) {
+		if _, err := Parse(id); err != nil {
+			return Policy{}, fmt.Errorf("license policy %s: %s", path, err)
+		}
+	}
+
+	return p, nil
+}
+
+// Evaluate parses the license expression and returns its verdict under the
+// policy along with a human-readable reason. Alternatives joined by OR take the
+// best verdict among them, while conjunctions joined by AND take the worst.
+func (p Policy) Evaluate(expr string) (Verdict, string) {
+	if strings.TrimSpace(expr) == "" {
+		return Invalid, "no license declared"
+	}
+	parsed, err := Parse(expr)
+	if err != nil {
+		return Invalid, err.Error()
+	}
+
+	return p.evaluate(parsed)
+}
+
+func (p Policy) evaluate(e Expression) (Verdict, string) {
+	if e.Op == "" {
+		return p.evaluateLeaf(e)
+	}
+
+	verdict, reason := p.evaluate(e.Operands[0])
+	for _, operand := range e.Operands[1:] {
+		v, r := p.evaluate(operand)
+		if (e.Op == "OR" && v < verdict) || (e.Op == "AND" && v > verdict) {
+			verdict, reason = v, r
+		}
+	}
+
+	return verdict, reason
+}
+
+func (p Policy) evaluateLeaf(e Expression) (Verdict, string) {
+	leaf := e.String()
+	if e.Exception != "" {
+		switch {
+		case contains(p.Deny, leaf):
+			return Denied, fmt.Sprintf("%s is denied by policy", leaf)
+		case contains(p.Allow, leaf):
+			return Allowed, ""
+		}
+	}
+
+	switch {
+	case contains(p.Deny, e.License):
+		return Denied, fmt.Sprintf("%s is denied by policy", e.License)
+	case len(p.Allow) == 0 || contains(p.Allow, e.License):
+		return Allowed, ""
+	default:
+		return Unlisted, fmt.Sprintf("%s is not on the policy allow list", e.License)
+	}
+}
+
+func contains(ids []string, id string) bool {
+	for _, candidate := range ids {
+		if strings.EqualFold(candidate, id) {
+			return true
+		}
+	}
+	return false
+}
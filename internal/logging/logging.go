@@ -0,0 +1,44 @@
+// Package logging builds the structured loggers the commands pass down to the
+// data, bench and api packages. Progress worth following on a terminal is
+// logged at info, per-manifest and per-statement detail at debug, so large
+// seed runs stay readable unless -v asks for the detail.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+)
+
+// Formats are the supported output formats.
+var Formats = []string{"text", "json"}
+
+// New returns a logger writing to w in the given format ("text" or "json"),
+// at debug level if verbose and info level otherwise.
+func New(w io.Writer, format string, verbose bool) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if verbose {
+		opts.Level = slog.LevelDebug
+	}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected one of: %v", format, Formats)
+	}
+}
+
+// Discard returns a logger dropping everything, for tests and background work.
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+// Std adapts lgr to the standard library's *log.Logger, logging each line at
+// level with a component attribute, for libraries such as gocql that take one.
+func Std(lgr *slog.Logger, component string, level slog.Level) *log.Logger {
+	return slog.NewLogLogger(lgr.With("component", component).Handler(), level)
+}
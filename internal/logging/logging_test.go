@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	lgr, err := New(&buf, "json", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lgr.Debug("Manifest written", "manifest_id", "m1")
+	lgr.Info("Snapshot loaded", "snapshot_id", "s1")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected debug records to be dropped by default, got %q", buf.String())
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("expected a JSON record: %s", err)
+	}
+	if record["msg"] != "Snapshot loaded" || record["snapshot_id"] != "s1" {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	buf.Reset()
+	lgr, _ = New(&buf, "text", true)
+	lgr.Debug("Manifest written", "manifest_id", "m1")
+	if !strings.Contains(buf.String(), "level=DEBUG") || !strings.Contains(buf.String(), "manifest_id=m1") {
+		t.Errorf("expected a verbose text debug record, got %q", buf.String())
+	}
+
+	if _, err := New(&buf, "xml", false); err == nil {
+		t.Errorf("expected an unknown format to be rejected")
+	}
+}
+
+func TestStd(t *testing.T) {
+	var buf bytes.Buffer
+	lgr, _ := New(&buf, "text", false)
+	Std(lgr, "gocql", slog.LevelWarn).Printf("unable to dial %s", "127.0.0.1")
+	if out := buf.String(); !strings.Contains(out, "level=WARN") || !strings.Contains(out, "component=gocql") ||
+		!strings.Contains(out, `msg="unable to dial 127.0.0.1"`) {
+		t.Errorf("expected a gocql warning, got %q", out)
+	}
+}
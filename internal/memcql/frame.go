@@ -0,0 +1,271 @@
+package memcql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// native protocol v3 opcodes
+const (
+	opError     = 0x00
+	opStartup   = 0x01
+	opReady     = 0x02
+	opOptions   = 0x05
+	opSupported = 0x06
+	opQuery     = 0x07
+	opResult    = 0x08
+	opPrepare   = 0x09
+	opExecute   = 0x0A
+	opRegister  = 0x0B
+	opBatch     = 0x0D
+)
+
+// RESULT kinds
+const (
+	resultVoid     = 0x0001
+	resultRows     = 0x0002
+	resultPrepared = 0x0004
+)
+
+// rows metadata flags
+const (
+	flagGlobalTableSpec = 0x0001
+	flagHasMorePages    = 0x0002
+	flagNoMetadata      = 0x0004
+)
+
+// query parameter flags
+const (
+	flagValues            = 0x01
+	flagPageSize          = 0x04
+	flagPagingState       = 0x08
+	flagSerialConsistency = 0x10
+	flagDefaultTimestamp  = 0x20
+	flagValueNames        = 0x40
+)
+
+// error codes
+const (
+	errServer  = 0x0000
+	errProto   = 0x000A
+	errSyntax  = 0x2000
+	errInvalid = 0x2200
+)
+
+const (
+	protoVersion    = 0x03
+	responseVersion = 0x80 | protoVersion
+	headerSize      = 9
+	maxFrameSize    = 256 << 20
+)
+
+// cqlError is an ERROR response.
+type cqlError struct {
+	code int32
+	msg  string
+}
+
+func (e *cqlError) Error() string {
+	return e.msg
+}
+
+func invalid(format string, args ...interface{}) *cqlError {
+	return &cqlError{errInvalid, fmt.Sprintf(format, args...)}
+}
+
+func syntax(format string, args ...interface{}) *cqlError {
+	return &cqlError{errSyntax, fmt.Sprintf(format, args...)}
+}
+
+type frame struct {
+	stream uint16
+	opcode byte
+	body   []byte
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+	if header[0] != protoVersion {
+		return frame{}, fmt.Errorf("unsupported protocol version %d", header[0]&0x7f)
+	}
+	size := binary.BigEndian.Uint32(header[5:])
+	if size > maxFrameSize {
+		return frame{}, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+	f := frame{stream: binary.BigEndian.Uint16(header[2:]), opcode: header[4], body: make([]byte, size)}
+	if _, err := io.ReadFull(r, f.body); err != nil {
+		return frame{}, err
+	}
+	return f, nil
+}
+
+func writeFrame(w io.Writer, stream uint16, opcode byte, body []byte) error {
+	var header [headerSize]byte
+	header[0] = responseVersion
+	binary.BigEndian.PutUint16(header[2:], stream)
+	header[4] = opcode
+	binary.BigEndian.PutUint32(header[5:], uint32(len(body)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// buffer encodes the protocol's notation: [short], [int], [string], [bytes]...
+type buffer struct {
+	b []byte
+}
+
+func (w *buffer) byte(v byte) {
+	w.b = append(w.b, v)
+}
+
+func (w *buffer) short(v uint16) {
+	w.b = binary.BigEndian.AppendUint16(w.b, v)
+}
+
+func (w *buffer) int(v int32) {
+	w.b = binary.BigEndian.AppendUint32(w.b, uint32(v))
+}
+
+func (w *buffer) string(s string) {
+	w.short(uint16(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *buffer) stringList(l []string) {
+	w.short(uint16(len(l)))
+	for _, s := range l {
+		w.string(s)
+	}
+}
+
+// bytes writes b as [bytes], a nil b as null.
+func (w *buffer) bytes(b []byte) {
+	if b == nil {
+		w.int(-1)
+		return
+	}
+	w.int(int32(len(b)))
+	w.b = append(w.b, b...)
+}
+
+func (w *buffer) shortBytes(b []byte) {
+	w.short(uint16(len(b)))
+	w.b = append(w.b, b...)
+}
+
+// reader decodes the protocol's notation, recording the first read past the
+// end of the body in err.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.b) {
+		r.err = fmt.Errorf("unexpected end of frame body")
+		return nil
+	}
+	out := r.b[:n:n]
+	r.b = r.b[n:]
+	return out
+}
+
+func (r *reader) byte() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) short() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) int() int32 {
+	if b := r.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *reader) long() int64 {
+	if b := r.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (r *reader) string() string {
+	return string(r.take(int(r.short())))
+}
+
+func (r *reader) longString() string {
+	return string(r.take(int(r.int())))
+}
+
+// bytes reads [bytes], returning nil for null.
+func (r *reader) bytes() []byte {
+	n := r.int()
+	if n < 0 {
+		return nil
+	}
+	return r.take(int(n))
+}
+
+func (r *reader) shortBytes() []byte {
+	return r.take(int(r.short()))
+}
+
+// queryParams are the parameters following a QUERY's string or an EXECUTE's ID.
+type queryParams struct {
+	values      [][]byte
+	pageSize    int
+	pagingState []byte
+	timestamp   int64
+	// hasTimestamp is set when the client sent a default timestamp
+	hasTimestamp bool
+}
+
+func (r *reader) queryParams() (queryParams, error) {
+	var p queryParams
+	r.short() // consistency
+	flags := r.byte()
+	if flags&flagValueNames != 0 {
+		return p, &cqlError{errProto, "named values are not supported"}
+	}
+	if flags&flagValues != 0 {
+		n := int(r.short())
+		for i := 0; i < n; i++ {
+			p.values = append(p.values, r.bytes())
+		}
+	}
+	if flags&flagPageSize != 0 {
+		p.pageSize = int(r.int())
+	}
+	if flags&flagPagingState != 0 {
+		p.pagingState = r.bytes()
+	}
+	if flags&flagSerialConsistency != 0 {
+		r.short()
+	}
+	if flags&flagDefaultTimestamp != 0 {
+		p.timestamp, p.hasTimestamp = r.long(), true
+	}
+	if r.err != nil {
+		return p, &cqlError{errProto, r.err.Error()}
+	}
+	return p, nil
+}
@@ -0,0 +1,337 @@
+// Package memcql is an in-process, single node stand-in for Cassandra
+// speaking version 3 of the CQL native protocol, so code written against a
+// gocql.Session can be exercised without a cluster.
+//
+// It supports the statements the data package issues: CREATE KEYSPACE, TYPE
+// and TABLE; INSERT, UPDATE (of columns, counters and set or list appends) and
+// DELETE of whole rows, optionally USING TIMESTAMP; and SELECT of columns, *,
+// COUNT(*) or SUM(column), restricted by equality or range on any column,
+// with ORDER BY on the first clustering column, LIMIT and paging. Batches are
+// applied statement by statement.
+//
+// Writes follow Cassandra's semantics where they affect what is read back:
+// cells are last-write-wins by timestamp, row tombstones shadow writes at or
+// before their timestamp, rows are returned in clustering order, set<...>
+// elements are deduplicated and sorted, and an empty non-frozen collection
+// reads back as null. Frozen values are returned exactly as written. There is
+// no replication, consistency, TTL, lightweight transactions or secondary
+// indexes, and every statement runs under a single lock.
+package memcql
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/gocql/gocql"
+)
+
+// Server is a memcql node listening on a loopback port.
+type Server struct {
+	lst   net.Listener
+	store *store
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// Start starts a server with an empty schema on a free loopback port.
+func Start() (*Server, error) {
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listening: %s", err)
+	}
+
+	s := &Server{lst: lst, store: newStore(), conns: map[net.Conn]struct{}{}}
+	if err := s.createSystemTables(); err != nil {
+		lst.Close()
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// createSystemTables creates the system.local table gocql reads the node's
+// metadata from when it connects.
+func (s *Server) createSystemTables() error {
+	hostID := make([]byte, 16)
+	if _, err := rand.Read(hostID); err != nil {
+		return err
+	}
+	hostID[6], hostID[8] = hostID[6]&0x0f|0x40, hostID[8]&0x3f|0x80
+
+	for _, stmt := range []string{
+		`CREATE KEYSPACE system WITH replication = {'class': 'LocalStrategy'}`,
+		`CREATE TABLE system.local (key text PRIMARY KEY, cluster_name text, data_center text, host_id uuid,
+		  partitioner text, rack text, release_version text)`,
+	} {
+		if _, err := s.store.run(stmt); err != nil {
+			return fmt.Errorf("creating system tables: %s", err)
+		}
+	}
+	_, err := s.store.run(`INSERT INTO system.local (key, cluster_name, data_center, host_id, partitioner, rack, release_version)
+	  VALUES ('local', 'memcql', 'datacenter1', ?, 'org.apache.cassandra.dht.Murmur3Partitioner', 'rack1', '3.11.16')`, hostID)
+	return err
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() *net.TCPAddr {
+	return s.lst.Addr().(*net.TCPAddr)
+}
+
+// Configure points a cluster configuration at the server, as the only host
+// and without the topology, status and schema events it doesn't send.
+func (s *Server) Configure(cfg *gocql.ClusterConfig) {
+	cfg.Hosts = []string{s.Addr().IP.String()}
+	cfg.Port = s.Addr().Port
+	cfg.ProtoVersion = protoVersion
+	cfg.DisableInitialHostLookup = true
+	cfg.PoolConfig.HostSelectionPolicy = gocql.RoundRobinHostPolicy()
+	cfg.Events.DisableNodeStatusEvents = true
+	cfg.Events.DisableTopologyEvents = true
+	cfg.Events.DisableSchemaEvents = true
+}
+
+// Close stops listening, closes every connection and waits for them to finish.
+func (s *Server) Close() error {
+	err := s.lst.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.lst.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// serve answers the connection's requests in the order they arrive.
+func (s *Server) serve(conn net.Conn) {
+	for {
+		f, err := readFrame(conn)
+		if err != nil {
+			return
+		}
+
+		opcode, body := s.handle(f)
+		if err := writeFrame(conn, f.stream, opcode, body); err != nil {
+			return
+		}
+	}
+}
+
+// handle returns the opcode and body of the response to a request.
+func (s *Server) handle(f frame) (byte, []byte) {
+	w := &buffer{}
+	err := s.respond(f, w)
+	if err == nil {
+		if len(w.b) == 0 {
+			return opReady, nil
+		}
+		if f.opcode == opOptions {
+			return opSupported, w.b
+		}
+		return opResult, w.b
+	}
+
+	var cerr *cqlError
+	if !errors.As(err, &cerr) {
+		cerr = &cqlError{errServer, err.Error()}
+	}
+	w = &buffer{}
+	w.int(cerr.code)
+	w.string(cerr.msg)
+	return opError, w.b
+}
+
+// respond writes the body of the response to a request to w, leaving it
+// empty for READY.
+func (s *Server) respond(f frame, w *buffer) error {
+	r := &reader{b: f.body}
+	switch f.opcode {
+	case opStartup, opRegister:
+		return nil
+
+	case opOptions:
+		w.short(2)
+		w.string("CQL_VERSION")
+		w.stringList([]string{"3.4.4"})
+		w.string("COMPRESSION")
+		w.stringList(nil)
+		return nil
+
+	case opQuery:
+		query := r.longString()
+		params, err := r.queryParams()
+		if err != nil {
+			return err
+		}
+		p, err := s.store.prepare(query)
+		if err != nil {
+			return err
+		}
+		return s.execute(p, params, w)
+
+	case opPrepare:
+		query := r.longString()
+		if r.err != nil {
+			return &cqlError{errProto, r.err.Error()}
+		}
+		p, err := s.store.prepare(query)
+		if err != nil {
+			return err
+		}
+		w.int(resultPrepared)
+		w.shortBytes(p.id)
+		writeMetadata(w, p.binds, nil)
+		if _, ok := p.stmt.(selection); ok {
+			writeMetadata(w, p.result, nil)
+		} else {
+			w.int(flagNoMetadata)
+			w.int(0)
+		}
+		return nil
+
+	case opExecute:
+		id := r.shortBytes()
+		params, err := r.queryParams()
+		if err != nil {
+			return err
+		}
+		p, err := s.store.preparedByID(id)
+		if err != nil {
+			return err
+		}
+		return s.execute(p, params, w)
+
+	case opBatch:
+		return s.batch(r, w)
+	}
+	return &cqlError{errProto, "unsupported opcode " + strconv.Itoa(int(f.opcode))}
+}
+
+func (s *Server) execute(p *prepared, params queryParams, w *buffer) error {
+	res, err := s.store.exec(p, execParams{
+		values:       params.values,
+		pageSize:     params.pageSize,
+		pagingState:  params.pagingState,
+		timestamp:    params.timestamp,
+		hasTimestamp: params.hasTimestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.cols == nil {
+		w.int(resultVoid)
+		return nil
+	}
+	w.int(resultRows)
+	writeMetadata(w, res.cols, res.pagingState)
+	w.int(int32(len(res.rows)))
+	for _, row := range res.rows {
+		for _, v := range row {
+			w.bytes(v)
+		}
+	}
+	return nil
+}
+
+// batch reads and applies a BATCH: its statements, each a query string or
+// prepared ID with its values, then its consistency, flags and timestamp.
+func (s *Server) batch(r *reader, w *buffer) error {
+	r.byte() // type
+	n := int(r.short())
+	stmts := make([]*prepared, n)
+	values := make([][][]byte, n)
+	for i := 0; i < n; i++ {
+		var err error
+		switch kind := r.byte(); kind {
+		case 0:
+			stmts[i], err = s.store.prepare(r.longString())
+		case 1:
+			stmts[i], err = s.store.preparedByID(r.shortBytes())
+		default:
+			err = &cqlError{errProto, "invalid batch statement kind " + strconv.Itoa(int(kind))}
+		}
+		if err != nil {
+			return err
+		}
+		nvalues := int(r.short())
+		for j := 0; j < nvalues; j++ {
+			values[i] = append(values[i], r.bytes())
+		}
+	}
+
+	var params execParams
+	r.short() // consistency
+	flags := r.byte()
+	if flags&flagSerialConsistency != 0 {
+		r.short()
+	}
+	if flags&flagDefaultTimestamp != 0 {
+		params.timestamp, params.hasTimestamp = r.long(), true
+	}
+	if r.err != nil {
+		return &cqlError{errProto, r.err.Error()}
+	}
+
+	if err := s.store.execBatch(stmts, values, params); err != nil {
+		return err
+	}
+	w.int(resultVoid)
+	return nil
+}
+
+// writeMetadata writes the bind or result metadata of the columns, all of
+// one table.
+func writeMetadata(w *buffer, cols []colSpec, pagingState []byte) {
+	var flags int32
+	if len(cols) > 0 {
+		flags |= flagGlobalTableSpec
+	}
+	if pagingState != nil {
+		flags |= flagHasMorePages
+	}
+	w.int(flags)
+	w.int(int32(len(cols)))
+	if pagingState != nil {
+		w.bytes(pagingState)
+	}
+	if len(cols) > 0 {
+		w.string(cols[0].keyspace)
+		w.string(cols[0].table)
+	}
+	for _, c := range cols {
+		w.string(c.name)
+		c.typ.writeOption(w)
+	}
+}
@@ -0,0 +1,198 @@
+package memcql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func connect(t *testing.T) *gocql.Session {
+	t.Helper()
+
+	srv, err := Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	cfg := gocql.NewCluster()
+	cfg.Timeout = 5 * time.Second
+	srv.Configure(cfg)
+	client, err := cfg.CreateSession()
+	if err != nil {
+		t.Fatalf("connecting: %s", err)
+	}
+	t.Cleanup(client.Close)
+
+	for _, ddl := range []string{
+		`CREATE KEYSPACE IF NOT EXISTS ks WITH replication = {'class': 'SimpleStrategy', 'replication_factor': 1}`,
+		`CREATE TABLE IF NOT EXISTS ks.events (
+		    // partition and clustering keys
+		    id varint,
+		    at timestamp,
+		    kind text,
+		    tags set<text>,
+		    PRIMARY KEY ((id), at)
+		) WITH CLUSTERING ORDER BY (at DESC)`,
+		`CREATE TABLE IF NOT EXISTS ks.counts (id varint, kind text, n counter, PRIMARY KEY ((id), kind))`,
+	} {
+		if err := client.Query(ddl).Exec(); err != nil {
+			t.Fatalf("creating schema: %s", err)
+		}
+	}
+	return client
+}
+
+func TestClusteringAndSets(t *testing.T) {
+	client := connect(t)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		tags := []string{"b", "a", "b"}
+		if i == 2 {
+			tags = []string{}
+		}
+		err := client.Query(`INSERT INTO ks.events (id, at, kind, tags) VALUES (?, ?, ?, ?)`, 1, base.Add(time.Duration(i)*time.Hour), "push", tags).Exec()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	iter := client.Query(`SELECT at, tags FROM ks.events WHERE id = ? AND at >= ? AND at < ?`, 1, base.Add(time.Hour), base.Add(4*time.Hour)).Iter()
+	var ats []time.Time
+	var at time.Time
+	var tags []string
+	for iter.Scan(&at, &tags) {
+		ats = append(ats, at)
+		if at.Equal(base.Add(2 * time.Hour)) {
+			if tags != nil {
+				t.Errorf("expected an empty set to read back as null, got %v", tags)
+			}
+		} else if !reflect.DeepEqual(tags, []string{"a", "b"}) {
+			t.Errorf("expected a sorted, deduplicated set, got %v", tags)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{base.Add(3 * time.Hour), base.Add(2 * time.Hour), base.Add(time.Hour)}
+	if !reflect.DeepEqual(ats, want) {
+		t.Errorf("expected the range in descending clustering order, got %v", ats)
+	}
+
+	if err := client.Query(`SELECT at FROM ks.events WHERE id = ? ORDER BY at ASC LIMIT 1`, 1).Scan(&at); err != nil || !at.Equal(base) {
+		t.Errorf("expected ORDER BY ASC to return the earliest row, got %v (%v)", at, err)
+	}
+
+	var count int64
+	if err := client.Query(`SELECT COUNT(*) FROM ks.events WHERE id = ?`, 1).Scan(&count); err != nil || count != 5 {
+		t.Errorf("expected 5 rows, got %d (%v)", count, err)
+	}
+}
+
+func TestTimestampsAndDeletes(t *testing.T) {
+	client := connect(t)
+
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	insert := `INSERT INTO ks.events (id, at, kind) VALUES (?, ?, ?) USING TIMESTAMP ?`
+	for _, w := range []struct {
+		kind string
+		ts   int64
+	}{{"newer", 20}, {"older", 10}} {
+		if err := client.Query(insert, 2, at, w.kind, w.ts).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var kind string
+	if err := client.Query(`SELECT kind FROM ks.events WHERE id = ? AND at = ?`, 2, at).Scan(&kind); err != nil || kind != "newer" {
+		t.Errorf("expected the write with the later timestamp to win, got %q (%v)", kind, err)
+	}
+
+	// a tombstone shadows writes at its timestamp
+	if err := client.Query(`DELETE FROM ks.events USING TIMESTAMP ? WHERE id = ? AND at = ?`, 30, 2, at).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Query(insert, 2, at, "deleted", 30).Exec(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Query(`SELECT kind FROM ks.events WHERE id = ? AND at = ?`, 2, at).Scan(&kind); err != gocql.ErrNotFound {
+		t.Errorf("expected the row to stay deleted, got %q (%v)", kind, err)
+	}
+}
+
+func TestCountersAndBatches(t *testing.T) {
+	client := connect(t)
+
+	batch := client.NewBatch(gocql.CounterBatch)
+	for _, kind := range []string{"a", "b", "a"} {
+		batch.Query(`UPDATE ks.counts SET n = n + 1 WHERE id = ? AND kind = ?`, 3, kind)
+	}
+	batch.Query(`UPDATE ks.counts SET n = n + ? WHERE id = ? AND kind = ?`, int64(-5), 3, "c")
+	if err := client.ExecuteBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]int64{}
+	iter := client.Query(`SELECT kind, n FROM ks.counts WHERE id = ?`, 3).Iter()
+	var kind string
+	var n int64
+	for iter.Scan(&kind, &n) {
+		got[kind] = n
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int64{"a": 2, "b": 1, "c": -5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected counts %v, got %v", want, got)
+	}
+
+	var sum int64
+	if err := client.Query(`SELECT SUM(n) FROM ks.counts WHERE id = ?`, 3).Scan(&sum); err != nil || sum != -2 {
+		t.Errorf("expected a sum of -2, got %d (%v)", sum, err)
+	}
+}
+
+func TestPaging(t *testing.T) {
+	client := connect(t)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 25; i++ {
+		if err := client.Query(`INSERT INTO ks.events (id, at) VALUES (?, ?)`, 4, base.Add(time.Duration(i)*time.Minute)).Exec(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a page at a time, as data.Page does
+	var pages, rows int
+	var state []byte
+	for {
+		iter := client.Query(`SELECT at FROM ks.events WHERE id = ?`, 4).PageSize(10).PageState(state).Iter()
+		rows += iter.NumRows()
+		state = iter.PageState()
+		if err := iter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		if len(state) == 0 {
+			break
+		}
+	}
+	if pages != 3 || rows != 25 {
+		t.Errorf("expected 25 rows in 3 pages, got %d in %d", rows, pages)
+	}
+
+	// and paged automatically
+	if n := client.Query(`SELECT at FROM ks.events WHERE id = ?`, 4).PageSize(10).Iter().NumRows(); n != 10 {
+		t.Errorf("expected a first page of 10 rows, got %d", n)
+	}
+	scanner := client.Query(`SELECT at FROM ks.events WHERE id = ?`, 4).PageSize(10).Iter().Scanner()
+	rows = 0
+	for scanner.Next() {
+		rows++
+	}
+	if err := scanner.Err(); err != nil || rows != 25 {
+		t.Errorf("expected 25 rows, got %d (%v)", rows, err)
+	}
+}
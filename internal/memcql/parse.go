@@ -0,0 +1,790 @@
+package memcql
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuoted
+	tokString
+	tokNumber
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// lex splits a statement into tokens, dropping comments. Identifiers keep
+// their case so keywords and names can be told apart from quoted names.
+func lex(stmt string) ([]token, error) {
+	var out []token
+	s := []rune(stmt)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '-' && i+1 < len(s) && s[i+1] == '-', c == '/' && i+1 < len(s) && s[i+1] == '/':
+			for i < len(s) && s[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(s) && s[i+1] == '*':
+			j := i + 2
+			for j+1 < len(s) && !(s[j] == '*' && s[j+1] == '/') {
+				j++
+			}
+			if j+1 >= len(s) {
+				return nil, syntax("unterminated comment in %q", stmt)
+			}
+			i = j + 2
+		case c == '\'' || c == '"':
+			var text strings.Builder
+			j := i + 1
+			for ; j < len(s); j++ {
+				if s[j] == c {
+					if j+1 < len(s) && s[j+1] == c {
+						text.WriteRune(c)
+						j++
+						continue
+					}
+					break
+				}
+				text.WriteRune(s[j])
+			}
+			if j == len(s) {
+				return nil, syntax("unterminated quote in %q", stmt)
+			}
+			kind := tokString
+			if c == '"' {
+				kind = tokQuoted
+			}
+			out = append(out, token{kind, text.String()})
+			i = j + 1
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(s[j]) || unicode.IsDigit(s[j]) || s[j] == '_') {
+				j++
+			}
+			out = append(out, token{tokIdent, string(s[i:j])})
+			i = j
+		case unicode.IsDigit(c) || c == '-' && i+1 < len(s) && unicode.IsDigit(s[i+1]):
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(s[j]) || s[j] == '.') {
+				j++
+			}
+			out = append(out, token{tokNumber, string(s[i:j])})
+			i = j
+		case (c == '<' || c == '>' || c == '!') && i+1 < len(s) && s[i+1] == '=':
+			out = append(out, token{tokSymbol, string(s[i : i+2])})
+			i += 2
+		case strings.ContainsRune("(),;.=<>*?+-{}:[]", c):
+			out = append(out, token{tokSymbol, string(c)})
+			i++
+		default:
+			return nil, syntax("unexpected character %q in %q", c, stmt)
+		}
+	}
+	return out, nil
+}
+
+// parser is a recursive descent parser of the statements memcql supports.
+type parser struct {
+	toks    []token
+	pos     int
+	markers int
+}
+
+func (p *parser) peek() token {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return token{}
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	if p.pos < len(p.toks) {
+		p.pos++
+	}
+	return t
+}
+
+// keyword reports whether the next token is one of words, consuming it if so.
+func (p *parser) keyword(words ...string) bool {
+	t := p.peek()
+	if t.kind != tokIdent {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.text, w) {
+			p.pos++
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(words ...string) error {
+	if !p.keyword(words...) {
+		return syntax("expected %s, found %q", strings.Join(words, " or "), p.peek().text)
+	}
+	return nil
+}
+
+// symbol reports whether the next token is sym, consuming it if so.
+func (p *parser) symbol(sym string) bool {
+	if t := p.peek(); t.kind == tokSymbol && t.text == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(sym string) error {
+	if !p.symbol(sym) {
+		return syntax("expected %q, found %q", sym, p.peek().text)
+	}
+	return nil
+}
+
+// ident reads a name, lowercasing it unless quoted.
+func (p *parser) ident() (string, error) {
+	switch t := p.next(); t.kind {
+	case tokIdent:
+		return strings.ToLower(t.text), nil
+	case tokQuoted:
+		return t.text, nil
+	default:
+		return "", syntax("expected a name, found %q", t.text)
+	}
+}
+
+// qualified reads a [keyspace.]name.
+func (p *parser) qualified() (keyspace, name string, err error) {
+	if name, err = p.ident(); err != nil {
+		return "", "", err
+	}
+	if p.symbol(".") {
+		keyspace = name
+		name, err = p.ident()
+	}
+	return keyspace, name, err
+}
+
+func (p *parser) ifNotExists() error {
+	if p.keyword("IF") {
+		if err := p.expectKeyword("NOT"); err != nil {
+			return err
+		}
+		return p.expectKeyword("EXISTS")
+	}
+	return nil
+}
+
+// end checks nothing but a trailing semicolon follows.
+func (p *parser) end() error {
+	p.symbol(";")
+	if p.pos < len(p.toks) {
+		return syntax("unexpected %q", p.peek().text)
+	}
+	return nil
+}
+
+// term is a bind marker, numbered from 0 in the order they appear, or a literal.
+type term struct {
+	marker int
+	lit    token
+}
+
+func (p *parser) term() (term, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokSymbol && t.text == "?":
+		p.markers++
+		return term{marker: p.markers - 1}, nil
+	case t.kind == tokString, t.kind == tokNumber,
+		t.kind == tokIdent && (strings.EqualFold(t.text, "true") || strings.EqualFold(t.text, "false") || strings.EqualFold(t.text, "null")):
+		return term{marker: -1, lit: t}, nil
+	}
+	return term{}, syntax("expected a value, found %q", t.text)
+}
+
+// typeSpec is a type as written, resolved against a keyspace's UDTs when
+// its table is created.
+type typeSpec struct {
+	name string
+	args []typeSpec
+}
+
+func (p *parser) typeSpec() (typeSpec, error) {
+	name, err := p.ident()
+	if err != nil {
+		return typeSpec{}, err
+	}
+	if p.symbol(".") {
+		// a keyspace-qualified UDT
+		if name, err = p.ident(); err != nil {
+			return typeSpec{}, err
+		}
+	}
+	out := typeSpec{name: name}
+	if p.symbol("<") {
+		for {
+			arg, err := p.typeSpec()
+			if err != nil {
+				return typeSpec{}, err
+			}
+			out.args = append(out.args, arg)
+			if p.symbol(">") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return typeSpec{}, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// skipOptions skips a table or keyspace's WITH options other than the
+// clustering order, up to the end of the statement.
+func (p *parser) skipOptions() {
+	for p.pos < len(p.toks) && !(p.peek().kind == tokSymbol && p.peek().text == ";") {
+		p.pos++
+	}
+}
+
+type createKeyspace struct {
+	name string
+}
+
+type createType struct {
+	keyspace, name string
+	fields         []string
+	types          []typeSpec
+}
+
+type columnDef struct {
+	name string
+	typ  typeSpec
+}
+
+type createTable struct {
+	keyspace, name string
+	columns        []columnDef
+	partitionKey   []string
+	clustering     []string
+	descending     map[string]bool
+}
+
+type insert struct {
+	keyspace, table string
+	columns         []string
+	values          []term
+	timestamp       *term
+}
+
+// assignment sets column to value or, when op is + or -, to the column plus
+// or minus value.
+type assignment struct {
+	column string
+	op     string
+	value  term
+}
+
+type update struct {
+	keyspace, table string
+	timestamp       *term
+	set             []assignment
+	where           []relation
+}
+
+type deletion struct {
+	keyspace, table string
+	timestamp       *term
+	where           []relation
+}
+
+type relation struct {
+	column string
+	op     string
+	value  term
+}
+
+type selection struct {
+	keyspace, table string
+	// columns is empty for SELECT *, COUNT(*) and SUM(column)
+	columns   []string
+	count     bool
+	sum       string
+	where     []relation
+	orderBy   string
+	orderDesc bool
+	limit     *term
+}
+
+// parse parses a statement, numbering its bind markers.
+func parse(stmt string) (interface{}, int, error) {
+	toks, err := lex(stmt)
+	if err != nil {
+		return nil, 0, err
+	}
+	p := &parser{toks: toks}
+
+	var out interface{}
+	switch {
+	case p.keyword("CREATE"):
+		out, err = p.create()
+	case p.keyword("INSERT"):
+		out, err = p.insert()
+	case p.keyword("UPDATE"):
+		out, err = p.update()
+	case p.keyword("DELETE"):
+		out, err = p.delete()
+	case p.keyword("SELECT"):
+		out, err = p.selection()
+	default:
+		err = syntax("unsupported statement %q", stmt)
+	}
+	if err == nil {
+		err = p.end()
+	}
+	return out, p.markers, err
+}
+
+func (p *parser) create() (interface{}, error) {
+	switch {
+	case p.keyword("KEYSPACE"):
+		if err := p.ifNotExists(); err != nil {
+			return nil, err
+		}
+		name, err := p.ident()
+		p.skipOptions()
+		return createKeyspace{name}, err
+
+	case p.keyword("TYPE"):
+		if err := p.ifNotExists(); err != nil {
+			return nil, err
+		}
+		var out createType
+		var err error
+		if out.keyspace, out.name, err = p.qualified(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			field, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			typ, err := p.typeSpec()
+			if err != nil {
+				return nil, err
+			}
+			out.fields, out.types = append(out.fields, field), append(out.types, typ)
+			if p.symbol(")") {
+				return out, nil
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+
+	case p.keyword("TABLE", "COLUMNFAMILY"):
+		if err := p.ifNotExists(); err != nil {
+			return nil, err
+		}
+		return p.createTable()
+	}
+	return nil, syntax("unsupported CREATE statement")
+}
+
+func (p *parser) createTable() (interface{}, error) {
+	out := createTable{descending: map[string]bool{}}
+	var err error
+	if out.keyspace, out.name, err = p.qualified(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.symbol(")") {
+		if p.keyword("PRIMARY") {
+			if err := p.expectKeyword("KEY"); err != nil {
+				return nil, err
+			}
+			if err := p.primaryKey(&out); err != nil {
+				return nil, err
+			}
+		} else {
+			var col columnDef
+			if col.name, err = p.ident(); err != nil {
+				return nil, err
+			}
+			if col.typ, err = p.typeSpec(); err != nil {
+				return nil, err
+			}
+			if p.keyword("PRIMARY") {
+				if err := p.expectKeyword("KEY"); err != nil {
+					return nil, err
+				}
+				out.partitionKey = []string{col.name}
+			}
+			out.columns = append(out.columns, col)
+		}
+		if !p.symbol(",") && !(p.peek().kind == tokSymbol && p.peek().text == ")") {
+			return nil, syntax("expected \",\" or \")\", found %q", p.peek().text)
+		}
+	}
+
+	if p.keyword("WITH") {
+		if p.keyword("CLUSTERING") {
+			if err := p.expectKeyword("ORDER"); err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("BY"); err != nil {
+				return nil, err
+			}
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			for {
+				col, err := p.ident()
+				if err != nil {
+					return nil, err
+				}
+				out.descending[col] = p.keyword("DESC")
+				if !out.descending[col] {
+					p.keyword("ASC")
+				}
+				if p.symbol(")") {
+					break
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+		p.skipOptions()
+	}
+	if len(out.partitionKey) == 0 {
+		return nil, invalid("table %s has no primary key", out.name)
+	}
+	return out, nil
+}
+
+// primaryKey reads a PRIMARY KEY's column list: a partition key column or
+// parenthesized partition key columns, then the clustering columns.
+func (p *parser) primaryKey(out *createTable) error {
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	if p.symbol("(") {
+		for {
+			col, err := p.ident()
+			if err != nil {
+				return err
+			}
+			out.partitionKey = append(out.partitionKey, col)
+			if p.symbol(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return err
+			}
+		}
+	} else {
+		col, err := p.ident()
+		if err != nil {
+			return err
+		}
+		out.partitionKey = []string{col}
+	}
+	for p.symbol(",") {
+		col, err := p.ident()
+		if err != nil {
+			return err
+		}
+		out.clustering = append(out.clustering, col)
+	}
+	return p.expect(")")
+}
+
+// using reads an optional USING TIMESTAMP clause.
+func (p *parser) using() (*term, error) {
+	if !p.keyword("USING") {
+		return nil, nil
+	}
+	if err := p.expectKeyword("TIMESTAMP"); err != nil {
+		return nil, err
+	}
+	t, err := p.term()
+	return &t, err
+}
+
+func (p *parser) insert() (interface{}, error) {
+	if err := p.expectKeyword("INTO"); err != nil {
+		return nil, err
+	}
+	var out insert
+	var err error
+	if out.keyspace, out.table, err = p.qualified(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		out.columns = append(out.columns, col)
+		if p.symbol(")") {
+			break
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		v, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		out.values = append(out.values, v)
+		if p.symbol(")") {
+			break
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+	if len(out.values) != len(out.columns) {
+		return nil, invalid("INSERT of %d columns has %d values", len(out.columns), len(out.values))
+	}
+	if p.keyword("IF") {
+		return nil, invalid("conditional updates are not supported")
+	}
+	out.timestamp, err = p.using()
+	return out, err
+}
+
+func (p *parser) update() (interface{}, error) {
+	var out update
+	var err error
+	if out.keyspace, out.table, err = p.qualified(); err != nil {
+		return nil, err
+	}
+	if out.timestamp, err = p.using(); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		var a assignment
+		if a.column, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		if t := p.peek(); t.kind == tokIdent && strings.EqualFold(t.text, a.column) {
+			p.next()
+			switch {
+			case p.symbol("+"):
+				a.op = "+"
+			case p.symbol("-"):
+				a.op = "-"
+			default:
+				return nil, syntax("expected + or - after %s", a.column)
+			}
+		}
+		if a.value, err = p.term(); err != nil {
+			return nil, err
+		}
+		out.set = append(out.set, a)
+		if !p.symbol(",") {
+			break
+		}
+	}
+	if out.where, err = p.where(); err != nil {
+		return nil, err
+	}
+	if p.keyword("IF") {
+		return nil, invalid("conditional updates are not supported")
+	}
+	return out, nil
+}
+
+func (p *parser) delete() (interface{}, error) {
+	if !p.keyword("FROM") {
+		return nil, invalid("only whole rows can be deleted")
+	}
+	var out deletion
+	var err error
+	if out.keyspace, out.table, err = p.qualified(); err != nil {
+		return nil, err
+	}
+	if out.timestamp, err = p.using(); err != nil {
+		return nil, err
+	}
+	if out.where, err = p.where(); err != nil {
+		return nil, err
+	}
+	if p.keyword("IF") {
+		return nil, invalid("conditional deletes are not supported")
+	}
+	return out, nil
+}
+
+// where reads a WHERE clause of relations joined by AND.
+func (p *parser) where() ([]relation, error) {
+	if err := p.expectKeyword("WHERE"); err != nil {
+		return nil, err
+	}
+	var out []relation
+	for {
+		var r relation
+		var err error
+		if r.column, err = p.ident(); err != nil {
+			return nil, err
+		}
+		op := p.next()
+		switch op.text {
+		case "=", "<", "<=", ">", ">=":
+			r.op = op.text
+		default:
+			return nil, syntax("unsupported relation %q on %s", op.text, r.column)
+		}
+		if r.value, err = p.term(); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+		if !p.keyword("AND") {
+			return out, nil
+		}
+	}
+}
+
+func (p *parser) selection() (interface{}, error) {
+	var out selection
+	var err error
+	switch {
+	case p.symbol("*"):
+	case p.keyword("COUNT"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if !p.symbol("*") {
+			if t := p.next(); t.text != "1" {
+				return nil, syntax("expected COUNT(*)")
+			}
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		out.count = true
+	case p.keyword("SUM"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if out.sum, err = p.ident(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+	default:
+		for {
+			col, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			out.columns = append(out.columns, col)
+			if !p.symbol(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	if out.keyspace, out.table, err = p.qualified(); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokIdent && strings.EqualFold(t.text, "WHERE") {
+		if out.where, err = p.where(); err != nil {
+			return nil, err
+		}
+	}
+	if p.keyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if out.orderBy, err = p.ident(); err != nil {
+			return nil, err
+		}
+		out.orderDesc = p.keyword("DESC")
+		if !out.orderDesc {
+			p.keyword("ASC")
+		}
+	}
+	if p.keyword("LIMIT") {
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		out.limit = &t
+	}
+	if p.keyword("ALLOW") {
+		if err := p.expectKeyword("FILTERING"); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// literal serializes a literal as a value of type t.
+func literal(t cqlType, lit token) ([]byte, error) {
+	switch {
+	case lit.kind == tokIdent && strings.EqualFold(lit.text, "null"):
+		return nil, nil
+	case lit.kind == tokIdent && t.ID == typeBoolean:
+		if strings.EqualFold(lit.text, "true") {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case lit.kind == tokString && (t.ID == typeVarchar || t.ID == typeASCII):
+		return []byte(lit.text), nil
+	case lit.kind == tokNumber:
+		n, err := strconv.ParseInt(lit.text, 10, 64)
+		if err != nil {
+			return nil, invalid("invalid integer %q", lit.text)
+		}
+		b, err := encodeInt(t, n)
+		if err != nil {
+			return nil, invalid("%s", err)
+		}
+		return b, nil
+	}
+	return nil, invalid("can't use %q as a %s value", lit.text, t)
+}
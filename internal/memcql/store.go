@@ -0,0 +1,970 @@
+package memcql
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	partitionKey = iota
+	clusteringKey
+	regular
+)
+
+// none is the timestamp of a row marker, tombstone or collection deletion
+// that was never written.
+const none = math.MinInt64
+
+type keyspace struct {
+	types  map[string]cqlType
+	tables map[string]*table
+}
+
+type column struct {
+	name string
+	typ  cqlType
+	kind int
+}
+
+type table struct {
+	keyspace, name string
+	// columns are in the order SELECT * returns them: the partition key, the
+	// clustering columns, then the rest by name
+	columns      []*column
+	byName       map[string]*column
+	partitionKey []*column
+	clustering   []*column
+	descending   []bool
+	partitions   map[string]*partition
+}
+
+type partition struct {
+	key  [][]byte
+	rows []*row
+}
+
+// row holds a row's cells with their write timestamps. Its marker is written
+// by INSERT, so a row inserted with only its primary key exists; deleted is
+// the timestamp of its latest tombstone, shadowing everything written at or
+// before it.
+type row struct {
+	clustering [][]byte
+	marker     int64
+	deleted    int64
+	cells      map[string]*cell
+}
+
+// cell is a column's value in a row: a single value with last write wins, a
+// counter, or the elements of a non-frozen collection added since its latest
+// overwrite (cleared).
+type cell struct {
+	ts      int64
+	value   []byte
+	live    bool
+	counter int64
+	cleared int64
+	elems   []element
+}
+
+type element struct {
+	ts    int64
+	value []byte
+}
+
+// colSpec is a column of bind or result metadata.
+type colSpec struct {
+	keyspace, table, name string
+	typ                   cqlType
+}
+
+// prepared is a parsed statement resolved against the schema.
+type prepared struct {
+	id     []byte
+	stmt   interface{}
+	table  *table
+	binds  []colSpec
+	result []colSpec
+}
+
+// result is a Void result when cols is nil.
+type result struct {
+	cols        []colSpec
+	rows        [][][]byte
+	pagingState []byte
+}
+
+// execParams are the parts of a QUERY, EXECUTE or BATCH applying to each
+// statement.
+type execParams struct {
+	values       [][]byte
+	pageSize     int
+	pagingState  []byte
+	timestamp    int64
+	hasTimestamp bool
+}
+
+// store is the schema and data of every keyspace, guarded by a single lock.
+type store struct {
+	mu        sync.Mutex
+	keyspaces map[string]*keyspace
+	prepared  map[string]*prepared
+	clock     int64
+}
+
+func newStore() *store {
+	return &store{keyspaces: map[string]*keyspace{}, prepared: map[string]*prepared{}}
+}
+
+// now returns a write timestamp for statements without one: microseconds
+// since the epoch, increasing with every call.
+func (s *store) now() int64 {
+	ts := time.Now().UnixMicro()
+	if ts <= s.clock {
+		ts = s.clock + 1
+	}
+	s.clock = ts
+	return ts
+}
+
+// prepare parses and resolves query, caching it by its ID.
+func (s *store) prepare(query string) (*prepared, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prepareLocked(query)
+}
+
+func (s *store) prepareLocked(query string) (*prepared, error) {
+	sum := md5.Sum([]byte(query))
+	if p, ok := s.prepared[string(sum[:])]; ok {
+		return p, nil
+	}
+
+	stmt, markers, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &prepared{id: sum[:], stmt: stmt, binds: make([]colSpec, markers)}
+	if err := s.resolve(p); err != nil {
+		return nil, err
+	}
+	// DDL isn't cached: it depends on the schema it changes
+	if p.table != nil {
+		s.prepared[string(p.id)] = p
+	}
+	return p, nil
+}
+
+// preparedByID returns a statement prepared by any connection.
+func (s *store) preparedByID(id []byte) (*prepared, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.prepared[string(id)]
+	if !ok {
+		return nil, invalid("prepared statement %x not found", id)
+	}
+	return p, nil
+}
+
+func (s *store) lookup(ks, name string) (*table, error) {
+	if ks == "" {
+		return nil, invalid("no keyspace has been specified for %s", name)
+	}
+	k, ok := s.keyspaces[ks]
+	if !ok {
+		return nil, invalid("keyspace %s does not exist", ks)
+	}
+	t, ok := k.tables[name]
+	if !ok {
+		return nil, invalid("unconfigured table %s", name)
+	}
+	return t, nil
+}
+
+func (t *table) column(name string) (*column, error) {
+	c, ok := t.byName[name]
+	if !ok {
+		return nil, invalid("undefined column name %s in table %s.%s", name, t.keyspace, t.name)
+	}
+	return c, nil
+}
+
+// resolve looks up the statement's table and columns, typing its bind markers
+// and, for a SELECT, its result columns.
+func (s *store) resolve(p *prepared) error {
+	var err error
+	bind := func(tm *term, name string, typ cqlType) {
+		if tm != nil && tm.marker >= 0 {
+			p.binds[tm.marker] = colSpec{p.table.keyspace, p.table.name, name, typ}
+		}
+	}
+	relations := func(rels []relation) error {
+		for i := range rels {
+			c, err := p.table.column(rels[i].column)
+			if err != nil {
+				return err
+			}
+			bind(&rels[i].value, c.name, c.typ)
+		}
+		return nil
+	}
+	timestamp := func(tm *term) {
+		bind(tm, "[timestamp]", cqlType{ID: typeBigint})
+	}
+
+	switch stmt := p.stmt.(type) {
+	case createKeyspace, createType, createTable:
+		return nil
+
+	case insert:
+		if p.table, err = s.lookup(stmt.keyspace, stmt.table); err != nil {
+			return err
+		}
+		for i, name := range stmt.columns {
+			c, err := p.table.column(name)
+			if err != nil {
+				return err
+			}
+			if c.typ.ID == typeCounter {
+				return invalid("INSERT statements are not allowed on counter tables, use UPDATE instead")
+			}
+			bind(&stmt.values[i], c.name, c.typ)
+		}
+		for _, c := range append(append([]*column{}, p.table.partitionKey...), p.table.clustering...) {
+			found := false
+			for _, name := range stmt.columns {
+				found = found || name == c.name
+			}
+			if !found {
+				return invalid("some primary key parts are missing: %s", c.name)
+			}
+		}
+		timestamp(stmt.timestamp)
+
+	case update:
+		if p.table, err = s.lookup(stmt.keyspace, stmt.table); err != nil {
+			return err
+		}
+		timestamp(stmt.timestamp)
+		for i, a := range stmt.set {
+			c, err := p.table.column(a.column)
+			if err != nil {
+				return err
+			}
+			if c.kind != regular {
+				return invalid("primary key part %s found in SET part", c.name)
+			}
+			switch {
+			case c.typ.ID == typeCounter && a.op == "":
+				return invalid("cannot set the value of counter column %s", c.name)
+			case a.op == "-" && c.typ.ID != typeCounter:
+				return invalid("removing elements from %s is not supported", c.name)
+			case a.op == "+" && c.typ.ID != typeCounter && !c.typ.multiCell():
+				return invalid("invalid operation for non-collection column %s", c.name)
+			}
+			bind(&stmt.set[i].value, c.name, c.typ)
+		}
+		if err := relations(stmt.where); err != nil {
+			return err
+		}
+		return p.table.checkKey(stmt.where)
+
+	case deletion:
+		if p.table, err = s.lookup(stmt.keyspace, stmt.table); err != nil {
+			return err
+		}
+		timestamp(stmt.timestamp)
+		if err := relations(stmt.where); err != nil {
+			return err
+		}
+		return p.table.checkKey(stmt.where)
+
+	case selection:
+		if p.table, err = s.lookup(stmt.keyspace, stmt.table); err != nil {
+			return err
+		}
+		t := p.table
+		spec := func(c *column) colSpec {
+			return colSpec{t.keyspace, t.name, c.name, c.typ}
+		}
+		switch {
+		case stmt.count:
+			p.result = []colSpec{{t.keyspace, t.name, "count", cqlType{ID: typeBigint}}}
+		case stmt.sum != "":
+			c, err := t.column(stmt.sum)
+			if err != nil {
+				return err
+			}
+			typ := c.typ
+			if typ.ID == typeCounter {
+				typ = cqlType{ID: typeBigint}
+			}
+			if _, err := encodeInt(typ, 0); err != nil {
+				return invalid("can't sum column %s of type %s", c.name, c.typ)
+			}
+			p.result = []colSpec{{t.keyspace, t.name, "system.sum(" + c.name + ")", typ}}
+		case len(stmt.columns) == 0:
+			for _, c := range t.columns {
+				p.result = append(p.result, spec(c))
+			}
+		default:
+			for _, name := range stmt.columns {
+				c, err := t.column(name)
+				if err != nil {
+					return err
+				}
+				p.result = append(p.result, spec(c))
+			}
+		}
+		if err := relations(stmt.where); err != nil {
+			return err
+		}
+		if stmt.orderBy != "" && (len(t.clustering) == 0 || t.clustering[0].name != stmt.orderBy) {
+			return invalid("order by is only supported on the first clustering column")
+		}
+		bind(stmt.limit, "[limit]", cqlType{ID: typeInt})
+	}
+	return nil
+}
+
+// checkKey checks the relations restrict every primary key column to a
+// single value, and nothing else.
+func (t *table) checkKey(rels []relation) error {
+	if len(rels) != len(t.partitionKey)+len(t.clustering) {
+		return invalid("UPDATE and DELETE statements must restrict every primary key column of %s.%s", t.keyspace, t.name)
+	}
+	for _, r := range rels {
+		if r.op != "=" || t.byName[r.column].kind == regular {
+			return invalid("UPDATE and DELETE statements must restrict only the primary key columns of %s.%s by equality", t.keyspace, t.name)
+		}
+	}
+	return nil
+}
+
+// run prepares and executes a statement with no parameters but values.
+func (s *store) run(query string, values ...[]byte) (result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, err := s.prepareLocked(query)
+	if err != nil {
+		return result{}, err
+	}
+	return s.execLocked(p, execParams{values: values})
+}
+
+func (s *store) exec(p *prepared, params execParams) (result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.execLocked(p, params)
+}
+
+// execBatch applies each statement, defaulting their timestamps to the
+// batch's. Unlike Cassandra it applies the statements preceding a failed one.
+func (s *store) execBatch(stmts []*prepared, values [][][]byte, params execParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !params.hasTimestamp {
+		params.timestamp, params.hasTimestamp = s.now(), true
+	}
+	for i, p := range stmts {
+		if _, ok := p.stmt.(selection); ok || p.table == nil {
+			return invalid("only INSERT, UPDATE and DELETE statements are allowed in a batch")
+		}
+		params.values = values[i]
+		if _, err := s.execLocked(p, params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *store) execLocked(p *prepared, params execParams) (result, error) {
+	if len(params.values) != len(p.binds) {
+		return result{}, invalid("there were %d markers(?) in CQL but %d bound variables", len(p.binds), len(params.values))
+	}
+
+	switch stmt := p.stmt.(type) {
+	case createKeyspace:
+		if _, ok := s.keyspaces[stmt.name]; !ok {
+			s.keyspaces[stmt.name] = &keyspace{types: map[string]cqlType{}, tables: map[string]*table{}}
+		}
+		return result{}, nil
+	case createType:
+		return result{}, s.createType(stmt)
+	case createTable:
+		return result{}, s.createTable(stmt)
+	case selection:
+		return p.table.selectRows(stmt, p, params)
+	}
+
+	ts, err := s.writeTime(p, params)
+	if err != nil {
+		return result{}, err
+	}
+	switch stmt := p.stmt.(type) {
+	case insert:
+		err = p.table.insert(stmt, params.values, ts)
+	case update:
+		err = p.table.update(stmt, params.values, ts)
+	case deletion:
+		var r *row
+		if r, err = p.table.keyedRow(stmt.where, params.values); err == nil && ts > r.deleted {
+			r.deleted = ts
+		}
+	}
+	return result{}, err
+}
+
+// writeTime returns the statement's USING TIMESTAMP, or else the timestamp of
+// the request, or else the server's clock.
+func (s *store) writeTime(p *prepared, params execParams) (int64, error) {
+	var using *term
+	switch stmt := p.stmt.(type) {
+	case insert:
+		using = stmt.timestamp
+	case update:
+		using = stmt.timestamp
+	case deletion:
+		using = stmt.timestamp
+	}
+	if using != nil {
+		b, err := value(*using, cqlType{ID: typeBigint}, params.values)
+		if err != nil {
+			return 0, err
+		}
+		if len(b) != 8 {
+			return 0, invalid("invalid timestamp of %d bytes", len(b))
+		}
+		return decodeInt(b), nil
+	}
+	if params.hasTimestamp {
+		return params.timestamp, nil
+	}
+	return s.now(), nil
+}
+
+// value returns a term's bound or literal value as a value of type typ.
+func value(t term, typ cqlType, values [][]byte) ([]byte, error) {
+	if t.marker >= 0 {
+		return values[t.marker], nil
+	}
+	return literal(typ, t.lit)
+}
+
+func (s *store) createType(stmt createType) error {
+	ks, ok := s.keyspaces[stmt.keyspace]
+	if !ok {
+		return invalid("keyspace %s does not exist", stmt.keyspace)
+	}
+	if _, ok := ks.types[stmt.name]; ok {
+		return nil
+	}
+	udt := cqlType{ID: typeUDT, Keyspace: stmt.keyspace, Name: stmt.name, Fields: stmt.fields, Frozen: true}
+	for _, spec := range stmt.types {
+		typ, err := ks.resolveType(spec)
+		if err != nil {
+			return err
+		}
+		// a UDT's fields are always frozen
+		udt.Elems = append(udt.Elems, frozen(typ))
+	}
+	ks.types[stmt.name] = udt
+	return nil
+}
+
+func frozen(t cqlType) cqlType {
+	t.Frozen = true
+	for i := range t.Elems {
+		t.Elems[i] = frozen(t.Elems[i])
+	}
+	return t
+}
+
+// resolveType resolves a type as written to a native, collection, tuple or
+// user-defined type. Collection elements and tuple fields are frozen.
+func (ks *keyspace) resolveType(spec typeSpec) (cqlType, error) {
+	if id, ok := nativeTypes[spec.name]; ok && len(spec.args) == 0 {
+		return cqlType{ID: id}, nil
+	}
+
+	var args []cqlType
+	for _, a := range spec.args {
+		typ, err := ks.resolveType(a)
+		if err != nil {
+			return cqlType{}, err
+		}
+		args = append(args, frozen(typ))
+	}
+	switch {
+	case spec.name == "frozen" && len(args) == 1:
+		return args[0], nil
+	case (spec.name == "list" || spec.name == "set") && len(args) == 1:
+		return cqlType{ID: map[string]uint16{"list": typeList, "set": typeSet}[spec.name], Elems: args}, nil
+	case spec.name == "map" && len(args) == 2:
+		return cqlType{ID: typeMap, Elems: args, Frozen: true}, nil
+	case spec.name == "tuple" && len(args) > 0:
+		return cqlType{ID: typeTuple, Elems: args, Frozen: true}, nil
+	}
+	if udt, ok := ks.types[spec.name]; ok && len(spec.args) == 0 {
+		return udt, nil
+	}
+	return cqlType{}, invalid("unknown type %s", spec.name)
+}
+
+func (s *store) createTable(stmt createTable) error {
+	ks, ok := s.keyspaces[stmt.keyspace]
+	if !ok {
+		return invalid("keyspace %s does not exist", stmt.keyspace)
+	}
+	if _, ok := ks.tables[stmt.name]; ok {
+		return nil
+	}
+
+	t := &table{keyspace: stmt.keyspace, name: stmt.name, byName: map[string]*column{}, partitions: map[string]*partition{}}
+	for _, def := range stmt.columns {
+		typ, err := ks.resolveType(def.typ)
+		if err != nil {
+			return err
+		}
+		t.byName[def.name] = &column{name: def.name, typ: typ, kind: regular}
+	}
+	for _, name := range stmt.partitionKey {
+		c, err := t.column(name)
+		if err != nil {
+			return err
+		}
+		c.kind = partitionKey
+		t.partitionKey = append(t.partitionKey, c)
+	}
+	for _, name := range stmt.clustering {
+		c, err := t.column(name)
+		if err != nil {
+			return err
+		}
+		c.kind = clusteringKey
+		t.clustering = append(t.clustering, c)
+		t.descending = append(t.descending, stmt.descending[name])
+	}
+
+	var rest []*column
+	for _, c := range t.byName {
+		if c.kind == regular {
+			rest = append(rest, c)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool { return rest[i].name < rest[j].name })
+	t.columns = append(append(append(t.columns, t.partitionKey...), t.clustering...), rest...)
+
+	ks.tables[stmt.name] = t
+	return nil
+}
+
+func partitionID(key [][]byte) string {
+	var b []byte
+	for _, k := range key {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(k)))
+		b = append(b, k...)
+	}
+	return string(b)
+}
+
+// compareClustering orders rows as the table clusters them.
+func (t *table) compareClustering(a, b [][]byte) int {
+	for i, c := range t.clustering {
+		if n := c.typ.compare(a[i], b[i]); n != 0 {
+			if t.descending[i] {
+				return -n
+			}
+			return n
+		}
+	}
+	return 0
+}
+
+// row returns the row with the primary key, adding it if there is none.
+func (t *table) row(pk, ck [][]byte) *row {
+	id := partitionID(pk)
+	p, ok := t.partitions[id]
+	if !ok {
+		p = &partition{key: pk}
+		t.partitions[id] = p
+	}
+	i := sort.Search(len(p.rows), func(i int) bool { return t.compareClustering(p.rows[i].clustering, ck) >= 0 })
+	if i < len(p.rows) && t.compareClustering(p.rows[i].clustering, ck) == 0 {
+		return p.rows[i]
+	}
+	r := &row{clustering: ck, marker: none, deleted: none, cells: map[string]*cell{}}
+	p.rows = append(p.rows, nil)
+	copy(p.rows[i+1:], p.rows[i:])
+	p.rows[i] = r
+	return r
+}
+
+// keyedRow returns the row of the primary key given by relations restricting
+// each of its columns by equality.
+func (t *table) keyedRow(rels []relation, values [][]byte) (*row, error) {
+	pk, ck := make([][]byte, len(t.partitionKey)), make([][]byte, len(t.clustering))
+	for _, r := range rels {
+		c := t.byName[r.column]
+		v, err := value(r.value, c.typ, values)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, invalid("invalid null value for primary key column %s", c.name)
+		}
+		t.setKey(pk, ck, c, v)
+	}
+	return t.row(pk, ck), nil
+}
+
+func (t *table) setKey(pk, ck [][]byte, c *column, v []byte) {
+	for i, k := range t.partitionKey {
+		if k == c {
+			pk[i] = v
+		}
+	}
+	for i, k := range t.clustering {
+		if k == c {
+			ck[i] = v
+		}
+	}
+}
+
+func (t *table) insert(stmt insert, values [][]byte, ts int64) error {
+	pk, ck := make([][]byte, len(t.partitionKey)), make([][]byte, len(t.clustering))
+	vals := make([][]byte, len(stmt.columns))
+	for i, name := range stmt.columns {
+		c := t.byName[name]
+		v, err := value(stmt.values[i], c.typ, values)
+		if err != nil {
+			return err
+		}
+		if c.kind != regular {
+			if v == nil {
+				return invalid("invalid null value for primary key column %s", c.name)
+			}
+			t.setKey(pk, ck, c, v)
+		}
+		vals[i] = v
+	}
+
+	r := t.row(pk, ck)
+	if ts > r.marker {
+		r.marker = ts
+	}
+	for i, name := range stmt.columns {
+		if c := t.byName[name]; c.kind == regular {
+			if err := r.cell(c.name).write(c.typ, "", vals[i], ts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *table) update(stmt update, values [][]byte, ts int64) error {
+	r, err := t.keyedRow(stmt.where, values)
+	if err != nil {
+		return err
+	}
+	for _, a := range stmt.set {
+		c := t.byName[a.column]
+		v, err := value(a.value, c.typ, values)
+		if err != nil {
+			return err
+		}
+		if err := r.cell(c.name).write(c.typ, a.op, v, ts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *row) cell(name string) *cell {
+	c, ok := r.cells[name]
+	if !ok {
+		c = &cell{ts: none, cleared: none}
+		r.cells[name] = c
+	}
+	return c
+}
+
+// write applies an assignment of v to the cell, or of the cell plus or minus
+// v if op is + or -.
+func (c *cell) write(typ cqlType, op string, v []byte, ts int64) error {
+	switch {
+	case typ.ID == typeCounter:
+		if v == nil {
+			return invalid("invalid null value for counter increment")
+		}
+		delta := decodeInt(v)
+		if op == "-" {
+			delta = -delta
+		}
+		c.counter += delta
+		c.live = true
+		if ts > c.ts {
+			c.ts = ts
+		}
+
+	case typ.multiCell():
+		// an overwrite deletes the elements written before it
+		if op == "" && ts-1 > c.cleared {
+			c.cleared = ts - 1
+			live := c.elems[:0]
+			for _, e := range c.elems {
+				if e.ts > c.cleared {
+					live = append(live, e)
+				}
+			}
+			c.elems = live
+		}
+		if v == nil {
+			return nil
+		}
+		elems, err := decodeCollection(v)
+		if err != nil {
+			return invalid("%s", err)
+		}
+		for _, e := range elems {
+			c.add(typ, e, ts)
+		}
+
+	default:
+		// last write wins; at the same timestamp a tombstone, then the
+		// greater value, wins
+		if ts > c.ts || ts == c.ts && (v == nil || c.live && bytes.Compare(v, c.value) > 0) {
+			c.ts, c.value, c.live = ts, v, v != nil
+		}
+	}
+	return nil
+}
+
+func (c *cell) add(typ cqlType, e []byte, ts int64) {
+	if typ.ID == typeSet {
+		for i := range c.elems {
+			if typ.Elems[0].compare(c.elems[i].value, e) == 0 {
+				if ts > c.elems[i].ts {
+					c.elems[i].ts = ts
+				}
+				return
+			}
+		}
+	}
+	c.elems = append(c.elems, element{ts, e})
+}
+
+// read returns the cell's value as of the row's latest tombstone.
+func (c *cell) read(typ cqlType, deleted int64) []byte {
+	switch {
+	case c == nil:
+		return nil
+	case typ.ID == typeCounter:
+		if !c.live || c.ts <= deleted {
+			return nil
+		}
+		return binary.BigEndian.AppendUint64(nil, uint64(c.counter))
+	case typ.multiCell():
+		var elems [][]byte
+		for _, e := range c.elems {
+			if e.ts > c.cleared && e.ts > deleted {
+				elems = append(elems, e.value)
+			}
+		}
+		if typ.ID == typeSet {
+			elems = sortSet(typ.Elems[0], elems)
+		}
+		return encodeCollection(elems)
+	}
+	if !c.live || c.ts <= deleted {
+		return nil
+	}
+	return c.value
+}
+
+// value returns the column's value in the row of partition p.
+func (t *table) value(p *partition, r *row, c *column) []byte {
+	switch c.kind {
+	case partitionKey:
+		for i, k := range t.partitionKey {
+			if k == c {
+				return p.key[i]
+			}
+		}
+	case clusteringKey:
+		for i, k := range t.clustering {
+			if k == c {
+				return r.clustering[i]
+			}
+		}
+	}
+	return r.cells[c.name].read(c.typ, r.deleted)
+}
+
+// live reports whether the row exists: it was inserted, or has a value in
+// any column, since its latest tombstone.
+func (t *table) live(r *row) bool {
+	if r.marker > r.deleted {
+		return true
+	}
+	for name, c := range r.cells {
+		if c.read(t.byName[name].typ, r.deleted) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// selectRows reads the rows matching every relation: from the partition they
+// restrict, or from every partition if they don't restrict the whole
+// partition key, in clustering order.
+func (t *table) selectRows(stmt selection, p *prepared, params execParams) (result, error) {
+	type restriction struct {
+		col *column
+		op  string
+		val []byte
+	}
+	var rs []restriction
+	pk := make([][]byte, len(t.partitionKey))
+	keyed := 0
+	for _, r := range stmt.where {
+		c := t.byName[r.column]
+		v, err := value(r.value, c.typ, params.values)
+		if err != nil {
+			return result{}, err
+		}
+		rs = append(rs, restriction{c, r.op, v})
+		if c.kind == partitionKey && r.op == "=" {
+			t.setKey(pk, nil, c, v)
+			keyed++
+		}
+	}
+
+	limit := -1
+	if stmt.limit != nil {
+		b, err := value(*stmt.limit, cqlType{ID: typeInt}, params.values)
+		if err != nil {
+			return result{}, err
+		}
+		if limit = int(decodeInt(b)); limit <= 0 {
+			return result{}, invalid("LIMIT must be strictly positive")
+		}
+	}
+
+	var partitions []*partition
+	if keyed == len(t.partitionKey) {
+		if p, ok := t.partitions[partitionID(pk)]; ok {
+			partitions = append(partitions, p)
+		}
+	} else {
+		ids := make([]string, 0, len(t.partitions))
+		for id := range t.partitions {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			partitions = append(partitions, t.partitions[id])
+		}
+	}
+	reverse := stmt.orderBy != "" && stmt.orderDesc != t.descending[0]
+
+	out := result{cols: p.result}
+	var count, sum int64
+	aggregate := stmt.count || stmt.sum != ""
+	for _, part := range partitions {
+		for i := range part.rows {
+			r := part.rows[i]
+			if reverse {
+				r = part.rows[len(part.rows)-1-i]
+			}
+			if !t.live(r) {
+				continue
+			}
+			matched := true
+			for _, cond := range rs {
+				v := t.value(part, r, cond.col)
+				if v == nil || cond.val == nil {
+					matched = false
+					break
+				}
+				n := cond.col.typ.compare(v, cond.val)
+				switch cond.op {
+				case "=":
+					matched = n == 0
+				case "<":
+					matched = n < 0
+				case "<=":
+					matched = n <= 0
+				case ">":
+					matched = n > 0
+				case ">=":
+					matched = n >= 0
+				}
+				if !matched {
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+
+			switch {
+			case stmt.count:
+				count++
+			case stmt.sum != "":
+				if v := t.value(part, r, t.byName[stmt.sum]); v != nil {
+					sum += decodeInt(v)
+				}
+			default:
+				if limit >= 0 && len(out.rows) == limit {
+					break
+				}
+				vals := make([][]byte, len(p.result))
+				for i, spec := range p.result {
+					vals[i] = t.value(part, r, t.byName[spec.name])
+				}
+				out.rows = append(out.rows, vals)
+			}
+		}
+	}
+
+	if aggregate {
+		n := count
+		if stmt.sum != "" {
+			n = sum
+		}
+		b, err := encodeInt(p.result[0].typ, n)
+		if err != nil {
+			return result{}, invalid("%s", err)
+		}
+		out.rows = [][][]byte{{b}}
+		return out, nil
+	}
+	return out.page(params.pageSize, params.pagingState)
+}
+
+// page returns the page of size rows starting at the offset encoded in state.
+func (res result) page(size int, state []byte) (result, error) {
+	offset := 0
+	if len(state) > 0 {
+		if len(state) != 8 || !strings.HasPrefix(string(state), "\x00\x00\x00\x00") {
+			return result{}, &cqlError{errProto, "invalid paging state"}
+		}
+		offset = int(binary.BigEndian.Uint64(state))
+	}
+	if offset > len(res.rows) {
+		offset = len(res.rows)
+	}
+	res.rows = res.rows[offset:]
+	if size > 0 && len(res.rows) > size {
+		res.rows = res.rows[:size]
+		res.pagingState = binary.BigEndian.AppendUint64(nil, uint64(offset+size))
+	}
+	return res, nil
+}
@@ -0,0 +1,259 @@
+package memcql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// native protocol type option IDs
+const (
+	typeASCII     = 0x01
+	typeBigint    = 0x02
+	typeBlob      = 0x03
+	typeBoolean   = 0x04
+	typeCounter   = 0x05
+	typeDouble    = 0x07
+	typeFloat     = 0x08
+	typeInt       = 0x09
+	typeTimestamp = 0x0B
+	typeUUID      = 0x0C
+	typeVarchar   = 0x0D
+	typeVarint    = 0x0E
+	typeTimeUUID  = 0x0F
+	typeList      = 0x20
+	typeMap       = 0x21
+	typeSet       = 0x22
+	typeUDT       = 0x30
+	typeTuple     = 0x31
+)
+
+var nativeTypes = map[string]uint16{
+	"ascii":     typeASCII,
+	"bigint":    typeBigint,
+	"blob":      typeBlob,
+	"boolean":   typeBoolean,
+	"counter":   typeCounter,
+	"double":    typeDouble,
+	"float":     typeFloat,
+	"int":       typeInt,
+	"timestamp": typeTimestamp,
+	"uuid":      typeUUID,
+	"text":      typeVarchar,
+	"varchar":   typeVarchar,
+	"varint":    typeVarint,
+	"timeuuid":  typeTimeUUID,
+}
+
+// cqlType is a column, field or element type. Elems holds the element type of
+// a list or set, the key and value types of a map, and the field types of a
+// tuple or UDT, whose field names are in Fields.
+type cqlType struct {
+	ID       uint16
+	Keyspace string
+	Name     string
+	Elems    []cqlType
+	Fields   []string
+	Frozen   bool
+}
+
+// multiCell is set for the collections stored a cell per element, rather
+// than as a single value: non-frozen lists and sets.
+func (t cqlType) multiCell() bool {
+	return !t.Frozen && (t.ID == typeList || t.ID == typeSet)
+}
+
+func (t cqlType) String() string {
+	switch t.ID {
+	case typeList, typeSet, typeMap, typeTuple:
+		var elems []string
+		for _, e := range t.Elems {
+			elems = append(elems, e.String())
+		}
+		name := map[uint16]string{typeList: "list", typeSet: "set", typeMap: "map", typeTuple: "tuple"}[t.ID]
+		return fmt.Sprintf("%s<%s>", name, strings.Join(elems, ", "))
+	case typeUDT:
+		return t.Keyspace + "." + t.Name
+	}
+	for name, id := range nativeTypes {
+		if id == t.ID && name != "varchar" {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%02x", t.ID)
+}
+
+// writeOption encodes the type as a protocol [option].
+func (t cqlType) writeOption(w *buffer) {
+	w.short(t.ID)
+	switch t.ID {
+	case typeList, typeSet, typeMap:
+		for _, e := range t.Elems {
+			e.writeOption(w)
+		}
+	case typeTuple:
+		w.short(uint16(len(t.Elems)))
+		for _, e := range t.Elems {
+			e.writeOption(w)
+		}
+	case typeUDT:
+		w.string(t.Keyspace)
+		w.string(t.Name)
+		w.short(uint16(len(t.Elems)))
+		for i, e := range t.Elems {
+			w.string(t.Fields[i])
+			e.writeOption(w)
+		}
+	}
+}
+
+// compare orders two serialized values of the type as Cassandra orders
+// clustering keys and set elements. Nulls sort first.
+func (t cqlType) compare(a, b []byte) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+
+	switch t.ID {
+	case typeInt, typeBigint, typeCounter, typeTimestamp:
+		x, y := decodeInt(a), decodeInt(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case typeVarint:
+		return decodeVarint(a).Cmp(decodeVarint(b))
+	case typeUUID, typeTimeUUID:
+		// time-based UUIDs order by time, others by their bytes
+		if len(a) == 16 && len(b) == 16 {
+			if va, vb := a[6]>>4, b[6]>>4; va != vb {
+				return int(va) - int(vb)
+			} else if va == 1 {
+				if ta, tb := uuidTime(a), uuidTime(b); ta != tb {
+					if ta < tb {
+						return -1
+					}
+					return 1
+				}
+			}
+		}
+	}
+	return bytes.Compare(a, b)
+}
+
+func uuidTime(u []byte) uint64 {
+	low := uint64(binary.BigEndian.Uint32(u[0:4]))
+	mid := uint64(binary.BigEndian.Uint16(u[4:6]))
+	high := uint64(binary.BigEndian.Uint16(u[6:8]) & 0x0fff)
+	return high<<48 | mid<<32 | low
+}
+
+// decodeInt decodes a big-endian two's complement integer of up to 8 bytes.
+func decodeInt(b []byte) int64 {
+	if len(b) == 0 {
+		return 0
+	}
+	var n int64
+	if b[0]&0x80 != 0 {
+		n = -1
+	}
+	for _, c := range b {
+		n = n<<8 | int64(c)
+	}
+	return n
+}
+
+func decodeVarint(b []byte) *big.Int {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return n
+}
+
+func encodeVarint(n *big.Int) []byte {
+	switch n.Sign() {
+	case 0:
+		return []byte{0}
+	case 1:
+		b := n.Bytes()
+		if b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return b
+	}
+	// two's complement of a negative number in the fewest bytes
+	size := len(n.Bytes()) + 1
+	b := new(big.Int).Add(n, new(big.Int).Lsh(big.NewInt(1), uint(size*8))).Bytes()
+	for len(b) < size {
+		b = append([]byte{0xff}, b...)
+	}
+	for len(b) > 1 && b[0] == 0xff && b[1]&0x80 != 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+// encodeInt serializes n as a value of the integer type t.
+func encodeInt(t cqlType, n int64) ([]byte, error) {
+	switch t.ID {
+	case typeInt:
+		return binary.BigEndian.AppendUint32(nil, uint32(n)), nil
+	case typeBigint, typeCounter, typeTimestamp:
+		return binary.BigEndian.AppendUint64(nil, uint64(n)), nil
+	case typeVarint:
+		return encodeVarint(big.NewInt(n)), nil
+	}
+	return nil, fmt.Errorf("can't use an integer as a %s value", t)
+}
+
+// decodeCollection splits a serialized list or set into its elements.
+func decodeCollection(b []byte) ([][]byte, error) {
+	r := &reader{b: b}
+	n := r.int()
+	var out [][]byte
+	for i := int32(0); i < n && r.err == nil; i++ {
+		out = append(out, r.bytes())
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("malformed collection value")
+	}
+	return out, nil
+}
+
+// encodeCollection serializes the elements of a list or set, or returns null
+// for none, as an empty non-frozen collection reads back.
+func encodeCollection(elems [][]byte) []byte {
+	if len(elems) == 0 {
+		return nil
+	}
+	w := &buffer{}
+	w.int(int32(len(elems)))
+	for _, e := range elems {
+		w.bytes(e)
+	}
+	return w.b
+}
+
+// sortSet sorts and deduplicates set elements of type elem.
+func sortSet(elem cqlType, elems [][]byte) [][]byte {
+	sort.Slice(elems, func(i, j int) bool { return elem.compare(elems[i], elems[j]) < 0 })
+	out := elems[:0]
+	for i, e := range elems {
+		if i == 0 || elem.compare(out[len(out)-1], e) != 0 {
+			out = append(out, e)
+		}
+	}
+	return out
+}
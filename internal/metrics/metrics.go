@@ -0,0 +1,172 @@
+// Package metrics instruments the loader, the queries it and the API server
+// run against Cassandra, and the API server's requests, as Prometheus metrics
+// served by Handler. Statement-level metrics are collected by gocql observers
+// attached with Observe, so every query of an observed session is counted.
+package metrics
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/elireisman/cass-dsapi/internal/cql"
+
+	"github.com/gocql/gocql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "dsapi"
+
+var (
+	// RowsWritten counts the rows inserted, updated or deleted, by table.
+	RowsWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_written_total",
+		Help:      "Rows inserted, updated or deleted, by table.",
+	}, []string{"table"})
+
+	// RowsRead counts the rows returned by queries, by table.
+	RowsRead = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_read_total",
+		Help:      "Rows returned by queries, by table.",
+	}, []string{"table"})
+
+	// Statements times each statement executed outside a batch, by operation and table.
+	Statements = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "statement_duration_seconds",
+		Help:      "Latency of statements executed outside batches, by operation and table.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, []string{"op", "table"})
+
+	// Batches times each batch executed, by table ("mixed" for batches
+	// spanning tables).
+	Batches = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_duration_seconds",
+		Help:      "Latency of batches executed, by table.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, []string{"table"})
+
+	// BatchSize is the number of statements per batch.
+	BatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "batch_size_statements",
+		Help:      "Statements per batch executed.",
+		Buckets:   []float64{1, 2, 5, 10, 25, 50, 100, 200, 500},
+	})
+
+	// Retries counts the attempts after the first at executing a statement or batch.
+	Retries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Retried attempts at executing statements and batches, by operation and table.",
+	}, []string{"op", "table"})
+
+	// Errors counts the failed attempts at executing a statement or batch.
+	Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_total",
+		Help:      "Failed attempts at executing statements and batches, by operation and table.",
+	}, []string{"op", "table"})
+
+	// WritesInFlight is the number of loader writes executing.
+	WritesInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "writes_in_flight",
+		Help:      "Loader statements and batches executing.",
+	})
+
+	// SnapshotsLoaded counts the snapshots fully loaded, by keyspace.
+	SnapshotsLoaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "snapshots_loaded_total",
+		Help:      "Snapshots fully loaded, by keyspace.",
+	}, []string{"keyspace"})
+
+	// ManifestsLoaded counts the manifests written with their dependencies, by keyspace.
+	ManifestsLoaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "manifests_loaded_total",
+		Help:      "Manifests written along with their dependencies, by keyspace.",
+	}, []string{"keyspace"})
+
+	// LoadDuration times each snapshot load, by keyspace.
+	LoadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "snapshot_load_duration_seconds",
+		Help:      "Time taken to load a snapshot, by keyspace.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 14),
+	}, []string{"keyspace"})
+
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "API requests, by route and status code.",
+	}, []string{"route", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of API requests, by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// InstrumentHandler counts and times the requests h serves as route.
+func InstrumentHandler(route string, h http.Handler) http.Handler {
+	labels := prometheus.Labels{"route": route}
+	return promhttp.InstrumentHandlerCounter(requests.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(requestDuration.MustCurryWith(labels), h))
+}
+
+// Observe attaches the statement and batch observers to cfg, alongside any
+// already attached; pass it to data.CreateClient.
+func Observe(cfg *gocql.ClusterConfig) {
+	cql.AddObservers(cfg, observer{}, observer{})
+}
+
+type observer struct{}
+
+func (observer) ObserveQuery(ctx context.Context, q gocql.ObservedQuery) {
+	stmt := cql.Parse(q.Statement)
+	Statements.WithLabelValues(stmt.Op, stmt.Table).Observe(q.End.Sub(q.Start).Seconds())
+	if q.Attempt > 0 {
+		Retries.WithLabelValues(stmt.Op, stmt.Table).Inc()
+	}
+	if q.Err != nil {
+		Errors.WithLabelValues(stmt.Op, stmt.Table).Inc()
+		return
+	}
+
+	switch stmt.Op {
+	case "select":
+		RowsRead.WithLabelValues(stmt.Table).Add(float64(q.Rows))
+	case "insert", "update", "delete":
+		RowsWritten.WithLabelValues(stmt.Table).Inc()
+	}
+}
+
+func (observer) ObserveBatch(ctx context.Context, b gocql.ObservedBatch) {
+	table := cql.BatchTable(b.Statements)
+	Batches.WithLabelValues(table).Observe(b.End.Sub(b.Start).Seconds())
+	if b.Attempt > 0 {
+		Retries.WithLabelValues("batch", table).Inc()
+	}
+	if b.Err != nil {
+		Errors.WithLabelValues("batch", table).Inc()
+		return
+	}
+
+	BatchSize.Observe(float64(len(b.Statements)))
+	for _, s := range b.Statements {
+		RowsWritten.WithLabelValues(cql.Parse(s).Table).Inc()
+	}
+}
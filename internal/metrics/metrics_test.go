@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserver(t *testing.T) {
+	start := time.Now()
+	rows := testutil.ToFloat64(RowsWritten.WithLabelValues("manifest_dependencies"))
+	errs := testutil.ToFloat64(Errors.WithLabelValues("batch", "manifest_dependencies"))
+	retries := testutil.ToFloat64(Retries.WithLabelValues("batch", "manifest_dependencies"))
+
+	stmts := []string{
+		"INSERT INTO ks.manifest_dependencies (manifest_id) VALUES(?)",
+		"INSERT INTO ks.manifest_dependencies (manifest_id) VALUES(?)",
+	}
+	observer{}.ObserveBatch(context.Background(), gocql.ObservedBatch{Statements: stmts, Start: start, End: start, Err: errors.New("timeout")})
+	observer{}.ObserveBatch(context.Background(), gocql.ObservedBatch{Statements: stmts, Start: start, End: start, Attempt: 1})
+
+	if got := testutil.ToFloat64(RowsWritten.WithLabelValues("manifest_dependencies")) - rows; got != 2 {
+		t.Errorf("expected only the successful batch's 2 rows to be counted, got %v", got)
+	}
+	if got := testutil.ToFloat64(Errors.WithLabelValues("batch", "manifest_dependencies")) - errs; got != 1 {
+		t.Errorf("expected 1 failed batch, got %v", got)
+	}
+	if got := testutil.ToFloat64(Retries.WithLabelValues("batch", "manifest_dependencies")) - retries; got != 1 {
+		t.Errorf("expected 1 retried batch, got %v", got)
+	}
+
+	read := testutil.ToFloat64(RowsRead.WithLabelValues("snapshots"))
+	observer{}.ObserveQuery(context.Background(), gocql.ObservedQuery{Statement: "SELECT id FROM ks.snapshots", Start: start, End: start, Rows: 3})
+	if got := testutil.ToFloat64(RowsRead.WithLabelValues("snapshots")) - read; got != 3 {
+		t.Errorf("expected 3 rows read, got %v", got)
+	}
+}
@@ -0,0 +1,59 @@
+package paging
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	codec := NewCodec([]byte("secret"))
+	state := []byte{4, 0, 0, 0, 1, 0, 240, 127, 255, 255, 253, 0}
+
+	token := codec.Encode("manifests?repository_id=1", state)
+	if token == "" {
+		t.Fatal("expected a token for a non-empty page state")
+	}
+
+	decoded, err := codec.Decode("manifests?repository_id=1", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, state) {
+		t.Errorf("expected %v, got %v", state, decoded)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	codec := NewCodec([]byte("secret"))
+
+	if token := codec.Encode("scope", nil); token != "" {
+		t.Errorf("expected no token for an empty page state, got %q", token)
+	}
+	if state, err := codec.Decode("scope", ""); err != nil || state != nil {
+		t.Errorf("expected an empty token to decode to the first page, got %v, %v", state, err)
+	}
+}
+
+func TestTampering(t *testing.T) {
+	codec := NewCodec([]byte("secret"))
+	token := codec.Encode("scope", []byte("page-state"))
+
+	if _, err := codec.Decode("other-scope", token); err == nil {
+		t.Error("expected token to be rejected for a different scope")
+	}
+	if _, err := NewCodec([]byte("other-secret")).Decode("scope", token); err == nil {
+		t.Error("expected token to be rejected under a different key")
+	}
+
+	tampered := []byte(token)
+	tampered[3] ^= 1
+	if _, err := codec.Decode("scope", string(tampered)); err == nil {
+		t.Error("expected tampered token to be rejected")
+	}
+
+	for _, bad := range []string{"!!!", "AQ", token[:10]} {
+		if _, err := codec.Decode("scope", bad); err == nil {
+			t.Errorf("expected malformed token %q to be rejected", bad)
+		}
+	}
+}
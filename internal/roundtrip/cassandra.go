@@ -0,0 +1,71 @@
+package roundtrip
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+
+	"github.com/gocql/gocql"
+)
+
+// variantFidelity is the fidelity of the schema variants that don't store
+// dependencies as manifest_dependencies rows; the rest have DefaultFidelity.
+var variantFidelity = map[string]Fidelity{
+	// a list of frozen UDTs keeps the written order, but each UDT's PURLs are sets
+	"frozen-udt": {PURLSets: true},
+	// depblob encodes the dependencies exactly as written
+	"blob": {},
+}
+
+// DefaultFidelity is the fidelity of the baseline manifest_dependencies table.
+var DefaultFidelity = Fidelity{ClusteredDependencies: true, PURLSets: true}
+
+// VariantFidelity returns the fidelity of the named schema variant.
+func VariantFidelity(name string) Fidelity {
+	if f, ok := variantFidelity[name]; ok {
+		return f
+	}
+	return DefaultFidelity
+}
+
+// Cassandra is a Backend loading snapshots with a schema variant's write path
+// and reading them back with its queries.
+type Cassandra struct {
+	Lgr      *slog.Logger
+	Client   *gocql.Session
+	Keyspace string
+	Variant  data.Variant
+}
+
+func (c Cassandra) Name() string {
+	return "cassandra/" + c.Variant.Name
+}
+
+func (c Cassandra) Fidelity() Fidelity {
+	return VariantFidelity(c.Variant.Name)
+}
+
+func (c Cassandra) Load(ctx context.Context, sm data.Snapshot) error {
+	return c.Variant.Load(ctx, c.Lgr, c.Client, sm, c.Keyspace)
+}
+
+// Read finds the snapshot among those of its ref created in the same
+// millisecond, then reads its manifests and dependencies.
+func (c Cassandra) Read(ctx context.Context, sm data.Snapshot) (data.Snapshot, error) {
+	q := c.Variant.Queries
+	from := Timestamp(sm.CreatedAt)
+	snapshots, err := q.SnapshotsInRange(ctx, c.Lgr, c.Client, c.Keyspace, sm.RepositoryID, sm.Ref, from, from.Add(time.Millisecond))
+	if err != nil {
+		return data.Snapshot{}, err
+	}
+
+	for _, found := range snapshots {
+		if found.ID == sm.ID {
+			return q.SnapshotTree(ctx, c.Lgr, c.Client, c.Keyspace, found)
+		}
+	}
+	return data.Snapshot{}, fmt.Errorf("snapshot %s not found among %d snapshots of %d %s", sm.ID, len(snapshots), sm.RepositoryID, sm.Ref)
+}
@@ -0,0 +1,59 @@
+package roundtrip
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/memcql"
+)
+
+// Memory is a Backend round-tripping snapshots as Cassandra does, with a
+// schema variant's write path and queries over a gocql session, but against
+// an in-process memcql server rather than a cluster. The statements, batches,
+// bind values and row scanners are those run against Cassandra; only the
+// storage is modelled, by memcql.
+type Memory struct {
+	Cassandra
+	server *memcql.Server
+}
+
+// NewMemory starts a memcql server, connects to it and creates the variant's
+// tables. Close the backend when done with it.
+func NewMemory(ctx context.Context, lgr *slog.Logger, variant data.Variant) (*Memory, error) {
+	server, err := memcql.Start()
+	if err != nil {
+		return nil, fmt.Errorf("starting memcql: %s", err)
+	}
+
+	client, err := data.CreateClient(ctx, lgr, server.Configure)
+	if err != nil {
+		server.Close()
+		return nil, fmt.Errorf("connecting to memcql: %s", err)
+	}
+
+	m := &Memory{
+		Cassandra: Cassandra{Lgr: lgr, Client: client, Keyspace: variant.Keyspace(data.Keyspace + "_roundtrip"), Variant: variant},
+		server:    server,
+	}
+	if err := data.CreateKeyspace(ctx, lgr, client, m.Keyspace); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("creating keyspace: %s", err)
+	}
+	if err := variant.CreateTables(ctx, lgr, client, m.Keyspace); err != nil {
+		m.Close()
+		return nil, fmt.Errorf("creating tables: %s", err)
+	}
+	return m, nil
+}
+
+func (m *Memory) Name() string {
+	return "memory/" + m.Variant.Name
+}
+
+// Close disconnects from and stops the memcql server, discarding its data.
+func (m *Memory) Close() {
+	m.Client.Close()
+	m.server.Close()
+}
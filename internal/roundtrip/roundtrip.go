@@ -0,0 +1,210 @@
+// Package roundtrip checks that generated snapshots survive being written and
+// read back: each snapshot is loaded into a Backend, read back with its keys
+// and compared with the snapshot as the storage layout is expected to return
+// it (see Expected).
+package roundtrip
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+
+	"github.com/gocql/gocql"
+)
+
+// Backend stores snapshots and reads them back.
+type Backend interface {
+	// Name identifies the backend in mismatch reports.
+	Name() string
+	// Fidelity describes how the backend transforms the dependencies written to it.
+	Fidelity() Fidelity
+	// Load writes the snapshot, its manifests and their dependencies.
+	Load(ctx context.Context, sm data.Snapshot) error
+	// Read returns the snapshot with sm's ID, repository and ref, along with
+	// its manifests and their dependencies.
+	Read(ctx context.Context, sm data.Snapshot) (data.Snapshot, error)
+}
+
+// Fidelity describes how a storage layout transforms the dependencies of a
+// manifest. Every layout returns timestamps at millisecond precision in UTC and
+// a snapshot's manifests ordered by package manager and file path, as they
+// are clustered in the manifests table.
+type Fidelity struct {
+	// ClusteredDependencies is set when dependencies are stored as rows and
+	// read back in primary key order (namespace, name, version) rather than
+	// the order they were generated in.
+	ClusteredDependencies bool
+	// PURLSets is set when a dependency's Runtime and Development PURLs are
+	// stored as set<text>: deduplicated, sorted, and empty sets read back as nil.
+	PURLSets bool
+}
+
+// Mismatch is a difference between a generated snapshot and the snapshot read back.
+type Mismatch struct {
+	Backend    string
+	SnapshotID gocql.UUID
+	Diffs      []string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s: snapshot %s: %d differences, first: %s", m.Backend, m.SnapshotID, len(m.Diffs), m.Diffs[0])
+}
+
+// maxDiffs caps the differences reported for a single snapshot.
+const maxDiffs = 20
+
+// Run loads each snapshot into the backend, reads it back and compares it
+// with the snapshot Expected to be read back, returning a Mismatch for each
+// snapshot that differs.
+func Run(ctx context.Context, lgr *slog.Logger, backend Backend, snapshots []data.Snapshot) ([]Mismatch, error) {
+	for _, sm := range snapshots {
+		if err := backend.Load(ctx, sm); err != nil {
+			return nil, fmt.Errorf("loading snapshot %s into %s: %s", sm.ID, backend.Name(), err)
+		}
+	}
+
+	var out []Mismatch
+	for _, sm := range snapshots {
+		got, err := backend.Read(ctx, sm)
+		if err != nil {
+			return nil, fmt.Errorf("reading snapshot %s from %s: %s", sm.ID, backend.Name(), err)
+		}
+		if diffs := Diff(Expected(sm, backend.Fidelity()), got); len(diffs) > 0 {
+			out = append(out, Mismatch{Backend: backend.Name(), SnapshotID: sm.ID, Diffs: diffs})
+		}
+	}
+
+	lgr.Info("Round-tripped snapshots", "backend", backend.Name(), "snapshots", len(snapshots), "mismatched", len(out))
+	return out, nil
+}
+
+// Expected returns a copy of sm as a storage layout with the given fidelity is
+// expected to return it.
+func Expected(sm data.Snapshot, fidelity Fidelity) data.Snapshot {
+	out := sm
+	out.CreatedAt = Timestamp(sm.CreatedAt)
+
+	out.Manifests = make([]data.Manifest, len(sm.Manifests))
+	for i, mm := range sm.Manifests {
+		mm.Runtime = expectedDependencies(mm.Runtime, fidelity)
+		mm.Development = expectedDependencies(mm.Development, fidelity)
+		mm.Transitives = expectedDependencies(mm.Transitives, fidelity)
+		out.Manifests[i] = mm
+	}
+	sort.SliceStable(out.Manifests, func(i, j int) bool {
+		a, b := out.Manifests[i], out.Manifests[j]
+		if a.PackageManager != b.PackageManager {
+			return a.PackageManager < b.PackageManager
+		}
+		return a.FilePath < b.FilePath
+	})
+	if len(out.Manifests) == 0 {
+		out.Manifests = nil
+	}
+
+	return out
+}
+
+func expectedDependencies(deps []data.Dependency, fidelity Fidelity) []data.Dependency {
+	if len(deps) == 0 {
+		return nil
+	}
+
+	out := make([]data.Dependency, len(deps))
+	for i, dep := range deps {
+		if fidelity.PURLSets {
+			dep.Runtime = PURLSet(dep.Runtime)
+			dep.Development = PURLSet(dep.Development)
+		}
+		out[i] = dep
+	}
+	if fidelity.ClusteredDependencies {
+		sort.SliceStable(out, func(i, j int) bool {
+			a, b := out[i], out[j]
+			if a.Namespace != b.Namespace {
+				return a.Namespace < b.Namespace
+			}
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.Version < b.Version
+		})
+	}
+
+	return out
+}
+
+// Timestamp returns t as a timestamp column stores it: truncated to the
+// millisecond, in UTC.
+func Timestamp(t time.Time) time.Time {
+	return t.Truncate(time.Millisecond).UTC()
+}
+
+// PURLSet returns purls as a set<text> column stores them: deduplicated and
+// sorted, with an empty set read back as nil.
+func PURLSet(purls []string) []string {
+	if len(purls) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]bool, len(purls))
+	var out []string
+	for _, purl := range purls {
+		if !seen[purl] {
+			seen[purl] = true
+			out = append(out, purl)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Diff returns a description of each difference between want and got, by
+// field path, up to maxDiffs.
+func Diff(want, got data.Snapshot) []string {
+	var out []string
+	diff("snapshot", reflect.ValueOf(want), reflect.ValueOf(got), &out)
+	return out
+}
+
+func diff(path string, want, got reflect.Value, out *[]string) {
+	if len(*out) >= maxDiffs {
+		return
+	}
+
+	switch want.Kind() {
+	case reflect.Struct:
+		if want.Type() == reflect.TypeOf(time.Time{}) {
+			if w, g := want.Interface().(time.Time), got.Interface().(time.Time); !w.Equal(g) || w.Location().String() != g.Location().String() {
+				*out = append(*out, fmt.Sprintf("%s: want %s, got %s", path, w, g))
+			}
+			return
+		}
+		for i := 0; i < want.NumField(); i++ {
+			diff(path+"."+want.Type().Field(i).Name, want.Field(i), got.Field(i), out)
+		}
+		return
+	case reflect.Slice:
+		if want.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		if want.IsNil() != got.IsNil() || want.Len() != got.Len() {
+			*out = append(*out, fmt.Sprintf("%s: want %d entries (nil: %t), got %d (nil: %t)",
+				path, want.Len(), want.IsNil(), got.Len(), got.IsNil()))
+			return
+		}
+		for i := 0; i < want.Len(); i++ {
+			diff(fmt.Sprintf("%s[%d]", path, i), want.Index(i), got.Index(i), out)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(want.Interface(), got.Interface()) {
+		*out = append(*out, fmt.Sprintf("%s: want %v, got %v", path, want.Interface(), got.Interface()))
+	}
+}
@@ -0,0 +1,233 @@
+package roundtrip
+
+import (
+	"context"
+	"flag"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/elireisman/cass-dsapi/internal/data"
+	"github.com/elireisman/cass-dsapi/internal/logging"
+)
+
+var (
+	cassandra = flag.Bool("cassandra", false, "also round-trip snapshots through the local Cassandra cluster")
+	variants  = flag.String("variants", strings.Join(variantNames(), ","), "comma-separated schema variants to round-trip through Cassandra")
+)
+
+func variantNames() []string {
+	var names []string
+	for _, v := range data.Variants() {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+func generate(t *testing.T, n int) []data.Snapshot {
+	t.Helper()
+
+	quiet := logging.Discard()
+	var out []data.Snapshot
+	for i := 0; i < n; i++ {
+		sm, err := data.GenerateSnapshot(context.Background(), quiet, nil, 5, 40)
+		if err != nil {
+			t.Fatalf("generating snapshot: %s", err)
+		}
+		out = append(out, sm)
+	}
+	return out
+}
+
+func checkRun(t *testing.T, backend Backend, snapshots []data.Snapshot) {
+	t.Helper()
+
+	mismatches, err := Run(context.Background(), logging.Discard(), backend, snapshots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mismatches {
+		t.Errorf("%s", m)
+		for _, d := range m.Diffs {
+			t.Logf("\t%s", d)
+		}
+	}
+}
+
+// stored returns sm as the tables of every schema variant store it, so that
+// it reads back unchanged: its timestamp at millisecond precision, manifests
+// in clustering order, and dependencies in primary key order with their PURLs
+// as sets.
+func stored(sm data.Snapshot) data.Snapshot {
+	sm.CreatedAt = Timestamp(sm.CreatedAt)
+	sm.Manifests = append([]data.Manifest(nil), sm.Manifests...)
+	sort.Slice(sm.Manifests, func(i, j int) bool {
+		a, b := sm.Manifests[i], sm.Manifests[j]
+		if a.PackageManager != b.PackageManager {
+			return a.PackageManager < b.PackageManager
+		}
+		return a.FilePath < b.FilePath
+	})
+	for i := range sm.Manifests {
+		mm := &sm.Manifests[i]
+		mm.Runtime, mm.Development, mm.Transitives = storedDependencies(mm.Runtime), storedDependencies(mm.Development), storedDependencies(mm.Transitives)
+	}
+	return sm
+}
+
+func storedDependencies(deps []data.Dependency) []data.Dependency {
+	if len(deps) == 0 {
+		return nil
+	}
+
+	out := append([]data.Dependency(nil), deps...)
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	for i := range out {
+		out[i].Runtime, out[i].Development = storedPURLs(out[i].Runtime), storedPURLs(out[i].Development)
+	}
+	return out
+}
+
+func storedPURLs(purls []string) []string {
+	var out []string
+	for _, purl := range purls {
+		if i := sort.SearchStrings(out, purl); i == len(out) || out[i] != purl {
+			out = append(out[:i], append([]string{purl}, out[i:]...)...)
+		}
+	}
+	return out
+}
+
+// TestMemoryRoundTrip loads snapshots with each schema variant's write path
+// and reads them back with its queries, over a gocql session to an in-process
+// memcql server. The snapshots are generated as the tables store them, so each
+// must read back exactly as it was generated.
+func TestMemoryRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	quiet := logging.Discard()
+
+	var snapshots []data.Snapshot
+	for _, sm := range generate(t, 5) {
+		snapshots = append(snapshots, stored(sm))
+	}
+
+	for _, variant := range data.Variants() {
+		t.Run(variant.Name, func(t *testing.T) {
+			backend, err := NewMemory(ctx, quiet, variant)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer backend.Close()
+
+			for _, sm := range snapshots {
+				if err := backend.Load(ctx, sm); err != nil {
+					t.Fatalf("loading snapshot %s: %s", sm.ID, err)
+				}
+			}
+			for _, sm := range snapshots {
+				got, err := backend.Read(ctx, sm)
+				if err != nil {
+					t.Fatalf("reading snapshot %s: %s", sm.ID, err)
+				}
+				if diffs := Diff(sm, got); len(diffs) > 0 {
+					t.Errorf("snapshot %s: %d differences", sm.ID, len(diffs))
+					for _, d := range diffs {
+						t.Logf("\t%s", d)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	sm := generate(t, 1)[0]
+	want := Expected(sm, DefaultFidelity)
+	if diffs := Diff(want, Expected(sm, DefaultFidelity)); len(diffs) > 0 {
+		t.Fatalf("expected no differences, got %v", diffs)
+	}
+
+	// a lost sub-millisecond timestamp is expected, a lost second isn't
+	got := Expected(sm, DefaultFidelity)
+	got.CreatedAt = got.CreatedAt.Add(-1e9)
+	if diffs := Diff(want, got); len(diffs) != 1 || !strings.HasPrefix(diffs[0], "snapshot.CreatedAt") {
+		t.Errorf("expected a CreatedAt difference, got %v", diffs)
+	}
+
+	// dependencies are compared by position, so a reordered or dropped one is reported
+	for i, mm := range want.Manifests {
+		if len(mm.Transitives) < 2 {
+			continue
+		}
+		got := Expected(sm, DefaultFidelity)
+		got.Manifests[i].Transitives = got.Manifests[i].Transitives[1:]
+		if diffs := Diff(want, got); len(diffs) == 0 {
+			t.Errorf("expected a dropped transitive dependency to be reported")
+		}
+
+		got = Expected(sm, DefaultFidelity)
+		ts := got.Manifests[i].Transitives
+		ts[0], ts[1] = ts[1], ts[0]
+		if diffs := Diff(want, got); len(diffs) == 0 {
+			t.Errorf("expected reordered transitive dependencies to be reported")
+		}
+		break
+	}
+}
+
+func TestPURLSet(t *testing.T) {
+	if PURLSet([]string{}) != nil {
+		t.Errorf("expected an empty set to read back as nil")
+	}
+	got := PURLSet([]string{"npm:b/b@1", "npm:a/a@1", "npm:b/b@1"})
+	if strings.Join(got, ",") != "npm:a/a@1,npm:b/b@1" {
+		t.Errorf("expected a sorted, deduplicated set, got %v", got)
+	}
+}
+
+// TestCassandraRoundTrip loads snapshots into each schema variant's keyspace
+// under eli_demo_roundtrip, e.g.
+//
+//	go test ./internal/roundtrip -args -cassandra -variants baseline,blob
+func TestCassandraRoundTrip(t *testing.T) {
+	if !*cassandra {
+		t.Skip("pass -cassandra to round-trip through the local cluster")
+	}
+
+	ctx := context.Background()
+	quiet := logging.Discard()
+	client, err := data.CreateClient(ctx, quiet)
+	if err != nil {
+		t.Fatalf("connecting to the cluster: %s", err)
+	}
+	defer client.Close()
+
+	snapshots := generate(t, 5)
+	for _, name := range strings.Split(*variants, ",") {
+		variant, err := data.LookupVariant(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Run(variant.Name, func(t *testing.T) {
+			keyspace := variant.Keyspace(data.Keyspace + "_roundtrip")
+			if err := data.CreateKeyspace(ctx, quiet, client, keyspace); err != nil {
+				t.Fatalf("creating keyspace: %s", err)
+			}
+			if err := variant.CreateTables(ctx, quiet, client, keyspace); err != nil {
+				t.Fatalf("creating tables: %s", err)
+			}
+
+			checkRun(t, Cassandra{Lgr: quiet, Client: client, Keyspace: keyspace, Variant: variant}, snapshots)
+		})
+	}
+}
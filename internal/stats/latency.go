@@ -0,0 +1,192 @@
+package stats
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultMaxSamples caps the latency samples a Recorder retains for percentile
+// estimation; beyond it samples are kept by reservoir sampling.
+const DefaultMaxSamples = 100000
+
+// number of power of 2 microsecond histogram buckets, the last of which
+// collects everything from ~4.5 days up
+const numBuckets = 40
+
+// Recorder accumulates operation latencies and error counts. It is safe for
+// concurrent use.
+type Recorder struct {
+	mu sync.Mutex
+
+	count  int64
+	errors int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+
+	// buckets[i] counts latencies in [2^(i-1), 2^i) microseconds
+	buckets [numBuckets]int64
+
+	// uniform reservoir sample of all recorded latencies
+	samples    []time.Duration
+	maxSamples int
+	rnd        *rand.Rand
+}
+
+// NewRecorder creates a Recorder retaining up to maxSamples latencies, chosen
+// using a random source seeded with seed.
+func NewRecorder(maxSamples int, seed int64) *Recorder {
+	return &Recorder{
+		maxSamples: maxSamples,
+		rnd:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Record adds the latency of a successful operation.
+func (r *Recorder) Record(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.count == 0 || d < r.min {
+		r.min = d
+	}
+	if d > r.max {
+		r.max = d
+	}
+	r.count++
+	r.sum += d
+	r.buckets[bucketOf(d)]++
+
+	if len(r.samples) < r.maxSamples {
+		r.samples = append(r.samples, d)
+	} else if i := r.rnd.Int63n(r.count); i < int64(r.maxSamples) {
+		r.samples[i] = d
+	}
+}
+
+// Error counts a failed operation. Failed operations are not part of the
+// latency distribution.
+func (r *Recorder) Error() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors++
+}
+
+// Bucket is a histogram bucket counting latencies below UpperMicros (and at or
+// above the previous bucket's bound).
+type Bucket struct {
+	UpperMicros int64 `json:"le_us"`
+	Count       int64 `json:"count"`
+}
+
+// Summary describes a latency distribution, with latencies in microseconds.
+type Summary struct {
+	Count     int64    `json:"count"`
+	Errors    int64    `json:"errors"`
+	Min       float64  `json:"min_us"`
+	Mean      float64  `json:"mean_us"`
+	P50       float64  `json:"p50_us"`
+	P90       float64  `json:"p90_us"`
+	P99       float64  `json:"p99_us"`
+	P999      float64  `json:"p999_us"`
+	Max       float64  `json:"max_us"`
+	Histogram []Bucket `json:"histogram"`
+}
+
+// Summary computes the distribution of the latencies recorded so far.
+func (r *Recorder) Summary() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := Summary{
+		Count:  r.count,
+		Errors: r.errors,
+	}
+	if r.count == 0 {
+		return out
+	}
+
+	sorted := append([]time.Duration{}, r.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	out.Min = micros(r.min)
+	out.Max = micros(r.max)
+	out.Mean = micros(r.sum) / float64(r.count)
+	out.P50 = micros(Percentile(sorted, 0.50))
+	out.P90 = micros(Percentile(sorted, 0.90))
+	out.P99 = micros(Percentile(sorted, 0.99))
+	out.P999 = micros(Percentile(sorted, 0.999))
+
+	last := 0
+	for i, n := range r.buckets {
+		if n > 0 {
+			last = i
+		}
+	}
+	for i := 0; i <= last; i++ {
+		out.Histogram = append(out.Histogram, Bucket{UpperMicros: 1 << i, Count: r.buckets[i]})
+	}
+
+	return out
+}
+
+// Samples returns the retained latency samples in microseconds, sorted
+// ascending. When more than max were retained (and max > 0) a random subset
+// of max of them is returned, drawn with the recorder's seeded source, so that
+// the result remains a uniform random sample of every recorded latency that
+// rank tests such as MannWhitneyU can be run on.
+func (r *Recorder) Samples(max int) []float64 {
+	r.mu.Lock()
+	sample := append([]time.Duration{}, r.samples...)
+	if max > 0 && len(sample) > max {
+		// a partial Fisher-Yates shuffle moves the subset to the front
+		for i := 0; i < max; i++ {
+			j := i + r.rnd.Intn(len(sample)-i)
+			sample[i], sample[j] = sample[j], sample[i]
+		}
+		sample = sample[:max]
+	}
+	r.mu.Unlock()
+	sort.Slice(sample, func(i, j int) bool { return sample[i] < sample[j] })
+
+	out := make([]float64, len(sample))
+	for i, d := range sample {
+		out[i] = micros(d)
+	}
+	return out
+}
+
+// Percentile returns the nearest-rank percentile p (0 < p <= 1) of sorted.
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func bucketOf(d time.Duration) int {
+	us := d.Microseconds()
+	if us <= 0 {
+		return 0
+	}
+	b := bits.Len64(uint64(us))
+	if b >= numBuckets {
+		b = numBuckets - 1
+	}
+	return b
+}
+
+func micros(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecorderSummary(t *testing.T) {
+	rec := NewRecorder(DefaultMaxSamples, 1)
+	for i := 1; i <= 1000; i++ {
+		rec.Record(time.Duration(i) * time.Microsecond)
+	}
+	rec.Error()
+	rec.Error()
+
+	s := rec.Summary()
+	if s.Count != 1000 || s.Errors != 2 {
+		t.Errorf("expected 1000 ops and 2 errors, got %d and %d", s.Count, s.Errors)
+	}
+	if s.Min != 1 || s.Max != 1000 || s.Mean != 500.5 {
+		t.Errorf("unexpected min/max/mean: %v/%v/%v", s.Min, s.Max, s.Mean)
+	}
+	if s.P50 != 500 || s.P90 != 900 || s.P99 != 990 || s.P999 != 999 {
+		t.Errorf("unexpected percentiles: p50=%v p90=%v p99=%v p999=%v", s.P50, s.P90, s.P99, s.P999)
+	}
+
+	var total int64
+	for i, b := range s.Histogram {
+		total += b.Count
+		if i > 0 && b.UpperMicros != 2*s.Histogram[i-1].UpperMicros {
+			t.Errorf("expected power of 2 bucket bounds, got %+v", s.Histogram)
+		}
+	}
+	if total != 1000 {
+		t.Errorf("expected histogram to count 1000 ops, got %d", total)
+	}
+	if last := s.Histogram[len(s.Histogram)-1]; last.UpperMicros != 1024 {
+		t.Errorf("expected last bucket bound of 1024us, got %d", last.UpperMicros)
+	}
+}
+
+func TestRecorderReservoir(t *testing.T) {
+	rec := NewRecorder(100, 1)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2500; i++ {
+				rec.Record(time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(rec.samples) != 100 {
+		t.Errorf("expected reservoir of 100 samples, got %d", len(rec.samples))
+	}
+	if s := rec.Summary(); s.Count != 10000 || s.P99 != 1000 {
+		t.Errorf("unexpected summary %+v", s)
+	}
+}
+
+func TestEmptySummary(t *testing.T) {
+	if s := NewRecorder(10, 1).Summary(); s.Count != 0 || s.P50 != 0 || len(s.Histogram) != 0 {
+		t.Errorf("expected empty summary, got %+v", s)
+	}
+}
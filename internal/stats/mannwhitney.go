@@ -0,0 +1,92 @@
+package stats
+
+import (
+	"math"
+	"sort"
+)
+
+// MannWhitney is the outcome of a two-sided Mann-Whitney U test.
+type MannWhitney struct {
+	U float64
+	Z float64
+	P float64
+}
+
+// MannWhitneyU tests whether samples a and b come from the same distribution,
+// without assuming either is normally distributed, which latencies rarely are.
+// The p-value uses the normal approximation with a tie correction, which is
+// accurate for the sample sizes benchmarks produce (more than ~20 per side).
+// It returns a p-value of 1 when either side is empty.
+func MannWhitneyU(a, b []float64) MannWhitney {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return MannWhitney{P: 1}
+	}
+
+	type sample struct {
+		value float64
+		fromA bool
+	}
+	all := make([]sample, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, sample{v, true})
+	}
+	for _, v := range b {
+		all = append(all, sample{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+
+	// tied values share the mean of the ranks they span
+	var rankSumA, tieTerm float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankSumA += rank
+			}
+		}
+		t := float64(j - i)
+		tieTerm += t*t*t - t
+		i = j
+	}
+
+	u := rankSumA - n1*(n1+1)/2
+	n := n1 + n2
+	mean := n1 * n2 / 2
+	variance := n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1)))
+	if variance <= 0 {
+		// every value is identical
+		return MannWhitney{U: u, P: 1}
+	}
+
+	// continuity correction towards the mean
+	diff := u - mean
+	switch {
+	case diff > 0.5:
+		diff -= 0.5
+	case diff < -0.5:
+		diff += 0.5
+	default:
+		diff = 0
+	}
+	z := diff / math.Sqrt(variance)
+
+	return MannWhitney{U: u, Z: z, P: math.Erfc(math.Abs(z) / math.Sqrt2)}
+}
+
+// Median returns the median of the sorted values.
+func Median(sorted []float64) float64 {
+	n := len(sorted)
+	switch {
+	case n == 0:
+		return 0
+	case n%2 == 1:
+		return sorted[n/2]
+	default:
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+}
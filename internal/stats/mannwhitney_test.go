@@ -0,0 +1,68 @@
+package stats
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMannWhitneyU(t *testing.T) {
+	a := []float64{1, 2, 3, 4, 5}
+	b := []float64{6, 7, 8, 9, 10}
+	if res := MannWhitneyU(a, b); res.U != 0 || res.P > 0.02 {
+		t.Errorf("expected fully separated samples to differ, got %+v", res)
+	}
+	if res := MannWhitneyU(b, a); res.U != 25 || res.Z <= 0 {
+		t.Errorf("expected U=25 and positive z for the larger sample, got %+v", res)
+	}
+
+	same := []float64{3, 3, 3, 3}
+	if res := MannWhitneyU(same, same); res.P != 1 {
+		t.Errorf("expected identical samples to have p=1, got %+v", res)
+	}
+	if res := MannWhitneyU(nil, a); res.P != 1 {
+		t.Errorf("expected an empty sample to have p=1, got %+v", res)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	var base, similar, slower []float64
+	for i := 0; i < 2000; i++ {
+		base = append(base, r.ExpFloat64()*1000)
+		similar = append(similar, r.ExpFloat64()*1000)
+		slower = append(slower, r.ExpFloat64()*1200)
+	}
+	if res := MannWhitneyU(base, similar); res.P < 0.01 {
+		t.Errorf("expected samples from the same distribution not to differ, got %+v", res)
+	}
+	if res := MannWhitneyU(base, slower); res.P > 0.01 || res.Z >= 0 {
+		t.Errorf("expected a 20%% slower distribution to differ, got %+v", res)
+	}
+}
+
+func TestRecorderSamples(t *testing.T) {
+	rec := NewRecorder(DefaultMaxSamples, 1)
+	for i := 1000; i >= 1; i-- {
+		rec.Record(time.Duration(i) * time.Microsecond)
+	}
+
+	all := rec.Samples(0)
+	if len(all) != 1000 || all[0] != 1 || all[999] != 1000 {
+		t.Errorf("expected 1000 sorted samples, got %d from %v to %v", len(all), all[0], all[len(all)-1])
+	}
+	subset := rec.Samples(100)
+	if len(subset) != 100 || !sort.Float64sAreSorted(subset) || math.Abs(Median(subset)-Median(all)) > 100 {
+		t.Errorf("expected 100 sorted samples with a similar median, got %d with median %v", len(subset), Median(subset))
+	}
+
+	// a random subset, unlike evenly spaced order statistics, has gaps of
+	// varying width between consecutive samples
+	gaps := map[float64]bool{}
+	for i := 1; i < len(subset); i++ {
+		gaps[subset[i]-subset[i-1]] = true
+	}
+	if len(gaps) < 5 {
+		t.Errorf("expected a random subset of the samples, got %v", subset)
+	}
+}
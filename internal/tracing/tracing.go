@@ -0,0 +1,155 @@
+// Package tracing exports OpenTelemetry spans for snapshot generation and
+// loading, API requests, and every statement and batch executed against
+// Cassandra. Until Setup installs an exporter, spans are started against the
+// no-op global tracer provider and cost next to nothing.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/elireisman/cass-dsapi/internal/cql"
+
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/elireisman/cass-dsapi"
+
+// Exporters are the supported span exporters.
+var Exporters = []string{"otlp", "stdout"}
+
+// Setup installs a global tracer provider exporting spans with the named
+// exporter: "otlp" sends them over OTLP/HTTP to endpoint (host:port, or the
+// OTEL_EXPORTER_OTLP_ENDPOINT default if empty) and "stdout" prints them as
+// JSON to stderr, alongside the logs and clear of any results on stdout. The
+// returned function flushes any buffered spans and must be called before
+// exiting.
+func Setup(ctx context.Context, service, exporter, endpoint string) (func(context.Context) error, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+		}
+		exp, err = otlptracehttp.New(ctx, opts...)
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected one of: %s", exporter, strings.Join(Exporters, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s exporter: %s", exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(service)))
+	if err != nil {
+		return nil, fmt.Errorf("describing service: %s", err)
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named name as a child of any span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, on the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Handler starts a server span named after the request's method and route
+// for each request h serves.
+func Handler(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, span := otel.Tracer(instrumentation).Start(req.Context(), req.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPRequestMethodKey.String(req.Method), semconv.HTTPRoute(route)))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, req.WithContext(ctx))
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Observe attaches observers to cfg recording a client span for each attempt
+// at executing a statement or batch, as a child of the span in the context it
+// was executed with, alongside any observers already attached; pass it to
+// data.CreateClient.
+func Observe(cfg *gocql.ClusterConfig) {
+	cql.AddObservers(cfg, observer{}, observer{})
+}
+
+type observer struct{}
+
+func (observer) ObserveQuery(ctx context.Context, q gocql.ObservedQuery) {
+	stmt := cql.Parse(q.Statement)
+	record(ctx, stmt.Op+" "+stmt.Table, q.Start, q.End, q.Err,
+		semconv.DBSystemCassandra,
+		semconv.DBName(q.Keyspace),
+		semconv.DBOperation(stmt.Op),
+		semconv.DBCassandraTable(stmt.Table),
+		semconv.DBStatement(q.Statement),
+		attribute.Int("db.cassandra.rows", q.Rows),
+		attribute.Int("db.cassandra.attempt", q.Attempt))
+}
+
+func (observer) ObserveBatch(ctx context.Context, b gocql.ObservedBatch) {
+	table := cql.BatchTable(b.Statements)
+	record(ctx, "batch "+table, b.Start, b.End, b.Err,
+		semconv.DBSystemCassandra,
+		semconv.DBName(b.Keyspace),
+		semconv.DBOperation("batch"),
+		semconv.DBCassandraTable(table),
+		attribute.Int("db.cassandra.batch.statements", len(b.Statements)),
+		attribute.Int("db.cassandra.attempt", b.Attempt))
+}
+
+// record records a span that has already happened, from start to end.
+func record(ctx context.Context, name string, start, end time.Time, err error, attrs ...attribute.KeyValue) {
+	_, span := otel.Tracer(instrumentation).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attrs...))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestObserver(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+
+	ctx, parent := Start(context.Background(), "WriteManifest")
+	start := time.Now().Add(-time.Second)
+	end := start.Add(10 * time.Millisecond)
+	observer{}.ObserveBatch(ctx, gocql.ObservedBatch{
+		Keyspace:   "ks",
+		Statements: []string{"INSERT INTO ks.manifest_dependencies (manifest_id) VALUES(?)"},
+		Start:      start,
+		End:        end,
+		Err:        errors.New("timeout"),
+	})
+	End(parent, nil)
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("expected the batch and manifest spans, got %d", len(ended))
+	}
+	batch := ended[0]
+	if batch.Name() != "batch manifest_dependencies" {
+		t.Errorf("unexpected batch span name %q", batch.Name())
+	}
+	if batch.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("expected the batch span to be a child of the manifest span")
+	}
+	if !batch.StartTime().Equal(start) || !batch.EndTime().Equal(end) {
+		t.Errorf("expected the batch span to cover the observed execution, got %s to %s", batch.StartTime(), batch.EndTime())
+	}
+	if batch.Status().Code != codes.Error {
+		t.Errorf("expected the failed batch to be marked as an error, got %v", batch.Status())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+
+	h := Handler("/owners/inventory", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/owners/inventory?owner_id=1", nil))
+
+	ended := spans.Ended()
+	if len(ended) != 1 || ended[0].Name() != "GET /owners/inventory" {
+		t.Fatalf("expected a span for the request, got %v", ended)
+	}
+	if ended[0].Status().Code != codes.Error {
+		t.Errorf("expected a 503 to be marked as an error, got %v", ended[0].Status())
+	}
+}
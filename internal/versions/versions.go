@@ -0,0 +1,416 @@
+package versions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Key encodes a package version into a string whose lexical (byte-wise) order
+// matches the ecosystem's version precedence, so it can be used as a Cassandra
+// clustering column in place of the raw version text.
+//
+// Layout: each numeric release segment is written as a single length digit
+// followed by the digits themselves, so "10" sorts above "9". Trailing zero
+// segments are dropped ("1.0" == "1.0.0"). The release is followed by a marker:
+//
+//	'-'  pre-release (followed by encoded identifiers and a '!' terminator)
+//	'.'  final release
+//	'/'  post-release (followed by encoded identifiers and a '!' terminator)
+//
+// All three markers sort below '0', so "1.0.0" < "1.0.0.1", and a
+// pre-release of a version sorts below the version itself.
+func Key(pkgMgr, version string) string {
+	v := parse(pkgMgr, version)
+
+	var sb strings.Builder
+	for _, seg := range v.release {
+		writeNumber(&sb, seg)
+	}
+
+	switch {
+	case len(v.pre) > 0:
+		sb.WriteByte('-')
+		writeIdentifiers(&sb, v.pre)
+	case len(v.post) > 0:
+		sb.WriteByte('/')
+		writeIdentifiers(&sb, v.post)
+	default:
+		sb.WriteByte('.')
+	}
+
+	return sb.String()
+}
+
+// Compare returns -1, 0 or 1 depending on whether version a has lower, equal or
+// higher precedence than version b within the given ecosystem.
+func Compare(pkgMgr, a, b string) int {
+	return strings.Compare(Key(pkgMgr, a), Key(pkgMgr, b))
+}
+
+// Bound is one end of a Range, expressed as an encoded version Key.
+type Bound struct {
+	Key       string
+	Inclusive bool
+}
+
+// Range is the intersection of a set of version comparators such as
+// ">=1.2.0 <2.0.0". A nil Lower or Upper bound means the range is open
+// on that side.
+type Range struct {
+	Lower *Bound
+	Upper *Bound
+}
+
+// ParseRange parses a version range expression made of comparators separated
+// by whitespace or commas (">=1.2.0 <2.0.0", ">=1.2, <2"). Supported operators
+// are >=, >, <=, <, = and ==; a bare version is treated as an exact match.
+func ParseRange(pkgMgr, expr string) (Range, error) {
+	fields := strings.FieldsFunc(expr, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(fields) == 0 {
+		return Range{}, fmt.Errorf("empty version range")
+	}
+
+	var rng Range
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		op := strings.TrimRightFunc(field, func(r rune) bool {
+			return r != '<' && r != '>' && r != '='
+		})
+		// tolerate a space between the operator and the version: ">= 1.2.0"
+		if op == field {
+			if i+1 >= len(fields) {
+				return Range{}, fmt.Errorf("comparator %q is missing a version", field)
+			}
+			i++
+			field += fields[i]
+		}
+		ver := strings.TrimPrefix(field, op)
+		if ver == "" {
+			return Range{}, fmt.Errorf("comparator %q is missing a version", field)
+		}
+		key := Key(pkgMgr, ver)
+
+		switch op {
+		case ">=":
+			rng.Lower = tighterLower(rng.Lower, &Bound{Key: key, Inclusive: true})
+		case ">":
+			rng.Lower = tighterLower(rng.Lower, &Bound{Key: key, Inclusive: false})
+		case "<=":
+			rng.Upper = tighterUpper(rng.Upper, &Bound{Key: key, Inclusive: true})
+		case "<":
+			rng.Upper = tighterUpper(rng.Upper, &Bound{Key: key, Inclusive: false})
+		case "", "=", "==":
+			rng.Lower = tighterLower(rng.Lower, &Bound{Key: key, Inclusive: true})
+			rng.Upper = tighterUpper(rng.Upper, &Bound{Key: key, Inclusive: true})
+		default:
+			return Range{}, fmt.Errorf("unsupported comparator %q in %q", op, expr)
+		}
+	}
+
+	return rng, nil
+}
+
+// Contains reports whether the encoded version key falls within the range.
+func (r Range) Contains(key string) bool {
+	if r.Lower != nil {
+		if c := strings.Compare(key, r.Lower.Key); c < 0 || (c == 0 && !r.Lower.Inclusive) {
+			return false
+		}
+	}
+	if r.Upper != nil {
+		if c := strings.Compare(key, r.Upper.Key); c > 0 || (c == 0 && !r.Upper.Inclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+func tighterLower(cur, next *Bound) *Bound {
+	if cur == nil {
+		return next
+	}
+	if c := strings.Compare(next.Key, cur.Key); c > 0 || (c == 0 && !next.Inclusive) {
+		return next
+	}
+	return cur
+}
+
+func tighterUpper(cur, next *Bound) *Bound {
+	if cur == nil {
+		return next
+	}
+	if c := strings.Compare(next.Key, cur.Key); c < 0 || (c == 0 && !next.Inclusive) {
+		return next
+	}
+	return cur
+}
+
+// identifier is a single dot-separated pre- or post-release component.
+// Numeric identifiers always have lower precedence than alphanumeric ones.
+type identifier struct {
+	numeric bool
+	num     string
+	str     string
+}
+
+type parsed struct {
+	release []string
+	pre     []identifier
+	post    []identifier
+}
+
+func parse(pkgMgr, version string) parsed {
+	version = strings.TrimSpace(version)
+	version = strings.TrimPrefix(strings.TrimPrefix(version, "v"), "V")
+
+	switch pkgMgr {
+	case "pip":
+		return parseQualified(strings.ToLower(version), pipQualifiers, true)
+	case "maven":
+		return parseQualified(strings.ToLower(version), mavenQualifiers, false)
+	case "gem":
+		return parseGem(version)
+	default:
+		// npm, cargo and pub all follow SemVer 2.0
+		return parseSemver(version)
+	}
+}
+
+// parseSemver handles MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD]
+func parseSemver(version string) parsed {
+	if idx := strings.IndexByte(version, '+'); idx >= 0 {
+		version = version[:idx]
+	}
+
+	var out parsed
+	core, pre, hasPre := strings.Cut(version, "-")
+	out.release = releaseSegments(strings.Split(core, "."))
+	if hasPre {
+		for _, part := range strings.Split(pre, ".") {
+			out.pre = append(out.pre, newIdentifier(part))
+		}
+	}
+
+	return out
+}
+
+// parseGem treats the first segment containing a letter as the start of a
+// pre-release, as RubyGems does ("1.0.0.pre" < "1.0.0").
+func parseGem(version string) parsed {
+	var out parsed
+	parts := splitAlnum(version)
+	i := 0
+	for ; i < len(parts) && isNumeric(parts[i]); i++ {
+	}
+	out.release = releaseSegments(parts[:i])
+	for _, part := range parts[i:] {
+		out.pre = append(out.pre, newIdentifier(part))
+	}
+
+	return out
+}
+
+// parseQualified handles ecosystems with a fixed, ranked set of qualifiers
+// (PEP 440, Maven). Negative ranks are pre-releases, positive ranks are
+// post-releases and zero is equivalent to a final release. Unknown qualifiers
+// are treated as pre-releases ordered lexically after the known ones.
+func parseQualified(version string, qualifiers map[string]int, epochs bool) parsed {
+	// PEP 440 epochs ("1!2.0") take precedence over everything else, and
+	// versions without one are implicitly epoch 0
+	epoch := "0"
+	if e, rest, ok := strings.Cut(version, "!"); epochs && ok && isNumeric(e) {
+		epoch, version = trimZeros(e), rest
+	}
+	if idx := strings.IndexByte(version, '+'); idx >= 0 {
+		version = version[:idx]
+	}
+
+	var out parsed
+	parts := splitAlnum(version)
+	i := 0
+	for ; i < len(parts) && isNumeric(parts[i]); i++ {
+	}
+	out.release = releaseSegments(parts[:i])
+	if epochs {
+		out.release = append([]string{epoch}, out.release...)
+	}
+
+	rest := parts[i:]
+	if len(rest) == 0 {
+		return out
+	}
+
+	rank, known := qualifiers[rest[0]]
+	if !known {
+		for _, part := range rest {
+			out.pre = append(out.pre, newIdentifier(part))
+		}
+		return out
+	}
+
+	// encode the qualifier's rank as a numeric identifier so the known
+	// qualifiers sort below any unknown (alphanumeric) ones
+	var ids []identifier
+	if rank < 0 {
+		ids = append(ids, identifier{numeric: true, num: strconv.Itoa(100 + rank)})
+	} else {
+		ids = append(ids, identifier{numeric: true, num: strconv.Itoa(rank)})
+	}
+	for _, part := range rest[1:] {
+		ids = append(ids, newIdentifier(part))
+	}
+
+	switch {
+	case rank < 0:
+		out.pre = ids
+	case rank > 0:
+		out.post = ids
+	}
+
+	return out
+}
+
+var (
+	pipQualifiers = map[string]int{
+		"dev":     -5,
+		"a":       -4,
+		"alpha":   -4,
+		"b":       -3,
+		"beta":    -3,
+		"c":       -2,
+		"rc":      -2,
+		"pre":     -2,
+		"preview": -2,
+		"post":    1,
+		"rev":     1,
+		"r":       1,
+	}
+
+	mavenQualifiers = map[string]int{
+		"alpha":     -6,
+		"a":         -6,
+		"beta":      -5,
+		"b":         -5,
+		"milestone": -4,
+		"m":         -4,
+		"rc":        -3,
+		"cr":        -3,
+		"snapshot":  -2,
+		"ga":        0,
+		"final":     0,
+		"release":   0,
+		"sp":        1,
+	}
+)
+
+func newIdentifier(s string) identifier {
+	if isNumeric(s) {
+		return identifier{numeric: true, num: trimZeros(s)}
+	}
+	return identifier{str: s}
+}
+
+// releaseSegments normalizes numeric release segments, dropping trailing
+// zeros so that "1.0" and "1.0.0" encode identically.
+func releaseSegments(parts []string) []string {
+	var out []string
+	for _, p := range parts {
+		if !isNumeric(p) {
+			p = "0"
+		}
+		out = append(out, trimZeros(p))
+	}
+	for len(out) > 1 && out[len(out)-1] == "0" {
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 {
+		out = []string{"0"}
+	}
+	return out
+}
+
+// splitAlnum splits on '.', '-' and '_' as well as on transitions between
+// letters and digits: "1.0rc2" => ["1", "0", "rc", "2"]
+func splitAlnum(s string) []string {
+	var parts []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			parts = append(parts, cur.String())
+			cur.Reset()
+		}
+	}
+
+	var prevDigit, started bool
+	for _, r := range s {
+		if r == '.' || r == '-' || r == '_' {
+			flush()
+			started = false
+			continue
+		}
+		digit := unicode.IsDigit(r)
+		if started && digit != prevDigit {
+			flush()
+		}
+		cur.WriteRune(r)
+		prevDigit, started = digit, true
+	}
+	flush()
+
+	return parts
+}
+
+// writeNumber emits a length-prefixed decimal so numbers of differing widths
+// compare correctly. Numbers wider than 9 digits share the 'z' prefix and fall
+// back to a zero-padded 32-digit representation.
+func writeNumber(sb *strings.Builder, digits string) {
+	if len(digits) <= 9 {
+		sb.WriteByte(byte('0' + len(digits)))
+		sb.WriteString(digits)
+		return
+	}
+	sb.WriteByte('z')
+	sb.WriteString(fmt.Sprintf("%032s", digits))
+}
+
+// writeIdentifiers emits each identifier as a kind byte ('0' numeric, '1'
+// alphanumeric) plus its contents and a ' ' separator, then a '!' terminator.
+// Both separator and terminator sort below every kind byte and identifier
+// character, so a shorter list of equal prefix has lower precedence.
+func writeIdentifiers(sb *strings.Builder, ids []identifier) {
+	for _, id := range ids {
+		if id.numeric {
+			sb.WriteByte('0')
+			writeNumber(sb, id.num)
+		} else {
+			sb.WriteByte('1')
+			sb.WriteString(id.str)
+		}
+		sb.WriteByte(' ')
+	}
+	sb.WriteByte('!')
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func trimZeros(s string) string {
+	s = strings.TrimLeft(s, "0")
+	if s == "" {
+		return "0"
+	}
+	return s
+}
@@ -0,0 +1,95 @@
+package versions
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestKeyOrdering(t *testing.T) {
+	cases := []struct {
+		pkgMgr  string
+		ordered []string
+	}{
+		{"npm", []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.2.0", "1.10.0", "9.0.0", "10.0.0"}},
+		{"cargo", []string{"0.9.9", "0.10.0", "1.0.0", "1.0.0.1", "1.0.10", "2.0.0"}},
+		{"pip", []string{"1.0.dev1", "1.0a1", "1.0b2", "1.0rc1", "1.0", "1.0.post1", "1.0.1", "1.10", "1!0.1"}},
+		{"maven", []string{"1.0-alpha1", "1.0-beta1", "1.0-M1", "1.0-RC1", "1.0-SNAPSHOT", "1.0", "1.0-sp1", "1.1"}},
+		{"gem", []string{"1.0.0.pre", "1.0.0.rc1", "1.0.0", "1.0.1", "1.10.0"}},
+	}
+
+	for _, tc := range cases {
+		keys := make([]string, len(tc.ordered))
+		for i, v := range tc.ordered {
+			keys[i] = Key(tc.pkgMgr, v)
+		}
+		if !sort.StringsAreSorted(keys) {
+			t.Errorf("%s: keys are not sorted for %v: %q", tc.pkgMgr, tc.ordered, keys)
+		}
+		for i := 1; i < len(keys); i++ {
+			if keys[i-1] == keys[i] {
+				t.Errorf("%s: %q and %q encode to the same key %q", tc.pkgMgr, tc.ordered[i-1], tc.ordered[i], keys[i])
+			}
+		}
+	}
+}
+
+func TestKeyEquivalence(t *testing.T) {
+	cases := []struct {
+		pkgMgr string
+		a, b   string
+	}{
+		{"npm", "1.0.0", "1.0"},
+		{"npm", "v1.2.3", "1.2.3"},
+		{"npm", "1.2.3+build.5", "1.2.3"},
+		{"pip", "1.0", "0!1.0.0"},
+		{"maven", "1.0-ga", "1.0"},
+		{"maven", "1.0-FINAL", "1.0.0"},
+	}
+
+	for _, tc := range cases {
+		if Compare(tc.pkgMgr, tc.a, tc.b) != 0 {
+			t.Errorf("%s: expected %q == %q, got keys %q and %q",
+				tc.pkgMgr, tc.a, tc.b, Key(tc.pkgMgr, tc.a), Key(tc.pkgMgr, tc.b))
+		}
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		expr string
+		in   []string
+		out  []string
+	}{
+		{">=1.2.0 <2.0.0", []string{"1.2.0", "1.10.0", "1.99.99", "2.0.0-rc.1"}, []string{"1.1.9", "1.2.0-rc.1", "2.0.0", "10.0.0"}},
+		{">= 1.2, < 2", []string{"1.2.0", "1.10.0"}, []string{"1.1.0", "2.0.0"}},
+		{">1.0.0 <=1.5.0", []string{"1.0.1", "1.5.0"}, []string{"1.0.0", "1.5.1"}},
+		{"=1.4.2", []string{"1.4.2", "1.4.2+meta"}, []string{"1.4.1", "1.4.3"}},
+		{"1.4.2", []string{"1.4.2"}, []string{"1.4.20"}},
+		{">=1.0.0 >=1.3.0 <3.0.0 <2.0.0", []string{"1.3.0", "1.9.9"}, []string{"1.2.0", "2.5.0"}},
+	}
+
+	for _, tc := range cases {
+		rng, err := ParseRange("npm", tc.expr)
+		if err != nil {
+			t.Fatalf("parsing %q: %s", tc.expr, err)
+		}
+		for _, v := range tc.in {
+			if !rng.Contains(Key("npm", v)) {
+				t.Errorf("expected %q to be within %q", v, tc.expr)
+			}
+		}
+		for _, v := range tc.out {
+			if rng.Contains(Key("npm", v)) {
+				t.Errorf("expected %q to be outside %q", v, tc.expr)
+			}
+		}
+	}
+}
+
+func TestParseRangeErrors(t *testing.T) {
+	for _, expr := range []string{"", " , ", ">=", "=>1.0.0", "!=1.0.0"} {
+		if _, err := ParseRange("npm", expr); err == nil {
+			t.Errorf("expected error parsing %q", expr)
+		}
+	}
+}